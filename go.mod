@@ -12,7 +12,7 @@ require (
 	github.com/grafana/grafana-plugin-sdk-go v0.139.0
 	github.com/huandu/xstrings v1.4.0 // indirect
 	github.com/imdario/mergo v0.3.13 // indirect
-	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/klauspost/compress v1.15.9
 	github.com/magefile/mage v1.13.0 // indirect
 	github.com/meln5674/gingk8s v0.0.0-20230529200204-ba6881769af9
 	github.com/meln5674/gosh v0.0.0-20230418002009-f731b8b62575
@@ -21,6 +21,8 @@ require (
 	github.com/onsi/ginkgo/v2 v2.9.5
 	github.com/onsi/gomega v1.27.6
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.12.1
+	github.com/testcontainers/testcontainers-go v0.15.0
 	github.com/youmark/pkcs8 v0.0.0-20201027041543-1326539a0a0a // indirect
 	go.mongodb.org/mongo-driver v1.10.0
 	sigs.k8s.io/yaml v1.3.0
@@ -0,0 +1,30 @@
+package bsonframe_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CoerceBool", func() {
+	DescribeTable("Should coerce", func(value interface{}, expected bool) {
+		Expect(bsonframe.CoerceBool(value)).To(Equal(expected))
+	},
+		Entry("int32 1", int32(1), true),
+		Entry("int64 0", int64(0), false),
+		Entry("float 1", 1.0, true),
+		Entry("TRUE", "TRUE", true),
+		Entry("no", " no ", false),
+		Entry("string 0", "0", false),
+		Entry("bool", true, true),
+	)
+
+	DescribeTable("Should reject", func(value interface{}) {
+		_, err := bsonframe.CoerceBool(value)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("other numbers", int32(2)),
+		Entry("other strings", "maybe"),
+	)
+})
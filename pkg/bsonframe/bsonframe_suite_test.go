@@ -0,0 +1,13 @@
+package bsonframe_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBSONFrame(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "BSON Frame Suite")
+}
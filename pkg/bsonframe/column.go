@@ -0,0 +1,303 @@
+package bsonframe
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Column describes a column of a frame, and converts document values into values of its type
+type Column struct {
+	Name string
+	Type data.FieldType
+	// Coerce indicates that values which do not match Type should be coerced to it with CoerceValue, if possible,
+	// instead of producing an error. This is used for inferred schemas, where the type is only
+	// a guess based on the first N documents.
+	Coerce bool
+	// ISODuration indicates that strings are ISO-8601 durations, which are converted to seconds
+	ISODuration bool
+	// BoolCoercion indicates that numbers and strings are coerced to booleans with CoerceBool
+	BoolCoercion bool
+	// Scale, if not zero, multiplies every value of a float64 column, to convert it to another unit
+	Scale float64
+	// fastConvert, if not nil, converts a value which is already of the expected BSON type
+	// without going through the converter registry
+	fastConvert fastConverter
+}
+
+// NewColumn returns a column of a type, which converts values of the matching BSON type without going through the converter registry
+func NewColumn(name string, type_ data.FieldType) Column {
+	return Column{
+		Name:        name,
+		Type:        type_,
+		fastConvert: fastConverterFor(type_),
+	}
+}
+
+// Get returns the value of this column in a document, which is nil if it is absent
+func (c *Column) Get(doc Document) interface{} {
+	return doc[c.Name]
+}
+
+// Convert converts a raw document value to the type of this column,
+// recording any coercions or truncations that were necessary in stats
+func (c *Column) Convert(value interface{}, opts *ConversionOptions, stats *Stats) (interface{}, error) {
+	converted, err := c.convert(value, opts, stats)
+	if err != nil || c.Scale == 0 {
+		return converted, err
+	}
+	switch v := converted.(type) {
+	case float64:
+		return v * c.Scale, nil
+	case *float64:
+		if v == nil {
+			return v, nil
+		}
+		scaled := *v * c.Scale
+		return &scaled, nil
+	default:
+		return converted, nil
+	}
+}
+
+func (c *Column) convert(value interface{}, opts *ConversionOptions, stats *Stats) (interface{}, error) {
+	if value == nil {
+		if placeholder, ok := c.nullPlaceholder(opts); ok {
+			return placeholder, nil
+		}
+		if !c.Type.Nullable() {
+			return nil, fmt.Errorf("Field %s was null or absent, but is not nullable. If using schema inference, please increase the depth to the first document missing this field, or manually specify the schema", c.Name)
+		}
+		return nil, nil
+	}
+
+	if c.fastConvert != nil {
+		converted, ok := c.fastConvert(value, opts)
+		if ok {
+			return converted, nil
+		}
+	}
+	if c.BoolCoercion {
+		b, err := CoerceBool(value)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s", c.Name))
+		}
+		return &b, nil
+	}
+	if text, ok := value.(string); ok && c.ISODuration {
+		seconds, err := ParseISODuration(text)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s", c.Name))
+		}
+		return &seconds, nil
+	}
+
+	converted, actualType, err := ConvertValue(value, false, opts)
+	if truncated, ok := err.(*TruncatedCellError); ok {
+		stats.RecordTruncation(c.Name, truncated.Limit)
+		converted, err = truncated.Marker(), nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s (%#v)", c.Name, value))
+	}
+	if converted == nil {
+		if placeholder, ok := c.nullPlaceholder(opts); ok {
+			return placeholder, nil
+		}
+		if !c.Type.Nullable() {
+			return nil, fmt.Errorf("Field %s was undefined, but is not nullable", c.Name)
+		}
+		return nil, nil
+	}
+
+	expectedType := c.Type.NonNullableType()
+	if actualType != expectedType {
+		var coerced interface{}
+		ok := false
+		if text, isString := converted.(string); isString && opts.Locale.Enabled() {
+			coerced, ok, err = opts.Locale.Parse(text, expectedType, opts.Location)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("Failed to parse value for %s", c.Name))
+			}
+		}
+		if !ok && c.Coerce {
+			coerced, ok = CoerceValue(converted, expectedType)
+		}
+		if !ok {
+			return nil, fmt.Errorf("Type mismatch for field %s: expected %s, got %s (%#v, %#v)", c.Name, c.Type, actualType, value, converted)
+		}
+		stats.RecordCoercion(c.Name, actualType, expectedType)
+		converted = coerced
+	}
+
+	if c.Type.Nullable() {
+		return MakeNullable(converted), nil
+	}
+	return converted, nil
+}
+
+// nullPlaceholder returns the value which replaces a null in this column, if it is a string column and a placeholder is configured
+func (c *Column) nullPlaceholder(opts *ConversionOptions) (interface{}, bool) {
+	if opts.NullString == nil || c.Type.NonNullableType() != data.FieldTypeString {
+		return nil, false
+	}
+	if c.Type.Nullable() {
+		placeholder := *opts.NullString
+		return &placeholder, true
+	}
+	return *opts.NullString, true
+}
+
+// fastConverter converts a raw BSON value directly to a Grafana value if it is already of the expected type.
+// If it is not, ok is false, and the caller must fall back to ToGrafanaValue.
+type fastConverter func(value interface{}, opts *ConversionOptions) (converted interface{}, ok bool)
+
+// fastConverterFor returns a fastConverter for the BSON types which map one-to-one to a Grafana field type,
+// or nil if there is no such mapping
+func fastConverterFor(type_ data.FieldType) fastConverter {
+	switch type_ {
+	case data.FieldTypeInt32:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(int32)
+			return v, ok
+		}
+	case data.FieldTypeNullableInt32:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(int32)
+			if !ok {
+				return nil, false
+			}
+			return &v, true
+		}
+	case data.FieldTypeInt64:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(int64)
+			return v, ok
+		}
+	case data.FieldTypeNullableInt64:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(int64)
+			if !ok {
+				return nil, false
+			}
+			return &v, true
+		}
+	case data.FieldTypeFloat64:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(float64)
+			return v, ok
+		}
+	case data.FieldTypeNullableFloat64:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(float64)
+			if !ok {
+				return nil, false
+			}
+			return &v, true
+		}
+	case data.FieldTypeString:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(string)
+			return v, ok
+		}
+	case data.FieldTypeNullableString:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(string)
+			if !ok {
+				return nil, false
+			}
+			return &v, true
+		}
+	case data.FieldTypeBool:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(bool)
+			return v, ok
+		}
+	case data.FieldTypeNullableBool:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(bool)
+			if !ok {
+				return nil, false
+			}
+			return &v, true
+		}
+	case data.FieldTypeTime:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(bsonPrim.DateTime)
+			if !ok {
+				return nil, false
+			}
+			return opts.localTime(v.Time()), true
+		}
+	case data.FieldTypeNullableTime:
+		return func(value interface{}, opts *ConversionOptions) (interface{}, bool) {
+			v, ok := value.(bsonPrim.DateTime)
+			if !ok {
+				return nil, false
+			}
+			t := opts.localTime(v.Time())
+			return &t, true
+		}
+	}
+	return nil
+}
+
+// CoerceValue attempts to convert a (non-nullable) Grafana value to another (non-nullable) Grafana type.
+// Numeric types are converted between each other as long as no precision is lost,
+// and any scalar can be converted to a string.
+func CoerceValue(value interface{}, to data.FieldType) (interface{}, bool) {
+	switch to {
+	case data.FieldTypeFloat64:
+		switch v := value.(type) {
+		case int32:
+			return float64(v), true
+		case int64:
+			if v > 1<<53 || v < -(1<<53) {
+				return nil, false
+			}
+			return float64(v), true
+		}
+	case data.FieldTypeInt64:
+		switch v := value.(type) {
+		case int32:
+			return int64(v), true
+		case float64:
+			// float64(math.MaxInt64) rounds up to 2^63, which does not fit
+			if v != math.Trunc(v) || v >= 1<<63 || v < math.MinInt64 {
+				return nil, false
+			}
+			return int64(v), true
+		}
+	case data.FieldTypeInt32:
+		switch v := value.(type) {
+		case int64:
+			if v > math.MaxInt32 || v < math.MinInt32 {
+				return nil, false
+			}
+			return int32(v), true
+		case float64:
+			if v != math.Trunc(v) || v > math.MaxInt32 || v < math.MinInt32 {
+				return nil, false
+			}
+			return int32(v), true
+		}
+	case data.FieldTypeString:
+		switch v := value.(type) {
+		case json.RawMessage:
+			return string(v), true
+		case time.Time:
+			return v.Format(time.RFC3339Nano), true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case int32, int64, bool:
+			return fmt.Sprintf("%v", v), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,264 @@
+package bsonframe
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ValueConverter converts a value of a single BSON type, as decoded by the driver, into a Grafana value and its field type.
+// A nil value with an unknown type is treated the same as null.
+type ValueConverter func(value interface{}, opts *ConversionOptions) (interface{}, data.FieldType, error)
+
+var (
+	// converters are the converters for each BSON type
+	converters = map[bsontype.Type]ValueConverter{}
+	// binaryConverters are the converters for binary values of specific subtypes, which take precedence over the converter for bsontype.Binary
+	binaryConverters = map[byte]ValueConverter{}
+)
+
+// builtinConverters are the default converters for each BSON type
+func builtinConverters() map[bsontype.Type]ValueConverter {
+	return map[bsontype.Type]ValueConverter{
+		bsontype.Double:           convertScalar,
+		bsontype.String:           convertScalar,
+		bsontype.EmbeddedDocument: convertDocument,
+		bsontype.Array:            convertArray,
+		bsontype.Binary:           convertBinary,
+		bsontype.Undefined:        convertNull,
+		bsontype.ObjectID:         convertObjectID,
+		bsontype.Boolean:          convertScalar,
+		bsontype.DateTime:         convertDateTime,
+		bsontype.Null:             convertNull,
+		bsontype.Regex:            convertRegex,
+		bsontype.DBPointer:        convertDebugString,
+		bsontype.JavaScript:       convertJavaScript,
+		bsontype.Symbol:           convertSymbol,
+		bsontype.CodeWithScope:    convertCodeWithScope,
+		bsontype.Int32:            convertScalar,
+		bsontype.Timestamp:        convertTimestamp,
+		bsontype.Int64:            convertScalar,
+		bsontype.Decimal128:       convertDecimal128,
+		bsontype.MinKey:           convertDebugString,
+		bsontype.MaxKey:           convertDebugString,
+	}
+}
+
+// The built-in converters refer back to ToGrafanaValue for nested values, so they cannot be part of the initializer of converters.
+// Converters registered by other init functions in this package, which may run first, are not replaced.
+func init() {
+	for type_, converter := range builtinConverters() {
+		if _, ok := converters[type_]; !ok {
+			converters[type_] = converter
+		}
+	}
+}
+
+// RegisterConverter replaces the converter for a BSON type.
+// This must only be called from an init function,
+// as the registry is not safe for concurrent modification.
+func RegisterConverter(type_ bsontype.Type, converter ValueConverter) {
+	converters[type_] = converter
+}
+
+// RegisterBinaryConverter sets the converter for binary values of a subtype, such as UUIDs (0x04) or user-defined subtypes (0x80-0xFF).
+// Subtypes without a converter use the converter for bsontype.Binary.
+// As with RegisterConverter, this must only be called from an init function.
+func RegisterBinaryConverter(subtype byte, converter ValueConverter) {
+	binaryConverters[subtype] = converter
+}
+
+// bsonTypeOf returns the BSON type of a value produced by the driver when decoding into an interface{}
+func bsonTypeOf(value interface{}) (bsontype.Type, bool) {
+	switch value.(type) {
+	case float64:
+		return bsontype.Double, true
+	case string:
+		return bsontype.String, true
+	case bsonPrim.D, bsonPrim.M, map[string]interface{}:
+		// map[string]interface{} isn't documented, but can be observed to be returned
+		return bsontype.EmbeddedDocument, true
+	case bsonPrim.A, []interface{}:
+		// []interface{} isn't documented, but can be observed to be returned
+		return bsontype.Array, true
+	case bsonPrim.Binary:
+		return bsontype.Binary, true
+	case bsonPrim.Undefined:
+		return bsontype.Undefined, true
+	case bsonPrim.ObjectID:
+		return bsontype.ObjectID, true
+	case bool:
+		return bsontype.Boolean, true
+	case bsonPrim.DateTime:
+		return bsontype.DateTime, true
+	case bsonPrim.Null:
+		// Not documented, but can be observed when decoding into some types
+		return bsontype.Null, true
+	case bsonPrim.Regex:
+		return bsontype.Regex, true
+	case bsonPrim.DBPointer:
+		return bsontype.DBPointer, true
+	case bsonPrim.JavaScript:
+		return bsontype.JavaScript, true
+	case bsonPrim.Symbol:
+		return bsontype.Symbol, true
+	case bsonPrim.CodeWithScope:
+		return bsontype.CodeWithScope, true
+	case int32:
+		return bsontype.Int32, true
+	case bsonPrim.Timestamp:
+		return bsontype.Timestamp, true
+	case int64:
+		return bsontype.Int64, true
+	case bsonPrim.Decimal128:
+		return bsontype.Decimal128, true
+	case bsonPrim.MinKey:
+		return bsontype.MinKey, true
+	case bsonPrim.MaxKey:
+		return bsontype.MaxKey, true
+	}
+	return 0, false
+}
+
+// lookupConverter finds the registered converter for a value
+func lookupConverter(value interface{}) (ValueConverter, bool) {
+	type_, ok := bsonTypeOf(value)
+	if !ok {
+		return nil, false
+	}
+	if binary, isBinary := value.(bsonPrim.Binary); isBinary {
+		if converter, ok := binaryConverters[binary.Subtype]; ok {
+			return converter, true
+		}
+	}
+	converter, ok := converters[type_]
+	return converter, ok
+}
+
+// convertScalar converts the types which are the same in BSON and Grafana
+func convertScalar(value interface{}, _ *ConversionOptions) (interface{}, data.FieldType, error) {
+	return value, data.FieldTypeFor(value), nil
+}
+
+func convertNull(interface{}, *ConversionOptions) (interface{}, data.FieldType, error) {
+	return nil, data.FieldTypeUnknown, nil
+}
+
+// convertDocument converts a document into a JSON cell, or a DBRef according to the DBRef format
+func convertDocument(value interface{}, opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	if ref, ok := asDBRef(value); ok {
+		return ref.toGrafanaValue(opts)
+	}
+	if opts.MaxCellDepth > 0 && exceedsDepth(value, opts.MaxCellDepth) {
+		return nil, data.FieldTypeJSON, &TruncatedCellError{Limit: fmt.Sprintf("depth of %d", opts.MaxCellDepth)}
+	}
+	bytes, err := marshalExtJSONCell(value, opts.canonical(), 0, 0)
+	if err != nil {
+		return nil, data.FieldTypeUnknown, err
+	}
+	if opts.MaxCellBytes > 0 && len(bytes) > opts.MaxCellBytes {
+		return nil, data.FieldTypeJSON, &TruncatedCellError{Limit: fmt.Sprintf("size of %d bytes", opts.MaxCellBytes)}
+	}
+	return bytes, data.FieldTypeJSON, nil
+}
+
+// convertArray converts an array into a JSON cell
+func convertArray(value interface{}, opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	// MarshalExtJSON doesn't accept arrays for whatever reason
+	// https://github.com/mongodb/mongo-go-driver/blob/v1/docs/common-issues.md#writexxx-can-only-write-while-positioned-on-a-element-or-value-but-is-positioned-on-a-toplevel
+	//
+	// The fast but dangerous way.
+	// In theory, this should never produce anything except {"Value":list_goes_here},
+	// so trimming should never fail, and it passes the test, but this isn't guaranteed
+	if opts.MaxCellDepth > 0 && exceedsDepth(value, opts.MaxCellDepth) {
+		return nil, data.FieldTypeJSON, &TruncatedCellError{Limit: fmt.Sprintf("depth of %d", opts.MaxCellDepth)}
+	}
+	bytes, err := marshalExtJSONCell(bsonPrim.M{"Value": value}, opts.canonical(), len(`{"Value":`), len(`}`))
+	if err != nil {
+		return nil, data.FieldTypeUnknown, err
+	}
+	if opts.MaxCellBytes > 0 && len(bytes) > opts.MaxCellBytes {
+		return nil, data.FieldTypeJSON, &TruncatedCellError{Limit: fmt.Sprintf("size of %d bytes", opts.MaxCellBytes)}
+	}
+
+	/*
+		// This is the "safe" but slow way,
+		// We have to do this dance where we marshal it to JSON, unmarshall it back,
+		// extract the data we want, and then re-marshal just that
+		var roundTrip struct{ Value interface{} }
+		err = json.Unmarshal(bytes, &roundTrip)
+		if err != nil {
+			return nil, data.FieldTypeUnknown, err
+		}
+		bytes, err = json.Marshal(roundTrip.Value)
+		if err != nil {
+			return nil, data.FieldTypeUnknown, err
+		}
+	*/
+
+	return bytes, data.FieldTypeJSON, nil
+}
+
+// convertBinary renders a binary value according to the binary mode
+func convertBinary(value interface{}, opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	binary := value.(bsonPrim.Binary)
+	switch opts.BinaryMode {
+	case BinaryModeBase64:
+		return base64.StdEncoding.EncodeToString(binary.Data), data.FieldTypeString, nil
+	case BinaryModeUUID:
+		if (binary.Subtype == bsontype.BinaryUUID || binary.Subtype == bsontype.BinaryUUIDOld) && len(binary.Data) == 16 {
+			encoded := hex.EncodeToString(binary.Data)
+			return encoded[0:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:], data.FieldTypeString, nil
+		}
+	}
+	return hex.EncodeToString(binary.Data), data.FieldTypeString, nil
+}
+
+func convertObjectID(value interface{}, _ *ConversionOptions) (interface{}, data.FieldType, error) {
+	bytes := [12]byte(value.(bsonPrim.ObjectID))
+	return hex.EncodeToString(bytes[:]), data.FieldTypeString, nil
+}
+
+func convertDateTime(value interface{}, opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	return opts.localTime(value.(bsonPrim.DateTime).Time()), data.FieldTypeTime, nil
+}
+
+func convertRegex(value interface{}, _ *ConversionOptions) (interface{}, data.FieldType, error) {
+	return value.(bsonPrim.Regex).Pattern, data.FieldTypeString, nil
+}
+
+func convertJavaScript(value interface{}, _ *ConversionOptions) (interface{}, data.FieldType, error) {
+	return string(value.(bsonPrim.JavaScript)), data.FieldTypeString, nil
+}
+
+func convertSymbol(value interface{}, _ *ConversionOptions) (interface{}, data.FieldType, error) {
+	return string(value.(bsonPrim.Symbol)), data.FieldTypeString, nil
+}
+
+func convertCodeWithScope(value interface{}, _ *ConversionOptions) (interface{}, data.FieldType, error) {
+	return string(value.(bsonPrim.CodeWithScope).Code), data.FieldTypeString, nil
+}
+
+func convertTimestamp(value interface{}, opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	return opts.localTime(time.Unix(int64(value.(bsonPrim.Timestamp).T), 0)), data.FieldTypeTime, nil
+}
+
+// convertDecimal128 converts a decimal to a float, or its exact string representation, according to the decimal mode
+func convertDecimal128(value interface{}, opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	if opts.DecimalMode == DecimalModeString {
+		return value.(bsonPrim.Decimal128).String(), data.FieldTypeString, nil
+	}
+	f, err := strconv.ParseFloat(value.(bsonPrim.Decimal128).String(), 64)
+	return f, data.FieldTypeFloat64, err
+}
+
+// convertDebugString converts the types with no meaningful Grafana equivalent to their Go representation
+func convertDebugString(value interface{}, _ *ConversionOptions) (interface{}, data.FieldType, error) {
+	return fmt.Sprintf("%#v", value), data.FieldTypeString, nil
+}
@@ -0,0 +1,32 @@
+package bsonframe_test
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// userDefinedSubtype is a binary subtype reserved for applications, so registering a converter for it does not affect other tests
+const userDefinedSubtype = 0x80
+
+func init() {
+	bsonframe.RegisterBinaryConverter(userDefinedSubtype, func(value interface{}, _ *bsonframe.ConversionOptions) (interface{}, data.FieldType, error) {
+		return string(value.(bsonPrim.Binary).Data), data.FieldTypeString, nil
+	})
+}
+
+var _ = Describe("RegisterBinaryConverter", func() {
+	It("Should use the registered converter for its subtype only", func() {
+		converted, _, err := bsonframe.ToGrafanaValue(bsonPrim.Binary{Subtype: userDefinedSubtype, Data: []byte("abc")}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(converted).To(Equal("abc"))
+		converted, type_, err := bsonframe.ToGrafanaValue(bsonPrim.Binary{Subtype: 0, Data: []byte("abc")}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(converted).To(Equal("616263"))
+		Expect(type_).To(Equal(data.FieldTypeString))
+	})
+})
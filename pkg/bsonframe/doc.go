@@ -0,0 +1,13 @@
+// Package bsonframe converts documents decoded by the MongoDB Go driver into Grafana data frames.
+//
+// Values are converted one at a time with ToGrafanaValue, which looks up a ValueConverter for the BSON type of the value.
+// The converters for each type can be replaced with RegisterConverter and RegisterBinaryConverter.
+//
+// Columns of a frame are described by a Column, which converts each value to the type of the column,
+// coercing it or parsing it according to ConversionOptions where needed, and recording what it did in Stats.
+// When the columns are not known ahead of time, SchemaInference guesses them from a sample of documents.
+// BuildFrame combines these to produce a frame from a set of documents.
+//
+// Documents are expected to be decoded into a Document (map[string]interface{}), as done by the driver
+// when decoding into an empty interface, so values are of the types documented for bson.Unmarshal.
+package bsonframe
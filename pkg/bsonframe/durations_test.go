@@ -0,0 +1,31 @@
+package bsonframe_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseISODuration", func() {
+	DescribeTable("Should parse", func(text string, expected float64) {
+		Expect(bsonframe.ParseISODuration(text)).To(Equal(expected))
+	},
+		Entry("time components", "PT1H2M3S", 3723.0),
+		Entry("date and time components", "P1DT12H", 129600.0),
+		Entry("weeks", "P2W", 1209600.0),
+		Entry("fractional seconds", "PT0.25S", 0.25),
+		Entry("a decimal comma", "PT1,5M", 90.0),
+		Entry("a negative duration", "-PT30S", -30.0),
+	)
+
+	DescribeTable("Should reject", func(text string) {
+		_, err := bsonframe.ParseISODuration(text)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("an empty duration", "P"),
+		Entry("an empty time part", "P1DT"),
+		Entry("minutes without T", "P1H"),
+		Entry("plain numbers", "90"),
+	)
+})
@@ -0,0 +1,66 @@
+package bsonframe
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+// NewFrame produces an empty frame with a field for each column
+func NewFrame(name string, columns []Column) *data.Frame {
+	names := make([]string, len(columns))
+	types := make([]data.FieldType, len(columns))
+	for ix, column := range columns {
+		names[ix] = column.Name
+		types[ix] = column.Type
+	}
+	frame := data.NewFrameOfFieldTypes(name, 0, types...)
+	frame.SetFieldNames(names...)
+	return frame
+}
+
+// AppendDocument converts the values of a document for each column, and appends them to a frame produced by NewFrame for the same columns
+func AppendDocument(frame *data.Frame, columns []Column, doc Document, opts *ConversionOptions, stats *Stats) error {
+	if opts == nil {
+		opts = &ConversionOptions{}
+	}
+	row := make([]interface{}, len(columns))
+	for ix := range columns {
+		value, err := columns[ix].Convert(columns[ix].Get(doc), opts, stats)
+		if err != nil {
+			return err
+		}
+		row[ix] = value
+	}
+	frame.AppendRow(row...)
+	return nil
+}
+
+// BuildFrame converts a set of documents into a frame. If columns is nil, they are inferred from all of the documents.
+// The returned stats describe any values which were coerced or truncated, and can be turned into notices for the frame.
+func BuildFrame(name string, columns []Column, docs []Document, opts *ConversionOptions) (*data.Frame, *Stats, error) {
+	if opts == nil {
+		opts = &ConversionOptions{}
+	}
+	if columns == nil {
+		inference := NewSchemaInference(nil)
+		inference.Opts = *opts
+		for ix, doc := range docs {
+			err := inference.UpdateDoc(doc)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, fmt.Sprintf("Failed to infer schema from document number %d", ix))
+			}
+		}
+		columns = inference.Finish()
+	}
+	frame := NewFrame(name, columns)
+	stats := &Stats{}
+	for ix, doc := range docs {
+		err := AppendDocument(frame, columns, doc, opts, stats)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("Failed to convert document number %d", ix))
+		}
+	}
+	return frame, stats, nil
+}
@@ -0,0 +1,42 @@
+package bsonframe_test
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BuildFrame", func() {
+	docs := []bsonframe.Document{
+		{"name": "a", "count": int32(1), "tags": bsonPrim.A{"x"}},
+		{"name": "b", "count": int64(2)},
+	}
+
+	It("Should infer columns ordered by name, and coerce mismatched values", func() {
+		frame, stats, err := bsonframe.BuildFrame("test", nil, docs[:1], nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame.Fields).To(HaveLen(3))
+		Expect(frame.Fields[0].Name).To(Equal("count"))
+		Expect(frame.Fields[1].Name).To(Equal("name"))
+		Expect(frame.Fields[2].Type()).To(Equal(data.FieldTypeJSON))
+		Expect(stats.Notices()).To(BeEmpty())
+
+		columns := []bsonframe.Column{bsonframe.NewColumn("name", data.FieldTypeString), bsonframe.NewColumn("count", data.FieldTypeInt32)}
+		columns[1].Coerce = true
+		frame, stats, err = bsonframe.BuildFrame("test", columns, docs, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame.Rows()).To(Equal(2))
+		Expect(frame.Fields[1].At(1)).To(Equal(int32(2)))
+		Expect(stats.Notices()).To(HaveLen(1))
+	})
+
+	It("Should reject documents missing a non-nullable column", func() {
+		columns := []bsonframe.Column{bsonframe.NewColumn("tags", data.FieldTypeJSON)}
+		_, _, err := bsonframe.BuildFrame("test", columns, docs, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
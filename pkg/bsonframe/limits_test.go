@@ -0,0 +1,57 @@
+package bsonframe_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cell limits", func() {
+	deep := bson.D{{Key: "a", Value: bson.D{{Key: "b", Value: bson.A{bson.D{{Key: "c", Value: 1}}}}}}}
+	large := bson.D{{Key: "payload", Value: strings.Repeat("x", 100)}}
+
+	DescribeTable("Should truncate cells exceeding", func(value interface{}, opts bsonframe.ConversionOptions, limit string) {
+		_, _, err := bsonframe.ConvertValue(value, false, &opts)
+		truncated, ok := err.(*bsonframe.TruncatedCellError)
+		Expect(ok).To(BeTrue(), "expected a truncation, got %v", err)
+		Expect(truncated.Limit).To(Equal(limit))
+	},
+		Entry("the depth of documents", deep, bsonframe.ConversionOptions{MaxCellDepth: 3}, "depth of 3"),
+		Entry("the depth of arrays", bson.A{deep}, bsonframe.ConversionOptions{MaxCellDepth: 4}, "depth of 4"),
+		Entry("the size of documents", large, bsonframe.ConversionOptions{MaxCellBytes: 50}, "size of 50 bytes"),
+		Entry("the size of arrays", bson.A{large}, bsonframe.ConversionOptions{MaxCellBytes: 50}, "size of 50 bytes"),
+	)
+
+	DescribeTable("Should keep cells within", func(value interface{}, opts bsonframe.ConversionOptions) {
+		converted, fieldType, err := bsonframe.ConvertValue(value, false, &opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fieldType).To(Equal(data.FieldTypeJSON))
+		Expect(converted).ToNot(BeNil())
+	},
+		Entry("the depth limit", deep, bsonframe.ConversionOptions{MaxCellDepth: 4}),
+		Entry("the size limit", large, bsonframe.ConversionOptions{MaxCellBytes: 200}),
+		Entry("no limits", deep, bsonframe.ConversionOptions{}),
+	)
+
+	It("Should replace truncated cells with a marker, and report them in a notice", func() {
+		column := bsonframe.NewColumn("doc", data.FieldTypeNullableJSON)
+		stats := bsonframe.Stats{}
+		opts := bsonframe.ConversionOptions{MaxCellDepth: 2}
+		for i := 0; i < 2; i++ {
+			converted, err := column.Convert(deep, &opts, &stats)
+			Expect(err).ToNot(HaveOccurred())
+			marker := converted.(*json.RawMessage)
+			Expect(string(*marker)).To(MatchJSON(`{"$truncated": "Cell exceeded the maximum depth of 2"}`))
+		}
+		notices := stats.Notices()
+		Expect(notices).To(HaveLen(1))
+		Expect(notices[0].Text).To(Equal("Field doc: 2 value(s) exceeded the maximum depth of 2 and were replaced with a truncation marker"))
+	})
+})
@@ -0,0 +1,146 @@
+package bsonframe
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// DecimalSeparatorPoint parses numbers such as 1,234.5
+	DecimalSeparatorPoint = "."
+	// DecimalSeparatorComma parses numbers such as 1.234,5
+	DecimalSeparatorComma = ","
+
+	// DateFormatDMY parses dates such as 31/12/2023
+	DateFormatDMY = "dd/mm/yyyy"
+	// DateFormatMDY parses dates such as 12/31/2023
+	DateFormatMDY = "mm/dd/yyyy"
+)
+
+// localizedDatePattern matches dates with numeric day, month, and four digit year, separated by /, ., or -,
+// optionally followed by a time of day
+var localizedDatePattern = regexp.MustCompile(`^(\d{1,2})[/.\-](\d{1,2})[/.\-](\d{4})(?:[ T](\d{1,2}):(\d{2})(?::(\d{2}))?)?$`)
+
+// LocaleOptions control how strings are parsed when they are found in numeric or time columns.
+// The zero value does not parse strings at all.
+type LocaleOptions struct {
+	// DecimalSeparator is the separator between the integer and fractional parts of numbers, either . or ,.
+	// The other is assumed to be a thousands separator, as are spaces and apostrophes.
+	DecimalSeparator string
+	// DateFormat is either dd/mm/yyyy, mm/dd/yyyy, or a Go time layout
+	DateFormat string
+}
+
+// Validate checks that the options are supported
+func (o *LocaleOptions) Validate() error {
+	switch o.DecimalSeparator {
+	case "", DecimalSeparatorPoint, DecimalSeparatorComma:
+		return nil
+	default:
+		return fmt.Errorf("Decimal separator must be one of: %s, %s", DecimalSeparatorPoint, DecimalSeparatorComma)
+	}
+}
+
+// Enabled returns true if strings should be parsed into numbers or times
+func (o *LocaleOptions) Enabled() bool {
+	return o.DecimalSeparator != "" || o.DateFormat != ""
+}
+
+// ParseLocalizedNumber parses a number which uses decimalSeparator (. or ,) and may have thousands separators,
+// such as 1.234,5 for a decimal comma, or 1,234.5 for a decimal point
+func ParseLocalizedNumber(text string, decimalSeparator string) (float64, error) {
+	thousandsSeparator := ","
+	if decimalSeparator == DecimalSeparatorComma {
+		thousandsSeparator = "."
+	}
+	normalized := strings.TrimSpace(text)
+	normalized = strings.NewReplacer(
+		thousandsSeparator, "",
+		" ", "",
+		"\u00a0", "",
+		"\u202f", "",
+		"'", "",
+	).Replace(normalized)
+	normalized = strings.Replace(normalized, decimalSeparator, ".", 1)
+	value, err := strconv.ParseFloat(normalized, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number %q", text)
+	}
+	return value, nil
+}
+
+// ParseLocalizedDate parses a date in one of the formats dd/mm/yyyy or mm/dd/yyyy (with any of /, ., or - as separators,
+// and an optional time of day), or using a Go time layout. Dates without a timezone are assumed to be UTC.
+func ParseLocalizedDate(text string, format string) (time.Time, error) {
+	return ParseLocalizedDateIn(text, format, time.UTC)
+}
+
+// ParseLocalizedDateIn parses a date like ParseLocalizedDate, but dates without a timezone are assumed to be in loc
+func ParseLocalizedDateIn(text string, format string, loc *time.Location) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if format != DateFormatDMY && format != DateFormatMDY {
+		return time.ParseInLocation(format, text, loc)
+	}
+	match := localizedDatePattern.FindStringSubmatch(text)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("Invalid date %q, expected %s", text, format)
+	}
+	parts := make([]int, len(match)-1)
+	for ix, part := range match[1:] {
+		if part == "" {
+			continue
+		}
+		parts[ix], _ = strconv.Atoi(part)
+	}
+	day, month := parts[0], parts[1]
+	if format == DateFormatMDY {
+		day, month = month, day
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, fmt.Errorf("Invalid date %q, expected %s", text, format)
+	}
+	t := time.Date(parts[2], time.Month(month), day, parts[3], parts[4], parts[5], 0, loc)
+	if t.Day() != day {
+		return time.Time{}, fmt.Errorf("Invalid date %q, day out of range for month", text)
+	}
+	return t, nil
+}
+
+// Parse converts a string into a (non-nullable) numeric or time type according to the locale options.
+// Dates without a timezone are assumed to be in loc, or UTC if it is nil.
+// ok is false if the options don't allow parsing into that type.
+func (o *LocaleOptions) Parse(text string, to data.FieldType, loc *time.Location) (interface{}, bool, error) {
+	switch {
+	case to == data.FieldTypeTime:
+		if o.DateFormat == "" {
+			return nil, false, nil
+		}
+		if loc == nil {
+			loc = time.UTC
+		}
+		t, err := ParseLocalizedDateIn(text, o.DateFormat, loc)
+		return t, true, err
+	case to.Numeric():
+		if o.DecimalSeparator == "" {
+			return nil, false, nil
+		}
+		value, err := ParseLocalizedNumber(text, o.DecimalSeparator)
+		if err != nil {
+			return nil, true, err
+		}
+		if to == data.FieldTypeFloat64 {
+			return value, true, nil
+		}
+		converted, ok := CoerceValue(value, to)
+		if !ok {
+			return nil, true, fmt.Errorf("%q cannot be represented as %s", text, to)
+		}
+		return converted, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,44 @@
+package bsonframe_test
+
+import (
+	"time"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseLocalizedNumber", func() {
+	DescribeTable("Should parse", func(text, separator string, expected float64) {
+		Expect(bsonframe.ParseLocalizedNumber(text, separator)).To(Equal(expected))
+	},
+		Entry("decimal comma with thousands", "1.234,5", ",", 1234.5),
+		Entry("decimal comma with spaces", "-1 234 567,25", ",", -1234567.25),
+		Entry("decimal point with thousands", "1,234.5", ".", 1234.5),
+		Entry("apostrophes", "1'000.5", ".", 1000.5),
+	)
+
+	It("Should reject text which is not a number", func() {
+		_, err := bsonframe.ParseLocalizedNumber("12,5 kg", ",")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ParseLocalizedDate", func() {
+	DescribeTable("Should parse", func(text, format string, expected time.Time) {
+		Expect(bsonframe.ParseLocalizedDate(text, format)).To(Equal(expected))
+	},
+		Entry("day first", "03/04/2021", "dd/mm/yyyy", time.Date(2021, time.April, 3, 0, 0, 0, 0, time.UTC)),
+		Entry("month first", "03/04/2021", "mm/dd/yyyy", time.Date(2021, time.March, 4, 0, 0, 0, 0, time.UTC)),
+		Entry("dots and a time", "31.12.2020 23:59:30", "dd/mm/yyyy", time.Date(2020, time.December, 31, 23, 59, 30, 0, time.UTC)),
+		Entry("a Go layout", "2021-04-03", "2006-01-02", time.Date(2021, time.April, 3, 0, 0, 0, 0, time.UTC)),
+	)
+
+	It("Should reject days which do not exist", func() {
+		_, err := bsonframe.ParseLocalizedDate("31/02/2021", "dd/mm/yyyy")
+		Expect(err).To(HaveOccurred())
+		_, err = bsonframe.ParseLocalizedDate("13/13/2021", "dd/mm/yyyy")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,96 @@
+package bsonframe
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DBRefFormatJSON renders DBRefs as a JSON cell with the fields in canonical order. This is the default.
+	DBRefFormatJSON = "json"
+	// DBRefFormatString renders DBRefs as a db.collection/id string
+	DBRefFormatString = "string"
+
+	// DecimalModeFloat converts Decimal128 values to float64, which may lose precision. This is the default.
+	DecimalModeFloat = "float"
+	// DecimalModeString converts Decimal128 values to their exact string representation
+	DecimalModeString = "string"
+
+	// BinaryModeHex renders binary values as hex strings. This is the default.
+	BinaryModeHex = "hex"
+	// BinaryModeBase64 renders binary values as standard base64 strings
+	BinaryModeBase64 = "base64"
+	// BinaryModeUUID renders binary values of the UUID subtypes (3 and 4) as hyphenated UUIDs, and all others as hex strings
+	BinaryModeUUID = "uuid"
+
+	// ExtJSONRelaxed renders document and array cells as relaxed extended JSON, where numbers and dates are plain JSON. This is the default.
+	ExtJSONRelaxed = "relaxed"
+	// ExtJSONCanonical renders document and array cells as canonical extended JSON, which preserves the exact BSON type of every value
+	ExtJSONCanonical = "canonical"
+)
+
+// ConversionOptions control how BSON values are converted to Grafana values.
+// The zero value converts values as faithfully as possible, with no limits.
+type ConversionOptions struct {
+	// DBRefFormat is how DBRef documents are rendered, either DBRefFormatJSON (the default) or DBRefFormatString
+	DBRefFormat string
+	// MaxCellDepth is the maximum nesting depth of a document or array cell, or zero for no limit
+	MaxCellDepth int
+	// MaxCellBytes is the maximum size of a document or array cell once marshaled, or zero for no limit
+	MaxCellBytes int
+	// NullString, if not nil, replaces null or absent values in string columns
+	NullString *string
+	// Locale controls the parsing of strings found in numeric or time columns
+	Locale LocaleOptions
+	// Location, if not nil, is the timezone times are converted to, and that dates parsed without a timezone are assumed to be in.
+	// If nil, dates without a timezone are assumed to be UTC.
+	Location *time.Location
+	// DecimalMode is how Decimal128 values are converted, either DecimalModeFloat (the default) or DecimalModeString
+	DecimalMode string
+	// BinaryMode is how binary values without a registered converter for their subtype are rendered,
+	// one of BinaryModeHex (the default), BinaryModeBase64, or BinaryModeUUID
+	BinaryMode string
+	// ExtJSONFlavor is the flavor of extended JSON document and array cells are rendered as, either ExtJSONRelaxed (the default) or ExtJSONCanonical
+	ExtJSONFlavor string
+}
+
+// Validate checks that the options are supported
+func (o *ConversionOptions) Validate() error {
+	switch o.DBRefFormat {
+	case "", DBRefFormatJSON, DBRefFormatString:
+	default:
+		return fmt.Errorf("DBRef format must be one of: %s, %s", DBRefFormatJSON, DBRefFormatString)
+	}
+	switch o.DecimalMode {
+	case "", DecimalModeFloat, DecimalModeString:
+	default:
+		return fmt.Errorf("Decimal mode must be one of: %s, %s", DecimalModeFloat, DecimalModeString)
+	}
+	switch o.BinaryMode {
+	case "", BinaryModeHex, BinaryModeBase64, BinaryModeUUID:
+	default:
+		return fmt.Errorf("Binary mode must be one of: %s, %s, %s", BinaryModeHex, BinaryModeBase64, BinaryModeUUID)
+	}
+	switch o.ExtJSONFlavor {
+	case "", ExtJSONRelaxed, ExtJSONCanonical:
+	default:
+		return fmt.Errorf("Extended JSON flavor must be one of: %s, %s", ExtJSONRelaxed, ExtJSONCanonical)
+	}
+	if o.MaxCellDepth < 0 || o.MaxCellBytes < 0 {
+		return fmt.Errorf("Cell limits must not be negative")
+	}
+	return o.Locale.Validate()
+}
+
+// localTime converts a time to the configured location, if any
+func (o *ConversionOptions) localTime(t time.Time) time.Time {
+	if o.Location == nil {
+		return t
+	}
+	return t.In(o.Location)
+}
+
+// canonical returns true if cells should be rendered as canonical extended JSON
+func (o *ConversionOptions) canonical() bool {
+	return o.ExtJSONFlavor == ExtJSONCanonical
+}
@@ -0,0 +1,68 @@
+package bsonframe_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConversionOptions", func() {
+	uuid := bsonPrim.Binary{Subtype: 0x04, Data: []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}}
+
+	DescribeTable("Should convert binary values", func(mode string, value bsonPrim.Binary, expected string) {
+		converted, type_, err := bsonframe.ToGrafanaValue(value, &bsonframe.ConversionOptions{BinaryMode: mode})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(type_).To(Equal(data.FieldTypeString))
+		Expect(converted).To(Equal(expected))
+	},
+		Entry("as hex by default", "", bsonPrim.Binary{Data: []byte("ab")}, "6162"),
+		Entry("as base64", bsonframe.BinaryModeBase64, bsonPrim.Binary{Data: []byte("ab")}, "YWI="),
+		Entry("as a UUID", bsonframe.BinaryModeUUID, uuid, "12345678-9abc-def0-1234-56789abcdef0"),
+		Entry("as hex if not a UUID subtype", bsonframe.BinaryModeUUID, bsonPrim.Binary{Data: []byte("ab")}, "6162"),
+	)
+
+	It("Should convert decimals to exact strings", func() {
+		decimal, err := bsonPrim.ParseDecimal128("0.1000000000000000000001")
+		Expect(err).ToNot(HaveOccurred())
+		converted, type_, err := bsonframe.ToGrafanaValue(decimal, &bsonframe.ConversionOptions{DecimalMode: bsonframe.DecimalModeString})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(type_).To(Equal(data.FieldTypeString))
+		Expect(converted).To(Equal("0.1000000000000000000001"))
+	})
+
+	It("Should render canonical extended JSON", func() {
+		converted, _, err := bsonframe.ToGrafanaValue(bsonPrim.D{{Key: "x", Value: int32(1)}}, &bsonframe.ConversionOptions{ExtJSONFlavor: bsonframe.ExtJSONCanonical})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(converted).To(Equal(json.RawMessage(`{"x":{"$numberInt":"1"}}`)))
+	})
+
+	It("Should convert times to the configured location", func() {
+		location := time.FixedZone("test", 3600)
+		opts := &bsonframe.ConversionOptions{Location: location}
+		converted, _, err := bsonframe.ToGrafanaValue(bsonPrim.NewDateTimeFromTime(time.Unix(0, 0)), opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(converted.(time.Time).Location()).To(Equal(location))
+
+		opts.Locale.DateFormat = bsonframe.DateFormatDMY
+		column := bsonframe.NewColumn("t", data.FieldTypeTime)
+		parsed, err := column.Convert("02/01/1970", opts, &bsonframe.Stats{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.(time.Time).Unix()).To(Equal(int64(86400 - 3600)))
+	})
+
+	DescribeTable("Should reject", func(opts bsonframe.ConversionOptions) {
+		Expect(opts.Validate()).To(HaveOccurred())
+	},
+		Entry("an unknown decimal mode", bsonframe.ConversionOptions{DecimalMode: "exact"}),
+		Entry("an unknown binary mode", bsonframe.ConversionOptions{BinaryMode: "octal"}),
+		Entry("an unknown extended JSON flavor", bsonframe.ConversionOptions{ExtJSONFlavor: "strict"}),
+		Entry("a negative depth", bsonframe.ConversionOptions{MaxCellDepth: -1}),
+	)
+})
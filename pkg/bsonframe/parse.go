@@ -0,0 +1,72 @@
+package bsonframe
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// isoDurationPattern matches ISO-8601 durations, such as P1DT2H or PT0.5S
+var isoDurationPattern = regexp.MustCompile(`^([-+])?P(?:([\d.,]+)Y)?(?:([\d.,]+)M)?(?:([\d.,]+)W)?(?:([\d.,]+)D)?(?:T(?:([\d.,]+)H)?(?:([\d.,]+)M)?(?:([\d.,]+)S)?)?$`)
+
+// isoDurationSeconds are the lengths in seconds of each component matched by isoDurationPattern.
+// Years and months have no fixed length, and are taken to be 365 and 30 days.
+var isoDurationSeconds = []float64{365 * 86400, 30 * 86400, 7 * 86400, 86400, 3600, 60, 1}
+
+// ParseISODuration parses an ISO-8601 duration, such as P1DT2H30M, into seconds
+func ParseISODuration(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+	match := isoDurationPattern.FindStringSubmatch(text)
+	if match == nil || text == "P" || strings.HasSuffix(text, "T") {
+		return 0, fmt.Errorf("Invalid ISO-8601 duration %q", text)
+	}
+	seconds := 0.0
+	for ix, part := range match[2:] {
+		if part == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.Replace(part, ",", ".", 1), 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid ISO-8601 duration %q", text)
+		}
+		seconds += value * isoDurationSeconds[ix]
+	}
+	if match[1] == "-" {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
+
+// CoerceBool converts the values legacy collections commonly use for flags into a boolean:
+// the numbers 0 and 1, and (case insensitively) the strings true/false, t/f, yes/no, y/n, on/off, and 1/0
+func CoerceBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int32:
+		return numberAsBool(float64(v))
+	case int64:
+		return numberAsBool(float64(v))
+	case float64:
+		return numberAsBool(v)
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "t", "yes", "y", "on", "1":
+			return true, nil
+		case "false", "f", "no", "n", "off", "0":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("%#v cannot be interpreted as a boolean", value)
+}
+
+func numberAsBool(value float64) (bool, error) {
+	switch value {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, fmt.Errorf("%v cannot be interpreted as a boolean, only 0 and 1 can", value)
+}
@@ -0,0 +1,103 @@
+package bsonframe
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// extJSONBufferSize is the initial capacity of pooled scratch buffers used to marshal document and array cells
+	extJSONBufferSize = 1 << 10
+	// maxPooledExtJSONBufferSize is the largest capacity of scratch buffer returned to the pool.
+	// Buffers which grew beyond this while marshaling an unusually large cell are left to the garbage collector,
+	// so that the pool does not hold on to them indefinitely.
+	maxPooledExtJSONBufferSize = 1 << 16
+	// cellArenaChunkSize is the size of the chunks that marshaled cells are copied into.
+	// Cells larger than this get their own allocation.
+	cellArenaChunkSize = 1 << 14
+)
+
+// extJSONBufferPool holds scratch buffers for bson.MarshalExtJSONAppend so that each
+// document/array cell doesn't have to grow a fresh buffer from scratch
+var extJSONBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, extJSONBufferSize)
+		return &buf
+	},
+}
+
+// cellArena hands out json.RawMessage's carved from a shared chunk, so that many small cells
+// share a single allocation instead of each having their own.
+// Slices handed out are capacity-limited, so appending to one can never overwrite another,
+// and the arena only ever moves forward, so it is safe to return to the pool while
+// previously handed out cells are still in use.
+type cellArena struct {
+	chunk []byte
+}
+
+var cellArenaPool = sync.Pool{
+	New: func() interface{} {
+		return &cellArena{}
+	},
+}
+
+func (a *cellArena) copy(src []byte) json.RawMessage {
+	if len(src) > cellArenaChunkSize {
+		return json.RawMessage(append([]byte(nil), src...))
+	}
+	if cap(a.chunk)-len(a.chunk) < len(src) {
+		a.chunk = make([]byte, 0, cellArenaChunkSize)
+	}
+	start := len(a.chunk)
+	a.chunk = append(a.chunk, src...)
+	return json.RawMessage(a.chunk[start:len(a.chunk):len(a.chunk)])
+}
+
+// marshalExtJSONCell marshals a value to relaxed or canonical extended JSON using a pooled scratch buffer,
+// optionally trimming a prefix and suffix, and returns a copy backed by a pooled arena
+func marshalExtJSONCell(value interface{}, canonical bool, trimPrefix, trimSuffix int) (json.RawMessage, error) {
+	bufPtr := extJSONBufferPool.Get().(*[]byte)
+	bytes, err := bson.MarshalExtJSONAppend((*bufPtr)[:0], value, canonical, false)
+	if err != nil {
+		extJSONBufferPool.Put(bufPtr)
+		return nil, err
+	}
+	// Keep the (possibly grown) buffer for next time, unless it grew too large
+	if cap(bytes) <= maxPooledExtJSONBufferSize {
+		*bufPtr = bytes[:0]
+		defer extJSONBufferPool.Put(bufPtr)
+	}
+
+	arena := cellArenaPool.Get().(*cellArena)
+	defer cellArenaPool.Put(arena)
+
+	return arena.copy(bytes[trimPrefix : len(bytes)-trimSuffix]), nil
+}
+
+// DetachCells replaces every JSON cell of a set of frames with its own copy.
+// Cells produced by marshalExtJSONCell share arena chunks, so frames which are kept after a query, such as by a cache,
+// should be detached first, so that a few retained cells do not keep entire chunks alive.
+func DetachCells(frames []*data.Frame) {
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			switch field.Type() {
+			case data.FieldTypeJSON:
+				for ix := 0; ix < field.Len(); ix++ {
+					field.Set(ix, append(json.RawMessage(nil), field.At(ix).(json.RawMessage)...))
+				}
+			case data.FieldTypeNullableJSON:
+				for ix := 0; ix < field.Len(); ix++ {
+					cell := field.At(ix).(*json.RawMessage)
+					if cell == nil {
+						continue
+					}
+					copied := append(json.RawMessage(nil), *cell...)
+					field.Set(ix, &copied)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package bsonframe
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conversion buffer pools", func() {
+	It("Should not return buffers which grew too large to the pool", func() {
+		large := bson.M{"value": strings.Repeat("x", 2*maxPooledExtJSONBufferSize)}
+		cell, err := marshalExtJSONCell(large, false, 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(cell)).To(BeNumerically(">", maxPooledExtJSONBufferSize))
+
+		buffers := make([]*[]byte, 0, 8)
+		for ix := 0; ix < cap(buffers); ix++ {
+			buf := extJSONBufferPool.Get().(*[]byte)
+			Expect(cap(*buf)).To(BeNumerically("<=", maxPooledExtJSONBufferSize))
+			buffers = append(buffers, buf)
+		}
+		for _, buf := range buffers {
+			extJSONBufferPool.Put(buf)
+		}
+	})
+
+	It("Should hand out arena cells which cannot overwrite each other", func() {
+		arena := &cellArena{}
+		first := arena.copy([]byte(`{"a":1}`))
+		second := arena.copy([]byte(`{"b":2}`))
+		Expect(cap(first)).To(Equal(len(first)))
+		first = append(first, '!')
+		Expect(string(second)).To(Equal(`{"b":2}`))
+	})
+
+	It("Should detach JSON cells from the arena", func() {
+		arena := &cellArena{}
+		cell := arena.copy([]byte(`{"a":1}`))
+		nullableCell := arena.copy([]byte(`[1,2]`))
+		frame := data.NewFrame("",
+			data.NewField("json", nil, []json.RawMessage{cell}),
+			data.NewField("nullable", nil, []*json.RawMessage{&nullableCell, nil}),
+		)
+
+		DetachCells([]*data.Frame{frame})
+
+		detached := frame.Fields[0].At(0).(json.RawMessage)
+		Expect(detached).To(Equal(cell))
+		Expect(&detached[0]).ToNot(BeIdenticalTo(&cell[0]))
+		nullableDetached := frame.Fields[1].At(0).(*json.RawMessage)
+		Expect(*nullableDetached).To(Equal(nullableCell))
+		Expect(&(*nullableDetached)[0]).ToNot(BeIdenticalTo(&nullableCell[0]))
+		Expect(frame.Fields[1].At(1)).To(BeNil())
+	})
+})
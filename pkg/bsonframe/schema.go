@@ -1,23 +1,32 @@
-package plugin
+package bsonframe
 
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
-type schemaInferenceState struct {
+// SchemaInference guesses the columns of a frame from a sample of documents.
+// Fields absent from some documents are nullable, and fields which appear with different types are an error.
+type SchemaInference struct {
+	// Columns, if not nil, restricts inference to the named fields
+	Columns map[string]struct{}
+	// Opts are used to convert sampled values, which determines their types
+	Opts ConversionOptions
+
 	typeGuesses map[string]data.FieldType
 	currentRow  map[string]data.FieldType
 	ignored     map[string]struct{}
 	afterFirst  bool
 }
 
-func NewSchemaInference(ignored map[string]struct{}) schemaInferenceState {
-	return schemaInferenceState{
+// NewSchemaInference starts inferring a schema, ignoring the named fields
+func NewSchemaInference(ignored map[string]struct{}) SchemaInference {
+	return SchemaInference{
 		typeGuesses: make(map[string]data.FieldType),
 		currentRow:  make(map[string]data.FieldType),
 		ignored:     ignored,
@@ -25,11 +34,20 @@ func NewSchemaInference(ignored map[string]struct{}) schemaInferenceState {
 	}
 }
 
-func (s *schemaInferenceState) updateField(name string, value interface{}) error {
+func (s *SchemaInference) updateField(name string, value interface{}) error {
 	if _, ignored := s.ignored[name]; ignored {
 		return nil
 	}
-	_, guess, err := ToGrafanaValue(value)
+	if s.Columns != nil {
+		if _, included := s.Columns[name]; !included {
+			return nil
+		}
+	}
+	_, guess, err := ToGrafanaValue(value, &s.Opts)
+	if _, truncated := err.(*TruncatedCellError); truncated {
+		// The cell will be replaced by a JSON marker
+		err = nil
+	}
 	if err != nil {
 		return err
 	}
@@ -42,7 +60,8 @@ func (s *schemaInferenceState) updateField(name string, value interface{}) error
 	return nil
 }
 
-func (s *schemaInferenceState) updateDoc(doc timestepDocument) error {
+// UpdateDoc updates the guessed types from the fields of a document
+func (s *SchemaInference) UpdateDoc(doc Document) error {
 	for name, value := range doc {
 		err := s.updateField(name, value)
 		if err != nil {
@@ -52,7 +71,7 @@ func (s *schemaInferenceState) updateDoc(doc timestepDocument) error {
 	return s.nextDoc()
 }
 
-func (s *schemaInferenceState) nextDoc() error {
+func (s *SchemaInference) nextDoc() error {
 	mismatchOld := make(map[string]data.FieldType, len(s.typeGuesses))
 	mismatchNew := make(map[string]data.FieldType, len(s.typeGuesses))
 	if s.afterFirst {
@@ -107,10 +126,20 @@ func (s *schemaInferenceState) nextDoc() error {
 	return nil
 }
 
-func (s *schemaInferenceState) finish() []field {
-	fields := make([]field, 0, len(s.typeGuesses))
-	for name, guess := range s.typeGuesses {
-		fields = append(fields, field{Name: name, Type: guess})
+// Finish produces the inferred columns, ordered by name, so that the same documents always produce the same columns
+func (s *SchemaInference) Finish() []Column {
+	names := make([]string, 0, len(s.typeGuesses))
+	for name := range s.typeGuesses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	columns := make([]Column, 0, len(s.typeGuesses))
+	for _, name := range names {
+		column := NewColumn(name, s.typeGuesses[name])
+		// Inferred types are only a guess based on the first N documents,
+		// so later documents are coerced to them where possible
+		column.Coerce = true
+		columns = append(columns, column)
 	}
-	return fields
+	return columns
 }
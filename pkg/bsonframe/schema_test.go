@@ -0,0 +1,71 @@
+package bsonframe_test
+
+import (
+	"math"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SchemaInference", func() {
+	It("Should infer from the first documents, and coerce later mismatches with a notice", func() {
+		docs := []bsonframe.Document{
+			{"n": int64(1), "host": "a"},
+			{"n": int64(2)},
+			{"n": float64(3), "host": "b"},
+			{"n": int32(4), "host": "c"},
+			{"n": float64(5.5), "host": "d"},
+		}
+		inference := bsonframe.NewSchemaInference(nil)
+		for _, doc := range docs[:2] {
+			Expect(inference.UpdateDoc(doc)).To(Succeed())
+		}
+		columns := inference.Finish()
+		Expect(columns).To(HaveLen(2))
+		Expect(columns[0].Name).To(Equal("host"))
+		Expect(columns[0].Type).To(Equal(data.FieldTypeNullableString))
+		Expect(columns[1].Name).To(Equal("n"))
+		Expect(columns[1].Type).To(Equal(data.FieldTypeInt64))
+
+		frame := bsonframe.NewFrame("test", columns)
+		stats := bsonframe.Stats{}
+		for _, doc := range docs[:4] {
+			Expect(bsonframe.AppendDocument(frame, columns, doc, nil, &stats)).To(Succeed())
+		}
+		Expect(frame.Rows()).To(Equal(4))
+		Expect(frame.Fields[1].At(2)).To(Equal(int64(3)))
+		Expect(frame.Fields[1].At(3)).To(Equal(int64(4)))
+		notices := stats.Notices()
+		Expect(notices).To(HaveLen(2))
+		Expect(notices[0].Text).To(HavePrefix("Field n: 1 value(s) of type []int32"))
+		Expect(notices[1].Text).To(HavePrefix("Field n: 1 value(s) of type []float64"))
+
+		// A fraction cannot be coerced to an integer without losing precision
+		Expect(bsonframe.AppendDocument(frame, columns, docs[4], nil, &stats)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("CoerceValue", func() {
+	DescribeTable("Should coerce to int64", func(value float64, expected int64) {
+		coerced, ok := bsonframe.CoerceValue(value, data.FieldTypeInt64)
+		Expect(ok).To(BeTrue())
+		Expect(coerced).To(Equal(expected))
+	},
+		Entry("whole numbers", float64(42), int64(42)),
+		Entry("the smallest int64", float64(math.MinInt64), int64(math.MinInt64)),
+		Entry("the largest float64 below 2^63", math.Nextafter(1<<63, 0), int64(math.Nextafter(1<<63, 0))),
+	)
+
+	DescribeTable("Should not coerce to int64", func(value float64) {
+		_, ok := bsonframe.CoerceValue(value, data.FieldTypeInt64)
+		Expect(ok).To(BeFalse())
+	},
+		Entry("fractions", 1.5),
+		Entry("2^63, which overflows", float64(1<<63)),
+		Entry("values below the smallest int64", -math.Nextafter(1<<63, math.Inf(1))),
+	)
+})
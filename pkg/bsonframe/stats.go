@@ -0,0 +1,156 @@
+package bsonframe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type coercion struct {
+	from data.FieldType
+	to   data.FieldType
+}
+
+// Stats tracks lossy or unexpected conversions made while building frames
+// so that they can be reported to the user instead of happening silently.
+// The zero value is ready to use, and is not safe for concurrent use.
+type Stats struct {
+	coerced map[string]map[coercion]int
+	// truncated counts, for each field, the cells replaced by a marker, for each limit exceeded
+	truncated map[string]map[string]int
+	// dropped counts, for each field not in the schema, the documents it was dropped from
+	dropped map[string]int
+}
+
+// RecordCoercion records that a value of a column was coerced from one type to another
+func (s *Stats) RecordCoercion(name string, from, to data.FieldType) {
+	s.addCoercions(name, coercion{from: from, to: to}, 1)
+}
+
+func (s *Stats) addCoercions(name string, c coercion, count int) {
+	if s.coerced == nil {
+		s.coerced = make(map[string]map[coercion]int)
+	}
+	fieldCoerced, ok := s.coerced[name]
+	if !ok {
+		fieldCoerced = make(map[coercion]int)
+		s.coerced[name] = fieldCoerced
+	}
+	fieldCoerced[c] += count
+}
+
+// RecordTruncation records that a value of a column exceeded a limit, and was replaced by a marker
+func (s *Stats) RecordTruncation(name string, limit string) {
+	s.addTruncations(name, limit, 1)
+}
+
+func (s *Stats) addTruncations(name string, limit string, count int) {
+	if s.truncated == nil {
+		s.truncated = make(map[string]map[string]int)
+	}
+	fieldTruncated, ok := s.truncated[name]
+	if !ok {
+		fieldTruncated = make(map[string]int)
+		s.truncated[name] = fieldTruncated
+	}
+	fieldTruncated[limit] += count
+}
+
+// RecordDroppedField records that a document had a field which is not in the schema, and so was left out of the frame
+func (s *Stats) RecordDroppedField(name string) {
+	s.addDroppedFields(name, 1)
+}
+
+func (s *Stats) addDroppedFields(name string, count int) {
+	if s.dropped == nil {
+		s.dropped = make(map[string]int)
+	}
+	s.dropped[name] += count
+}
+
+// Merge adds the counts from another set of stats to these
+func (s *Stats) Merge(other *Stats) {
+	for name, fieldCoerced := range other.coerced {
+		for c, count := range fieldCoerced {
+			s.addCoercions(name, c, count)
+		}
+	}
+	for name, fieldTruncated := range other.truncated {
+		for limit, count := range fieldTruncated {
+			s.addTruncations(name, limit, count)
+		}
+	}
+	for name, count := range other.dropped {
+		s.addDroppedFields(name, count)
+	}
+}
+
+// sortedKeys returns the keys of a map of counts by name, sorted
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Notices produces a notice for each field that had at least one value coerced or truncated, in field name order,
+// followed by a single notice listing the fields which were dropped
+func (s *Stats) Notices() []data.Notice {
+	names := make([]string, 0, len(s.coerced))
+	for name := range s.coerced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	truncatedNames := make([]string, 0, len(s.truncated))
+	for name := range s.truncated {
+		truncatedNames = append(truncatedNames, name)
+	}
+	sort.Strings(truncatedNames)
+
+	notices := make([]data.Notice, 0, len(names)+len(truncatedNames)+1)
+	for _, name := range names {
+		coercions := make([]coercion, 0, len(s.coerced[name]))
+		for c := range s.coerced[name] {
+			coercions = append(coercions, c)
+		}
+		sort.Slice(coercions, func(i, j int) bool {
+			if coercions[i].from != coercions[j].from {
+				return coercions[i].from < coercions[j].from
+			}
+			return coercions[i].to < coercions[j].to
+		})
+		for _, c := range coercions {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Field %s: %d value(s) of type %s did not match the inferred type and were coerced to %s", name, s.coerced[name][c], c.from, c.to),
+			})
+		}
+	}
+	for _, name := range truncatedNames {
+		for _, limit := range sortedKeys(s.truncated[name]) {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Field %s: %d value(s) exceeded the maximum %s and were replaced with a truncation marker", name, s.truncated[name][limit], limit),
+			})
+		}
+	}
+	if len(s.dropped) != 0 {
+		dropped := sortedKeys(s.dropped)
+		counts := make([]string, len(dropped))
+		for ix, name := range dropped {
+			counts[ix] = fmt.Sprintf("%s (%d)", name, s.dropped[name])
+		}
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf(
+				"Some documents had fields which were not seen during schema inference, and were left out: %s. Increase the schema inference depth to include them",
+				strings.Join(counts, ", "),
+			),
+		})
+	}
+	return notices
+}
@@ -0,0 +1,39 @@
+package bsonframe_test
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stats", func() {
+	It("Should report dropped fields in a single notice", func() {
+		stats := bsonframe.Stats{}
+		stats.RecordDroppedField("late")
+		other := bsonframe.Stats{}
+		other.RecordDroppedField("late")
+		other.RecordDroppedField("early")
+		stats.Merge(&other)
+		notices := stats.Notices()
+		Expect(notices).To(HaveLen(1))
+		Expect(notices[0].Severity).To(Equal(data.NoticeSeverityWarning))
+		Expect(notices[0].Text).To(ContainSubstring("early (1), late (2)"))
+	})
+
+	It("Should order notices for the same field deterministically", func() {
+		stats := bsonframe.Stats{}
+		stats.RecordCoercion("x", data.FieldTypeString, data.FieldTypeFloat64)
+		stats.RecordCoercion("x", data.FieldTypeInt64, data.FieldTypeFloat64)
+		stats.RecordTruncation("x", "depth")
+		stats.RecordTruncation("x", "bytes")
+		notices := stats.Notices()
+		Expect(notices).To(HaveLen(4))
+		Expect(notices[0].Text).To(ContainSubstring("[]int64"))
+		Expect(notices[1].Text).To(ContainSubstring("[]string"))
+		Expect(notices[2].Text).To(ContainSubstring("maximum bytes"))
+		Expect(notices[3].Text).To(ContainSubstring("maximum depth"))
+	})
+})
@@ -0,0 +1,32 @@
+package bsonframe_test
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scaled columns", func() {
+	It("Should scale numbers of any type, and leave nulls", func() {
+		column := bsonframe.NewColumn("used", data.FieldTypeNullableFloat64)
+		column.Coerce = true
+		column.Scale = 100
+		stats := bsonframe.Stats{}
+		opts := bsonframe.ConversionOptions{}
+
+		converted, err := column.Convert(0.25, &opts, &stats)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*converted.(*float64)).To(Equal(25.0))
+
+		converted, err = column.Convert(int32(2), &opts, &stats)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*converted.(*float64)).To(Equal(200.0))
+
+		converted, err = column.Convert(nil, &opts, &stats)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(converted).To(BeNil())
+	})
+})
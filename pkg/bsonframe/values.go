@@ -0,0 +1,222 @@
+package bsonframe
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Document is a document as decoded by the driver into an empty interface
+type Document = map[string]interface{}
+
+// ToGrafanaValue converts a value decoded by the driver into a Grafana value and its field type, using the registered converters.
+// Null and undefined values produce nil with an unknown type. Nil options are treated as the zero value.
+// Only the types documented as being returned by bson.Unmarshal are handled, notably not pointer types, like *float64.
+func ToGrafanaValue(value interface{}, opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	// https://pkg.go.dev/go.mongodb.org/mongo-driver@v1.11.1/bson#hdr-Native_Go_Types
+	if opts == nil {
+		opts = &ConversionOptions{}
+	}
+	if value == nil {
+		return nil, data.FieldTypeUnknown, nil
+	}
+	converter, ok := lookupConverter(value)
+	if !ok {
+		return nil, data.FieldTypeUnknown, fmt.Errorf("Got value with a type not expected to be generated by BSON: %#v (%s)", value, reflect.ValueOf(value).Type())
+	}
+	return converter(value, opts)
+}
+
+// ConvertValue converts a value like ToGrafanaValue, and, if nullable is true, into the nullable form of its type
+func ConvertValue(value interface{}, nullable bool, opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	converted, type_, err := ToGrafanaValue(value, opts)
+	if err != nil {
+		return nil, type_, err
+	}
+	if converted == nil {
+		return nil, type_, nil
+	}
+	if !nullable {
+		return converted, type_, nil
+	}
+
+	return MakeNullable(converted), type_.NullableType(), nil
+}
+
+// MakeNullable converts a scalar value into a pointer to that value
+func MakeNullable(converted interface{}) interface{} {
+	// Adding e.g. a float64 to a frame of *float64 is not handled seamlessly,
+	// we have do it manually
+	// We can't just do valueValueValue.Addr().Interface(), as scalar's aren't addressable
+	convertedValue := reflect.ValueOf(converted)
+	convertedPtr := reflect.New(convertedValue.Type())
+	convertedPtr.Elem().Set(convertedValue)
+	return convertedPtr.Interface()
+}
+
+// dbRef is a document following the DBRef convention
+// https://www.mongodb.com/docs/manual/reference/database-references/#dbrefs
+type dbRef struct {
+	Ref   interface{}
+	ID    interface{}
+	DB    interface{}
+	Extra bsonPrim.D
+}
+
+// asDBRef checks if a value is a DBRef-shaped document, that is, one with a $ref and $id field, and optionally a $db field
+func asDBRef(value interface{}) (dbRef, bool) {
+	var doc bsonPrim.D
+	switch v := value.(type) {
+	case bsonPrim.D:
+		doc = v
+	case bsonPrim.M:
+		doc = sortedDoc(v)
+	case map[string]interface{}:
+		doc = sortedDoc(v)
+	default:
+		return dbRef{}, false
+	}
+	if len(doc) < 2 {
+		return dbRef{}, false
+	}
+	ref := dbRef{}
+	hasRef, hasID := false, false
+	for _, elem := range doc {
+		switch elem.Key {
+		case "$ref":
+			ref.Ref = elem.Value
+			hasRef = true
+		case "$id":
+			ref.ID = elem.Value
+			hasID = true
+		case "$db":
+			ref.DB = elem.Value
+		default:
+			ref.Extra = append(ref.Extra, elem)
+		}
+	}
+	if !hasRef || !hasID {
+		return dbRef{}, false
+	}
+	if _, ok := ref.Ref.(string); !ok {
+		return dbRef{}, false
+	}
+	return ref, true
+}
+
+func (r dbRef) toGrafanaValue(opts *ConversionOptions) (interface{}, data.FieldType, error) {
+	if opts.DBRefFormat == DBRefFormatString {
+		id, _, err := ToGrafanaValue(r.ID, opts)
+		if err != nil {
+			return nil, data.FieldTypeUnknown, err
+		}
+		if r.DB != nil {
+			return fmt.Sprintf("%v.%v/%v", r.DB, r.Ref, id), data.FieldTypeString, nil
+		}
+		return fmt.Sprintf("%v/%v", r.Ref, id), data.FieldTypeString, nil
+	}
+
+	// Always produce the fields in the canonical order, regardless of how they were decoded
+	doc := bsonPrim.D{
+		{Key: "$ref", Value: r.Ref},
+		{Key: "$id", Value: r.ID},
+	}
+	if r.DB != nil {
+		doc = append(doc, bsonPrim.E{Key: "$db", Value: r.DB})
+	}
+	doc = append(doc, r.Extra...)
+	bytes, err := marshalExtJSONCell(doc, opts.canonical(), 0, 0)
+	if err != nil {
+		return nil, data.FieldTypeUnknown, err
+	}
+	return bytes, data.FieldTypeJSON, nil
+}
+
+// TruncatedCellError indicates a document or array cell exceeded one of the limits in its ConversionOptions,
+// and should be replaced by its marker
+type TruncatedCellError struct {
+	// Limit describes the limit which was exceeded
+	Limit string
+}
+
+func (e *TruncatedCellError) Error() string {
+	return fmt.Sprintf("Cell exceeded the maximum %s", e.Limit)
+}
+
+// Marker is the JSON cell which replaces the truncated value
+func (e *TruncatedCellError) Marker() json.RawMessage {
+	marker, _ := json.Marshal(map[string]string{"$truncated": e.Error()})
+	return marker
+}
+
+// exceedsDepth returns true if a value has documents or arrays nested more than maxDepth deep, counting the value itself.
+// Traversal stops as soon as the limit is exceeded, so pathological values are not walked in full.
+func exceedsDepth(value interface{}, maxDepth int) bool {
+	switch v := value.(type) {
+	case bsonPrim.D:
+		if maxDepth == 0 {
+			return true
+		}
+		for _, elem := range v {
+			if exceedsDepth(elem.Value, maxDepth-1) {
+				return true
+			}
+		}
+	case bsonPrim.M:
+		if maxDepth == 0 {
+			return true
+		}
+		for _, elem := range v {
+			if exceedsDepth(elem, maxDepth-1) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		if maxDepth == 0 {
+			return true
+		}
+		for _, elem := range v {
+			if exceedsDepth(elem, maxDepth-1) {
+				return true
+			}
+		}
+	case bsonPrim.A:
+		if maxDepth == 0 {
+			return true
+		}
+		for _, elem := range v {
+			if exceedsDepth(elem, maxDepth-1) {
+				return true
+			}
+		}
+	case []interface{}:
+		if maxDepth == 0 {
+			return true
+		}
+		for _, elem := range v {
+			if exceedsDepth(elem, maxDepth-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortedDoc converts a map into a document with its keys in sorted order
+func sortedDoc(m map[string]interface{}) bsonPrim.D {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	doc := make(bsonPrim.D, len(keys))
+	for ix, key := range keys {
+		doc[ix] = bsonPrim.E{Key: key, Value: m[key]}
+	}
+	return doc
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// addressSortKeySuffix is appended to the name of an address field to name its sort key field
+const addressSortKeySuffix = "_sortKey"
+
+// ipSortKey returns a normalized form of an IP address, and a key which sorts addresses numerically.
+// IPv6 addresses do not fit in any numeric field type, so the key is the 16 byte form of the address as fixed width hex,
+// which sorts lexically in the same order. IPv4 addresses use their IPv4-mapped IPv6 form, and so sort together.
+func ipSortKey(text string) (string, string, bool) {
+	ip := net.ParseIP(strings.TrimSpace(text))
+	if ip == nil {
+		return "", "", false
+	}
+	return ip.String(), hex.EncodeToString(ip.To16()), true
+}
+
+// macSortKey returns a normalized (lowercase, colon separated) form of a MAC address, and a key which sorts addresses numerically
+func macSortKey(text string) (string, string, bool) {
+	mac, err := net.ParseMAC(strings.TrimSpace(text))
+	if err != nil {
+		return "", "", false
+	}
+	return mac.String(), hex.EncodeToString(mac), true
+}
+
+// AddAddressSortKeys normalizes the string fields named in ipFields and macFields as IP and MAC addresses,
+// and adds a hidden field after each, which the table can be sorted by to order the addresses numerically.
+// Values which are not valid addresses are left as-is, and have a null sort key.
+func AddAddressSortKeys(frames []*data.Frame, ipFields []string, macFields []string) error {
+	if len(ipFields) == 0 && len(macFields) == 0 {
+		return nil
+	}
+	parsers := make(map[string]func(string) (string, string, bool), len(ipFields)+len(macFields))
+	for _, name := range ipFields {
+		parsers[name] = ipSortKey
+	}
+	for _, name := range macFields {
+		parsers[name] = macSortKey
+	}
+	for _, frame := range frames {
+		fields := make([]*data.Field, 0, len(frame.Fields))
+		for _, field := range frame.Fields {
+			fields = append(fields, field)
+			parse, ok := parsers[field.Name]
+			if !ok {
+				continue
+			}
+			if field.Type().NonNullableType() != data.FieldTypeString {
+				return fmt.Errorf("Address field %s must be a string, but is %s", field.Name, field.Type())
+			}
+			keys := make([]*string, field.Len())
+			for row := 0; row < field.Len(); row++ {
+				value, ok := field.ConcreteAt(row)
+				if !ok {
+					continue
+				}
+				normalized, key, ok := parse(value.(string))
+				if !ok {
+					continue
+				}
+				field.SetConcrete(row, normalized)
+				keys[row] = &key
+			}
+			sortKey := data.NewField(field.Name+addressSortKeySuffix, field.Labels, keys)
+			sortKey.Config = &data.FieldConfig{
+				Custom: map[string]interface{}{
+					"hidden":   true,
+					"hideFrom": map[string]bool{"viz": true, "legend": true, "tooltip": true},
+				},
+			}
+			fields = append(fields, sortKey)
+		}
+		frame.Fields = fields
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package plugin_test
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AddAddressSortKeys", func() {
+	It("Should add sort keys which order addresses numerically", func() {
+		ip, mac := "10.0.0.10", "AA-BB-CC-00-11-22"
+		frame := data.NewFrame("",
+			data.NewField("ip", nil, []string{"10.0.0.9", " 10.0.0.10", "::1", "bogus"}),
+			data.NewField("mac", nil, []*string{&mac, nil, &ip, &mac}),
+		)
+		Expect(plugin.AddAddressSortKeys([]*data.Frame{frame}, []string{"ip"}, []string{"mac"})).To(Succeed())
+		Expect(frame.Fields).To(HaveLen(4))
+		Expect(frame.Fields[1].Name).To(Equal("ip_sortKey"))
+		Expect(frame.Fields[1].Config.Custom["hidden"]).To(BeTrue())
+
+		Expect(frame.Fields[0].At(1)).To(Equal("10.0.0.10"))
+		nine := *(frame.Fields[1].At(0).(*string))
+		ten := *(frame.Fields[1].At(1).(*string))
+		loopback := *(frame.Fields[1].At(2).(*string))
+		Expect(nine < ten).To(BeTrue())
+		Expect(loopback < nine).To(BeTrue())
+		Expect(frame.Fields[1].At(3)).To(BeNil())
+
+		Expect(*(frame.Fields[2].At(0).(*string))).To(Equal("aa:bb:cc:00:11:22"))
+		Expect(*(frame.Fields[3].At(0).(*string))).To(Equal("aabbcc001122"))
+		Expect(frame.Fields[3].At(1)).To(BeNil())
+		Expect(frame.Fields[3].At(2)).To(BeNil())
+	})
+
+	It("Should reject fields which are not strings", func() {
+		frame := data.NewFrame("", data.NewField("ip", nil, []int64{1}))
+		Expect(plugin.AddAddressSortKeys([]*data.Frame{frame}, []string{"ip"}, nil)).ToNot(Succeed())
+	})
+})
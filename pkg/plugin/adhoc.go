@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	adhocOperatorEquals    = "="
+	adhocOperatorNotEquals = "!="
+	adhocOperatorLess      = "<"
+	adhocOperatorGreater   = ">"
+	adhocOperatorRegex     = "=~"
+	adhocOperatorNotRegex  = "!~"
+
+	defaultAdhocKeysSample   = 100
+	maxAdhocKeysSample       = 10000
+	maxAdhocKeysNestingDepth = 4
+)
+
+// adhocFilter is a filter selected in an ad-hoc filters variable, which Grafana applies to every query of the datasource
+type adhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// adhocValues interprets the value of an ad-hoc filter for comparisons. Ad-hoc filter values are always strings,
+// so numbers, booleans, and ObjectIDs are compared both as themselves and as strings.
+func adhocValues(value string) bson.A {
+	values := bson.A{value}
+	if oid, err := bsonPrim.ObjectIDFromHex(value); err == nil {
+		values = append(values, oid)
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		values = append(values, number)
+	}
+	if value == "true" || value == "false" {
+		values = append(values, value == "true")
+	}
+	return values
+}
+
+// condition produces the condition on the key of an ad-hoc filter, for use in a $match stage
+func (f *adhocFilter) condition() (interface{}, error) {
+	switch f.Operator {
+	case adhocOperatorEquals, "":
+		return bson.D{bson.E{Key: "$in", Value: adhocValues(f.Value)}}, nil
+	case adhocOperatorNotEquals:
+		return bson.D{bson.E{Key: "$nin", Value: adhocValues(f.Value)}}, nil
+	case adhocOperatorLess, adhocOperatorGreater:
+		op := "$lt"
+		if f.Operator == adhocOperatorGreater {
+			op = "$gt"
+		}
+		var value interface{} = f.Value
+		if number, err := strconv.ParseFloat(f.Value, 64); err == nil {
+			value = number
+		}
+		return bson.D{bson.E{Key: op, Value: value}}, nil
+	case adhocOperatorRegex, adhocOperatorNotRegex:
+		regex := bsonPrim.Regex{Pattern: f.Value}
+		if f.Operator == adhocOperatorNotRegex {
+			return bson.D{bson.E{Key: "$not", Value: regex}}, nil
+		}
+		return bson.D{bson.E{Key: "$regex", Value: regex}}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported ad-hoc filter operator %s", f.Operator)
+	}
+}
+
+// AdhocFilterMatch produces the filter matching every ad-hoc filter, or nil if there are none
+func AdhocFilterMatch(filters []adhocFilter) (bson.D, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	conditions := make(bson.A, 0, len(filters))
+	for ix := range filters {
+		if filters[ix].Key == "" {
+			return nil, fmt.Errorf("Ad-hoc filters require a key")
+		}
+		condition, err := filters[ix].condition()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, bson.D{bson.E{Key: filters[ix].Key, Value: condition}})
+	}
+	if len(conditions) == 1 {
+		return conditions[0].(bson.D), nil
+	}
+	// The same key may be filtered more than once, so filters are combined with $and rather than into a single document
+	return bson.D{bson.E{Key: "$and", Value: conditions}}, nil
+}
+
+// AdhocKeys returns the sorted, dotted paths of the fields of sampled documents, descending into nested documents
+// up to a fixed depth, so that they may be offered as ad-hoc filter keys
+func AdhocKeys(docs []bson.D) []string {
+	seen := map[string]struct{}{}
+	var visit func(prefix string, doc bson.D, depth int)
+	visit = func(prefix string, doc bson.D, depth int) {
+		for _, elem := range doc {
+			path := prefix + elem.Key
+			seen[path] = struct{}{}
+			nested, ok := elem.Value.(bson.D)
+			if ok && depth < maxAdhocKeysNestingDepth {
+				visit(path+".", nested, depth+1)
+			}
+		}
+	}
+	for _, doc := range docs {
+		visit("", doc, 1)
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type adhocKeysResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// handleAdhocKeys returns the fields found in a sample of the documents of a collection, for use as ad-hoc filter keys.
+// Values are served by handleFieldValues.
+func (d *MongoDBDatasource) handleAdhocKeys(w http.ResponseWriter, r *http.Request, database, collection string) {
+	sample, err := queryIntParam(r, "sample", defaultAdhocKeysSample, maxAdhocKeysSample)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, client, _, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	pipeline := mongo.Pipeline{bson.D{bson.E{Key: "$sample", Value: bson.D{bson.E{Key: "size", Value: sample}}}}}
+	cursor, err := client.Database(database).Collection(collection).Aggregate(ctx, pipeline, mongoOpts.Aggregate().SetComment(newQueryComment()))
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to sample documents"))
+		return
+	}
+	docs := []bson.D{}
+	err = cursor.All(ctx, &docs)
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to decode sampled documents"))
+		return
+	}
+	writeJSON(w, http.StatusOK, adhocKeysResponse{Keys: AdhocKeys(docs)})
+}
@@ -0,0 +1,60 @@
+package plugin_test
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AdhocFilterMatch", func() {
+	match := func(filters string) (bson.D, error) {
+		var qm plugin.QueryModel
+		Expect(json.Unmarshal([]byte(`{"adhocFilters": `+filters+`}`), &qm)).To(Succeed())
+		return plugin.AdhocFilterMatch(qm.AdhocFilters)
+	}
+
+	It("Should match values which could be numbers or ObjectIDs as either", func() {
+		filter, err := match(`[{"key": "code", "operator": "=", "value": "404"}]`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter).To(Equal(bson.D{{Key: "code", Value: bson.D{{Key: "$in", Value: bson.A{"404", 404.0}}}}}))
+
+		oid, _ := primitive.ObjectIDFromHex("5f1d7b2e9c3a4b0012345678")
+		filter, err = match(`[{"key": "_id", "operator": "!=", "value": "5f1d7b2e9c3a4b0012345678"}]`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter).To(Equal(bson.D{{Key: "_id", Value: bson.D{{Key: "$nin", Value: bson.A{"5f1d7b2e9c3a4b0012345678", oid}}}}}))
+	})
+
+	It("Should combine filters with $and", func() {
+		filter, err := match(`[{"key": "a", "operator": ">", "value": "1"}, {"key": "b", "operator": "!~", "value": "^x"}]`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter).To(Equal(bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "a", Value: bson.D{{Key: "$gt", Value: 1.0}}}},
+			bson.D{{Key: "b", Value: bson.D{{Key: "$not", Value: primitive.Regex{Pattern: "^x"}}}}},
+		}}}))
+	})
+
+	It("Should return nothing without filters", func() {
+		Expect(match(`[]`)).To(BeNil())
+	})
+
+	It("Should reject unknown operators", func() {
+		_, err := match(`[{"key": "a", "operator": "~~", "value": "1"}]`)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("AdhocKeys", func() {
+	It("Should list nested fields as dotted paths", func() {
+		keys := plugin.AdhocKeys([]bson.D{
+			{{Key: "host", Value: "a"}, {Key: "meta", Value: bson.D{{Key: "region", Value: "us"}}}},
+			{{Key: "host", Value: "b"}, {Key: "code", Value: 200}},
+		})
+		Expect(keys).To(Equal([]string{"code", "host", "meta", "meta.region"}))
+	})
+})
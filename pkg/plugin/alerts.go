@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// alertWebhookPayload is the subset of the Grafana alerting webhook contact point payload that is recorded
+// https://grafana.com/docs/grafana/latest/alerting/manage-notifications/webhook-notifier/
+type alertWebhookPayload struct {
+	Receiver string              `json:"receiver"`
+	Status   string              `json:"status"`
+	OrgID    int64               `json:"orgId"`
+	GroupKey string              `json:"groupKey"`
+	Alerts   []alertWebhookAlert `json:"alerts"`
+}
+
+type alertWebhookAlert struct {
+	Status       string             `json:"status"`
+	Labels       map[string]string  `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+	StartsAt     time.Time          `json:"startsAt"`
+	EndsAt       time.Time          `json:"endsAt"`
+	GeneratorURL string             `json:"generatorURL"`
+	Fingerprint  string             `json:"fingerprint"`
+	Values       map[string]float64 `json:"values"`
+}
+
+// handleAlerts records the alerts in a Grafana alerting webhook payload as documents in the configured alert sink collection.
+// This is disabled unless explicitly enabled in the datasource settings.
+func (d *MongoDBDatasource) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	ctx := r.Context()
+	pCtx := httpadapter.PluginConfigFromContext(ctx)
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !settings.AlertSinkEnabled {
+		writeError(w, http.StatusForbidden, fmt.Errorf("The alert sink is not enabled for this datasource"))
+		return
+	}
+	if settings.AlertSinkDatabase == "" || settings.AlertSinkCollection == "" {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("The alert sink is enabled, but no database and collection are configured"))
+		return
+	}
+	user := httpadapter.UserFromContext(ctx)
+	if user == nil || user.Role == grafanaViewerRole {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Only editors and admins may record alerts"))
+		return
+	}
+
+	var payload alertWebhookPayload
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid alert webhook payload"))
+		return
+	}
+	if len(payload.Alerts) == 0 {
+		writeJSON(w, http.StatusOK, map[string]int{"inserted": 0})
+		return
+	}
+
+	receivedAt := time.Now()
+	docs := make([]interface{}, len(payload.Alerts))
+	for ix, alert := range payload.Alerts {
+		doc := bson.D{
+			bson.E{Key: "receivedAt", Value: receivedAt},
+			bson.E{Key: "receiver", Value: payload.Receiver},
+			bson.E{Key: "orgId", Value: payload.OrgID},
+			bson.E{Key: "groupKey", Value: payload.GroupKey},
+			bson.E{Key: "status", Value: alert.Status},
+			bson.E{Key: "labels", Value: alert.Labels},
+			bson.E{Key: "annotations", Value: alert.Annotations},
+			bson.E{Key: "startsAt", Value: alert.StartsAt},
+			bson.E{Key: "fingerprint", Value: alert.Fingerprint},
+			bson.E{Key: "generatorURL", Value: alert.GeneratorURL},
+			bson.E{Key: "values", Value: alert.Values},
+		}
+		// Firing alerts have a zero end time
+		if !alert.EndsAt.IsZero() {
+			doc = append(doc, bson.E{Key: "endsAt", Value: alert.EndsAt})
+		}
+		docs[ix] = doc
+	}
+
+	mongoClient, err, internalErr := connect(ctx, pCtx)
+	if internalErr != nil {
+		writeError(w, http.StatusInternalServerError, internalErr)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer mongoClient.Disconnect(ctx)
+
+	result, err := mongoClient.Database(settings.AlertSinkDatabase).Collection(settings.AlertSinkCollection).InsertMany(ctx, docs)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, errors.Wrap(err, "Failed to record alerts"))
+		return
+	}
+	log.DefaultLogger.Info("Recorded alerts", "count", len(result.InsertedIDs), "database", settings.AlertSinkDatabase, "collection", settings.AlertSinkCollection)
+	writeJSON(w, http.StatusOK, map[string]int{"inserted": len(result.InsertedIDs)})
+}
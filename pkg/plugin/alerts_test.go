@@ -0,0 +1,49 @@
+package plugin_test
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// statusSender records the status of a resource response
+type statusSender struct {
+	status int
+}
+
+func (s *statusSender) Send(resp *backend.CallResourceResponse) error {
+	s.status = resp.Status
+	return nil
+}
+
+var _ = Describe("Alert sink", func() {
+	settings := backend.DataSourceInstanceSettings{
+		UID:      "alerts",
+		JSONData: []byte(`{"url": "mongodb://localhost:27017", "alertSinkEnabled": true, "alertSinkDatabase": "alerts", "alertSinkCollection": "alerts"}`),
+	}
+
+	DescribeTable("Should forbid users who may not write", func(user *backend.User) {
+		instance, err := plugin.NewMongoDBDatasource(settings)
+		Expect(err).ToNot(HaveOccurred())
+		ds := instance.(*plugin.MongoDBDatasource)
+		sender := &statusSender{}
+		err = ds.CallResource(context.Background(), &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{User: user, DataSourceInstanceSettings: &settings},
+			Path:          "alerts",
+			Method:        http.MethodPost,
+			URL:           "alerts",
+			Body:          []byte(`{"alerts": [{"status": "firing"}]}`),
+		}, sender)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.status).To(Equal(http.StatusForbidden))
+	},
+		Entry("viewers", &backend.User{Login: "viewer", Role: "Viewer"}),
+		Entry("anonymous requests", nil),
+	)
+})
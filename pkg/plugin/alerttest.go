@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+// defaultAlertTestTimeRange is the relative time range used by new alert rules in Grafana
+const defaultAlertTestTimeRange = 10 * time.Minute
+
+// alertTestRequest is the body of a request to /test-alert-query
+type alertTestRequest struct {
+	// Query is the query JSON, as it appears in the alert rule
+	Query json.RawMessage `json:"query"`
+	// TimeRange is how far back from the current time the query should cover, e.g. "10m"
+	TimeRange string `json:"timeRange,omitempty"`
+}
+
+// alertSeries is the value an alert rule would see for a single series, after reducing it to its last value
+type alertSeries struct {
+	Name   string      `json:"name"`
+	Labels data.Labels `json:"labels,omitempty"`
+	Value  *float64    `json:"value"`
+}
+
+// AlertTestResult reports whether the result of a query can be evaluated by an alert rule, and what it would evaluate to
+type AlertTestResult struct {
+	Alertable bool          `json:"alertable"`
+	NoData    bool          `json:"noData,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Problems  []string      `json:"problems"`
+	Notices   []string      `json:"notices"`
+	Series    []alertSeries `json:"series"`
+}
+
+// CheckAlertable checks frames as Grafana alerting would, converting them into series with server-side expressions.
+// Tables (frames without a time field) are treated as one series per row, labeled by their string columns,
+// in which case rows must have distinct labels. Each series is reduced to its last non-null value.
+func CheckAlertable(frames []*data.Frame) AlertTestResult {
+	result := AlertTestResult{Alertable: true, Problems: []string{}, Notices: []string{}, Series: []alertSeries{}}
+	if countRows(frames) == 0 {
+		result.NoData = true
+		result.Notices = append(result.Notices, "The query returned no data, so alert rules would be in the No Data state")
+		return result
+	}
+
+	// The same columns are used as labels by Grafana when converting numeric tables
+	labelsFrom := []string{}
+	seen := map[string]struct{}{}
+	for _, frame := range frames {
+		if frame.TimeSeriesSchema().Type != data.TimeSeriesTypeNot {
+			continue
+		}
+		for _, field := range frame.Fields {
+			if field.Type().NonNullableType() != data.FieldTypeString {
+				continue
+			}
+			if _, ok := seen[field.Name]; !ok {
+				seen[field.Name] = struct{}{}
+				labelsFrom = append(labelsFrom, field.Name)
+			}
+		}
+	}
+
+	series, notices, err := ReshapeForSSE(frames, labelsFrom)
+	if err != nil {
+		result.Alertable = false
+		result.Problems = append(result.Problems, err.Error())
+		return result
+	}
+	for _, notice := range notices {
+		result.Notices = append(result.Notices, notice.Text)
+	}
+	if len(series) == 0 {
+		result.Alertable = false
+		result.Problems = append(result.Problems, "The query returned no numeric fields")
+		return result
+	}
+
+	for _, frame := range series {
+		valueField := frame.Fields[len(frame.Fields)-1]
+		if len(frame.Fields) == 1 && valueField.Len() > 1 {
+			result.Alertable = false
+			result.Problems = append(result.Problems, fmt.Sprintf("%d rows have the labels %s, but each row of a table must have distinct labels", valueField.Len(), valueField.Labels))
+		}
+		s := alertSeries{Name: valueField.Name, Labels: valueField.Labels}
+		for row := valueField.Len() - 1; row >= 0; row-- {
+			value, _ := valueField.NullableFloatAt(row)
+			if value != nil {
+				s.Value = value
+				break
+			}
+		}
+		result.Series = append(result.Series, s)
+	}
+	sort.SliceStable(result.Series, func(i, j int) bool { return result.Series[i].Labels.String() < result.Series[j].Labels.String() })
+	return result
+}
+
+// handleTestAlertQuery runs a query as the alert scheduler would, with the alert read intent over a time range ending now,
+// bypassing the cache, and reports whether the result is alertable, and the values alert conditions would see
+func (d *MongoDBDatasource) handleTestAlertQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	ctx := r.Context()
+	pCtx := httpadapter.PluginConfigFromContext(ctx)
+
+	var req alertTestRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+	if len(req.Query) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("A query is required"))
+		return
+	}
+	timeRange := defaultAlertTestTimeRange
+	if req.TimeRange != "" {
+		timeRange, err = time.ParseDuration(req.TimeRange)
+		if err != nil || timeRange <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Time range must be a positive duration"))
+			return
+		}
+	}
+	var header struct {
+		RefID         string `json:"refId"`
+		IntervalMS    int64  `json:"intervalMs"`
+		MaxDataPoints int64  `json:"maxDataPoints"`
+	}
+	err = json.Unmarshal(req.Query, &header)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid query"))
+		return
+	}
+	if header.RefID == "" {
+		header.RefID = "A"
+	}
+
+	now := time.Now()
+	response := d.query(ctx, pCtx, requestOrigin{app: requestAppAlerting, orgID: pCtx.OrgID}, backend.DataQuery{
+		RefID:         header.RefID,
+		JSON:          req.Query,
+		TimeRange:     backend.TimeRange{From: now.Add(-timeRange), To: now},
+		Interval:      time.Duration(header.IntervalMS) * time.Millisecond,
+		MaxDataPoints: header.MaxDataPoints,
+	})
+	if response.Error != nil {
+		writeJSON(w, http.StatusOK, AlertTestResult{Error: response.Error.Error(), Problems: []string{}, Notices: []string{}, Series: []alertSeries{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, CheckAlertable(response.Frames))
+}
@@ -0,0 +1,61 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckAlertable", func() {
+	It("Should reduce time series to their last value", func() {
+		labels := data.Labels{"host": "a"}
+		two := 2.0
+		frame := data.NewFrame("",
+			data.NewField("ts", labels, []time.Time{time.Unix(0, 0), time.Unix(60, 0), time.Unix(120, 0)}),
+			data.NewField("cpu", labels, []*float64{nil, &two, nil}),
+		)
+		result := plugin.CheckAlertable([]*data.Frame{frame})
+		Expect(result.Alertable).To(BeTrue())
+		Expect(result.Series).To(HaveLen(1))
+		Expect(result.Series[0].Labels).To(Equal(labels))
+		Expect(*result.Series[0].Value).To(Equal(2.0))
+	})
+
+	It("Should label numeric tables by their string columns", func() {
+		frame := data.NewFrame("",
+			data.NewField("host", nil, []string{"a", "b"}),
+			data.NewField("errors", nil, []int64{1, 5}),
+		)
+		result := plugin.CheckAlertable([]*data.Frame{frame})
+		Expect(result.Alertable).To(BeTrue())
+		Expect(result.Series).To(HaveLen(2))
+		Expect(result.Series[1].Labels).To(Equal(data.Labels{"host": "b"}))
+		Expect(*result.Series[1].Value).To(Equal(5.0))
+	})
+
+	It("Should reject tables with duplicate labels", func() {
+		frame := data.NewFrame("",
+			data.NewField("host", nil, []string{"a", "a"}),
+			data.NewField("errors", nil, []int64{1, 5}),
+		)
+		result := plugin.CheckAlertable([]*data.Frame{frame})
+		Expect(result.Alertable).To(BeFalse())
+		Expect(result.Problems).To(HaveLen(1))
+	})
+
+	It("Should reject results without numbers", func() {
+		frame := data.NewFrame("", data.NewField("host", nil, []string{"a"}))
+		result := plugin.CheckAlertable([]*data.Frame{frame})
+		Expect(result.Alertable).To(BeFalse())
+	})
+
+	It("Should report no data", func() {
+		result := plugin.CheckAlertable(nil)
+		Expect(result.NoData).To(BeTrue())
+	})
+})
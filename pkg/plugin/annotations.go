@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// queryTypeAnnotations is an annotation query type which returns the annotations written through the /annotations route
+	queryTypeAnnotations = "Annotations"
+
+	maxAnnotationTextLength = 10000
+	maxAnnotations          = 10000
+)
+
+// annotationRequest is the body of requests to create or update an annotation
+type annotationRequest struct {
+	// Time and TimeEnd are milliseconds since the epoch. TimeEnd is only set for regions.
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd,omitempty"`
+	Text         string   `json:"text"`
+	Tags         []string `json:"tags,omitempty"`
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int64    `json:"panelId,omitempty"`
+}
+
+func (a *annotationRequest) validate() error {
+	if a.Time <= 0 {
+		return fmt.Errorf("Annotations require a time")
+	}
+	if a.TimeEnd != 0 && a.TimeEnd < a.Time {
+		return fmt.Errorf("Annotations must not end before they start")
+	}
+	if len(a.Text) > maxAnnotationTextLength {
+		return fmt.Errorf("Annotation text must be at most %d characters", maxAnnotationTextLength)
+	}
+	for _, tag := range a.Tags {
+		if strings.Contains(tag, ",") {
+			return fmt.Errorf("Annotation tags must not contain commas")
+		}
+	}
+	return nil
+}
+
+// fields returns the fields of the document of an annotation which are set by the user
+func (a *annotationRequest) fields() bson.D {
+	fields := bson.D{
+		bson.E{Key: "time", Value: time.Unix(0, a.Time*int64(time.Millisecond))},
+		bson.E{Key: "text", Value: a.Text},
+		bson.E{Key: "tags", Value: a.Tags},
+		bson.E{Key: "dashboardUID", Value: a.DashboardUID},
+		bson.E{Key: "panelId", Value: a.PanelID},
+	}
+	if a.Tags == nil {
+		fields[2].Value = []string{}
+	}
+	if a.TimeEnd != 0 {
+		fields = append(fields, bson.E{Key: "timeEnd", Value: time.Unix(0, a.TimeEnd*int64(time.Millisecond))})
+	}
+	return fields
+}
+
+// AnnotationDocument is an annotation as stored in the annotations collection
+type AnnotationDocument struct {
+	ID      bsonPrim.ObjectID `bson:"_id"`
+	Time    time.Time         `bson:"time"`
+	TimeEnd *time.Time        `bson:"timeEnd"`
+	Text    string            `bson:"text"`
+	Tags    []string          `bson:"tags"`
+}
+
+// AnnotationFrame produces an annotation frame from annotation documents. Annotations without an end are points.
+func AnnotationFrame(docs []AnnotationDocument) *data.Frame {
+	ids := make([]string, len(docs))
+	times := make([]time.Time, len(docs))
+	timeEnds := make([]time.Time, len(docs))
+	texts := make([]string, len(docs))
+	tags := make([]string, len(docs))
+	for ix, doc := range docs {
+		ids[ix] = doc.ID.Hex()
+		times[ix] = doc.Time
+		timeEnds[ix] = doc.Time
+		if doc.TimeEnd != nil {
+			timeEnds[ix] = *doc.TimeEnd
+		}
+		texts[ix] = doc.Text
+		tags[ix] = strings.Join(doc.Tags, ",")
+	}
+	return data.NewFrame("annotations",
+		data.NewField("id", nil, ids),
+		data.NewField("time", nil, times),
+		data.NewField("timeEnd", nil, timeEnds),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	)
+}
+
+// annotationsConfigured checks that annotations are enabled, and where they are stored
+func (d *datasource) annotationsConfigured() error {
+	if !d.AnnotationsEnabled {
+		return fmt.Errorf("Annotations are not enabled for this datasource")
+	}
+	if d.AnnotationsDatabase == "" || d.AnnotationsCollection == "" {
+		return fmt.Errorf("Annotations are enabled, but no database and collection are configured")
+	}
+	return nil
+}
+
+// annotationsQuery answers an Annotations annotation query with the annotations overlapping its time range.
+// Annotations are read with the credentials used for all other queries.
+func (d *MongoDBDatasource) annotationsQuery(ctx context.Context, pCtx backend.PluginContext, timeRange backend.TimeRange) (response backend.DataResponse) {
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	err = settings.annotationsConfigured()
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	client, err, internalErr := connect(ctx, pCtx)
+	if internalErr != nil {
+		response.Error = errors.Wrap(internalErr, "Internal failure while connecting to mongo")
+		return response
+	}
+	if err != nil {
+		response.Error = errors.Wrap(err, "Failed to connect to mongo")
+		return response
+	}
+	defer client.Disconnect(ctx)
+
+	filter := bson.D{
+		bson.E{Key: "time", Value: bson.D{bson.E{Key: "$lte", Value: timeRange.To}}},
+		bson.E{Key: "$or", Value: bson.A{
+			bson.D{bson.E{Key: "time", Value: bson.D{bson.E{Key: "$gte", Value: timeRange.From}}}},
+			bson.D{bson.E{Key: "timeEnd", Value: bson.D{bson.E{Key: "$gte", Value: timeRange.From}}}},
+		}},
+	}
+	opts := mongoOpts.Find().SetSort(bson.D{bson.E{Key: "time", Value: 1}}).SetLimit(maxAnnotations).SetComment(newQueryComment())
+	cursor, err := client.Database(settings.AnnotationsDatabase).Collection(settings.AnnotationsCollection).Find(ctx, filter, opts)
+	if err != nil {
+		response.Error = errors.Wrap(err, "Failed to query annotations")
+		return response
+	}
+	docs := []AnnotationDocument{}
+	err = cursor.All(ctx, &docs)
+	if err != nil {
+		response.Error = errors.Wrap(err, "Failed to decode annotations")
+		return response
+	}
+	response.Frames = data.Frames{AnnotationFrame(docs)}
+	return response
+}
+
+// annotationsClient connects with the separate credentials used to write annotations, so that the credentials used for queries
+// can remain read-only
+func annotationsClient(ctx context.Context, pCtx backend.PluginContext, settings datasource) (*mongo.Client, error) {
+	if settings.AnnotationsUsername == "" {
+		return nil, fmt.Errorf("Annotations are enabled, but no write credentials are configured")
+	}
+	settings.Username = settings.AnnotationsUsername
+	settings.Password = settings.AnnotationsPassword
+	client, err, internalErr := connectDatasource(ctx, settings)
+	if internalErr != nil {
+		return nil, internalErr
+	}
+	return client, err
+}
+
+// handleAnnotations creates, updates, and deletes annotations in the configured annotations collection:
+//
+//	POST /annotations
+//	PUT /annotations/{id}
+//	DELETE /annotations/{id}
+//
+// This is disabled unless explicitly enabled in the datasource settings, requires separate write credentials,
+// and is not available to viewers.
+func (d *MongoDBDatasource) handleAnnotations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	pCtx := httpadapter.PluginConfigFromContext(ctx)
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = settings.annotationsConfigured()
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	user := httpadapter.UserFromContext(ctx)
+	if user == nil || user.Role == grafanaViewerRole {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Only editors and admins may edit annotations"))
+		return
+	}
+	segments, err := resourcePathSegments(r, "/annotations")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var id bsonPrim.ObjectID
+	switch {
+	case r.Method == http.MethodPost && len(segments) == 0:
+	case (r.Method == http.MethodPut || r.Method == http.MethodDelete) && len(segments) == 1:
+		id, err = bsonPrim.ObjectIDFromHex(segments[0])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid annotation id"))
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST /annotations, PUT /annotations/{id}, and DELETE /annotations/{id} are supported"))
+		return
+	}
+
+	var req annotationRequest
+	if r.Method != http.MethodDelete {
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+			return
+		}
+		err = req.validate()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	client, err := annotationsClient(ctx, pCtx, settings)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Disconnect(ctx)
+	collection := client.Database(settings.AnnotationsDatabase).Collection(settings.AnnotationsCollection)
+
+	now := time.Now()
+	switch r.Method {
+	case http.MethodPost:
+		doc := append(req.fields(), bson.E{Key: "createdBy", Value: user.Login}, bson.E{Key: "createdAt", Value: now})
+		result, err := collection.InsertOne(ctx, doc)
+		if err != nil {
+			writeMongoError(w, errors.Wrap(err, "Failed to create annotation"))
+			return
+		}
+		id, _ = result.InsertedID.(bsonPrim.ObjectID)
+	case http.MethodPut:
+		// timeEnd is unset when a region becomes a point
+		update := bson.D{bson.E{Key: "$set", Value: append(req.fields(), bson.E{Key: "updatedBy", Value: user.Login}, bson.E{Key: "updatedAt", Value: now})}}
+		if req.TimeEnd == 0 {
+			update = append(update, bson.E{Key: "$unset", Value: bson.D{bson.E{Key: "timeEnd", Value: ""}}})
+		}
+		result, err := collection.UpdateByID(ctx, id, update)
+		if err != nil {
+			writeMongoError(w, errors.Wrap(err, "Failed to update annotation"))
+			return
+		}
+		if result.MatchedCount == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("No annotation with id %s", id.Hex()))
+			return
+		}
+	case http.MethodDelete:
+		result, err := collection.DeleteOne(ctx, bson.D{bson.E{Key: "_id", Value: id}})
+		if err != nil {
+			writeMongoError(w, errors.Wrap(err, "Failed to delete annotation"))
+			return
+		}
+		if result.DeletedCount == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("No annotation with id %s", id.Hex()))
+			return
+		}
+	}
+	log.DefaultLogger.Info("Edited annotation", "user", user.Login, "method", r.Method, "id", id.Hex())
+	writeJSON(w, http.StatusOK, map[string]string{"id": id.Hex()})
+}
@@ -0,0 +1,30 @@
+package plugin_test
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AnnotationFrame", func() {
+	It("Should end points where they start, and join tags", func() {
+		oid, _ := primitive.ObjectIDFromHex("5f1d7b2e9c3a4b0012345678")
+		start := time.Unix(60, 0)
+		end := time.Unix(120, 0)
+		frame := plugin.AnnotationFrame([]plugin.AnnotationDocument{
+			{ID: oid, Time: start, Text: "deploy", Tags: []string{"a", "b"}},
+			{ID: oid, Time: start, TimeEnd: &end, Text: "outage"},
+		})
+		Expect(frame.Rows()).To(Equal(2))
+		Expect(frame.Fields[0].At(0)).To(Equal("5f1d7b2e9c3a4b0012345678"))
+		Expect(frame.Fields[2].At(0)).To(Equal(start))
+		Expect(frame.Fields[2].At(1)).To(Equal(end))
+		Expect(frame.Fields[4].At(0)).To(Equal("a,b"))
+		Expect(frame.Fields[4].At(1)).To(Equal(""))
+	})
+})
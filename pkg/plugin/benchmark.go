@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+const (
+	defaultBenchmarkRows    = 10000
+	maxBenchmarkRows        = 200000
+	defaultBenchmarkColumns = 10
+	maxBenchmarkColumns     = 200
+	maxBenchmarkNesting     = 8
+)
+
+// benchmarkRequest describes the shape of the documents generated for the /benchmark route
+type benchmarkRequest struct {
+	Rows    int `json:"rows,omitempty"`
+	Columns int `json:"columns,omitempty"`
+	// Nesting is the depth of the embedded documents in every seventh column. If zero, there are no embedded documents.
+	Nesting int `json:"nesting,omitempty"`
+}
+
+func (r *benchmarkRequest) parse() error {
+	if r.Rows == 0 {
+		r.Rows = defaultBenchmarkRows
+	}
+	if r.Columns == 0 {
+		r.Columns = defaultBenchmarkColumns
+	}
+	if r.Rows < 0 || r.Rows > maxBenchmarkRows {
+		return fmt.Errorf("Rows must be between 1 and %d", maxBenchmarkRows)
+	}
+	if r.Columns < 0 || r.Columns > maxBenchmarkColumns {
+		return fmt.Errorf("Columns must be between 1 and %d", maxBenchmarkColumns)
+	}
+	if r.Nesting < 0 || r.Nesting > maxBenchmarkNesting {
+		return fmt.Errorf("Nesting must be between 0 and %d", maxBenchmarkNesting)
+	}
+	return nil
+}
+
+// benchmarkResult reports the throughput of decoding and converting generated documents
+type benchmarkResult struct {
+	Rows    int `json:"rows"`
+	Columns int `json:"columns"`
+	Nesting int `json:"nesting"`
+	// Bytes is the total size of the generated documents as BSON
+	Bytes int `json:"bytes"`
+	// DecodeTime is spent decoding BSON into documents, and ConvertTime is spent inferring the schema and building the frame
+	DecodeTime     time.Duration `json:"decodeTimeNs"`
+	ConvertTime    time.Duration `json:"convertTimeNs"`
+	RowsPerSecond  float64       `json:"rowsPerSecond"`
+	BytesPerSecond float64       `json:"bytesPerSecond"`
+	Allocations    uint64        `json:"allocations"`
+	AllocBytes     uint64        `json:"allocatedBytes"`
+	GOMAXPROCS     int           `json:"gomaxprocs"`
+}
+
+// benchmarkValue generates the value of a column, cycling through the common BSON types
+func benchmarkValue(random *rand.Rand, column, nesting int, now time.Time) interface{} {
+	switch column % 7 {
+	case 0:
+		return random.Int63()
+	case 1:
+		return random.Float64() * 1000
+	case 2:
+		return fmt.Sprintf("value-%d", random.Intn(1000))
+	case 3:
+		return random.Intn(2) == 0
+	case 4:
+		return now.Add(-time.Duration(random.Intn(86400)) * time.Second)
+	case 5:
+		var oid bsonPrim.ObjectID
+		random.Read(oid[:])
+		return oid
+	default:
+		if nesting == 0 {
+			return random.Int31()
+		}
+		return bson.D{
+			{Key: "n", Value: random.Intn(100)},
+			{Key: "s", Value: "nested"},
+			{Key: "child", Value: benchmarkValue(random, column, nesting-1, now)},
+		}
+	}
+}
+
+// GenerateBenchmarkDocuments generates BSON documents of the given shape, which are identical for the same seed
+func GenerateBenchmarkDocuments(rows, columns, nesting int, seed int64) ([][]byte, error) {
+	random := rand.New(rand.NewSource(seed))
+	now := time.Unix(1600000000, 0)
+	docs := make([][]byte, rows)
+	for row := range docs {
+		doc := make(bson.D, columns)
+		for column := range doc {
+			doc[column] = bson.E{Key: fmt.Sprintf("c%d", column), Value: benchmarkValue(random, column, nesting, now)}
+		}
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		docs[row] = raw
+	}
+	return docs, nil
+}
+
+// RunConversionBenchmark decodes BSON documents and converts them into a frame, as a query does,
+// inferring the schema from every document, and measures the time and memory spent
+func RunConversionBenchmark(docs [][]byte, opts bsonframe.ConversionOptions) (benchmarkResult, error) {
+	result := benchmarkResult{Rows: len(docs), GOMAXPROCS: runtime.GOMAXPROCS(0)}
+	for _, raw := range docs {
+		result.Bytes += len(raw)
+	}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	decoded := make([]bsonframe.Document, len(docs))
+	for ix, raw := range docs {
+		doc := make(bsonframe.Document)
+		err := bson.Unmarshal(raw, &doc)
+		if err != nil {
+			return benchmarkResult{}, errors.Wrap(err, "Failed to decode generated document")
+		}
+		decoded[ix] = doc
+	}
+	result.DecodeTime = time.Since(start)
+
+	start = time.Now()
+	frame, _, err := bsonframe.BuildFrame("benchmark", nil, decoded, &opts)
+	if err != nil {
+		return benchmarkResult{}, err
+	}
+	result.ConvertTime = time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	result.Allocations = after.Mallocs - before.Mallocs
+	result.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	result.Columns = len(frame.Fields)
+	if total := (result.DecodeTime + result.ConvertTime).Seconds(); total > 0 {
+		result.RowsPerSecond = float64(result.Rows) / total
+		result.BytesPerSecond = float64(result.Bytes) / total
+	}
+	return result, nil
+}
+
+// handleBenchmark converts generated documents of a configurable shape into a frame, and reports the throughput,
+// for sizing the resources of the plugin and checking for performance regressions. Nothing is sent to MongoDB.
+// This is only available to Grafana admins, as it can use a significant amount of CPU and memory.
+func (d *MongoDBDatasource) handleBenchmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	ctx := r.Context()
+	user := httpadapter.UserFromContext(ctx)
+	if user == nil || user.Role != grafanaAdminRole {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Only admins may run benchmarks"))
+		return
+	}
+	settings, err := loadDatasource(httpadapter.PluginConfigFromContext(ctx))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// An empty body benchmarks the defaults
+	var req benchmarkRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+	err = req.parse()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	docs, err := GenerateBenchmarkDocuments(req.Rows, req.Columns, req.Nesting, time.Now().UnixNano())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	opts := bsonframe.ConversionOptions{}
+	settings.applyCellLimits(&opts)
+	result, err := RunConversionBenchmark(docs, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	result.Nesting = req.Nesting
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,30 @@
+package plugin_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RunConversionBenchmark", func() {
+	It("Should convert every generated document", func() {
+		docs, err := plugin.GenerateBenchmarkDocuments(50, 9, 2, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(docs).To(HaveLen(50))
+
+		result, err := plugin.RunConversionBenchmark(docs, bsonframe.ConversionOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Rows).To(Equal(50))
+		Expect(result.Columns).To(Equal(9))
+		Expect(result.Bytes).To(BeNumerically(">", 0))
+		Expect(result.RowsPerSecond).To(BeNumerically(">", 0))
+	})
+
+	It("Should generate the same documents for the same seed", func() {
+		first, err := plugin.GenerateBenchmarkDocuments(3, 7, 1, 42)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plugin.GenerateBenchmarkDocuments(3, 7, 1, 42)).To(Equal(first))
+	})
+})
@@ -0,0 +1,27 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+// applyBoolFields makes the fields named by the query's boolFields into nullable booleans, which coerce their values with bsonframe.CoerceBool
+func (m *QueryModel) applyBoolFields(fields []bsonframe.Column) []bsonframe.Column {
+	if len(m.BoolFields) == 0 {
+		return fields
+	}
+	names := make(map[string]struct{}, len(m.BoolFields))
+	for _, name := range m.BoolFields {
+		names[name] = struct{}{}
+	}
+	converted := make([]bsonframe.Column, len(fields))
+	for ix, f := range fields {
+		if _, ok := names[f.Name]; ok {
+			f = bsonframe.NewColumn(f.Name, data.FieldTypeNullableBool)
+			f.BoolCoercion = true
+		}
+		converted[ix] = f
+	}
+	return converted
+}
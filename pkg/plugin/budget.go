@@ -0,0 +1,230 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const (
+	// maxBudgetEntries bounds the number of panel queries tracked by each datasource instance.
+	// When exceeded, the least recently executed query is forgotten.
+	maxBudgetEntries = 5000
+
+	budgetByPanel     = "panel"
+	budgetByDashboard = "dashboard"
+)
+
+// queryCost is the cost of a single execution of a query.
+// MongoDB does not report the number of documents examined by an aggregation outside of explain and the profiler,
+// so only the documents and bytes returned to the plugin are recorded.
+type queryCost struct {
+	serverTime        time.Duration
+	documentsReturned int
+	bytesReturned     int64
+}
+
+// commandTimer uses command monitoring to sum the time taken by the commands which make up the execution of a query
+type commandTimer struct {
+	lock  sync.Mutex
+	total time.Duration
+}
+
+func (t *commandTimer) monitor() *event.CommandMonitor {
+	finished := func(evt event.CommandFinishedEvent) {
+		if _, tracked := trackedCommands[evt.CommandName]; !tracked {
+			return
+		}
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		t.total += time.Duration(evt.DurationNanos)
+	}
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) { finished(evt.CommandFinishedEvent) },
+		Failed:    func(_ context.Context, evt *event.CommandFailedEvent) { finished(evt.CommandFinishedEvent) },
+	}
+}
+
+func (t *commandTimer) elapsed() time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.total
+}
+
+// combineMonitors produces a monitor which forwards every event to each of several monitors,
+// as a client only accepts a single monitor
+func combineMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			for _, monitor := range monitors {
+				if monitor.Started != nil {
+					monitor.Started(ctx, evt)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			for _, monitor := range monitors {
+				if monitor.Succeeded != nil {
+					monitor.Succeeded(ctx, evt)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			for _, monitor := range monitors {
+				if monitor.Failed != nil {
+					monitor.Failed(ctx, evt)
+				}
+			}
+		},
+	}
+}
+
+// budgetEntry is the accumulated cost of a query, or of all queries of a dashboard
+type budgetEntry struct {
+	DashboardUID      string    `json:"dashboardUid"`
+	PanelID           string    `json:"panelId,omitempty"`
+	RefID             string    `json:"refId,omitempty"`
+	Executions        int       `json:"executions"`
+	ServerMillis      float64   `json:"serverMs"`
+	MaxServerMillis   float64   `json:"maxServerMs"`
+	DocumentsReturned int64     `json:"documentsReturned"`
+	BytesReturned     int64     `json:"bytesReturned"`
+	LastExecuted      time.Time `json:"lastExecuted"`
+}
+
+func (e *budgetEntry) add(other budgetEntry) {
+	e.Executions += other.Executions
+	e.ServerMillis += other.ServerMillis
+	if other.MaxServerMillis > e.MaxServerMillis {
+		e.MaxServerMillis = other.MaxServerMillis
+	}
+	e.DocumentsReturned += other.DocumentsReturned
+	e.BytesReturned += other.BytesReturned
+	if other.LastExecuted.After(e.LastExecuted) {
+		e.LastExecuted = other.LastExecuted
+	}
+}
+
+type budgetKey struct {
+	dashboardUID string
+	panelID      string
+	refID        string
+}
+
+// queryBudget accumulates the cost of each query of each dashboard panel executed by this datasource instance.
+// Costs are only held in memory, and so are lost when the datasource instance is recreated. The zero value is ready to use.
+type queryBudget struct {
+	lock    sync.Mutex
+	entries map[budgetKey]*budgetEntry
+}
+
+// record adds the cost of an execution of a query. Queries which were not issued by a dashboard are not recorded.
+func (b *queryBudget) record(origin requestOrigin, refID string, cost queryCost) {
+	if origin.dashboardUID == "" {
+		return
+	}
+	millis := float64(cost.serverTime) / float64(time.Millisecond)
+	execution := budgetEntry{
+		DashboardUID:      origin.dashboardUID,
+		PanelID:           origin.panelID,
+		RefID:             refID,
+		Executions:        1,
+		ServerMillis:      millis,
+		MaxServerMillis:   millis,
+		DocumentsReturned: int64(cost.documentsReturned),
+		BytesReturned:     cost.bytesReturned,
+		LastExecuted:      time.Now(),
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.entries == nil {
+		b.entries = make(map[budgetKey]*budgetEntry)
+	}
+	key := budgetKey{dashboardUID: origin.dashboardUID, panelID: origin.panelID, refID: refID}
+	entry, ok := b.entries[key]
+	if !ok {
+		if len(b.entries) >= maxBudgetEntries {
+			b.evictOldest()
+		}
+		entry = &budgetEntry{DashboardUID: origin.dashboardUID, PanelID: origin.panelID, RefID: refID}
+		b.entries[key] = entry
+	}
+	entry.add(execution)
+}
+
+func (b *queryBudget) evictOldest() {
+	var oldestKey budgetKey
+	var oldest *budgetEntry
+	for key, entry := range b.entries {
+		if oldest == nil || entry.LastExecuted.Before(oldest.LastExecuted) {
+			oldestKey, oldest = key, entry
+		}
+	}
+	delete(b.entries, oldestKey)
+}
+
+// report returns the accumulated costs, either per query or per dashboard, most expensive first
+func (b *queryBudget) report(by string) []budgetEntry {
+	b.lock.Lock()
+	entries := make([]budgetEntry, 0, len(b.entries))
+	dashboards := make(map[string]*budgetEntry)
+	for _, entry := range b.entries {
+		if by != budgetByDashboard {
+			entries = append(entries, *entry)
+			continue
+		}
+		dashboard, ok := dashboards[entry.DashboardUID]
+		if !ok {
+			dashboard = &budgetEntry{DashboardUID: entry.DashboardUID}
+			dashboards[entry.DashboardUID] = dashboard
+		}
+		dashboard.add(*entry)
+	}
+	b.lock.Unlock()
+	for _, dashboard := range dashboards {
+		entries = append(entries, *dashboard)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ServerMillis != entries[j].ServerMillis {
+			return entries[i].ServerMillis > entries[j].ServerMillis
+		}
+		return entries[i].LastExecuted.After(entries[j].LastExecuted)
+	})
+	return entries
+}
+
+// handleBudget reports the cost of the queries executed by this datasource instance, most expensive first,
+// either per panel query, or summed per dashboard:
+//
+//	GET /budget?by=panel|dashboard&limit=100
+func (d *MongoDBDatasource) handleBudget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only GET is supported"))
+		return
+	}
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "":
+		by = budgetByPanel
+	case budgetByPanel, budgetByDashboard:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("by must be one of: %s, %s", budgetByPanel, budgetByDashboard))
+		return
+	}
+	limit, err := queryIntParam(r, "limit", 100, maxBudgetEntries)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	entries := d.budget.report(by)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"by": by, "entries": entries})
+}
@@ -0,0 +1,300 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Builder stages are a structured alternative to the raw JSON pipeline, intended for visual query editors.
+// Field names are always treated as names, and values as literals, so template variables bound to them cannot
+// change the shape of the pipeline.
+
+const (
+	builderStageMatch   = "match"
+	builderStageGroup   = "group"
+	builderStageSort    = "sort"
+	builderStageProject = "project"
+	builderStageLimit   = "limit"
+)
+
+// builderOperators maps the operators of match conditions to their query operators
+var builderOperators = map[string]string{
+	"eq":     "$eq",
+	"ne":     "$ne",
+	"gt":     "$gt",
+	"gte":    "$gte",
+	"lt":     "$lt",
+	"lte":    "$lte",
+	"in":     "$in",
+	"nin":    "$nin",
+	"exists": "$exists",
+	"regex":  "$regex",
+}
+
+// builderAccumulators are the accumulators supported by group stages. count does not take a field.
+var builderAccumulators = []string{"count", "sum", "avg", "min", "max", "first", "last"}
+
+type builderCondition struct {
+	Field    string `json:"field"`
+	Operator string `json:"operator"`
+	// Value is parsed as extended JSON, so dates can be given as {"$date": ...}
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type builderAccumulator struct {
+	// Name is the output field
+	Name     string `json:"name"`
+	Operator string `json:"operator"`
+	Field    string `json:"field,omitempty"`
+}
+
+type builderSortField struct {
+	Field      string `json:"field"`
+	Descending bool   `json:"descending,omitempty"`
+}
+
+// builderStage is a single typed stage. Only the fields relevant to its type may be set.
+type builderStage struct {
+	Type string `json:"type"`
+	// Conditions of match stages, all of which must be true
+	Conditions []builderCondition `json:"conditions,omitempty"`
+	// GroupBy are the fields of group stages which identify a group. They are output as top-level fields,
+	// with dots replaced by underscores. No fields produces a single group.
+	GroupBy      []string             `json:"groupBy,omitempty"`
+	Accumulators []builderAccumulator `json:"accumulators,omitempty"`
+	Sort         []builderSortField   `json:"sort,omitempty"`
+	// Fields of project stages are the only fields kept. _id is removed unless it is included.
+	Fields []string `json:"fields,omitempty"`
+	Limit  int64    `json:"limit,omitempty"`
+}
+
+// validateBuilderField checks that a field is a plain field name, and not an expression
+func validateBuilderField(name string) error {
+	if name == "" {
+		return fmt.Errorf("Field names must not be empty")
+	}
+	if strings.HasPrefix(name, "$") {
+		return fmt.Errorf("Field %s must not start with $", name)
+	}
+	if strings.ContainsRune(name, 0) {
+		return fmt.Errorf("Field %s must not contain null characters", name)
+	}
+	return nil
+}
+
+// parseBuilderValue parses a literal value of a match condition
+func parseBuilderValue(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var wrapper bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{"value":`+string(raw)+`}`), false, &wrapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid value")
+	}
+	return wrapper[0].Value, nil
+}
+
+func (c *builderCondition) compile() (bson.E, error) {
+	err := validateBuilderField(c.Field)
+	if err != nil {
+		return bson.E{}, err
+	}
+	operator, ok := builderOperators[c.Operator]
+	if !ok {
+		return bson.E{}, fmt.Errorf("Unknown operator %s for field %s", c.Operator, c.Field)
+	}
+	value, err := parseBuilderValue(c.Value)
+	if err != nil {
+		return bson.E{}, errors.Wrap(err, fmt.Sprintf("Field %s", c.Field))
+	}
+	switch c.Operator {
+	case "in", "nin":
+		if _, ok := value.(bson.A); !ok {
+			return bson.E{}, fmt.Errorf("The %s operator for field %s requires an array", c.Operator, c.Field)
+		}
+	case "exists":
+		if _, ok := value.(bool); !ok {
+			return bson.E{}, fmt.Errorf("The exists operator for field %s requires true or false", c.Field)
+		}
+	case "regex":
+		if _, ok := value.(string); !ok {
+			return bson.E{}, fmt.Errorf("The regex operator for field %s requires a string", c.Field)
+		}
+	}
+	return bson.E{Key: c.Field, Value: bson.D{bson.E{Key: operator, Value: value}}}, nil
+}
+
+func (a *builderAccumulator) compile() (bson.E, error) {
+	err := validateBuilderField(a.Name)
+	if err != nil {
+		return bson.E{}, err
+	}
+	if strings.Contains(a.Name, ".") || a.Name == "_id" {
+		return bson.E{}, fmt.Errorf("Accumulator name %s must not contain dots, or be _id", a.Name)
+	}
+	if a.Operator == "count" {
+		return bson.E{Key: a.Name, Value: bson.D{bson.E{Key: "$sum", Value: 1}}}, nil
+	}
+	for _, operator := range builderAccumulators {
+		if a.Operator != operator {
+			continue
+		}
+		err = validateBuilderField(a.Field)
+		if err != nil {
+			return bson.E{}, errors.Wrap(err, fmt.Sprintf("Accumulator %s", a.Name))
+		}
+		return bson.E{Key: a.Name, Value: bson.D{bson.E{Key: "$" + a.Operator, Value: "$" + a.Field}}}, nil
+	}
+	return bson.E{}, fmt.Errorf("Accumulator %s must be one of: %s", a.Name, strings.Join(builderAccumulators, ", "))
+}
+
+// compileGroup produces a $group stage, followed by the stages which move the group fields out of _id
+func (s *builderStage) compileGroup() (mongo.Pipeline, error) {
+	id := bson.D{}
+	lift := bson.D{}
+	for _, field := range s.GroupBy {
+		err := validateBuilderField(field)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ReplaceAll(field, ".", "_")
+		id = append(id, bson.E{Key: key, Value: "$" + field})
+		lift = append(lift, bson.E{Key: key, Value: "$_id." + key})
+	}
+	group := bson.D{bson.E{Key: "_id", Value: id}}
+	if len(id) == 0 {
+		group[0].Value = nil
+	}
+	for _, accumulator := range s.Accumulators {
+		compiled, err := accumulator.compile()
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, compiled)
+	}
+	pipeline := mongo.Pipeline{bson.D{bson.E{Key: "$group", Value: group}}}
+	if len(lift) != 0 {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$addFields", Value: lift}})
+	}
+	return append(pipeline, bson.D{bson.E{Key: "$project", Value: bson.D{bson.E{Key: "_id", Value: 0}}}}), nil
+}
+
+func (s *builderStage) compile() (mongo.Pipeline, error) {
+	switch s.Type {
+	case builderStageMatch:
+		match := bson.D{}
+		for _, condition := range s.Conditions {
+			compiled, err := condition.compile()
+			if err != nil {
+				return nil, err
+			}
+			match = append(match, compiled)
+		}
+		if len(match) > 1 {
+			// A field may have more than one condition, so they can't share a document
+			clauses := make(bson.A, len(match))
+			for ix, condition := range match {
+				clauses[ix] = bson.D{condition}
+			}
+			match = bson.D{bson.E{Key: "$and", Value: clauses}}
+		}
+		return mongo.Pipeline{bson.D{bson.E{Key: "$match", Value: match}}}, nil
+	case builderStageGroup:
+		return s.compileGroup()
+	case builderStageSort:
+		if len(s.Sort) == 0 {
+			return nil, fmt.Errorf("Sort stages must have at least one field")
+		}
+		sort := bson.D{}
+		for _, field := range s.Sort {
+			err := validateBuilderField(field.Field)
+			if err != nil {
+				return nil, err
+			}
+			direction := 1
+			if field.Descending {
+				direction = -1
+			}
+			sort = append(sort, bson.E{Key: field.Field, Value: direction})
+		}
+		return mongo.Pipeline{bson.D{bson.E{Key: "$sort", Value: sort}}}, nil
+	case builderStageProject:
+		if len(s.Fields) == 0 {
+			return nil, fmt.Errorf("Project stages must have at least one field")
+		}
+		projection := bson.D{}
+		hasID := false
+		for _, field := range s.Fields {
+			err := validateBuilderField(field)
+			if err != nil {
+				return nil, err
+			}
+			hasID = hasID || field == "_id"
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+		if !hasID {
+			projection = append(projection, bson.E{Key: "_id", Value: 0})
+		}
+		return mongo.Pipeline{bson.D{bson.E{Key: "$project", Value: projection}}}, nil
+	case builderStageLimit:
+		if s.Limit <= 0 {
+			return nil, fmt.Errorf("Limit stages must have a positive limit")
+		}
+		return mongo.Pipeline{bson.D{bson.E{Key: "$limit", Value: s.Limit}}}, nil
+	default:
+		return nil, fmt.Errorf(
+			"Stage type must be one of: %s, %s, %s, %s, %s",
+			builderStageMatch, builderStageGroup, builderStageSort, builderStageProject, builderStageLimit,
+		)
+	}
+}
+
+// compileBuilderStages validates builder stages, and compiles them to a pipeline
+func compileBuilderStages(stages []builderStage) (mongo.Pipeline, error) {
+	pipeline := mongo.Pipeline{}
+	for ix, stage := range stages {
+		compiled, err := stage.compile()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Stage %d (%s)", ix+1, stage.Type))
+		}
+		pipeline = append(pipeline, compiled...)
+	}
+	return pipeline, nil
+}
+
+// builderQuery is the fixed-shape query produced by the visual query builder: the documents of the query's collection
+// matching every condition, optionally grouped, then sorted and limited. It compiles to the equivalent builder stages.
+type builderQuery struct {
+	Match        []builderCondition   `json:"match,omitempty"`
+	GroupBy      []string             `json:"groupBy,omitempty"`
+	Accumulators []builderAccumulator `json:"accumulators,omitempty"`
+	Sort         []builderSortField   `json:"sort,omitempty"`
+	Limit        int64                `json:"limit,omitempty"`
+}
+
+// stages produces the builder stages of a builder query, omitting those which are not used
+func (q *builderQuery) stages() ([]builderStage, error) {
+	stages := []builderStage{}
+	if len(q.Match) != 0 {
+		stages = append(stages, builderStage{Type: builderStageMatch, Conditions: q.Match})
+	}
+	if len(q.GroupBy) != 0 || len(q.Accumulators) != 0 {
+		stages = append(stages, builderStage{Type: builderStageGroup, GroupBy: q.GroupBy, Accumulators: q.Accumulators})
+	}
+	if len(q.Sort) != 0 {
+		stages = append(stages, builderStage{Type: builderStageSort, Sort: q.Sort})
+	}
+	if q.Limit < 0 {
+		return nil, fmt.Errorf("Builder query limit must not be negative")
+	}
+	if q.Limit != 0 {
+		stages = append(stages, builderStage{Type: builderStageLimit, Limit: q.Limit})
+	}
+	return stages, nil
+}
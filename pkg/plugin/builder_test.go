@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Builder stages", func() {
+	mctx := MacroContext{From: time.Unix(0, 0).UTC(), To: time.Unix(3600, 0).UTC()}
+
+	pipelineJSON := func(query string) (string, error) {
+		pipeline, err := buildPipeline([]byte(query), mctx)
+		if err != nil {
+			return "", err
+		}
+		bytes, err := bson.MarshalExtJSON(bson.D{{Key: "pipeline", Value: pipeline}}, false, false)
+		return string(bytes), err
+	}
+
+	It("Should compile typed stages", func() {
+		pipeline, err := pipelineJSON(`{"version":2,"aggregation":"ignored","stages":[
+			{"type":"match","conditions":[
+				{"field":"status","operator":"gte","value":500},
+				{"field":"status","operator":"lt","value":600},
+				{"field":"ts","operator":"gt","value":{"$date":"2022-01-01T00:00:00Z"}}
+			]},
+			{"type":"group","groupBy":["host.name"],"accumulators":[{"name":"errors","operator":"count"},{"name":"worst","operator":"max","field":"latency"}]},
+			{"type":"sort","sort":[{"field":"errors","descending":true}]},
+			{"type":"limit","limit":5},
+			{"type":"project","fields":["host_name","errors"]}
+		]}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pipeline).To(MatchJSON(`{"pipeline": [
+			{"$match": {"$and": [
+				{"status": {"$gte": 500}},
+				{"status": {"$lt": 600}},
+				{"ts": {"$gt": {"$date": "2022-01-01T00:00:00Z"}}}
+			]}},
+			{"$group": {"_id": {"host_name": "$host.name"}, "errors": {"$sum": 1}, "worst": {"$max": "$latency"}}},
+			{"$addFields": {"host_name": "$_id.host_name"}},
+			{"$project": {"_id": 0}},
+			{"$sort": {"errors": -1}},
+			{"$limit": 5},
+			{"$project": {"host_name": 1, "errors": 1, "_id": 0}}
+		]}`))
+	})
+
+	It("Should bind values as literals", func() {
+		pipeline, err := pipelineJSON(`{"version":2,"stages":[{"type":"match","conditions":[{"field":"name","operator":"eq","value":{"$where":"sleep(1000)"}}]}]}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pipeline).To(MatchJSON(`{"pipeline": [{"$match": {"name": {"$eq": {"$where": "sleep(1000)"}}}}]}`))
+	})
+
+	DescribeTable("Should reject invalid stages", func(stages string) {
+		_, err := pipelineJSON(`{"version":2,"stages":` + stages + `}`)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("unknown type", `[{"type":"lookup"}]`),
+		Entry("expression as a field", `[{"type":"project","fields":["$where"]}]`),
+		Entry("unknown operator", `[{"type":"match","conditions":[{"field":"a","operator":"where","value":1}]}]`),
+		Entry("in without an array", `[{"type":"match","conditions":[{"field":"a","operator":"in","value":1}]}]`),
+		Entry("accumulator without a field", `[{"type":"group","accumulators":[{"name":"total","operator":"sum"}]}]`),
+		Entry("empty sort", `[{"type":"sort"}]`),
+		Entry("non-positive limit", `[{"type":"limit","limit":0}]`),
+	)
+
+	It("Should not be available to legacy queries", func() {
+		_, err := pipelineJSON(`{"version":1,"stages":[{"type":"limit","limit":1}]}`)
+		Expect(err).To(HaveOccurred())
+	})
+	It("Should compile builder queries in a fixed order", func() {
+		pipeline, err := pipelineJSON(`{"version":2,"builder":{
+			"limit":10,
+			"sort":[{"field":"n","descending":true}],
+			"groupBy":["host"],
+			"accumulators":[{"name":"n","operator":"count"}],
+			"match":[{"field":"status","operator":"eq","value":"error"}]
+		}}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pipeline).To(MatchJSON(`{"pipeline": [
+			{"$match": {"status": {"$eq": "error"}}},
+			{"$group": {"_id": {"host": "$host"}, "n": {"$sum": 1}}},
+			{"$addFields": {"host": "$_id.host"}},
+			{"$project": {"_id": 0}},
+			{"$sort": {"n": -1}},
+			{"$limit": 10}
+		]}`))
+	})
+
+	It("Should reject builder queries combined with stages", func() {
+		_, err := pipelineJSON(`{"version":2,"builder":{"limit":1},"stages":[{"type":"limit","limit":1}]}`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should reject negative builder query limits", func() {
+		_, err := pipelineJSON(`{"version":2,"builder":{"limit":-1}}`)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pkg/errors"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+// queryCacheSweepThreshold is the number of entries above which expired entries are removed when a new entry is stored
+const queryCacheSweepThreshold = 256
+
+// queryCacheIgnoredFields are fields of the query JSON that do not affect its result, and so are not part of the cache key
+var queryCacheIgnoredFields = []string{"refId", "datasource", "datasourceId", "key", "hide", "cache"}
+
+const (
+	// cacheModeUse returns a cached response if there is one, and caches the response otherwise. This is the default.
+	cacheModeUse = "use"
+	// cacheModeRefresh ignores any cached response, and replaces it with the new response
+	cacheModeRefresh = "refresh"
+	// cacheModeBypass neither reads nor writes the cache
+	cacheModeBypass = "bypass"
+
+	// cacheSkipHeader is sent by Grafana when the user asks for fresh data, in which case the cache is bypassed
+	cacheSkipHeader = "X-Cache-Skip"
+)
+
+// QueryCacheMode returns how a query uses the cache, from the cache field of the query, or bypass if Grafana
+// sent the cache skip header with the request
+func QueryCacheMode(queryJSON []byte, headers map[string]string) (string, error) {
+	var model struct {
+		Cache string `json:"cache"`
+	}
+	err := json.Unmarshal(queryJSON, &model)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to parse query")
+	}
+	switch model.Cache {
+	case "", cacheModeUse, cacheModeRefresh, cacheModeBypass:
+	default:
+		return "", fmt.Errorf("Cache must be one of: %s, %s, %s", cacheModeUse, cacheModeRefresh, cacheModeBypass)
+	}
+	if skip, _ := strconv.ParseBool(headerValue(headers, cacheSkipHeader)); skip {
+		return cacheModeBypass, nil
+	}
+	if model.Cache == "" {
+		return cacheModeUse, nil
+	}
+	return model.Cache, nil
+}
+
+type queryCacheEntry struct {
+	response backend.DataResponse
+	expires  time.Time
+}
+
+// queryCache holds successful query responses for a fixed time-to-live.
+// In order for queries with a moving time range to hit the cache, time ranges are aligned to multiples of the time-to-live.
+type queryCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]queryCacheEntry
+}
+
+func newQueryCache(ttl time.Duration) *queryCache {
+	return &queryCache{
+		ttl:     ttl,
+		entries: make(map[string]queryCacheEntry),
+	}
+}
+
+// alignTimeRange truncates both ends of a time range to a multiple of the cache time-to-live
+func (c *queryCache) alignTimeRange(timeRange backend.TimeRange) backend.TimeRange {
+	return backend.TimeRange{
+		From: timeRange.From.Truncate(c.ttl),
+		To:   timeRange.To.Truncate(c.ttl),
+	}
+}
+
+func (c *queryCache) get(key string) (backend.DataResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return backend.DataResponse{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return backend.DataResponse{}, false
+	}
+	return entry.response, true
+}
+
+// set caches a response. The frames of the response are detached from the arenas they were converted into, see bsonframe.DetachCells.
+func (c *queryCache) set(key string, response backend.DataResponse) {
+	bsonframe.DetachCells(response.Frames)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if len(c.entries) >= queryCacheSweepThreshold {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = queryCacheEntry{response: response, expires: now.Add(c.ttl)}
+}
+
+// QueryCacheKey computes a key which is identical for any two queries which would produce the same result
+func QueryCacheKey(intent string, query backend.DataQuery) (string, error) {
+	var model map[string]interface{}
+	err := json.Unmarshal(query.JSON, &model)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to parse query")
+	}
+	for _, field := range queryCacheIgnoredFields {
+		delete(model, field)
+	}
+	// encoding/json sorts map keys, so equivalent queries always produce the same bytes
+	normalized, err := json.Marshal(model)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to remarshal query")
+	}
+	hash := sha256.New()
+	hash.Write([]byte(intent))
+	hash.Write([]byte{0})
+	hash.Write(normalized)
+	hash.Write([]byte{0})
+	hash.Write([]byte(strconv.FormatInt(query.TimeRange.From.UnixNano(), 10)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(strconv.FormatInt(query.TimeRange.To.UnixNano(), 10)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(strconv.FormatInt(int64(query.Interval), 10)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(strconv.FormatInt(query.MaxDataPoints, 10)))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cachedQuery executes a query, using the cache if one is configured, according to the cache mode. See QueryCacheMode.
+func (d *MongoDBDatasource) cachedQuery(ctx context.Context, pCtx backend.PluginContext, origin requestOrigin, query backend.DataQuery, mode string) backend.DataResponse {
+	if d.cache == nil || mode == cacheModeBypass {
+		return d.query(ctx, pCtx, origin, query)
+	}
+	// Only the key uses the aligned time range, so that the query itself still returns the newest data
+	aligned := query
+	aligned.TimeRange = d.cache.alignTimeRange(query.TimeRange)
+	key, err := QueryCacheKey(origin.readIntent(), aligned)
+	if err != nil {
+		// The query itself will fail to parse and report a proper error
+		return d.query(ctx, pCtx, origin, query)
+	}
+	if mode != cacheModeRefresh {
+		if response, ok := d.cache.get(key); ok {
+			return response
+		}
+	}
+	response := d.query(ctx, pCtx, origin, query)
+	if response.Error == nil {
+		d.cache.set(key, response)
+	}
+	return response
+}
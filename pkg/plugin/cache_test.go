@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QueryCacheMode", func() {
+	It("Should use the cache by default", func() {
+		Expect(QueryCacheMode([]byte(`{}`), nil)).To(Equal("use"))
+	})
+
+	It("Should use the mode of the query", func() {
+		Expect(QueryCacheMode([]byte(`{"cache": "refresh"}`), nil)).To(Equal("refresh"))
+	})
+
+	It("Should bypass the cache when Grafana asks to skip it", func() {
+		Expect(QueryCacheMode([]byte(`{"cache": "refresh"}`), map[string]string{"http_X-Cache-Skip": "true"})).To(Equal("bypass"))
+		Expect(QueryCacheMode([]byte(`{}`), map[string]string{"X-Cache-Skip": "false"})).To(Equal("use"))
+	})
+
+	It("Should reject unknown modes", func() {
+		_, err := QueryCacheMode([]byte(`{"cache": "sometimes"}`), nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("QueryCacheKey", func() {
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	base := backend.DataQuery{JSON: []byte(`{"collection": "c"}`), TimeRange: timeRange, Interval: time.Minute, MaxDataPoints: 100}
+	keyOf := func(query backend.DataQuery) string {
+		key, err := QueryCacheKey(readIntentDashboard, query)
+		Expect(err).ToNot(HaveOccurred())
+		return key
+	}
+	key := func(queryJSON string) string {
+		query := base
+		query.JSON = []byte(queryJSON)
+		return keyOf(query)
+	}
+
+	It("Should ignore fields which do not affect the result", func() {
+		Expect(key(`{"refId": "A", "collection": "c", "hide": false}`)).To(Equal(key(`{"refId": "B", "collection": "c"}`)))
+	})
+
+	It("Should distinguish queries which differ only in their query type", func() {
+		Expect(key(`{"queryType": "Table", "collection": "c"}`)).ToNot(Equal(key(`{"queryType": "Timeseries", "collection": "c"}`)))
+	})
+
+	DescribeTable("Should change with", func(change func(*backend.DataQuery)) {
+		changed := base
+		change(&changed)
+		Expect(keyOf(changed)).ToNot(Equal(keyOf(base)))
+	},
+		Entry("the start of the time range", func(q *backend.DataQuery) { q.TimeRange.From = q.TimeRange.From.Add(time.Second) }),
+		Entry("the end of the time range", func(q *backend.DataQuery) { q.TimeRange.To = q.TimeRange.To.Add(time.Second) }),
+		Entry("the interval", func(q *backend.DataQuery) { q.Interval = time.Second }),
+		Entry("the maximum data points", func(q *backend.DataQuery) { q.MaxDataPoints = 1000 }),
+	)
+
+	It("Should change with the read intent", func() {
+		alert, err := QueryCacheKey(readIntentAlert, base)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(alert).ToNot(Equal(keyOf(base)))
+	})
+})
+
+var _ = Describe("cachedQuery", func() {
+	// The cached query is one which fails to parse if it is actually executed, so that a cache miss is visible in the response
+	query := backend.DataQuery{
+		JSON:      []byte(`{"queryType": 1}`),
+		TimeRange: backend.TimeRange{From: time.Unix(10, 0), To: time.Unix(3590, 0)},
+	}
+	cached := backend.DataResponse{Frames: data.Frames{data.NewFrame("cached")}}
+	var d *MongoDBDatasource
+
+	BeforeEach(func() {
+		d = &MongoDBDatasource{cache: newQueryCache(time.Minute)}
+		aligned := query
+		aligned.TimeRange = backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3540, 0)}
+		key, err := QueryCacheKey(readIntentDashboard, aligned)
+		Expect(err).ToNot(HaveOccurred())
+		d.cache.set(key, cached)
+	})
+
+	It("Should return the cached response for a time range in the same time-to-live window", func() {
+		Expect(d.cachedQuery(context.Background(), backend.PluginContext{}, requestOrigin{}, query, cacheModeUse)).To(Equal(cached))
+	})
+
+	It("Should not return the cached response for a different time range", func() {
+		moved := query
+		moved.TimeRange.To = moved.TimeRange.To.Add(time.Minute)
+		Expect(d.cachedQuery(context.Background(), backend.PluginContext{}, requestOrigin{}, moved, cacheModeUse).Error).To(HaveOccurred())
+	})
+
+	It("Should execute the query when refreshing", func() {
+		Expect(d.cachedQuery(context.Background(), backend.PluginContext{}, requestOrigin{}, query, cacheModeRefresh).Error).To(HaveOccurred())
+	})
+
+	It("Should execute the query when Grafana asks to skip the cache", func() {
+		mode, err := QueryCacheMode([]byte(`{}`), map[string]string{"http_X-Cache-Skip": "true"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(d.cachedQuery(context.Background(), backend.PluginContext{}, requestOrigin{}, query, mode).Error).To(HaveOccurred())
+	})
+})
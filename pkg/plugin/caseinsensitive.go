@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	caseInsensitiveStrategyCollation = "collation"
+	caseInsensitiveStrategyRegex     = "regex"
+)
+
+// caseInsensitiveFilter restricts a query to documents where a field equals a value, ignoring case
+type caseInsensitiveFilter struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// caseInsensitiveStrategy reports how a caseInsensitiveFilter was implemented, and is included in the frame metadata
+type caseInsensitiveStrategy struct {
+	Field    string `json:"field"`
+	Strategy string `json:"strategy"`
+	Index    string `json:"index,omitempty"`
+}
+
+// indexCollation is the collation of an index, as returned by listIndexes
+type indexCollation struct {
+	Locale          string `bson:"locale"`
+	CaseLevel       bool   `bson:"caseLevel"`
+	CaseFirst       string `bson:"caseFirst"`
+	Strength        int    `bson:"strength"`
+	NumericOrdering bool   `bson:"numericOrdering"`
+	Alternate       string `bson:"alternate"`
+	MaxVariable     string `bson:"maxVariable"`
+	Normalization   bool   `bson:"normalization"`
+	Backwards       bool   `bson:"backwards"`
+}
+
+func (c *indexCollation) toOptions() *mongoOpts.Collation {
+	return &mongoOpts.Collation{
+		Locale:          c.Locale,
+		CaseLevel:       c.CaseLevel,
+		CaseFirst:       c.CaseFirst,
+		Strength:        c.Strength,
+		NumericOrdering: c.NumericOrdering,
+		Alternate:       c.Alternate,
+		MaxVariable:     c.MaxVariable,
+		Normalization:   c.Normalization,
+		Backwards:       c.Backwards,
+	}
+}
+
+// ignoresCase returns true if equality under the collation is case-insensitive
+func (c *indexCollation) ignoresCase() bool {
+	return c.Locale != "" && c.Locale != "simple" && (c.Strength == 1 || c.Strength == 2) && !c.CaseLevel
+}
+
+type indexSpec struct {
+	Name      string          `bson:"name"`
+	Key       bson.D          `bson:"key"`
+	Collation *indexCollation `bson:"collation"`
+}
+
+// listCaseInsensitiveIndexes returns the indexes of a collection which can serve case-insensitive equality on their leading field
+func listCaseInsensitiveIndexes(ctx context.Context, collection *mongo.Collection) ([]indexSpec, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list indexes")
+	}
+	defer cursor.Close(ctx)
+	indexes := make([]indexSpec, 0)
+	for cursor.Next(ctx) {
+		var index indexSpec
+		err = cursor.Decode(&index)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to decode index")
+		}
+		if index.Collation != nil && index.Collation.ignoresCase() && len(index.Key) != 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes, cursor.Err()
+}
+
+// planCaseInsensitiveFilters produces a $match stage implementing a set of case-insensitive filters.
+// Filters on fields which lead a case-insensitive index are implemented as plain equality, using the collation of that index,
+// so that the index can be used. Because a query can only have one collation, only indexes with the same collation
+// as the first chosen index are eligible. All other filters are implemented as anchored, case-insensitive regular expressions.
+// Note that the collation applies to the entire pipeline, and so will affect other string comparisons, such as $group keys.
+// If useIndexes is false, indexes are not listed, and every filter is implemented as a regular expression.
+func planCaseInsensitiveFilters(ctx context.Context, collection *mongo.Collection, filters []caseInsensitiveFilter, useIndexes bool) (bson.D, *mongoOpts.Collation, []caseInsensitiveStrategy, error) {
+	var indexes []indexSpec
+	if useIndexes {
+		var err error
+		indexes, err = listCaseInsensitiveIndexes(ctx, collection)
+		if err != nil {
+			log.DefaultLogger.Warn("Could not list indexes, falling back to regular expressions for case-insensitive filters", "error", err)
+			indexes = nil
+		}
+	}
+	return matchCaseInsensitiveFilters(indexes, filters)
+}
+
+// matchCaseInsensitiveFilters implements planCaseInsensitiveFilters once the case-insensitive indexes are known
+func matchCaseInsensitiveFilters(indexes []indexSpec, filters []caseInsensitiveFilter) (bson.D, *mongoOpts.Collation, []caseInsensitiveStrategy, error) {
+	var chosen *indexCollation
+	match := bson.D{}
+	strategies := make([]caseInsensitiveStrategy, len(filters))
+	for ix, filter := range filters {
+		if filter.Field == "" {
+			return nil, nil, nil, errors.New("Case-insensitive filters must have a field")
+		}
+		strategies[ix].Field = filter.Field
+		for _, index := range indexes {
+			if index.Key[0].Key != filter.Field || (chosen != nil && *chosen != *index.Collation) {
+				continue
+			}
+			chosen = index.Collation
+			strategies[ix].Strategy = caseInsensitiveStrategyCollation
+			strategies[ix].Index = index.Name
+			match = append(match, bson.E{Key: filter.Field, Value: filter.Value})
+			break
+		}
+		if strategies[ix].Strategy == "" {
+			strategies[ix].Strategy = caseInsensitiveStrategyRegex
+			match = append(match, bson.E{Key: filter.Field, Value: bson.D{
+				bson.E{Key: "$regex", Value: "^" + regexp.QuoteMeta(filter.Value) + "$"},
+				bson.E{Key: "$options", Value: "i"},
+			}})
+		}
+	}
+
+	var collation *mongoOpts.Collation
+	if chosen != nil {
+		collation = chosen.toOptions()
+	}
+	return bson.D{bson.E{Key: "$match", Value: match}}, collation, strategies, nil
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.mongodb.org/mongo-driver/bson"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ = Describe("indexCollation", func() {
+	DescribeTable("Should ignore case", func(collation indexCollation, expected bool) {
+		Expect(collation.ignoresCase()).To(Equal(expected))
+	},
+		Entry("at primary strength", indexCollation{Locale: "en", Strength: 1}, true),
+		Entry("at secondary strength", indexCollation{Locale: "en", Strength: 2}, true),
+		Entry("not at tertiary strength", indexCollation{Locale: "en", Strength: 3}, false),
+		Entry("not with the case level", indexCollation{Locale: "en", Strength: 2, CaseLevel: true}, false),
+		Entry("not with the simple locale", indexCollation{Locale: "simple", Strength: 2}, false),
+		Entry("not without a locale", indexCollation{Strength: 2}, false),
+	)
+})
+
+var _ = Describe("matchCaseInsensitiveFilters", func() {
+	english := &indexCollation{Locale: "en", Strength: 2}
+	french := &indexCollation{Locale: "fr", Strength: 2}
+	regex := func(value string) bson.D {
+		return bson.D{{Key: "$regex", Value: value}, {Key: "$options", Value: "i"}}
+	}
+
+	It("Should use anchored, escaped regular expressions without a suitable index", func() {
+		indexes := []indexSpec{{Name: "other_1", Key: bson.D{{Key: "other", Value: 1}}, Collation: english}}
+		match, collation, strategies, err := matchCaseInsensitiveFilters(indexes, []caseInsensitiveFilter{{Field: "host", Value: "web-1.example"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(match).To(Equal(bson.D{{Key: "$match", Value: bson.D{{Key: "host", Value: regex(`^web-1\.example$`)}}}}))
+		Expect(collation).To(BeNil())
+		Expect(strategies).To(Equal([]caseInsensitiveStrategy{{Field: "host", Strategy: caseInsensitiveStrategyRegex}}))
+	})
+
+	It("Should use equality and the index collation for fields which lead a case-insensitive index", func() {
+		indexes := []indexSpec{{Name: "host_1_ts_1", Key: bson.D{{Key: "host", Value: 1}, {Key: "ts", Value: 1}}, Collation: english}}
+		match, collation, strategies, err := matchCaseInsensitiveFilters(indexes, []caseInsensitiveFilter{{Field: "host", Value: "Web-1"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(match).To(Equal(bson.D{{Key: "$match", Value: bson.D{{Key: "host", Value: "Web-1"}}}}))
+		Expect(collation).To(Equal(&mongoOpts.Collation{Locale: "en", Strength: 2}))
+		Expect(strategies).To(Equal([]caseInsensitiveStrategy{{Field: "host", Strategy: caseInsensitiveStrategyCollation, Index: "host_1_ts_1"}}))
+	})
+
+	It("Should only use indexes with the same collation as the first chosen index", func() {
+		indexes := []indexSpec{
+			{Name: "host_1", Key: bson.D{{Key: "host", Value: 1}}, Collation: english},
+			{Name: "region_1", Key: bson.D{{Key: "region", Value: 1}}, Collation: french},
+			{Name: "user_1", Key: bson.D{{Key: "user", Value: 1}}, Collation: &indexCollation{Locale: "en", Strength: 2}},
+		}
+		filters := []caseInsensitiveFilter{{Field: "host", Value: "a"}, {Field: "region", Value: "b"}, {Field: "user", Value: "c"}}
+		match, collation, strategies, err := matchCaseInsensitiveFilters(indexes, filters)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(match).To(Equal(bson.D{{Key: "$match", Value: bson.D{
+			{Key: "host", Value: "a"},
+			{Key: "region", Value: regex("^b$")},
+			{Key: "user", Value: "c"},
+		}}}))
+		Expect(collation).To(Equal(&mongoOpts.Collation{Locale: "en", Strength: 2}))
+		Expect(strategies).To(Equal([]caseInsensitiveStrategy{
+			{Field: "host", Strategy: caseInsensitiveStrategyCollation, Index: "host_1"},
+			{Field: "region", Strategy: caseInsensitiveStrategyRegex},
+			{Field: "user", Strategy: caseInsensitiveStrategyCollation, Index: "user_1"},
+		}))
+	})
+
+	It("Should reject filters without a field", func() {
+		_, _, _, err := matchCaseInsensitiveFilters(nil, []caseInsensitiveFilter{{Value: "a"}})
+		Expect(err).To(MatchError(ContainSubstring("must have a field")))
+	})
+})
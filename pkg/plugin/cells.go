@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	cellEncodingZstd = "zstd"
+	cellEncodingGzip = "gzip"
+
+	// defaultCompressCellBytes is the size above which JSON cells are compressed, if not set.
+	// Cells must also be within the maximum cell size of the datasource, or they are replaced with null before this happens.
+	defaultCompressCellBytes = 64 << 10
+)
+
+// cellCompression moves large JSON cells out of table cells and into the metadata of their frame, compressed,
+// replacing each with a link to the /collections/{coll}/document/{id} route which serves the full document
+type cellCompression struct {
+	// MinBytes is the size above which cells are compressed
+	MinBytes int `json:"minBytes,omitempty"`
+	// Encoding is either zstd (the default) or gzip
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func (c *cellCompression) validate() error {
+	switch c.Encoding {
+	case "", cellEncodingZstd, cellEncodingGzip:
+	default:
+		return fmt.Errorf("Cell compression encoding must be one of: %s, %s", cellEncodingZstd, cellEncodingGzip)
+	}
+	if c.MinBytes < 0 {
+		return fmt.Errorf("Cell compression minimum size must not be negative")
+	}
+	return nil
+}
+
+// CompressedCell is the compressed content of a large JSON cell, as found in the custom metadata of its frame
+type CompressedCell struct {
+	Field           string `json:"field"`
+	Row             int    `json:"row"`
+	Bytes           int    `json:"bytes"`
+	CompressedBytes int    `json:"compressedBytes"`
+	Encoding        string `json:"encoding"`
+	// Data is the compressed cell, which is base64 encoded in JSON
+	Data []byte `json:"data"`
+}
+
+// compressedCellPlaceholder replaces a compressed cell
+type compressedCellPlaceholder struct {
+	TruncatedBytes int `json:"truncatedBytes"`
+	// Document links to the full document, if the frame has an _id field
+	Document string `json:"document,omitempty"`
+}
+
+func compressCell(cell []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case cellEncodingZstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(cell, nil), nil
+	case cellEncodingGzip:
+		var out bytes.Buffer
+		writer := gzip.NewWriter(&out)
+		_, err := writer.Write(cell)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.Close()
+		if err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("Cell compression encoding must be one of: %s, %s", cellEncodingZstd, cellEncodingGzip)
+	}
+}
+
+// CompressLargeCells compresses every JSON cell larger than the configured size into the custom metadata of its frame,
+// under compressedCells. idOf returns the link to the document of a row, if there is one. It returns the number of cells
+// compressed, and their total size before and after compression.
+func CompressLargeCells(frame *data.Frame, c cellCompression, idOf func(row int) (string, bool)) (count, before, after int, err error) {
+	err = c.validate()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if c.MinBytes <= 0 {
+		c.MinBytes = defaultCompressCellBytes
+	}
+	if c.Encoding == "" {
+		c.Encoding = cellEncodingZstd
+	}
+	cells := []CompressedCell{}
+	for _, field := range frame.Fields {
+		if field.Type().NonNullableType() != data.FieldTypeJSON {
+			continue
+		}
+		for row := 0; row < field.Len(); row++ {
+			value, ok := field.ConcreteAt(row)
+			if !ok {
+				continue
+			}
+			cell := value.(json.RawMessage)
+			if len(cell) <= c.MinBytes {
+				continue
+			}
+			compressed, err := compressCell(cell, c.Encoding)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			cells = append(cells, CompressedCell{
+				Field:           field.Name,
+				Row:             row,
+				Bytes:           len(cell),
+				CompressedBytes: len(compressed),
+				Encoding:        c.Encoding,
+				Data:            compressed,
+			})
+			placeholder := compressedCellPlaceholder{TruncatedBytes: len(cell)}
+			placeholder.Document, _ = idOf(row)
+			replacement, err := json.Marshal(placeholder)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			field.SetConcrete(row, json.RawMessage(replacement))
+			before += len(cell)
+			after += len(compressed)
+		}
+	}
+	if len(cells) == 0 {
+		return 0, 0, 0, nil
+	}
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Custom = mergeCustomMeta(frame.Meta.Custom, map[string]interface{}{"compressedCells": cells})
+	return len(cells), before, after, nil
+}
+
+// mergeCustomMeta adds keys to the custom metadata of a frame, keeping any which are already set
+func mergeCustomMeta(existing interface{}, custom map[string]interface{}) interface{} {
+	existingMap, ok := existing.(map[string]interface{})
+	if !ok {
+		return custom
+	}
+	merged := make(map[string]interface{}, len(existingMap)+len(custom))
+	for key, value := range existingMap {
+		merged[key] = value
+	}
+	for key, value := range custom {
+		merged[key] = value
+	}
+	return merged
+}
+
+// compressQueryCells compresses the large cells of every frame of a query, linking each to its document by the _id field, if any
+func (m *QueryModel) compressQueryCells(frames []*data.Frame, datasourceUID string) ([]data.Notice, error) {
+	count, before, after := 0, 0, 0
+	for _, frame := range frames {
+		idField := frameField(frame, "_id")
+		idOf := func(row int) (string, bool) {
+			if idField == nil {
+				return "", false
+			}
+			id, ok := idField.ConcreteAt(row)
+			if !ok {
+				return "", false
+			}
+			link, err := DocumentLink(datasourceUID, m.Database, m.Collection, id)
+			return link, err == nil
+		}
+		frameCount, frameBefore, frameAfter, err := CompressLargeCells(frame, *m.CompressCells, idOf)
+		if err != nil {
+			return nil, err
+		}
+		count += frameCount
+		before += frameBefore
+		after += frameAfter
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	return []data.Notice{{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("%d large cell(s) were compressed from %d to %d bytes into the frame metadata, and replaced with links to their documents", count, before, after),
+	}}, nil
+}
@@ -0,0 +1,70 @@
+package plugin_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CompressLargeCells", func() {
+	large := json.RawMessage(`{"payload": "` + strings.Repeat("x", 200) + `"}`)
+	small := json.RawMessage(`{"payload": "x"}`)
+	link := func(row int) (string, bool) { return "/document/" + string(rune('a'+row)), true }
+
+	compress := func(text string) (*data.Frame, int) {
+		qm := plugin.QueryModel{}
+		Expect(json.Unmarshal([]byte(text), &qm)).To(Succeed())
+		frame := data.NewFrame("", data.NewField("doc", nil, []*json.RawMessage{&small, &large, nil}))
+		count, before, after, err := plugin.CompressLargeCells(frame, *qm.CompressCells, link)
+		Expect(err).ToNot(HaveOccurred())
+		if count != 0 {
+			Expect(before).To(Equal(len(large)))
+			Expect(after).To(BeNumerically("<", before))
+		}
+		return frame, count
+	}
+
+	cells := func(frame *data.Frame) []plugin.CompressedCell {
+		return frame.Meta.Custom.(map[string]interface{})["compressedCells"].([]plugin.CompressedCell)
+	}
+
+	It("Should replace large cells with links, and compress them with zstd by default", func() {
+		frame, count := compress(`{"compressCells": {"minBytes": 100}}`)
+		Expect(count).To(Equal(1))
+		Expect(frame.Fields[0].At(0)).To(Equal(&small))
+		Expect(string(*frame.Fields[0].At(1).(*json.RawMessage))).To(MatchJSON(`{"truncatedBytes": 215, "document": "/document/b"}`))
+		cell := cells(frame)[0]
+		Expect(cell.Row).To(Equal(1))
+		Expect(cell.Encoding).To(Equal("zstd"))
+		decoder, err := zstd.NewReader(nil)
+		Expect(err).ToNot(HaveOccurred())
+		decompressed, err := decoder.DecodeAll(cell.Data, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decompressed).To(Equal([]byte(large)))
+	})
+
+	It("Should compress with gzip", func() {
+		frame, _ := compress(`{"compressCells": {"minBytes": 100, "encoding": "gzip"}}`)
+		reader, err := gzip.NewReader(bytes.NewReader(cells(frame)[0].Data))
+		Expect(err).ToNot(HaveOccurred())
+		decompressed, err := io.ReadAll(reader)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decompressed).To(Equal([]byte(large)))
+	})
+
+	It("Should leave cells smaller than the default size", func() {
+		frame, count := compress(`{"compressCells": {}}`)
+		Expect(count).To(Equal(0))
+		Expect(frame.Meta).To(BeNil())
+	})
+})
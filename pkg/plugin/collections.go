@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultFieldValuesLimit = 50
+	maxFieldValuesLimit     = 1000
+)
+
+type fieldValuesResponse struct {
+	Values []interface{} `json:"values"`
+}
+
+// handleCollections serves routes which describe collections, all of which require the database query parameter:
+//
+//	GET /collections
+//	GET /collections/{coll}/fields/{path}/values?q=...&limit=50
+//	GET /collections/{coll}/estimates?fields=a,b&sample=1000
+//	GET /collections/{coll}/document/{id}
+//	GET /collections/{coll}/keys?sample=100
+//	GET /collections/{coll}/indexes
+func (d *MongoDBDatasource) handleCollections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only GET is supported"))
+		return
+	}
+	segments, err := resourcePathSegments(r, "/collections")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	database := r.URL.Query().Get("database")
+	if database == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("The database query parameter is required"))
+		return
+	}
+	switch {
+	case len(segments) == 0:
+		d.handleListCollections(w, r, database)
+	case len(segments) == 4 && segments[1] == "fields" && segments[3] == "values":
+		d.handleFieldValues(w, r, database, segments[0], segments[2])
+	case len(segments) == 2 && segments[1] == "estimates":
+		d.handleEstimates(w, r, database, segments[0])
+	case len(segments) == 3 && segments[1] == "document":
+		d.handleDocument(w, r, database, segments[0], segments[2])
+	case len(segments) == 2 && segments[1] == "keys":
+		d.handleAdhocKeys(w, r, database, segments[0])
+	case len(segments) == 2 && segments[1] == "indexes":
+		d.handleIndexes(w, r, database, segments[0])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("Not found"))
+	}
+}
+
+type collectionsResponse struct {
+	Collections []string `json:"collections"`
+	// Source is "static" if the collections were configured in the datasource settings, "storage" if they were read from the
+	// storage configuration of an Atlas Data Federation endpoint, or "server" if they were listed from MongoDB
+	Source string `json:"source"`
+}
+
+// handleListCollections lists the collections in a database which the datasource user is authorized to query.
+// If a static list of collections is configured for the database, it is returned instead, for users which cannot list collections at all.
+func (d *MongoDBDatasource) handleListCollections(w http.ResponseWriter, r *http.Request, database string) {
+	settings, err := loadDatasource(httpadapter.PluginConfigFromContext(r.Context()))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if static, ok := settings.StaticCollections[database]; ok {
+		writeJSON(w, http.StatusOK, collectionsResponse{Collections: static, Source: "static"})
+		return
+	}
+
+	ctx, client, _, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if settings.DataFederation {
+		names, err := listFederatedCollections(ctx, client, database)
+		if err != nil {
+			writeMongoError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, collectionsResponse{Collections: names, Source: "storage"})
+		return
+	}
+
+	opts := mongoOpts.ListCollections().SetNameOnly(true).SetAuthorizedCollections(true)
+	names, err := client.Database(database).ListCollectionNames(ctx, bson.D{}, opts)
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to list collections"))
+		return
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, collectionsResponse{Collections: names, Source: "server"})
+}
+
+// handleFieldValues returns the distinct values of a field, optionally only those which start with a prefix.
+// The prefix is matched with an anchored regular expression, so that an index on the field can be used.
+func (d *MongoDBDatasource) handleFieldValues(w http.ResponseWriter, r *http.Request, database, collection, path string) {
+	limit, err := queryIntParam(r, "limit", defaultFieldValuesLimit, maxFieldValuesLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	prefix := r.URL.Query().Get("q")
+
+	ctx, client, _, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	pipeline := fieldValuesPipeline(path, prefix, limit)
+	cursor, err := client.Database(database).Collection(collection).Aggregate(ctx, pipeline, mongoOpts.Aggregate().SetComment(newQueryComment()))
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to query field values"))
+		return
+	}
+	defer cursor.Close(ctx)
+
+	values := make([]interface{}, 0, limit)
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID interface{} `bson:"_id"`
+		}
+		err = cursor.Decode(&doc)
+		if err != nil {
+			writeMongoError(w, errors.Wrap(err, "Failed to decode field value"))
+			return
+		}
+		if doc.ID == nil {
+			continue
+		}
+		value, _, err := ToGrafanaValue(doc.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		values = append(values, value)
+	}
+	if cursor.Err() != nil {
+		writeMongoError(w, errors.Wrap(cursor.Err(), "Failed to query field values"))
+		return
+	}
+	writeJSON(w, http.StatusOK, fieldValuesResponse{Values: values})
+}
+
+// fieldValuesPipeline produces the pipeline which returns up to limit distinct values of a field, in order,
+// which start with a prefix, if one is given
+func fieldValuesPipeline(path, prefix string, limit int) mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if prefix != "" {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$match", Value: bson.D{
+			bson.E{Key: path, Value: bson.D{bson.E{Key: "$regex", Value: "^" + regexp.QuoteMeta(prefix)}}},
+		}}})
+	}
+	return append(pipeline,
+		bson.D{bson.E{Key: "$group", Value: bson.D{bson.E{Key: "_id", Value: "$" + path}}}},
+		bson.D{bson.E{Key: "$sort", Value: bson.D{bson.E{Key: "_id", Value: 1}}}},
+		bson.D{bson.E{Key: "$limit", Value: limit}},
+	)
+}
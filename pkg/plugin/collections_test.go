@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var _ = Describe("fieldValuesPipeline", func() {
+	It("Should group, sort and limit the values of a field", func() {
+		Expect(fieldValuesPipeline("host.name", "", 50)).To(Equal(mongo.Pipeline{
+			{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$host.name"}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+			{{Key: "$limit", Value: 50}},
+		}))
+	})
+
+	It("Should match a prefix with an anchored, escaped regular expression", func() {
+		pipeline := fieldValuesPipeline("host", "web.1", 10)
+		Expect(pipeline).To(HaveLen(4))
+		Expect(pipeline[0]).To(Equal(bson.D{{Key: "$match", Value: bson.D{
+			{Key: "host", Value: bson.D{{Key: "$regex", Value: `^web\.1`}}},
+		}}}))
+	})
+})
+
+var _ = Describe("Collection routes", func() {
+	settings := `{"url": "mongodb://localhost:27017"}`
+
+	DescribeTable("Should reject", func(url string, status int, message string) {
+		code, body := callResource(settings, url)
+		Expect(code).To(Equal(status))
+		Expect(body).To(ContainSubstring(message))
+	},
+		Entry("requests without a database", "collections/c/fields/host/values", http.StatusBadRequest, "The database query parameter is required"),
+		Entry("unknown routes", "collections/c/unknown?database=db", http.StatusNotFound, "Not found"),
+		Entry("invalid limits", "collections/c/fields/host/values?database=db&limit=none", http.StatusBadRequest, "limit must be a positive integer"),
+	)
+})
@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const (
+	maxLoggedStringLength = 256
+	maxLoggedArrayLength  = 20
+	maxLoggedDepth        = 8
+	maxLoggedCommandBytes = 8192
+
+	redactedValue = "<redacted>"
+)
+
+// redactedCommandFields are the fields which are never logged, wherever they appear in a command or reply.
+// The driver already omits the bodies of authentication commands, so these are a second line of defense.
+var redactedCommandFields = map[string]struct{}{
+	"password":      {},
+	"pwd":           {},
+	"payload":       {},
+	"secret":        {},
+	"token":         {},
+	"authorization": {},
+	"signature":     {},
+	"saslstart":     {},
+	"saslcontinue":  {},
+}
+
+// replyBatchFields are the fields of replies which hold the documents returned by a query. They are user data, and may be
+// arbitrarily large, so only their lengths are logged.
+var replyBatchFields = map[string]struct{}{
+	"firstBatch": {},
+	"nextBatch":  {},
+	"values":     {},
+}
+
+// sanitizeValue replaces sensitive and oversized values with placeholders
+func sanitizeValue(key string, value interface{}, depth int) interface{} {
+	if _, redacted := redactedCommandFields[strings.ToLower(key)]; redacted {
+		return redactedValue
+	}
+	switch v := value.(type) {
+	case bson.D:
+		if depth >= maxLoggedDepth {
+			return "<nested document>"
+		}
+		sanitized := make(bson.D, len(v))
+		for ix, elem := range v {
+			if _, batch := replyBatchFields[elem.Key]; batch {
+				if docs, ok := elem.Value.(bson.A); ok {
+					sanitized[ix] = bson.E{Key: elem.Key, Value: fmt.Sprintf("<%d documents>", len(docs))}
+					continue
+				}
+			}
+			sanitized[ix] = bson.E{Key: elem.Key, Value: sanitizeValue(elem.Key, elem.Value, depth+1)}
+		}
+		return sanitized
+	case bson.A:
+		if depth >= maxLoggedDepth {
+			return "<nested array>"
+		}
+		length := len(v)
+		if length > maxLoggedArrayLength {
+			length = maxLoggedArrayLength
+		}
+		sanitized := make(bson.A, length, length+1)
+		for ix := range sanitized {
+			sanitized[ix] = sanitizeValue("", v[ix], depth+1)
+		}
+		if len(v) > length {
+			sanitized = append(sanitized, fmt.Sprintf("<%d more>", len(v)-length))
+		}
+		return sanitized
+	case string:
+		if len(v) > maxLoggedStringLength {
+			return fmt.Sprintf("%s...<%d bytes>", v[:maxLoggedStringLength], len(v))
+		}
+		return v
+	case bsonPrim.Binary:
+		return fmt.Sprintf("<binary %d bytes>", len(v.Data))
+	default:
+		return v
+	}
+}
+
+// SanitizeCommand produces a copy of a command or reply which is safe to log, with credentials redacted,
+// returned documents replaced by their count, and long strings, long arrays, binary data, and deeply nested values truncated
+func SanitizeCommand(raw bson.Raw) (bson.D, error) {
+	doc := bson.D{}
+	err := bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return sanitizeValue("", doc, 0).(bson.D), nil
+}
+
+// sanitizedCommandJSON renders a sanitized command or reply for logging, truncating it if it is still too large
+func sanitizedCommandJSON(raw bson.Raw) string {
+	if len(raw) == 0 {
+		// The driver omits the bodies of security-sensitive commands
+		return redactedValue
+	}
+	doc, err := SanitizeCommand(raw)
+	if err != nil {
+		return fmt.Sprintf("<invalid document: %s>", err)
+	}
+	bytes, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return fmt.Sprintf("<invalid document: %s>", err)
+	}
+	if len(bytes) > maxLoggedCommandBytes {
+		return fmt.Sprintf("%s...<%d bytes>", bytes[:maxLoggedCommandBytes], len(bytes))
+	}
+	return string(bytes)
+}
+
+// commandLogEnabled checks if commands should be logged, as sanitizing them is not free
+func commandLogEnabled() bool {
+	level := log.DefaultLogger.Level()
+	return level == log.Debug || level == log.Trace
+}
+
+// commandLogMonitor logs every command sent by the driver, and its outcome, at debug level, so that the exact command
+// executed for a query can be compared to the same query run in another tool
+func commandLogMonitor(refID string) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			log.DefaultLogger.Debug(
+				"Command started",
+				"refId", refID,
+				"requestId", evt.RequestID,
+				"command", evt.CommandName,
+				"database", evt.DatabaseName,
+				"body", sanitizedCommandJSON(evt.Command),
+			)
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			log.DefaultLogger.Debug(
+				"Command succeeded",
+				"refId", refID,
+				"requestId", evt.RequestID,
+				"command", evt.CommandName,
+				"duration", time.Duration(evt.DurationNanos),
+				"reply", sanitizedCommandJSON(evt.Reply),
+			)
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			log.DefaultLogger.Debug(
+				"Command failed",
+				"refId", refID,
+				"requestId", evt.RequestID,
+				"command", evt.CommandName,
+				"duration", time.Duration(evt.DurationNanos),
+				"failure", evt.Failure,
+			)
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package plugin_test
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SanitizeCommand", func() {
+	sanitize := func(doc bson.D) string {
+		raw, err := bson.Marshal(doc)
+		Expect(err).ToNot(HaveOccurred())
+		sanitized, err := plugin.SanitizeCommand(raw)
+		Expect(err).ToNot(HaveOccurred())
+		bytes, err := bson.MarshalExtJSON(sanitized, false, false)
+		Expect(err).ToNot(HaveOccurred())
+		return string(bytes)
+	}
+
+	It("Should keep the pipeline of a command", func() {
+		Expect(sanitize(bson.D{
+			{Key: "aggregate", Value: "logs"},
+			{Key: "pipeline", Value: bson.A{bson.D{{Key: "$match", Value: bson.D{{Key: "status", Value: 500}}}}}},
+			{Key: "$db", Value: "test"},
+		})).To(MatchJSON(`{"aggregate": "logs", "pipeline": [{"$match": {"status": 500}}], "$db": "test"}`))
+	})
+
+	It("Should redact credentials at any depth", func() {
+		Expect(sanitize(bson.D{
+			{Key: "createUser", Value: "u"},
+			{Key: "pwd", Value: "hunter2"},
+			{Key: "$clusterTime", Value: bson.D{{Key: "signature", Value: bson.D{{Key: "hash", Value: "x"}}}}},
+		})).To(MatchJSON(`{"createUser": "u", "pwd": "<redacted>", "$clusterTime": {"signature": "<redacted>"}}`))
+	})
+
+	It("Should replace returned documents with their count", func() {
+		Expect(sanitize(bson.D{
+			{Key: "cursor", Value: bson.D{
+				{Key: "id", Value: int64(0)},
+				{Key: "firstBatch", Value: bson.A{bson.D{{Key: "secret", Value: 1}}, bson.D{}}},
+			}},
+			{Key: "ok", Value: 1.0},
+		})).To(MatchJSON(`{"cursor": {"id": 0, "firstBatch": "<2 documents>"}, "ok": 1.0}`))
+	})
+
+	It("Should truncate large payloads", func() {
+		values := bson.A{}
+		for ix := 0; ix < 25; ix++ {
+			values = append(values, ix)
+		}
+		sanitized := sanitize(bson.D{
+			{Key: "text", Value: strings.Repeat("a", 1000)},
+			{Key: "ids", Value: values},
+			{Key: "blob", Value: bsonPrim.Binary{Data: make([]byte, 64)}},
+		})
+		Expect(sanitized).To(ContainSubstring(`...<1000 bytes>`))
+		Expect(sanitized).ToNot(ContainSubstring(strings.Repeat("a", 257)))
+		Expect(sanitized).To(ContainSubstring(`19,"<5 more>"]`))
+		Expect(sanitized).To(ContainSubstring(`"blob":"<binary 64 bytes>"`))
+	})
+})
@@ -0,0 +1,379 @@
+package plugin
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// dateTruncReferenceMillis is the reference date which $dateTrunc aligns bins to, 2000-01-01T00:00:00Z
+var dateTruncReferenceMillis = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+
+// dateTruncFixedUnits are the $dateTrunc units which have a fixed length in milliseconds
+var dateTruncFixedUnits = map[string]int64{
+	"millisecond": 1,
+	"second":      int64(time.Second / time.Millisecond),
+	"minute":      int64(time.Minute / time.Millisecond),
+	"hour":        int64(time.Hour / time.Millisecond),
+	"day":         int64(24 * time.Hour / time.Millisecond),
+	"week":        int64(7 * 24 * time.Hour / time.Millisecond),
+}
+
+// dateTruncWeekdays are the allowed values of startOfWeek, in the order they appear in the week of the reference date
+var dateTruncWeekdays = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// containsOperator returns true if an expression, stage, or pipeline uses an operator anywhere
+func containsOperator(value interface{}, operator string) bool {
+	switch v := value.(type) {
+	case mongo.Pipeline:
+		for _, stage := range v {
+			if containsOperator(stage, operator) {
+				return true
+			}
+		}
+	case bson.D:
+		for _, elem := range v {
+			if elem.Key == operator || containsOperator(elem.Value, operator) {
+				return true
+			}
+		}
+	case bsonPrim.A:
+		for _, elem := range v {
+			if containsOperator(elem, operator) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if containsOperator(elem, operator) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rewriteOperator replaces every expression of the form {operator: args} with the result of rewrite(args),
+// innermost first. The original value is not modified.
+func rewriteOperator(value interface{}, operator string, rewrite func(args interface{}) (interface{}, error)) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case bson.D:
+		if len(v) == 1 && v[0].Key == operator {
+			args, err := rewriteOperator(v[0].Value, operator, rewrite)
+			if err != nil {
+				return nil, err
+			}
+			return rewrite(args)
+		}
+		rewritten := make(bson.D, len(v))
+		for ix, elem := range v {
+			rewritten[ix].Key = elem.Key
+			rewritten[ix].Value, err = rewriteOperator(elem.Value, operator, rewrite)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return rewritten, nil
+	case bsonPrim.A:
+		rewritten := make(bsonPrim.A, len(v))
+		for ix, elem := range v {
+			rewritten[ix], err = rewriteOperator(elem, operator, rewrite)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return rewritten, nil
+	case []interface{}:
+		rewritten := make([]interface{}, len(v))
+		for ix, elem := range v {
+			rewritten[ix], err = rewriteOperator(elem, operator, rewrite)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return rewritten, nil
+	}
+	return value, nil
+}
+
+// rewritePipelineOperator applies rewriteOperator to each stage of a pipeline
+func rewritePipelineOperator(pipeline mongo.Pipeline, operator string, rewrite func(args interface{}) (interface{}, error)) (mongo.Pipeline, error) {
+	rewritten := make(mongo.Pipeline, len(pipeline))
+	for ix, stage := range pipeline {
+		rewrittenStage, err := rewriteOperator(stage, operator, rewrite)
+		if err != nil {
+			return nil, err
+		}
+		rewritten[ix] = rewrittenStage.(bson.D)
+	}
+	return rewritten, nil
+}
+
+// RewriteDateTruncForLegacyServers replaces all $dateTrunc expressions, which require MongoDB 5.0,
+// with equivalent epoch arithmetic or $dateFromParts expressions, which are available from MongoDB 4.0
+func RewriteDateTruncForLegacyServers(pipeline mongo.Pipeline) (mongo.Pipeline, error) {
+	return rewritePipelineOperator(pipeline, "$dateTrunc", legacyDateTrunc)
+}
+
+func legacyDateTrunc(args interface{}) (interface{}, error) {
+	doc, ok := args.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("$dateTrunc arguments must be a document, got %#v", args)
+	}
+	var date, timezone interface{}
+	unit := ""
+	binSize := int64(1)
+	startOfWeek := "sunday"
+	for _, elem := range doc {
+		switch elem.Key {
+		case "date":
+			date = elem.Value
+		case "unit":
+			unit, ok = elem.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("$dateTrunc unit must be a literal string to be rewritten for MongoDB < 5.0")
+			}
+		case "binSize":
+			binSize, ok = toInt64(elem.Value)
+			if !ok || binSize < 1 {
+				return nil, fmt.Errorf("$dateTrunc binSize must be a literal positive integer to be rewritten for MongoDB < 5.0")
+			}
+		case "timezone":
+			timezone = elem.Value
+		case "startOfWeek":
+			startOfWeek, ok = elem.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("$dateTrunc startOfWeek must be a literal string to be rewritten for MongoDB < 5.0")
+			}
+		default:
+			return nil, fmt.Errorf("Unknown $dateTrunc argument %s", elem.Key)
+		}
+	}
+	if date == nil {
+		return nil, fmt.Errorf("$dateTrunc requires a date")
+	}
+	unit = strings.ToLower(unit)
+
+	if unitMillis, ok := dateTruncFixedUnits[unit]; ok {
+		if timezone != nil {
+			return nil, fmt.Errorf("$dateTrunc with unit %s cannot be rewritten for MongoDB < 5.0 when a timezone is specified", unit)
+		}
+		reference := dateTruncReferenceMillis
+		if unit == "week" {
+			weekday := -1
+			for ix, day := range dateTruncWeekdays {
+				if day == strings.ToLower(startOfWeek) || day[:3] == strings.ToLower(startOfWeek) {
+					weekday = ix
+				}
+			}
+			if weekday == -1 {
+				return nil, fmt.Errorf("Invalid $dateTrunc startOfWeek %s", startOfWeek)
+			}
+			// The reference date is a saturday
+			reference += int64(1+weekday) * dateTruncFixedUnits["day"]
+		}
+		size := unitMillis * binSize
+		millis := bson.D{bson.E{Key: "$toLong", Value: date}}
+		offset := bson.D{bson.E{Key: "$subtract", Value: bson.A{millis, reference}}}
+		// $mod keeps the sign of the dividend, so this is needed to handle dates before the reference date
+		remainder := bson.D{bson.E{Key: "$mod", Value: bson.A{
+			bson.D{bson.E{Key: "$add", Value: bson.A{
+				bson.D{bson.E{Key: "$mod", Value: bson.A{offset, size}}},
+				size,
+			}}},
+			size,
+		}}}
+		return bson.D{bson.E{Key: "$toDate", Value: bson.D{bson.E{Key: "$subtract", Value: bson.A{millis, remainder}}}}}, nil
+	}
+
+	if binSize != 1 {
+		return nil, fmt.Errorf("$dateTrunc with unit %s and a binSize other than 1 cannot be rewritten for MongoDB < 5.0", unit)
+	}
+	datePart := func(operator string) bson.D {
+		if timezone == nil {
+			return bson.D{bson.E{Key: operator, Value: date}}
+		}
+		return bson.D{bson.E{Key: operator, Value: bson.D{
+			bson.E{Key: "date", Value: date},
+			bson.E{Key: "timezone", Value: timezone},
+		}}}
+	}
+	parts := bson.D{bson.E{Key: "year", Value: datePart("$year")}}
+	switch unit {
+	case "year":
+	case "quarter":
+		parts = append(parts, bson.E{Key: "month", Value: bson.D{bson.E{Key: "$add", Value: bson.A{
+			bson.D{bson.E{Key: "$multiply", Value: bson.A{
+				bson.D{bson.E{Key: "$floor", Value: bson.D{bson.E{Key: "$divide", Value: bson.A{
+					bson.D{bson.E{Key: "$subtract", Value: bson.A{datePart("$month"), 1}}},
+					3,
+				}}}}},
+				3,
+			}}},
+			1,
+		}}}})
+	case "month":
+		parts = append(parts, bson.E{Key: "month", Value: datePart("$month")})
+	default:
+		return nil, fmt.Errorf("Invalid $dateTrunc unit %s", unit)
+	}
+	if timezone != nil {
+		parts = append(parts, bson.E{Key: "timezone", Value: timezone})
+	}
+	return bson.D{bson.E{Key: "$dateFromParts", Value: parts}}, nil
+}
+
+// toInt64 converts any BSON numeric type holding an integral value to an int64
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// percentileInputField holds the input of $__percentile accumulators while sorting for the approximation used before MongoDB 7.0
+const percentileInputField = "__percentileInput"
+
+// percentileAccumulator is a $__percentile placeholder found in a $group stage
+type percentileAccumulator struct {
+	output string
+	input  interface{}
+	p      float64
+}
+
+// RewritePercentiles replaces the $__percentile placeholders produced by the macro of the same name, which may only appear as
+// accumulators of $group stages. If native is true, they are replaced with $percentile, which requires MongoDB 7.0.
+// Otherwise, the input documents are sorted by the percentile input, which is pushed into an array, and the element at the
+// nearest rank is selected after the $group, which works on MongoDB 4.0 or later. As documents can only be sorted once,
+// all placeholders in the same $group must then have the same input.
+// In both cases, a stage is added after the $group to reduce each output to a single number.
+func RewritePercentiles(pipeline mongo.Pipeline, native bool) (mongo.Pipeline, error) {
+	rewritten := make(mongo.Pipeline, 0, len(pipeline))
+	for _, stage := range pipeline {
+		if len(stage) != 1 || stage[0].Key != "$group" {
+			if containsOperator(stage, percentileMarker) {
+				return nil, fmt.Errorf("%s may only be used as a $group accumulator", percentileMarker)
+			}
+			rewritten = append(rewritten, stage)
+			continue
+		}
+		group, ok := stage[0].Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("$group must be a document, got %#v", stage[0].Value)
+		}
+		found := make([]percentileAccumulator, 0)
+		newGroup := make(bson.D, 0, len(group))
+		for _, elem := range group {
+			acc, ok := elem.Value.(bson.D)
+			if elem.Key == "_id" || !ok || len(acc) != 1 || acc[0].Key != percentileMarker {
+				if containsOperator(elem.Value, percentileMarker) {
+					return nil, fmt.Errorf("%s may only be used as a $group accumulator", percentileMarker)
+				}
+				newGroup = append(newGroup, elem)
+				continue
+			}
+			percentile, err := parsePercentileMarker(elem.Key, acc[0].Value)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, percentile)
+			if native {
+				newGroup = append(newGroup, bson.E{Key: elem.Key, Value: bson.D{bson.E{Key: "$percentile", Value: bson.D{
+					bson.E{Key: "input", Value: percentile.input},
+					bson.E{Key: "p", Value: bson.A{percentile.p}},
+					bson.E{Key: "method", Value: "approximate"},
+				}}}})
+			} else {
+				newGroup = append(newGroup, bson.E{Key: elem.Key, Value: bson.D{bson.E{Key: "$push", Value: "$" + percentileInputField}}})
+			}
+		}
+		if len(found) == 0 {
+			rewritten = append(rewritten, stage)
+			continue
+		}
+
+		if !native {
+			for _, percentile := range found[1:] {
+				if !reflect.DeepEqual(percentile.input, found[0].input) {
+					return nil, fmt.Errorf("%s with different inputs in the same $group requires MongoDB 7.0 or later", percentileMarker)
+				}
+			}
+			rewritten = append(rewritten,
+				bson.D{bson.E{Key: "$addFields", Value: bson.D{bson.E{Key: percentileInputField, Value: found[0].input}}}},
+				bson.D{bson.E{Key: "$sort", Value: bson.D{bson.E{Key: percentileInputField, Value: 1}}}},
+			)
+		}
+		rewritten = append(rewritten, bson.D{bson.E{Key: "$group", Value: newGroup}})
+
+		outputs := make(bson.D, len(found))
+		for ix, percentile := range found {
+			values := interface{}("$" + percentile.output)
+			index := interface{}(0)
+			if !native {
+				// Non-numeric values, including nulls, sort before numbers, and are not included by $percentile
+				values = bson.D{bson.E{Key: "$filter", Value: bson.D{
+					bson.E{Key: "input", Value: values},
+					bson.E{Key: "as", Value: "value"},
+					bson.E{Key: "cond", Value: bson.D{bson.E{Key: "$in", Value: bson.A{
+						bson.D{bson.E{Key: "$type", Value: "$$value"}},
+						bson.A{"double", "int", "long", "decimal"},
+					}}}},
+				}}}
+				index = bson.D{bson.E{Key: "$toInt", Value: bson.D{bson.E{Key: "$floor", Value: bson.D{bson.E{Key: "$multiply", Value: bson.A{
+					percentile.p,
+					bson.D{bson.E{Key: "$subtract", Value: bson.A{bson.D{bson.E{Key: "$size", Value: values}}, 1}}},
+				}}}}}}}
+			}
+			outputs[ix] = bson.E{Key: percentile.output, Value: bson.D{bson.E{Key: "$arrayElemAt", Value: bson.A{values, index}}}}
+		}
+		rewritten = append(rewritten, bson.D{bson.E{Key: "$addFields", Value: outputs}})
+	}
+	return rewritten, nil
+}
+
+func parsePercentileMarker(output string, args interface{}) (percentileAccumulator, error) {
+	doc, ok := args.(bson.D)
+	if !ok {
+		return percentileAccumulator{}, fmt.Errorf("%s arguments must be a document, got %#v", percentileMarker, args)
+	}
+	percentile := percentileAccumulator{output: output}
+	hasP := false
+	for _, elem := range doc {
+		switch elem.Key {
+		case "input":
+			percentile.input = elem.Value
+		case "p":
+			switch p := elem.Value.(type) {
+			case float64:
+				percentile.p = p
+			case int32:
+				percentile.p = float64(p)
+			case int64:
+				percentile.p = float64(p)
+			default:
+				return percentileAccumulator{}, fmt.Errorf("%s p must be a number, got %#v", percentileMarker, elem.Value)
+			}
+			hasP = true
+		default:
+			return percentileAccumulator{}, fmt.Errorf("Unknown %s argument %s", percentileMarker, elem.Key)
+		}
+	}
+	if percentile.input == nil || !hasP {
+		return percentileAccumulator{}, fmt.Errorf("%s requires an input and p", percentileMarker)
+	}
+	return percentile, nil
+}
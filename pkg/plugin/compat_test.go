@@ -0,0 +1,84 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RewriteDateTruncForLegacyServers", func() {
+	It("Should rewrite fixed units to epoch arithmetic", func() {
+		pipeline := mongo.Pipeline{}
+		Expect(bson.UnmarshalExtJSON([]byte(`[{"$group":{"_id":{"$dateTrunc":{"date":"$ts","unit":"minute","binSize":5}}}}]`), false, &pipeline)).To(Succeed())
+		rewritten, err := plugin.RewriteDateTruncForLegacyServers(pipeline)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bson.MarshalExtJSON(bson.D{{Key: "p", Value: rewritten}}, false, false)).To(MatchJSON(`{"p":[{"$group":{"_id":{"$toDate":{"$subtract":[
+			{"$toLong":"$ts"},
+			{"$mod":[{"$add":[{"$mod":[{"$subtract":[{"$toLong":"$ts"},946684800000]},300000]},300000]},300000]}
+		]}}}}]}`))
+	})
+
+	It("Should rewrite calendar units to $dateFromParts", func() {
+		pipeline := mongo.Pipeline{}
+		Expect(bson.UnmarshalExtJSON([]byte(`[{"$project":{"month":{"$dateTrunc":{"date":"$ts","unit":"month"}}}}]`), false, &pipeline)).To(Succeed())
+		rewritten, err := plugin.RewriteDateTruncForLegacyServers(pipeline)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bson.MarshalExtJSON(bson.D{{Key: "p", Value: rewritten}}, false, false)).To(MatchJSON(`{"p":[{"$project":{"month":{"$dateFromParts":{"year":{"$year":"$ts"},"month":{"$month":"$ts"}}}}}]}`))
+	})
+
+	It("Should refuse calendar units with a bin size", func() {
+		pipeline := mongo.Pipeline{}
+		Expect(bson.UnmarshalExtJSON([]byte(`[{"$project":{"month":{"$dateTrunc":{"date":"$ts","unit":"month","binSize":2}}}}]`), false, &pipeline)).To(Succeed())
+		_, err := plugin.RewriteDateTruncForLegacyServers(pipeline)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("RewritePercentiles", func() {
+	parse := func(text string) mongo.Pipeline {
+		expanded, err := plugin.ExpandMacros(text, plugin.MacroContext{})
+		Expect(err).ToNot(HaveOccurred())
+		pipeline := mongo.Pipeline{}
+		Expect(bson.UnmarshalExtJSON([]byte(expanded), false, &pipeline)).To(Succeed())
+		return pipeline
+	}
+
+	It("Should use $percentile on MongoDB 7.0", func() {
+		rewritten, err := plugin.RewritePercentiles(parse(`[{"$group":{"_id":"$host","p95":$__percentile(latency, 0.95)}}]`), true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bson.MarshalExtJSON(bson.D{{Key: "p", Value: rewritten}}, false, false)).To(MatchJSON(`{"p":[
+			{"$group":{"_id":"$host","p95":{"$percentile":{"input":"$latency","p":[0.95],"method":"approximate"}}}},
+			{"$addFields":{"p95":{"$arrayElemAt":["$p95",0]}}}
+		]}`))
+	})
+
+	It("Should sort and index on older servers", func() {
+		rewritten, err := plugin.RewritePercentiles(parse(`[{"$group":{"_id":"$host","p50":$__percentile(latency, 0.5),"n":{"$sum":1}}}]`), false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bson.MarshalExtJSON(bson.D{{Key: "p", Value: rewritten}}, false, false)).To(MatchJSON(`{"p":[
+			{"$addFields":{"__percentileInput":"$latency"}},
+			{"$sort":{"__percentileInput":1}},
+			{"$group":{"_id":"$host","p50":{"$push":"$__percentileInput"},"n":{"$sum":1}}},
+			{"$addFields":{"p50":{"$arrayElemAt":[
+				{"$filter":{"input":"$p50","as":"value","cond":{"$in":[{"$type":"$$value"},["double","int","long","decimal"]]}}},
+				{"$toInt":{"$floor":{"$multiply":[0.5,{"$subtract":[{"$size":
+					{"$filter":{"input":"$p50","as":"value","cond":{"$in":[{"$type":"$$value"},["double","int","long","decimal"]]}}}
+				},1]}]}}}
+			]}}}
+		]}`))
+	})
+
+	It("Should refuse different inputs in the same $group on older servers", func() {
+		_, err := plugin.RewritePercentiles(parse(`[{"$group":{"_id":null,"a":$__percentile(x, 0.5),"b":$__percentile(y, 0.5)}}]`), false)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should refuse placeholders outside of $group", func() {
+		_, err := plugin.RewritePercentiles(parse(`[{"$project":{"a":$__percentile(x, 0.5)}}]`), true)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// clusterTimePattern matches cluster times as seconds and an optional increment, either bare (1700000000,1 or 1700000000.1),
+// or as printed by mongosh (Timestamp(1700000000, 1) or Timestamp({ t: 1700000000, i: 1 }))
+var clusterTimePattern = regexp.MustCompile(`^(?:Timestamp\(\s*(?:\{\s*t:\s*)?)?(\d+)(?:\s*[,.]\s*(?:i:\s*)?(\d+))?\s*\}?\s*\)?$`)
+
+// ParseClusterTime parses a cluster time, such as the operationTime returned by a write,
+// either in one of the forms matched by clusterTimePattern, or as extended JSON ({"$timestamp": {"t": 1700000000, "i": 1}})
+func ParseClusterTime(raw string) (bsonPrim.Timestamp, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "{") {
+		var wrapper struct {
+			Value bsonPrim.Timestamp `bson:"value"`
+		}
+		err := bson.UnmarshalExtJSON([]byte(`{"value":`+raw+`}`), false, &wrapper)
+		if err != nil {
+			return bsonPrim.Timestamp{}, errors.Wrap(err, "Invalid cluster time")
+		}
+		return wrapper.Value, nil
+	}
+	match := clusterTimePattern.FindStringSubmatch(raw)
+	if match == nil {
+		return bsonPrim.Timestamp{}, fmt.Errorf("Invalid cluster time %q", raw)
+	}
+	t, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil {
+		return bsonPrim.Timestamp{}, errors.Wrap(err, "Invalid cluster time seconds")
+	}
+	var i uint64
+	if match[2] != "" {
+		i, err = strconv.ParseUint(match[2], 10, 32)
+		if err != nil {
+			return bsonPrim.Timestamp{}, errors.Wrap(err, "Invalid cluster time increment")
+		}
+	}
+	return bsonPrim.Timestamp{T: uint32(t), I: uint32(i)}, nil
+}
+
+// startQuerySession starts a session with the consistency guarantees requested by a query, if any.
+// If afterClusterTime is set, the session is causally consistent, and its reads will not return until the
+// server has caught up to that cluster time, so that panels reflect writes which returned that operationTime.
+// The returned context must be used for all operations which should use the session, and the returned function called when finished.
+// If no session is required, the original context is returned.
+func startQuerySession(ctx context.Context, client *mongo.Client, qm *QueryModel) (context.Context, func(), error) {
+	if qm.AfterClusterTime != "" && qm.Snapshot != "" {
+		return nil, nil, fmt.Errorf("Snapshot reads cannot also wait for a cluster time")
+	}
+	if qm.AfterClusterTime == "" {
+		return ctx, func() {}, nil
+	}
+	afterClusterTime, err := ParseClusterTime(qm.AfterClusterTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := client.StartSession(mongoOpts.Session().SetCausalConsistency(true))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to start session")
+	}
+	err = session.AdvanceOperationTime(&afterClusterTime)
+	if err != nil {
+		session.EndSession(ctx)
+		return nil, nil, errors.Wrap(err, "Failed to advance session to cluster time")
+	}
+	return mongo.NewSessionContext(ctx, session), func() { session.EndSession(ctx) }, nil
+}
+
+// snapshotDashboard requests a snapshot as of the end of the query time range,
+// which is the same for all panels of a dashboard loaded with a relative time range
+const snapshotDashboard = "dashboard"
+
+// getSnapshotTime returns the cluster time a query should read a snapshot at, or nil if it should not use a snapshot
+func (m *QueryModel) getSnapshotTime(timeRange backend.TimeRange) (*bsonPrim.Timestamp, error) {
+	switch m.Snapshot {
+	case "":
+		return nil, nil
+	case snapshotDashboard:
+		// The server rejects cluster times in the future
+		at := timeRange.To
+		if now := time.Now(); at.After(now) {
+			at = now
+		}
+		return &bsonPrim.Timestamp{T: uint32(at.Unix())}, nil
+	default:
+		ts, err := ParseClusterTime(m.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return &ts, nil
+	}
+}
+
+// aggregateAtSnapshot runs an aggregation with snapshot read concern at a cluster time.
+// The driver does not support atClusterTime outside of transactions, so the aggregate command is built by hand from the usual options.
+// Requires MongoDB 5.0 or later, and the cluster time must be within the server's snapshot history window (5 minutes by default).
+func aggregateAtSnapshot(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, opts *mongoOpts.AggregateOptions, readPref *readpref.ReadPref, at bsonPrim.Timestamp) (*mongo.Cursor, error) {
+	cmd := snapshotAggregateCommand(collection.Name(), pipeline, opts, at)
+	runOpts := mongoOpts.RunCmd()
+	if readPref != nil {
+		runOpts.SetReadPreference(readPref)
+	}
+	cursor, err := collection.Database().RunCommandCursor(ctx, cmd, runOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to read snapshot at cluster time %d,%d", at.T, at.I))
+	}
+	return cursor, nil
+}
+
+// snapshotAggregateCommand builds the aggregate command run by aggregateAtSnapshot
+func snapshotAggregateCommand(collection string, pipeline mongo.Pipeline, opts *mongoOpts.AggregateOptions, at bsonPrim.Timestamp) bson.D {
+	cmd := bson.D{
+		bson.E{Key: "aggregate", Value: collection},
+		bson.E{Key: "pipeline", Value: pipeline},
+		bson.E{Key: "cursor", Value: bson.D{}},
+		bson.E{Key: "readConcern", Value: bson.D{
+			bson.E{Key: "level", Value: "snapshot"},
+			bson.E{Key: "atClusterTime", Value: at},
+		}},
+	}
+	if opts.Comment != nil {
+		cmd = append(cmd, bson.E{Key: "comment", Value: *opts.Comment})
+	}
+	if opts.MaxTime != nil {
+		cmd = append(cmd, bson.E{Key: "maxTimeMS", Value: int64(*opts.MaxTime / time.Millisecond)})
+	}
+	if opts.Collation != nil {
+		cmd = append(cmd, bson.E{Key: "collation", Value: opts.Collation.ToDocument()})
+	}
+	if opts.Hint != nil {
+		cmd = append(cmd, bson.E{Key: "hint", Value: opts.Hint})
+	}
+	return cmd
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseClusterTime", func() {
+	DescribeTable("Should parse", func(raw string, expected bsonPrim.Timestamp) {
+		Expect(ParseClusterTime(raw)).To(Equal(expected))
+	},
+		Entry("seconds only", "1700000000", bsonPrim.Timestamp{T: 1700000000}),
+		Entry("seconds and increment", "1700000000,3", bsonPrim.Timestamp{T: 1700000000, I: 3}),
+		Entry("mongosh legacy format", "Timestamp(1700000000, 3)", bsonPrim.Timestamp{T: 1700000000, I: 3}),
+		Entry("mongosh format", "Timestamp({ t: 1700000000, i: 3 })", bsonPrim.Timestamp{T: 1700000000, I: 3}),
+		Entry("extended JSON", `{"$timestamp": {"t": 1700000000, "i": 3}}`, bsonPrim.Timestamp{T: 1700000000, I: 3}),
+	)
+
+	It("Should reject garbage", func() {
+		_, err := ParseClusterTime("yesterday")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Snapshot reads", func() {
+	past := time.Unix(1700000000, 0)
+
+	DescribeTable("getSnapshotTime", func(snapshot string, timeRange backend.TimeRange, expected *bsonPrim.Timestamp) {
+		qm := QueryModel{Snapshot: snapshot}
+		Expect(qm.getSnapshotTime(timeRange)).To(Equal(expected))
+	},
+		Entry("should not use a snapshot by default", "", backend.TimeRange{To: past}, nil),
+		Entry("should use the end of the time range for dashboards", snapshotDashboard, backend.TimeRange{To: past}, &bsonPrim.Timestamp{T: 1700000000}),
+		Entry("should use a given cluster time", "1600000000,2", backend.TimeRange{To: past}, &bsonPrim.Timestamp{T: 1600000000, I: 2}),
+	)
+
+	It("Should not use a cluster time in the future for dashboards", func() {
+		qm := QueryModel{Snapshot: snapshotDashboard}
+		at, err := qm.getSnapshotTime(backend.TimeRange{To: time.Now().Add(time.Hour)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(int64(at.T)).To(BeNumerically("<=", time.Now().Unix()))
+	})
+
+	It("Should reject invalid cluster times", func() {
+		qm := QueryModel{Snapshot: "yesterday"}
+		_, err := qm.getSnapshotTime(backend.TimeRange{To: past})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should not wait for a cluster time as well", func() {
+		_, _, err := startQuerySession(context.Background(), nil, &QueryModel{Snapshot: snapshotDashboard, AfterClusterTime: "1700000000"})
+		Expect(err).To(MatchError("Snapshot reads cannot also wait for a cluster time"))
+	})
+
+	It("Should build an aggregate command with snapshot read concern and the usual options", func() {
+		at := bsonPrim.Timestamp{T: 1700000000, I: 1}
+		pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.D{{Key: "a", Value: 1}}}}}
+		opts := mongoOpts.Aggregate().SetComment("comment").SetMaxTime(1500 * time.Millisecond).SetHint("a_1")
+		Expect(snapshotAggregateCommand("events", pipeline, opts, at)).To(Equal(bson.D{
+			{Key: "aggregate", Value: "events"},
+			{Key: "pipeline", Value: pipeline},
+			{Key: "cursor", Value: bson.D{}},
+			{Key: "readConcern", Value: bson.D{{Key: "level", Value: "snapshot"}, {Key: "atClusterTime", Value: at}}},
+			{Key: "comment", Value: "comment"},
+			{Key: "maxTimeMS", Value: int64(1500)},
+			{Key: "hint", Value: "a_1"},
+		}))
+	})
+})
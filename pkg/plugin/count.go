@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// commandCount returns the number of documents matching Filter, as a single numeric field
+	commandCount = "count"
+	// commandEstimatedCount returns the number of documents in the collection from its metadata, without scanning it.
+	// This does not support a filter.
+	commandEstimatedCount = "estimatedDocumentCount"
+
+	countFieldName = "count"
+)
+
+// CountFrame produces the frame returned by count commands
+func CountFrame(count int64) *data.Frame {
+	return data.NewFrame(countFieldName, data.NewField(countFieldName, nil, []int64{count}))
+}
+
+// countQuery runs a query using the count or estimatedDocumentCount commands
+func (d *MongoDBDatasource) countQuery(ctx context.Context, pCtx backend.PluginContext, origin requestOrigin, query backend.DataQuery, qm *QueryModel) (response backend.DataResponse) {
+	err := qm.requireCurrentModel(fmt.Sprintf("The %s command", qm.Command))
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	filter, err := qm.getCommandFilter(MacroContext{From: query.TimeRange.From, To: query.TimeRange.To, Interval: query.Interval, MaxDataPoints: query.MaxDataPoints})
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if qm.Command == commandEstimatedCount && len(filter) != 0 {
+		response.Error = fmt.Errorf("The %s command does not support filters or automatic time bounds, use the %s command instead", commandEstimatedCount, commandCount)
+		return response
+	}
+
+	// estimatedDocumentCount reads collection metadata, so does not need to be filtered by time
+	guarded := filter
+	if qm.Command == commandEstimatedCount {
+		guarded = nil
+	}
+	ctx, client, limits, done, err := simpleCommandClient(ctx, pCtx, origin, qm, guarded)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	defer done()
+
+	collection := client.Database(qm.Database).Collection(qm.Collection)
+	var count int64
+	if qm.Command == commandEstimatedCount {
+		opts := mongoOpts.EstimatedDocumentCount()
+		if limits.timeout > 0 {
+			opts.SetMaxTime(limits.timeout)
+		}
+		count, err = collection.EstimatedDocumentCount(ctx, opts)
+	} else {
+		opts := mongoOpts.Count()
+		if limits.timeout > 0 {
+			opts.SetMaxTime(limits.timeout)
+		}
+		var hint interface{}
+		hint, err = qm.getHint()
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		if hint != nil {
+			opts.SetHint(hint)
+		}
+		count, err = collection.CountDocuments(ctx, filter, opts)
+	}
+	if err != nil {
+		response.Error = errors.Wrap(err, "Failed to send query to mongo")
+		return response
+	}
+	response.Frames = data.Frames{CountFrame(count)}
+	qm.setExecutedFilter(response.Frames, filter)
+	return response
+}
@@ -0,0 +1,18 @@
+package plugin_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CountFrame", func() {
+	It("Should produce a single numeric value", func() {
+		frame := plugin.CountFrame(42)
+		Expect(frame.Fields).To(HaveLen(1))
+		Expect(frame.Rows()).To(Equal(1))
+		Expect(frame.Fields[0].Type().Numeric()).To(BeTrue())
+		Expect(frame.Fields[0].At(0)).To(Equal(int64(42)))
+	})
+})
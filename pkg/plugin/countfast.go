@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexKey is the name and key pattern of an index
+type IndexKey struct {
+	Name string
+	Key  bson.D
+}
+
+// CountFastPath recognizes a pipeline which only counts the documents matching a filter, that is, one or more $match stages
+// followed by a $count stage, and optionally the $limit added to cap the number of rows. It returns the combined filter,
+// and the field the count is returned in.
+func CountFastPath(pipeline mongo.Pipeline) (filter bson.D, countField string, ok bool) {
+	find := findQuery{filter: bson.D{}}
+	ix := 0
+	for ; ix < len(pipeline) && len(pipeline[ix]) == 1 && pipeline[ix][0].Key == "$match"; ix++ {
+		match, ok := pipeline[ix][0].Value.(bson.D)
+		if !ok {
+			return nil, "", false
+		}
+		find.and(match)
+	}
+	if ix == 0 || ix == len(pipeline) || len(pipeline[ix]) != 1 || pipeline[ix][0].Key != "$count" {
+		return nil, "", false
+	}
+	countField, ok = pipeline[ix][0].Value.(string)
+	if !ok {
+		return nil, "", false
+	}
+	// $count produces a single document, so limits after it change nothing
+	for _, stage := range pipeline[ix+1:] {
+		if len(stage) != 1 || stage[0].Key != "$limit" {
+			return nil, "", false
+		}
+		if limit, ok := toInt64(stage[0].Value); !ok || limit < 1 {
+			return nil, "", false
+		}
+	}
+	return find.filter, countField, true
+}
+
+// CountIndex chooses the index which covers the most leading fields of its key pattern with the fields of a filter,
+// preferring smaller indexes, then names, to break ties. It returns an empty name if no index begins with a filtered field.
+// Only ascending and descending indexes are considered. Sparse and partial indexes must not be given, as hinting them
+// would leave out the documents they do not index.
+func CountIndex(filter bson.D, indexes []IndexKey) string {
+	equality := []string{}
+	ranges := []string{}
+	matchFields(filter, &equality, &ranges)
+	filtered := make(map[string]struct{}, len(equality)+len(ranges))
+	for _, field := range append(equality, ranges...) {
+		filtered[field] = struct{}{}
+	}
+
+	candidates := make([]IndexKey, len(indexes))
+	copy(candidates, indexes)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if len(candidates[i].Key) != len(candidates[j].Key) {
+			return len(candidates[i].Key) < len(candidates[j].Key)
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	best, bestCovered := "", 0
+	for _, index := range candidates {
+		covered := 0
+		for _, elem := range index.Key {
+			if _, ok := toInt64(elem.Value); !ok {
+				covered = 0
+				break
+			}
+			if _, ok := filtered[elem.Key]; !ok {
+				break
+			}
+			covered++
+		}
+		if covered > bestCovered {
+			best, bestCovered = index.Name, covered
+		}
+	}
+	return best
+}
+
+// countIndexes lists the indexes of a collection which may be hinted for a count without changing its result
+func countIndexes(ctx context.Context, collection *mongo.Collection) ([]IndexKey, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list indexes")
+	}
+	specs := []indexSpecification{}
+	err = cursor.All(ctx, &specs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode indexes")
+	}
+	indexes := make([]IndexKey, 0, len(specs))
+	for _, spec := range specs {
+		if spec.Sparse || len(spec.PartialFilterExpression) != 0 {
+			continue
+		}
+		key := bson.D{}
+		err = bson.Unmarshal(spec.Key, &key)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to decode indexes")
+		}
+		indexes = append(indexes, IndexKey{Name: spec.Name, Key: key})
+	}
+	return indexes, nil
+}
+
+// countFastPathResult describes the index hinted when counting the documents matching a filter, for the query inspector
+type countFastPathResult struct {
+	Index string `json:"index"`
+}
+
+// countWithIndex counts the documents matching a filter with CountDocuments, hinting the index which best covers the filter,
+// and returns a cursor with the same document the pipeline would have. CountDocuments is itself an aggregate of a $match
+// and a $group, so the only difference from the pipeline as written is the hint.
+// If no index covers the filter, or the indexes cannot be listed, the pipeline is left as it is, and a nil cursor is returned.
+func countWithIndex(ctx context.Context, collection *mongo.Collection, filter bson.D, countField string, opts *mongoOpts.AggregateOptions) (*mongo.Cursor, *countFastPathResult, error) {
+	indexes, err := countIndexes(ctx, collection)
+	if err != nil {
+		// Users may be allowed to read a collection without being allowed to list its indexes
+		log.DefaultLogger.Warn("Could not list indexes, skipping count fast path", "error", err)
+		return nil, nil, nil
+	}
+	index := CountIndex(filter, indexes)
+	if index == "" {
+		return nil, nil, nil
+	}
+	countOpts := mongoOpts.Count().SetHint(index)
+	if opts.MaxTime != nil {
+		countOpts.SetMaxTime(*opts.MaxTime)
+	}
+	if opts.Comment != nil {
+		countOpts.SetComment(*opts.Comment)
+	}
+	count, err := collection.CountDocuments(ctx, filter, countOpts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to send query to mongo")
+	}
+	// $count produces no document at all when nothing matches
+	docs := []interface{}{}
+	if count != 0 {
+		docs = append(docs, bson.D{bson.E{Key: countField, Value: int32OrInt64(count)}})
+	}
+	cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cursor, &countFastPathResult{Index: index}, nil
+}
+
+// int32OrInt64 returns a count with the type $count would have returned it with
+func int32OrInt64(n int64) interface{} {
+	if n == int64(int32(n)) {
+		return int32(n)
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CountFastPath", func() {
+	build := func(aggregation string) mongo.Pipeline {
+		query, err := json.Marshal(map[string]interface{}{"aggregation": aggregation})
+		Expect(err).ToNot(HaveOccurred())
+		pipeline, err := buildPipeline(query, MacroContext{})
+		Expect(err).ToNot(HaveOccurred())
+		return pipeline
+	}
+
+	It("Should recognize a filtered count", func() {
+		filter, field, ok := CountFastPath(build(`[{"$match": {"status": "error"}}, {"$count": "n"}]`))
+		Expect(ok).To(BeTrue())
+		Expect(field).To(Equal("n"))
+		Expect(filter).To(Equal(bson.D{{Key: "status", Value: "error"}}))
+	})
+
+	It("Should combine several filters, and ignore limits after the count", func() {
+		filter, _, ok := CountFastPath(build(`[{"$match": {"a": 1}}, {"$match": {"b": 2}}, {"$count": "n"}, {"$limit": 10}]`))
+		Expect(ok).To(BeTrue())
+		Expect(filter).To(Equal(bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "a", Value: int32(1)}}, bson.D{{Key: "b", Value: int32(2)}}}}}))
+	})
+
+	DescribeTable("Should not recognize", func(aggregation string) {
+		_, _, ok := CountFastPath(build(aggregation))
+		Expect(ok).To(BeFalse())
+	},
+		Entry("counts without a filter", `[{"$count": "n"}]`),
+		Entry("counts after other stages", `[{"$match": {"a": 1}}, {"$unwind": "$b"}, {"$count": "n"}]`),
+		Entry("stages after the count", `[{"$match": {"a": 1}}, {"$count": "n"}, {"$project": {"n": 1}}]`),
+		Entry("filters without a count", `[{"$match": {"a": 1}}]`),
+	)
+})
+
+var _ = Describe("CountIndex", func() {
+	indexes := []IndexKey{
+		{Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+		{Name: "status_1", Key: bson.D{{Key: "status", Value: int32(1)}}},
+		{Name: "status_1_ts_-1", Key: bson.D{{Key: "status", Value: int32(1)}, {Key: "ts", Value: int32(-1)}}},
+		{Name: "host_text", Key: bson.D{{Key: "host", Value: "text"}}},
+	}
+
+	It("Should choose the index covering the most filtered fields", func() {
+		filter := bson.D{{Key: "status", Value: "error"}, {Key: "ts", Value: bson.D{{Key: "$gte", Value: int32(0)}}}}
+		Expect(CountIndex(filter, indexes)).To(Equal("status_1_ts_-1"))
+	})
+
+	It("Should prefer smaller indexes which cover as many fields", func() {
+		Expect(CountIndex(bson.D{{Key: "status", Value: "error"}}, indexes)).To(Equal("status_1"))
+	})
+
+	It("Should not choose indexes which are not ascending or descending", func() {
+		Expect(CountIndex(bson.D{{Key: "host", Value: "a"}}, indexes)).To(BeEmpty())
+	})
+
+	It("Should not choose indexes which do not begin with a filtered field", func() {
+		Expect(CountIndex(bson.D{{Key: "ts", Value: int32(0)}}, indexes)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type databasesResponse struct {
+	Databases []string `json:"databases"`
+	// Source is "static" if the databases are those with collections configured in the datasource settings, or "server" if they were listed from MongoDB
+	Source string `json:"source"`
+}
+
+// handleDatabases serves the routes which list databases, and the collections within them, for the query editor:
+//
+//	GET /databases
+//	GET /databases/{db}/collections
+func (d *MongoDBDatasource) handleDatabases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only GET is supported"))
+		return
+	}
+	segments, err := resourcePathSegments(r, "/databases")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch {
+	case len(segments) == 0:
+		d.handleListDatabases(w, r)
+	case len(segments) == 2 && segments[0] != "" && segments[1] == "collections":
+		d.handleListCollections(w, r, segments[0])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("Not found"))
+	}
+}
+
+// handleListDatabases lists the databases the datasource user is authorized to query. If the user cannot list databases at all,
+// the databases with a static list of collections configured are returned instead.
+func (d *MongoDBDatasource) handleListDatabases(w http.ResponseWriter, r *http.Request) {
+	settings, err := loadDatasource(httpadapter.PluginConfigFromContext(r.Context()))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx, client, _, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	opts := mongoOpts.ListDatabases().SetNameOnly(true).SetAuthorizedDatabases(true)
+	names, err := client.ListDatabaseNames(ctx, bson.D{}, opts)
+	if err != nil && isPermissionError(err) && len(settings.StaticCollections) != 0 {
+		static := make([]string, 0, len(settings.StaticCollections))
+		for database := range settings.StaticCollections {
+			static = append(static, database)
+		}
+		sort.Strings(static)
+		writeJSON(w, http.StatusOK, databasesResponse{Databases: static, Source: "static"})
+		return
+	}
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to list databases"))
+		return
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, databasesResponse{Databases: names, Source: "server"})
+}
@@ -0,0 +1,32 @@
+package plugin
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Database routes", func() {
+	settings := `{"url": "mongodb://localhost:27017", "staticCollections": {"metrics": ["hosts", "cpu"]}}`
+
+	It("Should list the configured collections of a database without connecting", func() {
+		status, body := callResource(settings, "databases/metrics/collections")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"collections": ["hosts", "cpu"], "source": "static"}`))
+	})
+
+	It("Should unescape database names", func() {
+		status, body := callResource(`{"url": "mongodb://localhost:27017", "staticCollections": {"my db": ["c"]}}`, "databases/my%20db/collections")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`{"collections": ["c"], "source": "static"}`))
+	})
+
+	DescribeTable("Should not find", func(url string) {
+		status, _ := callResource(settings, url)
+		Expect(status).To(Equal(http.StatusNotFound))
+	},
+		Entry("unknown routes of a database", "databases/metrics/users"),
+		Entry("nested routes", "databases/metrics/collections/hosts"),
+	)
+})
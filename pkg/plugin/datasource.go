@@ -5,8 +5,11 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
 )
 
 type jsonData struct {
@@ -16,12 +19,88 @@ type jsonData struct {
 	TLSCA          string `json:"tlsCa"`
 	TLSInsecure    bool   `json:"tlsInsecure"`
 	TLSServerName  string `json:"tlsServerName"`
+
+	// DataFederation marks the URL as an Atlas Data Federation endpoint, which does not support indexes or replica set commands. See federation.go.
+	DataFederation bool `json:"dataFederation"`
+
+	MaxQueryTimeout string `json:"maxQueryTimeout"`
+	MaxQueryRows    int    `json:"maxQueryRows"`
+	MaxQueryBytes   int64  `json:"maxQueryBytes"`
+
+	HardQueryTimeout string `json:"hardQueryTimeout"`
+
+	// RequestTimeBudget is the wall-clock time allowed for all of the queries of a request, such as those of a dashboard. See requestBudget.
+	RequestTimeBudget string `json:"requestTimeBudget"`
+
+	// MaxCellDepth and MaxCellBytes limit document and array cells. Zero or a negative value disables the limit.
+	MaxCellDepth int `json:"maxCellDepth"`
+	MaxCellBytes int `json:"maxCellBytes"`
+
+	ReadPreference      string             `json:"readPreference"`
+	MaxStalenessSeconds int                `json:"maxStalenessSeconds"`
+	DashboardReadIntent readIntentSettings `json:"dashboardReadIntent"`
+	AlertReadIntent     readIntentSettings `json:"alertReadIntent"`
+
+	AlertSinkEnabled    bool   `json:"alertSinkEnabled"`
+	AlertSinkDatabase   string `json:"alertSinkDatabase"`
+	AlertSinkCollection string `json:"alertSinkCollection"`
+
+	CacheTTL      string        `json:"cacheTTL"`
+	WarmupQueries []warmupQuery `json:"warmupQueries"`
+
+	MaterializeEnabled bool             `json:"materializeEnabled"`
+	ScheduledMerges    []scheduledMerge `json:"scheduledMerges"`
+
+	// ScheduledExports periodically write the results of queries to files in ExportDirectory, which must already exist.
+	// Their recent runs are listed by the /scheduled-exports route.
+	ExportDirectory  string            `json:"exportDirectory"`
+	ScheduledExports []scheduledExport `json:"scheduledExports"`
+
+	// HealthEventsEnabled records connection failures and restorations, for the /events route and HealthEvents annotation queries
+	HealthEventsEnabled bool `json:"healthEventsEnabled"`
+
+	// DemoDataEnabled allows admins to seed DemoDataDatabase (grafana_demo by default) with synthetic data using the /demo-data route
+	DemoDataEnabled  bool   `json:"demoDataEnabled"`
+	DemoDataDatabase string `json:"demoDataDatabase"`
+
+	// SuggestEndpoint receives natural language prompts from the /suggest route, which is disabled if this is empty. See RequestPipelineSuggestion.
+	SuggestEndpoint string `json:"suggestEndpoint"`
+	SuggestModel    string `json:"suggestModel"`
+
+	// LintRules overrides the severity (off, info, warn, or block) of lint rules by their id. See lintRules.
+	LintRules map[string]string `json:"lintRules"`
+
+	// LargeCollections are collections, as database.collection or just collection for any database, which queries must filter by time.
+	// See checkTimeGuard.
+	LargeCollections []string `json:"largeCollections"`
+
+	// Snippets are saved arrays of stages, by name, which pipelines may include with $__include(name). See ExpandIncludes.
+	Snippets map[string]string `json:"snippets"`
+
+	// DrillDownRedactedFields are removed from the documents served by the /collections/{coll}/document/{id} route, by collection,
+	// as database.collection, or just collection for any database, or * for every collection. See RedactionProjection.
+	// They only declutter drill-downs, and do not protect the fields, which panel queries and exports still return.
+	DrillDownRedactedFields map[string][]string `json:"drillDownRedactedFields"`
+
+	// AnnotationsEnabled allows editors to create, update, and delete annotations in AnnotationsDatabase.AnnotationsCollection
+	// using the /annotations route, using the separate AnnotationsUsername and AnnotationsPassword credentials.
+	// They are read back by Annotations annotation queries.
+	AnnotationsEnabled    bool   `json:"annotationsEnabled"`
+	AnnotationsDatabase   string `json:"annotationsDatabase"`
+	AnnotationsCollection string `json:"annotationsCollection"`
+
+	// StaticCollections are the collections offered by the editor for each database, instead of listing them from the server
+	StaticCollections map[string][]string `json:"staticCollections"`
 }
 
 type secureJsonData struct {
 	Username          string `json:"username"`
 	Password          string `json:"password"`
 	TLSCertificateKey string `json:"tlsCertificateKey"`
+	SuggestAPIKey     string `json:"suggestApiKey"`
+
+	AnnotationsUsername string `json:"annotationsUsername"`
+	AnnotationsPassword string `json:"annotationsPassword"`
 }
 
 type datasource struct {
@@ -52,7 +131,7 @@ func (d *datasource) getTLS() (*tls.Config, error) {
 	if d.TLSCA != "" {
 		tlsConfig.RootCAs = x509.NewCertPool()
 		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(d.TLSCA)) {
-			return nil, fmt.Errorf("failed to add tlsCA")
+			return nil, fmt.Errorf("No PEM certificates found in tlsCa")
 		}
 	}
 	if d.TLSInsecure {
@@ -73,3 +152,32 @@ func (d *datasource) getTLS() (*tls.Config, error) {
 	}
 	return tlsConfig, nil
 }
+
+// applyCellLimits sets the limits on document and array cells in a set of conversion options.
+// A depth limit already set by the query is kept if it is stricter than the datasource limit.
+func (d *datasource) applyCellLimits(opts *bsonframe.ConversionOptions) {
+	depth := cellLimit(d.MaxCellDepth)
+	if opts.MaxCellDepth == 0 || (depth != 0 && opts.MaxCellDepth > depth) {
+		opts.MaxCellDepth = depth
+	}
+	opts.MaxCellBytes = cellLimit(d.MaxCellBytes)
+}
+
+func cellLimit(configured int) int {
+	if configured < 0 {
+		return 0
+	}
+	return configured
+}
+
+// getHardQueryTimeout returns the ceiling after which queries are killed on the server, or zero if there is none
+func (d *datasource) getHardQueryTimeout() (time.Duration, error) {
+	if d.HardQueryTimeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(d.HardQueryTimeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "Invalid hard query timeout in datasource settings")
+	}
+	return timeout, nil
+}
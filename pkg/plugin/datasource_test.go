@@ -0,0 +1,24 @@
+package plugin
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("applyCellLimits", func() {
+	DescribeTable("Should limit cells", func(settings datasource, queryDepth, depth, bytes int) {
+		opts := bsonframe.ConversionOptions{MaxCellDepth: queryDepth}
+		settings.applyCellLimits(&opts)
+		Expect(opts.MaxCellDepth).To(Equal(depth))
+		Expect(opts.MaxCellBytes).To(Equal(bytes))
+	},
+		Entry("not at all unless configured", datasource{}, 0, 0, 0),
+		Entry("to the configured limits", datasource{jsonData: jsonData{MaxCellDepth: 8, MaxCellBytes: 1024}}, 0, 8, 1024),
+		Entry("not at all when disabled", datasource{jsonData: jsonData{MaxCellDepth: -1, MaxCellBytes: -1}}, 0, 0, 0),
+		Entry("to the query depth if it is stricter", datasource{jsonData: jsonData{MaxCellDepth: 8}}, 4, 4, 0),
+		Entry("to the configured depth if the query depth is not", datasource{jsonData: jsonData{MaxCellDepth: 8}}, 16, 8, 0),
+		Entry("to the query depth if none is configured", datasource{}, 16, 16, 0),
+	)
+})
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pkg/errors"
+)
+
+// requestBudget divides the wall-clock time allowed for a QueryData request between its queries, which run one after another,
+// so that a single slow query fails on its own instead of the whole request exceeding the timeout of a gateway in front of Grafana.
+// Each query is given a fair share of the time left, so time left unused by fast queries carries over to later ones.
+type requestBudget struct {
+	total     time.Duration
+	deadline  time.Time
+	remaining int
+}
+
+// newRequestBudget starts the budget of a request, which ends early if the context of the request has an earlier deadline.
+// A nil budget, when total is not positive, places no limit on queries.
+func newRequestBudget(ctx context.Context, total time.Duration, queries int, now time.Time) *requestBudget {
+	if total <= 0 {
+		return nil
+	}
+	deadline := now.Add(total)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	return &requestBudget{total: total, deadline: deadline, remaining: queries}
+}
+
+// FairShareDeadline returns the deadline of the next of a number of remaining queries, which must share the time left
+// before the deadline of their request equally
+func FairShareDeadline(requestDeadline, now time.Time, remaining int) time.Time {
+	if remaining < 1 {
+		remaining = 1
+	}
+	return now.Add(requestDeadline.Sub(now) / time.Duration(remaining))
+}
+
+// run executes the next query of the request within its share of the budget
+func (b *requestBudget) run(ctx context.Context, query backend.DataQuery, execute func(context.Context) backend.DataResponse) backend.DataResponse {
+	if b == nil {
+		return execute(ctx)
+	}
+	now := time.Now()
+	deadline := FairShareDeadline(b.deadline, now, b.remaining)
+	b.remaining--
+	if !deadline.After(now) {
+		return backend.DataResponse{Error: fmt.Errorf("Query %s was not run, as the time budget of %s for this request was already spent", query.RefID, b.total)}
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	response := execute(ctx)
+	if response.Error != nil && ctx.Err() == context.DeadlineExceeded {
+		response.Error = errors.Wrap(response.Error, fmt.Sprintf("Query exceeded its share (%s) of the time budget of %s for this request", deadline.Sub(now).Round(time.Millisecond), b.total))
+	}
+	return response
+}
+
+// getRequestTimeBudget returns the configured wall-clock time allowed for all of the queries of a request, or zero if there is no limit
+func (d *datasource) getRequestTimeBudget() (time.Duration, error) {
+	if d.RequestTimeBudget == "" {
+		return 0, nil
+	}
+	budget, err := time.ParseDuration(d.RequestTimeBudget)
+	if err != nil {
+		return 0, errors.Wrap(err, "Invalid request time budget in datasource settings")
+	}
+	return budget, nil
+}
@@ -0,0 +1,35 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FairShareDeadline", func() {
+	start := time.Unix(1000, 0)
+	deadline := start.Add(30 * time.Second)
+
+	It("Should share the budget equally between queries", func() {
+		Expect(plugin.FairShareDeadline(deadline, start, 3)).To(Equal(start.Add(10 * time.Second)))
+	})
+
+	It("Should carry time left unused by earlier queries over to later ones", func() {
+		// The first of three queries took 1s of its 10s share
+		now := start.Add(time.Second)
+		Expect(plugin.FairShareDeadline(deadline, now, 2)).To(Equal(now.Add(14500 * time.Millisecond)))
+	})
+
+	It("Should give the last query all of the time left", func() {
+		now := start.Add(25 * time.Second)
+		Expect(plugin.FairShareDeadline(deadline, now, 1)).To(Equal(deadline))
+	})
+
+	It("Should not extend past a spent budget", func() {
+		now := deadline.Add(time.Second)
+		Expect(plugin.FairShareDeadline(deadline, now, 2).After(now)).To(BeFalse())
+	})
+})
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/pkg/errors"
+)
+
+// dashboardUIDHeader is sent by Grafana with queries issued while rendering a dashboard.
+// Depending on the version of Grafana, forwarded headers may be prefixed with "http_".
+const dashboardUIDHeader = "X-Dashboard-Uid"
+
+// headerValue returns the value of a header forwarded by Grafana, which may be prefixed with http_, or an empty string if it is absent
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) || strings.EqualFold(key, "http_"+name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// dashboardUIDFromHeaders returns the UID of the dashboard a query was issued by, if any
+func dashboardUIDFromHeaders(headers map[string]string) string {
+	return headerValue(headers, dashboardUIDHeader)
+}
+
+// defaultCoalescedQueryTimeout bounds a query shared between dashboard requests when no maximum query timeout is configured.
+// The shared query is detached from the requests waiting for it, so that one leaving does not fail the others.
+const defaultCoalescedQueryTimeout = 5 * time.Minute
+
+// coalescedQuery is a query being executed on behalf of one or more waiting requests
+type coalescedQuery struct {
+	cancel   context.CancelFunc
+	done     chan struct{}
+	response backend.DataResponse
+	waiters  int
+}
+
+// QueryCoalescer shares the execution of identical queries issued concurrently by different requests
+type QueryCoalescer struct {
+	lock     sync.Mutex
+	inflight map[string]*coalescedQuery
+}
+
+// Do executes a query, unless one with the same key is already being executed, in which case its response is shared.
+// The query is executed with a context which is not cancelled by any single caller, bounded by timeout, while each caller
+// only waits as long as its own context allows. Once every caller has stopped waiting, the query is cancelled, so it
+// runs no longer than the latest deadline of the callers still waiting for it.
+func (c *QueryCoalescer) Do(ctx context.Context, key string, timeout time.Duration, execute func(context.Context) backend.DataResponse) (backend.DataResponse, bool) {
+	c.lock.Lock()
+	if c.inflight == nil {
+		c.inflight = make(map[string]*coalescedQuery)
+	}
+	query, shared := c.inflight[key]
+	if !shared {
+		queryCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		query = &coalescedQuery{cancel: cancel, done: make(chan struct{})}
+		c.inflight[key] = query
+		go func() {
+			defer cancel()
+			query.response = execute(queryCtx)
+			c.forget(key, query)
+			close(query.done)
+		}()
+	}
+	query.waiters++
+	c.lock.Unlock()
+
+	select {
+	case <-query.done:
+		return query.response, shared
+	case <-ctx.Done():
+		c.lock.Lock()
+		query.waiters--
+		if query.waiters == 0 {
+			query.cancel()
+			// Later requests must not join a query which was cancelled
+			c.forgetLocked(key, query)
+		}
+		c.lock.Unlock()
+		return backend.DataResponse{Error: errors.Wrap(ctx.Err(), "Gave up waiting for a query shared with another request")}, false
+	}
+}
+
+// forget stops sharing a query with later callers
+func (c *QueryCoalescer) forget(key string, query *coalescedQuery) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.forgetLocked(key, query)
+}
+
+func (c *QueryCoalescer) forgetLocked(key string, query *coalescedQuery) {
+	if c.inflight[key] == query {
+		delete(c.inflight, key)
+	}
+}
+
+// QueryWave deduplicates the queries of a single QueryData request, which may contain
+// the same query more than once when a dashboard has repeated panels or rows
+type QueryWave struct {
+	dashboardUID string
+	headers      map[string]string
+	responses    map[string]backend.DataResponse
+}
+
+// NewQueryWave starts deduplicating the queries of a request with the given headers
+func NewQueryWave(headers map[string]string) *QueryWave {
+	return &QueryWave{
+		dashboardUID: dashboardUIDFromHeaders(headers),
+		headers:      headers,
+		responses:    make(map[string]backend.DataResponse),
+	}
+}
+
+// Run executes a query, unless a query with the same key was already executed in the same wave,
+// or is currently being executed on behalf of the same dashboard by a concurrent request,
+// in which case its response is shared.
+// Queries which are not issued by a dashboard are never coalesced with other requests.
+func (w *QueryWave) Run(ctx context.Context, coalescer *QueryCoalescer, key string, timeout time.Duration, execute func(context.Context) backend.DataResponse) backend.DataResponse {
+	if response, ok := w.responses[key]; ok {
+		log.DefaultLogger.Debug("Reusing response for duplicate query")
+		return response
+	}
+	var response backend.DataResponse
+	if w.dashboardUID == "" {
+		response = execute(ctx)
+	} else {
+		var shared bool
+		response, shared = coalescer.Do(ctx, w.dashboardUID+"/"+key, timeout, execute)
+		if shared {
+			log.DefaultLogger.Debug("Coalesced duplicate dashboard query", "dashboard", w.dashboardUID)
+		}
+	}
+	w.responses[key] = response
+	return response
+}
+
+// coalescedQueryTimeout is the timeout of queries shared between requests, which is the maximum query timeout
+// for the read intent of the request, if any
+func coalescedQueryTimeout(pCtx backend.PluginContext, origin requestOrigin) time.Duration {
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		// The query itself will report invalid settings
+		return defaultCoalescedQueryTimeout
+	}
+	settings = settings.forReadIntent(origin.readIntent())
+	limits, err := (&QueryModel{}).getQueryLimits(&settings)
+	if err != nil || limits.timeout <= 0 {
+		return defaultCoalescedQueryTimeout
+	}
+	return limits.timeout
+}
+
+// dedupedQuery executes a query through the wave of its request. See QueryWave.
+func (d *MongoDBDatasource) dedupedQuery(ctx context.Context, pCtx backend.PluginContext, origin requestOrigin, wave *QueryWave, query backend.DataQuery) backend.DataResponse {
+	key, err := QueryCacheKey(origin.readIntent(), query)
+	if err != nil {
+		// The query itself will fail to parse and report a proper error
+		return d.cachedQuery(ctx, pCtx, origin, query, cacheModeBypass)
+	}
+	mode, err := QueryCacheMode(query.JSON, wave.headers)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	return wave.Run(ctx, &d.coalescer, key, coalescedQueryTimeout(pCtx, origin), func(ctx context.Context) backend.DataResponse {
+		return d.cachedQuery(ctx, pCtx, origin, query, mode)
+	})
+}
@@ -0,0 +1,149 @@
+package plugin_test
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QueryWave", func() {
+	dashboard := map[string]string{"http_X-Dashboard-Uid": "abc"}
+
+	var executions int32
+	var started, release chan struct{}
+	// execute counts its executions, and blocks until released if release is not nil
+	execute := func(ctx context.Context) backend.DataResponse {
+		atomic.AddInt32(&executions, 1)
+		if release != nil {
+			started <- struct{}{}
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return backend.DataResponse{Error: ctx.Err()}
+			}
+		}
+		return backend.DataResponse{Frames: data.Frames{data.NewFrame("result")}}
+	}
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&executions, 0)
+		started, release = make(chan struct{}, 2), nil
+	})
+
+	It("Should reuse the response of a duplicate query in the same wave", func(ctx SpecContext) {
+		wave := plugin.NewQueryWave(nil)
+		coalescer := &plugin.QueryCoalescer{}
+		first := wave.Run(ctx, coalescer, "key", time.Minute, execute)
+		second := wave.Run(ctx, coalescer, "key", time.Minute, execute)
+		Expect(second).To(Equal(first))
+		Expect(wave.Run(ctx, coalescer, "other", time.Minute, execute).Error).ToNot(HaveOccurred())
+		Expect(atomic.LoadInt32(&executions)).To(Equal(int32(2)))
+	})
+
+	It("Should coalesce concurrent queries of the same dashboard from different requests", func(ctx SpecContext) {
+		release = make(chan struct{})
+		coalescer := &plugin.QueryCoalescer{}
+		responses := make(chan backend.DataResponse, 2)
+		go func() {
+			responses <- plugin.NewQueryWave(dashboard).Run(ctx, coalescer, "key", time.Minute, execute)
+		}()
+		Eventually(started).Should(Receive())
+		go func() {
+			responses <- plugin.NewQueryWave(dashboard).Run(ctx, coalescer, "key", time.Minute, execute)
+		}()
+		// Give the second request time to join the first
+		Consistently(started, 100*time.Millisecond).ShouldNot(Receive())
+		close(release)
+		for i := 0; i < 2; i++ {
+			var response backend.DataResponse
+			Eventually(responses).Should(Receive(&response))
+			Expect(response.Error).ToNot(HaveOccurred())
+		}
+		Expect(atomic.LoadInt32(&executions)).To(Equal(int32(1)))
+	})
+
+	It("Should not coalesce queries which were not issued by a dashboard", func(ctx SpecContext) {
+		release = make(chan struct{})
+		coalescer := &plugin.QueryCoalescer{}
+		responses := make(chan backend.DataResponse, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				responses <- plugin.NewQueryWave(nil).Run(ctx, coalescer, "key", time.Minute, execute)
+			}()
+			Eventually(started).Should(Receive())
+		}
+		close(release)
+		Eventually(responses).Should(Receive())
+		Eventually(responses).Should(Receive())
+		Expect(atomic.LoadInt32(&executions)).To(Equal(int32(2)))
+	})
+
+	It("Should not fail the other requests when the first one leaves", func(ctx SpecContext) {
+		release = make(chan struct{})
+		coalescer := &plugin.QueryCoalescer{}
+		firstCtx, cancelFirst := context.WithCancel(ctx)
+		first := make(chan backend.DataResponse, 1)
+		second := make(chan backend.DataResponse, 1)
+		go func() {
+			first <- plugin.NewQueryWave(dashboard).Run(firstCtx, coalescer, "key", time.Minute, execute)
+		}()
+		Eventually(started).Should(Receive())
+		go func() {
+			second <- plugin.NewQueryWave(dashboard).Run(ctx, coalescer, "key", time.Minute, execute)
+		}()
+		Consistently(started, 100*time.Millisecond).ShouldNot(Receive())
+
+		cancelFirst()
+		var response backend.DataResponse
+		Eventually(first).Should(Receive(&response))
+		Expect(response.Error).To(HaveOccurred())
+
+		close(release)
+		Eventually(second).Should(Receive(&response))
+		Expect(response.Error).ToNot(HaveOccurred())
+		Expect(atomic.LoadInt32(&executions)).To(Equal(int32(1)))
+	})
+
+	It("Should cancel the shared query once every request has stopped waiting", func(ctx SpecContext) {
+		coalescer := &plugin.QueryCoalescer{}
+		cancelled := make(chan struct{})
+		blocking := func(ctx context.Context) backend.DataResponse {
+			atomic.AddInt32(&executions, 1)
+			started <- struct{}{}
+			<-ctx.Done()
+			close(cancelled)
+			return backend.DataResponse{Error: ctx.Err()}
+		}
+		firstCtx, cancelFirst := context.WithCancel(ctx)
+		secondCtx, cancelSecond := context.WithTimeout(ctx, time.Second)
+		defer cancelSecond()
+		responses := make(chan backend.DataResponse, 2)
+		go func() {
+			responses <- plugin.NewQueryWave(dashboard).Run(firstCtx, coalescer, "key", time.Minute, blocking)
+		}()
+		Eventually(started).Should(Receive())
+		go func() {
+			responses <- plugin.NewQueryWave(dashboard).Run(secondCtx, coalescer, "key", time.Minute, blocking)
+		}()
+		Consistently(started, 100*time.Millisecond).ShouldNot(Receive())
+
+		// The query keeps running for the second request, until its deadline passes
+		cancelFirst()
+		Eventually(responses).Should(Receive())
+		Consistently(cancelled, 100*time.Millisecond).ShouldNot(BeClosed())
+		Eventually(responses).Should(Receive())
+		Eventually(cancelled).Should(BeClosed())
+
+		// A later request starts a new query, rather than joining the cancelled one
+		Expect(plugin.NewQueryWave(dashboard).Run(ctx, coalescer, "key", time.Minute, execute).Error).ToNot(HaveOccurred())
+		Expect(atomic.LoadInt32(&executions)).To(Equal(int32(2)))
+	})
+})
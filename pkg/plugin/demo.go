@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultDemoDatabase   = "grafana_demo"
+	demoMetricsCollection = "metrics"
+	demoEventsCollection  = "events"
+
+	defaultDemoDays     = 7
+	maxDemoDays         = 30
+	defaultDemoInterval = 5 * time.Minute
+	minDemoInterval     = 10 * time.Second
+
+	// demoInsertBatchSize is the number of documents inserted by each insertMany
+	demoInsertBatchSize = 1000
+)
+
+var (
+	demoHosts    = []string{"web-1", "web-2", "db-1", "worker-1"}
+	demoRegions  = []string{"eu-west", "eu-west", "us-east", "us-east"}
+	demoServices = []string{"checkout", "search", "accounts"}
+)
+
+// DemoData are the documents seeded into the demo database
+type DemoData struct {
+	// Metrics are one document per host per interval, with cpu and memory percentages and a request count
+	Metrics []interface{}
+	// Events are individual requests, with a service, status code, latency, and log level
+	Events []interface{}
+}
+
+// GenerateDemoData produces synthetic time series and events for the days before end, one point per host per interval.
+// The same arguments always produce the same documents.
+func GenerateDemoData(end time.Time, days int, interval time.Duration) DemoData {
+	random := rand.New(rand.NewSource(end.Unix()))
+	start := end.Add(-time.Duration(days) * 24 * time.Hour)
+	demo := DemoData{}
+	for t := start; t.Before(end); t = t.Add(interval) {
+		// A daily cycle, peaking in the afternoon
+		daily := math.Sin(2 * math.Pi * (float64(t.Hour()) + float64(t.Minute())/60 - 9) / 24)
+		timestamp := bsonPrim.NewDateTimeFromTime(t)
+		for ix, host := range demoHosts {
+			load := 40 + 25*daily + 10*random.NormFloat64()
+			demo.Metrics = append(demo.Metrics, bson.D{
+				{Key: "timestamp", Value: timestamp},
+				{Key: "host", Value: host},
+				{Key: "region", Value: demoRegions[ix]},
+				{Key: "cpu", Value: math.Max(0, math.Min(100, load))},
+				{Key: "memory", Value: math.Max(0, math.Min(100, 55+5*daily+3*random.NormFloat64()))},
+				{Key: "requests", Value: int64(math.Max(0, 200+150*daily+30*random.NormFloat64()))},
+			})
+		}
+		for _, service := range demoServices {
+			status := int32(200)
+			level := "info"
+			switch roll := random.Float64(); {
+			case roll < 0.03:
+				status, level = 500, "error"
+			case roll < 0.1:
+				status, level = 404, "warn"
+			}
+			demo.Events = append(demo.Events, bson.D{
+				{Key: "timestamp", Value: bsonPrim.NewDateTimeFromTime(t.Add(time.Duration(random.Int63n(int64(interval)))))},
+				{Key: "service", Value: service},
+				{Key: "status", Value: status},
+				{Key: "level", Value: level},
+				{Key: "latencyMs", Value: math.Round(random.ExpFloat64()*80*(1.5+daily)*100) / 100},
+				{Key: "message", Value: fmt.Sprintf("%s request completed with status %d", service, status)},
+			})
+		}
+	}
+	return demo
+}
+
+type demoDataRequest struct {
+	Days int `json:"days,omitempty"`
+	// Interval is the time between points, as a Go duration
+	Interval string `json:"interval,omitempty"`
+	// Reset drops the demo collections before seeding them
+	Reset bool `json:"reset,omitempty"`
+}
+
+type demoDataResult struct {
+	Database string         `json:"database"`
+	Inserted map[string]int `json:"inserted"`
+}
+
+func (r *demoDataRequest) parse() (days int, interval time.Duration, err error) {
+	days = r.Days
+	if days == 0 {
+		days = defaultDemoDays
+	}
+	if days < 0 || days > maxDemoDays {
+		return 0, 0, fmt.Errorf("Days must be between 1 and %d", maxDemoDays)
+	}
+	interval = defaultDemoInterval
+	if r.Interval != "" {
+		interval, err = time.ParseDuration(r.Interval)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "Invalid interval")
+		}
+	}
+	if interval < minDemoInterval {
+		return 0, 0, fmt.Errorf("Interval must be at least %s", minDemoInterval)
+	}
+	return days, interval, nil
+}
+
+// handleDemoData seeds the demo database with synthetic data for the sample dashboard shipped with the plugin.
+// This is disabled unless explicitly enabled in the datasource settings, and is only available to Grafana admins,
+// as it writes to MongoDB.
+func (d *MongoDBDatasource) handleDemoData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	ctx := r.Context()
+	pCtx := httpadapter.PluginConfigFromContext(ctx)
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !settings.DemoDataEnabled {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Demo data is not enabled for this datasource"))
+		return
+	}
+	user := httpadapter.UserFromContext(ctx)
+	if user == nil || user.Role != grafanaAdminRole {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Only admins may seed demo data"))
+		return
+	}
+
+	// An empty body seeds the defaults
+	var req demoDataRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+	days, interval, err := req.parse()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	mongoClient, err, internalErr := connect(ctx, pCtx)
+	if internalErr != nil {
+		writeError(w, http.StatusInternalServerError, internalErr)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer mongoClient.Disconnect(ctx)
+
+	database := settings.DemoDataDatabase
+	if database == "" {
+		database = defaultDemoDatabase
+	}
+	demo := GenerateDemoData(time.Now().Truncate(interval), days, interval)
+	result := demoDataResult{Database: database, Inserted: map[string]int{}}
+	for name, docs := range map[string][]interface{}{demoMetricsCollection: demo.Metrics, demoEventsCollection: demo.Events} {
+		collection := mongoClient.Database(database).Collection(name)
+		if req.Reset {
+			err = collection.Drop(ctx)
+			if err != nil {
+				writeMongoError(w, errors.Wrap(err, fmt.Sprintf("Failed to drop %s", name)))
+				return
+			}
+		}
+		for start := 0; start < len(docs); start += demoInsertBatchSize {
+			end := start + demoInsertBatchSize
+			if end > len(docs) {
+				end = len(docs)
+			}
+			_, err = collection.InsertMany(ctx, docs[start:end], mongoOpts.InsertMany().SetOrdered(false))
+			if err != nil {
+				writeMongoError(w, errors.Wrap(err, fmt.Sprintf("Failed to insert into %s", name)))
+				return
+			}
+			result.Inserted[name] += end - start
+		}
+	}
+	log.DefaultLogger.Info("Seeded demo data", "user", user.Login, "database", database, "inserted", result.Inserted)
+	writeJSON(w, http.StatusOK, result)
+}
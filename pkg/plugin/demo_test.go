@@ -0,0 +1,30 @@
+package plugin_test
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GenerateDemoData", func() {
+	end := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	It("Should produce a point per host and an event per service for each interval", func() {
+		demo := plugin.GenerateDemoData(end, 1, time.Hour)
+		Expect(demo.Metrics).To(HaveLen(24 * 4))
+		Expect(demo.Events).To(HaveLen(24 * 3))
+		for _, doc := range demo.Metrics {
+			cpu := doc.(bson.D).Map()["cpu"].(float64)
+			Expect(cpu).To(And(BeNumerically(">=", 0), BeNumerically("<=", 100)))
+		}
+	})
+
+	It("Should be deterministic", func() {
+		Expect(plugin.GenerateDemoData(end, 1, time.Hour)).To(Equal(plugin.GenerateDemoData(end, 1, time.Hour)))
+	})
+})
@@ -0,0 +1,175 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// commandDistinct returns the distinct values of DistinctField among the documents matching Filter, as a single string field.
+// This is intended for template variables.
+const commandDistinct = "distinct"
+
+// simpleCommandClient connects for a query which runs a single command instead of reading a cursor,
+// honoring the read preference and timeout which apply to the query. If filter is not nil, it must pass the time range guard.
+func simpleCommandClient(ctx context.Context, pCtx backend.PluginContext, origin requestOrigin, qm *QueryModel, filter bson.D) (context.Context, *mongo.Client, queryLimits, func(), error) {
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		return nil, nil, queryLimits{}, nil, err
+	}
+	if filter != nil {
+		err = settings.checkFilterTimeGuard(qm, filter)
+		if err != nil {
+			return nil, nil, queryLimits{}, nil, err
+		}
+	}
+	settings = settings.forReadIntent(origin.readIntent())
+	limits, err := qm.getQueryLimits(&settings)
+	if err != nil {
+		return nil, nil, queryLimits{}, nil, err
+	}
+	cancel := func() {}
+	if limits.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.timeout)
+	}
+	clientOpts := mongoOpts.Client()
+	readPref, err := settings.getReadPreference()
+	if err != nil {
+		cancel()
+		return nil, nil, queryLimits{}, nil, err
+	}
+	if readPref != nil {
+		clientOpts.SetReadPreference(readPref)
+	}
+	client, err, internalErr := connect(ctx, pCtx, clientOpts)
+	if internalErr != nil {
+		cancel()
+		return nil, nil, queryLimits{}, nil, errors.Wrap(internalErr, "Internal failure while connecting to mongo")
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, queryLimits{}, nil, errors.Wrap(err, "Failed to connect to mongo")
+	}
+	done := func() {
+		client.Disconnect(ctx)
+		cancel()
+	}
+	return ctx, client, limits, done, nil
+}
+
+// getCommandFilter produces the filter of a query which runs a single command, combined with any ad-hoc filters,
+// and the automatic time bound, if enabled
+func (m *QueryModel) getCommandFilter(mctx MacroContext) (bson.D, error) {
+	filter, err := parseFindDocument("filter", m.Filter, m.canonicalInput(), mctx)
+	if err != nil {
+		return nil, err
+	}
+	find := findQuery{filter: filter}
+	adhoc, err := AdhocFilterMatch(m.AdhocFilters)
+	if err != nil {
+		return nil, err
+	}
+	if adhoc != nil {
+		find.and(adhoc)
+	}
+	if !m.AutoTimeBound {
+		return find.filter, nil
+	}
+	if m.TimestampField == "" {
+		return nil, fmt.Errorf("Automatic time bounds require a timestamp field")
+	}
+	timeBoundStage, err := m.getTimeBoundPipelineStage(mctx.From, mctx.To)
+	if err != nil {
+		return nil, err
+	}
+	find.and(timeBoundStage[0].Value.(bson.D))
+	return find.filter, nil
+}
+
+// DistinctFrame converts the result of a distinct command to a frame with a single string field, sorted, without nulls.
+// Values are formatted with FormatVariableValue.
+// If limit is positive, only that many values are kept, and a notice is added if any were dropped.
+func DistinctFrame(field string, values []interface{}, limit int) (*data.Frame, error) {
+	strs := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		formatted, err := FormatVariableValue(value)
+		if err != nil {
+			return nil, err
+		}
+		if formatted == nil {
+			continue
+		}
+		str := *formatted
+		// Different BSON values, e.g. 1 and 1.0, may format the same
+		if _, ok := seen[str]; ok {
+			continue
+		}
+		seen[str] = struct{}{}
+		strs = append(strs, str)
+	}
+	sort.Strings(strs)
+	var notices []data.Notice
+	if limit > 0 && len(strs) > limit {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Results were truncated to the first %d of %d values", limit, len(strs)),
+		})
+		strs = strs[:limit]
+	}
+	frame := data.NewFrame(field, data.NewField(field, nil, strs))
+	if len(notices) != 0 {
+		frame.AppendNotices(notices...)
+	}
+	return frame, nil
+}
+
+// distinctQuery runs a query using the distinct command
+func (d *MongoDBDatasource) distinctQuery(ctx context.Context, pCtx backend.PluginContext, origin requestOrigin, query backend.DataQuery, qm *QueryModel) (response backend.DataResponse) {
+	err := qm.requireCurrentModel("The distinct command")
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if qm.DistinctField == "" {
+		response.Error = fmt.Errorf("The distinct command requires a field")
+		return response
+	}
+	filter, err := qm.getCommandFilter(MacroContext{From: query.TimeRange.From, To: query.TimeRange.To, Interval: query.Interval, MaxDataPoints: query.MaxDataPoints})
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	ctx, client, limits, done, err := simpleCommandClient(ctx, pCtx, origin, qm, filter)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	defer done()
+
+	opts := mongoOpts.Distinct()
+	if limits.timeout > 0 {
+		opts.SetMaxTime(limits.timeout)
+	}
+	values, err := client.Database(qm.Database).Collection(qm.Collection).Distinct(ctx, qm.DistinctField, filter, opts)
+	if err != nil {
+		response.Error = errors.Wrap(err, "Failed to send query to mongo")
+		return response
+	}
+	frame, err := DistinctFrame(qm.DistinctField, values, limits.maxRows)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	response.Frames = data.Frames{frame}
+	qm.setExecutedFilter(response.Frames, filter)
+	return response
+}
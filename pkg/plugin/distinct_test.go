@@ -0,0 +1,33 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DistinctFrame", func() {
+	It("Should produce a sorted string field without nulls or duplicates", func() {
+		frame, err := plugin.DistinctFrame("host", []interface{}{"web-2", nil, int32(1), "web-1", float64(1), primitive.Null{}}, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame.Fields).To(HaveLen(1))
+		Expect(frame.Fields[0].Name).To(Equal("host"))
+		values := make([]string, frame.Rows())
+		for ix := range values {
+			values[ix] = frame.Fields[0].At(ix).(string)
+		}
+		Expect(values).To(Equal([]string{"1", "web-1", "web-2"}))
+		Expect(frame.Meta).To(BeNil())
+	})
+
+	It("Should truncate to the limit with a notice", func() {
+		frame, err := plugin.DistinctFrame("host", []interface{}{"c", "b", "a"}, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame.Rows()).To(Equal(2))
+		Expect(frame.Fields[0].At(1)).To(Equal("b"))
+		Expect(frame.Meta.Notices).To(HaveLen(1))
+	})
+})
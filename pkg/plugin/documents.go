@@ -9,6 +9,8 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
 )
 
 type frameCountDocument struct {
@@ -16,50 +18,132 @@ type frameCountDocument struct {
 	Count  int                    `bson:"count"`
 }
 
-type timestepDocument = map[string]interface{}
+type timestepDocument = bsonframe.Document
 
-type field struct {
-	Name string
-	Type data.FieldType
+type resultParser struct {
+	frames map[string]*data.Frame
+	model  resolvedQueryModel
+	stats  bsonframe.Stats
+	opts   bsonframe.ConversionOptions
+	// reducer, if set, receives all documents instead of them being converted into rows
+	reducer *decimalReducer
+	// skippedRows counts the documents which were skipped because converting them panicked
+	skippedRows     int
+	firstSkipReason string
 }
 
-func (f *field) get(doc timestepDocument) interface{} {
-	return doc[f.Name]
+// rowPanicError is a panic while converting a single document
+type rowPanicError struct {
+	value interface{}
 }
 
-type resultParser struct {
-	frames map[string]*data.Frame
-	model  resolvedQueryModel
+func (e *rowPanicError) Error() string {
+	if err, ok := e.value.(error); ok {
+		return err.Error()
+	}
+	return fmt.Sprintf("%v", e.value)
+}
+
+// recoverParsePanic must be deferred, and converts a panic while parsing a document into a *rowPanicError
+func recoverParsePanic(doc interface{}, err *error) {
+	if panic_ := recover(); panic_ != nil {
+		buf := make([]byte, 1<<16)
+		buflen := runtime.Stack(buf, false)
+		log.DefaultLogger.Error("Panic while parsing document", "document", doc, "error", panic_, "trace", string(buf[:buflen]))
+		*err = &rowPanicError{value: panic_}
+	}
+}
+
+// skipPanickedRow checks if extracting a row failed because of a panic, and if so, records it as skipped.
+// A panic while extracting a row has no side effects, so only that row is lost, rather than the whole query.
+// Panics while appending rows are not skipped, as they may leave frames with fields of different lengths.
+func (p *resultParser) skipPanickedRow(err error, docNumber int) bool {
+	panicErr, ok := err.(*rowPanicError)
+	if !ok {
+		return false
+	}
+	if p.skippedRows == 0 {
+		p.firstSkipReason = fmt.Sprintf("document number %d: %s", docNumber, panicErr)
+	}
+	p.skippedRows++
+	return true
+}
+
+// skippedRowsNotice warns that documents were skipped because they could not be converted, or returns nil if none were
+func (p *resultParser) skippedRowsNotice() *data.Notice {
+	if p.skippedRows == 0 {
+		return nil
+	}
+	return &data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Skipped %d document(s) which could not be converted, the first being %s", p.skippedRows, p.firstSkipReason),
+	}
 }
 
-func (p *resultParser) parseQueryResultDocument(doc timestepDocument) (err error) {
-	defer func() {
-		if panic_ := recover(); panic_ != nil {
-			buf := make([]byte, 1<<16)
-			buflen := runtime.Stack(buf, false)
-			log.DefaultLogger.Error("Panic while parsing document", "document", doc, "error", panic_, "trace", string(buf[:buflen]))
-			switch panic_.(type) {
-			case error:
-				err = panic_.(error)
-			default:
-				err = fmt.Errorf("%v", panic_)
-			}
+// parseCursor parses all remaining documents in a cursor, one at a time.
+// It returns the number of documents that were processed.
+func (p *resultParser) parseCursor(ctx context.Context, cursor *bufferedCursor) (docCount int, err error) {
+	doc, more, decodeErr, err := cursor.Next(ctx)
+	for more {
+		err = p.parseQueryResultDocument(doc)
+		if err != nil && p.skipPanickedRow(err, docCount) {
+			err = nil
+		}
+		if err != nil {
+			return docCount, fmt.Errorf("Failed to convert document number %d: %s, %v", docCount, err, doc)
+		}
+		doc, more, decodeErr, err = cursor.Next(ctx)
+		docCount++
+	}
+	if err != nil {
+		if decodeErr {
+			return docCount, errors.Wrap(err, fmt.Sprintf("Failed to decode document number %d", docCount))
 		}
-	}()
-	labels, labelsID := p.model.getLabels(doc)
+		return docCount, errors.Wrap(err, fmt.Sprintf("Failed to fetch result document number %d", docCount+1))
+	}
+	return docCount, nil
+}
+
+func (p *resultParser) parseQueryResultDocument(doc timestepDocument) error {
+	if p.reducer != nil {
+		return p.reducer.add(doc)
+	}
+	labels, labelsID, row, err := p.extractRow(doc, &p.stats)
+	if err != nil {
+		return err
+	}
+	err = p.appendRow(labels, labelsID, row)
+	if err != nil {
+		// Wrapped so that a panic while appending is never mistaken for one while extracting
+		return errors.Wrap(err, "Failed to append row")
+	}
+	return nil
+}
+
+// extractRow determines the labels and converts the values of a document.
+// It does not modify the parser, and is safe to call concurrently as long as each caller has its own stats.
+func (p *resultParser) extractRow(doc timestepDocument, stats *bsonframe.Stats) (labels data.Labels, labelsID string, row []interface{}, err error) {
+	defer recoverParsePanic(doc, &err)
+	labels, labelsID = p.model.getLabels(doc)
+	row, err = p.model.getValues(doc, &p.opts, stats)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "Failed to extract value columns")
+	}
+	return labels, labelsID, row, nil
+}
+
+// appendRow adds an already extracted row to the frame for its labels, creating it if necessary
+func (p *resultParser) appendRow(labels data.Labels, labelsID string, row []interface{}) (err error) {
+	defer recoverParsePanic(row, &err)
 	frame, ok := p.frames[labelsID]
 	if !ok {
-		log.DefaultLogger.Debug("Creating frame for unique label combination", "doc", doc, "labels", labels, "labelsID", labelsID)
+		log.DefaultLogger.Debug("Creating frame for unique label combination", "labels", labels, "labelsID", labelsID)
 		frame, err = p.model.makeFrame(labelsID, labels)
 		if err != nil {
 			return err
 		}
 		p.frames[labelsID] = frame
 	}
-	row, err := p.model.getValues(doc)
-	if err != nil {
-		return errors.Wrap(err, "Failed to extract value columns")
-	}
 	log.DefaultLogger.Debug("Parsed row", "row", row, "id", labelsID)
 	frame.AppendRow(row...)
 
@@ -69,6 +153,7 @@ func (p *resultParser) parseQueryResultDocument(doc timestepDocument) (err error
 type bufferingCursor struct {
 	*mongo.Cursor
 	buffer []timestepDocument
+	limits *cursorLimits
 }
 
 func (c *bufferingCursor) Next(ctx context.Context) (doc timestepDocument, more bool, err error) {
@@ -77,6 +162,10 @@ func (c *bufferingCursor) Next(ctx context.Context) (doc timestepDocument, more
 		err = c.Cursor.Err()
 		return
 	}
+	if !c.limits.admit(c.Cursor.Current) {
+		more = false
+		return
+	}
 
 	doc = make(timestepDocument)
 	err = c.Cursor.Decode(&doc)
@@ -93,6 +182,7 @@ func (c *bufferingCursor) Next(ctx context.Context) (doc timestepDocument, more
 type bufferedCursor struct {
 	*mongo.Cursor
 	buffer []timestepDocument
+	limits *cursorLimits
 }
 
 func (c *bufferedCursor) Next(ctx context.Context) (doc timestepDocument, more bool, decodeErr bool, err error) {
@@ -108,6 +198,10 @@ func (c *bufferedCursor) Next(ctx context.Context) (doc timestepDocument, more b
 		err = c.Cursor.Err()
 		return
 	}
+	if !c.limits.admit(c.Cursor.Current) {
+		more = false
+		return
+	}
 
 	doc = make(timestepDocument)
 	err = c.Decode(&doc)
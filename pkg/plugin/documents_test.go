@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// panickingModel is a table model which panics while extracting the values of any document with a "boom" field,
+// and, if panicOnMakeFrame is set, while creating frames
+type panickingModel struct {
+	tableQueryModel
+	panicOnMakeFrame bool
+}
+
+func (m *panickingModel) getValues(doc timestepDocument, opts *bsonframe.ConversionOptions, stats *bsonframe.Stats) ([]interface{}, error) {
+	if _, ok := doc["boom"]; ok {
+		panic("boom")
+	}
+	return m.tableQueryModel.getValues(doc, opts, stats)
+}
+
+func (m *panickingModel) makeFrame(id string, labels data.Labels) (*data.Frame, error) {
+	if m.panicOnMakeFrame {
+		panic("cannot make frame")
+	}
+	return m.tableQueryModel.makeFrame(id, labels)
+}
+
+var _ = Describe("Skipping documents which panic", func() {
+	docs := []interface{}{
+		bson.D{{Key: "n", Value: int64(1)}},
+		bson.D{{Key: "n", Value: int64(2)}, {Key: "boom", Value: true}},
+		bson.D{{Key: "n", Value: int64(3)}},
+		bson.D{{Key: "n", Value: int64(4)}, {Key: "boom", Value: true}},
+	}
+	parse := func(model resolvedQueryModel, workers int) (*resultParser, error) {
+		cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		ctx := context.Background()
+		defer cursor.Close(ctx)
+		parser := &resultParser{frames: map[string]*data.Frame{}, model: model}
+		if workers > 1 {
+			_, err = parser.parseCursorParallel(ctx, &bufferedCursor{Cursor: cursor}, workers)
+		} else {
+			_, err = parser.parseCursor(ctx, &bufferedCursor{Cursor: cursor})
+		}
+		return parser, err
+	}
+
+	DescribeTable("Should skip only the documents whose values panic", func(workers int) {
+		model := &panickingModel{tableQueryModel: tableQueryModel{fields: []bsonframe.Column{bsonframe.NewColumn("n", data.FieldTypeNullableInt64)}}}
+		parser, err := parse(model, workers)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parser.frames).To(HaveLen(1))
+		frame := parser.frames[""]
+		Expect(frame.Rows()).To(Equal(2))
+		one, three := int64(1), int64(3)
+		Expect(frame.Fields[0].At(0)).To(Equal(&one))
+		Expect(frame.Fields[0].At(1)).To(Equal(&three))
+
+		notice := parser.skippedRowsNotice()
+		Expect(notice).ToNot(BeNil())
+		Expect(notice.Severity).To(Equal(data.NoticeSeverityWarning))
+		Expect(notice.Text).To(Equal("Skipped 2 document(s) which could not be converted, the first being document number 1: boom"))
+	},
+		Entry("serially", 1),
+		Entry("in parallel", 4),
+	)
+
+	DescribeTable("Should fail the query if appending a row panics", func(workers int) {
+		model := &panickingModel{
+			tableQueryModel:  tableQueryModel{fields: []bsonframe.Column{bsonframe.NewColumn("n", data.FieldTypeNullableInt64)}},
+			panicOnMakeFrame: true,
+		}
+		parser, err := parse(model, workers)
+		Expect(err).To(MatchError(ContainSubstring("cannot make frame")))
+		Expect(parser.skippedRowsNotice()).To(BeNil())
+	},
+		Entry("serially", 1),
+		Entry("in parallel", 4),
+	)
+
+	It("Should not report skipped documents if there were none", func() {
+		Expect((&resultParser{}).skippedRowsNotice()).To(BeNil())
+	})
+})
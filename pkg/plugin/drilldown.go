@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// redactAllCollections is the key of the fields redacted from documents of every collection
+const redactAllCollections = "*"
+
+// DocumentLink returns the path, relative to the Grafana root, of the resource route which serves a single document
+func DocumentLink(datasourceUID, database, collection string, id interface{}) (string, error) {
+	idJSON, err := bson.MarshalExtJSON(bson.D{bson.E{Key: "_id", Value: id}}, false, false)
+	if err != nil {
+		return "", err
+	}
+	var wrapper struct {
+		ID json.RawMessage `json:"_id"`
+	}
+	err = json.Unmarshal(idJSON, &wrapper)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"/api/datasources/uid/%s/resources/collections/%s/document/%s?database=%s",
+		url.PathEscape(datasourceUID), url.PathEscape(collection), url.PathEscape(string(wrapper.ID)), url.QueryEscape(database),
+	), nil
+}
+
+// AddDocumentLinks adds a data link to the _id field of every frame, which opens the full document of a row
+func AddDocumentLinks(frames []*data.Frame, datasourceUID, database, collection string) {
+	// ${__value.raw} is the hex string of ObjectIDs, which the route also accepts
+	link := data.DataLink{
+		Title:       "Full document",
+		URL:         fmt.Sprintf("/api/datasources/uid/%s/resources/collections/%s/document/${__value.raw}?database=%s", url.PathEscape(datasourceUID), url.PathEscape(collection), url.QueryEscape(database)),
+		TargetBlank: true,
+	}
+	for _, frame := range frames {
+		field := frameField(frame, "_id")
+		if field == nil {
+			continue
+		}
+		if field.Config == nil {
+			field.Config = &data.FieldConfig{}
+		}
+		field.Config.Links = append(field.Config.Links, link)
+	}
+}
+
+// RedactionProjection returns a projection which removes the fields redacted from documents of a collection,
+// which are those configured for every collection, the collection in any database, and the collection in its database.
+// Fields within other redacted fields are omitted, as MongoDB rejects projections with overlapping paths.
+func RedactionProjection(rules map[string][]string, database, collection string) bson.D {
+	seen := map[string]struct{}{}
+	fields := []string{}
+	for _, key := range []string{redactAllCollections, collection, database + "." + collection} {
+		for _, field := range rules[key] {
+			if _, ok := seen[field]; ok || field == "" {
+				continue
+			}
+			seen[field] = struct{}{}
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	projection := bson.D{}
+	for _, field := range fields {
+		nested := false
+		for _, kept := range projection {
+			nested = nested || strings.HasPrefix(field, kept.Key+".")
+		}
+		if !nested {
+			projection = append(projection, bson.E{Key: field, Value: 0})
+		}
+	}
+	return projection
+}
+
+// ParseDocumentID parses the id of a document route, which is extended JSON, or a bare string.
+// Frames contain ObjectIDs as hex strings, so strings which could be ObjectIDs match either.
+func ParseDocumentID(text string) (bson.D, error) {
+	var wrapper bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{"_id":`+text+`}`), false, &wrapper)
+	var id interface{} = text
+	if err == nil {
+		id = wrapper[0].Value
+	}
+	if str, ok := id.(string); ok {
+		if oid, err := bsonPrim.ObjectIDFromHex(str); err == nil {
+			return bson.D{bson.E{Key: "_id", Value: bson.D{bson.E{Key: "$in", Value: bson.A{oid, str}}}}}, nil
+		}
+	}
+	return bson.D{bson.E{Key: "_id", Value: id}}, nil
+}
+
+// handleDocument returns a single document, by its _id, as relaxed extended JSON, with the fields redacted from drill-downs
+// of its collection removed. See DrillDownRedactedFields.
+func (d *MongoDBDatasource) handleDocument(w http.ResponseWriter, r *http.Request, database, collection, id string) {
+	filter, err := ParseDocumentID(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, client, settings, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	opts := mongoOpts.FindOne().SetComment(newQueryComment())
+	projection := RedactionProjection(settings.DrillDownRedactedFields, database, collection)
+	if len(projection) != 0 {
+		opts.SetProjection(projection)
+	}
+	var doc bson.Raw
+	err = client.Database(database).Collection(collection).FindOne(ctx, filter, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No document with _id %s", id))
+		return
+	}
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to find document"))
+		return
+	}
+	body, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,75 @@
+package plugin_test
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DocumentLink", func() {
+	It("Should link to the document route with an extended JSON id", func() {
+		link, err := plugin.DocumentLink("abc", "db", "logs", "5f1d7b2e9c3a4b0012345678")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(link).To(Equal("/api/datasources/uid/abc/resources/collections/logs/document/%225f1d7b2e9c3a4b0012345678%22?database=db"))
+	})
+})
+
+var _ = Describe("ParseDocumentID", func() {
+	It("Should match strings which could be ObjectIDs as either", func() {
+		oid, _ := primitive.ObjectIDFromHex("5f1d7b2e9c3a4b0012345678")
+		for _, text := range []string{`"5f1d7b2e9c3a4b0012345678"`, `5f1d7b2e9c3a4b0012345678`} {
+			filter, err := plugin.ParseDocumentID(text)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(filter).To(Equal(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{oid, "5f1d7b2e9c3a4b0012345678"}}}}}))
+		}
+	})
+
+	It("Should parse extended JSON ids", func() {
+		filter, err := plugin.ParseDocumentID(`42`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter).To(Equal(bson.D{{Key: "_id", Value: int32(42)}}))
+		filter, err = plugin.ParseDocumentID(`host-1`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter).To(Equal(bson.D{{Key: "_id", Value: "host-1"}}))
+	})
+})
+
+var _ = Describe("RedactionProjection", func() {
+	rules := map[string][]string{
+		"*":          {"password"},
+		"users":      {"profile.ssn", "password"},
+		"app.users":  {"profile", "tokens"},
+		"other.logs": {"secret"},
+	}
+
+	It("Should combine the rules for every collection, the collection, and the collection in its database", func() {
+		Expect(plugin.RedactionProjection(rules, "app", "users")).To(Equal(bson.D{
+			{Key: "password", Value: 0},
+			{Key: "profile", Value: 0},
+			{Key: "tokens", Value: 0},
+		}))
+		Expect(plugin.RedactionProjection(rules, "test", "users")).To(Equal(bson.D{
+			{Key: "password", Value: 0},
+			{Key: "profile.ssn", Value: 0},
+		}))
+	})
+
+	It("Should be empty if nothing is redacted", func() {
+		Expect(plugin.RedactionProjection(nil, "app", "logs")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("AddDocumentLinks", func() {
+	It("Should link the _id field to the document route", func() {
+		frame := data.NewFrame("", data.NewField("_id", nil, []string{"a"}), data.NewField("value", nil, []float64{1}))
+		plugin.AddDocumentLinks([]*data.Frame{frame}, "abc", "db", "logs")
+		Expect(frame.Fields[0].Config.Links).To(HaveLen(1))
+		Expect(frame.Fields[0].Config.Links[0].URL).To(Equal("/api/datasources/uid/abc/resources/collections/logs/document/${__value.raw}?database=db"))
+		Expect(frame.Fields[1].Config).To(BeNil())
+	})
+})
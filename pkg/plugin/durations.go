@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+// durationUnits maps the accepted source units of duration fields to Grafana unit IDs
+var durationUnits = map[string]string{
+	"ns": "ns",
+	"us": "µs",
+	"µs": "µs",
+	"ms": "ms",
+	"s":  "s",
+	"m":  "m",
+	"h":  "h",
+	"d":  "d",
+}
+
+// durationField marks a numeric field as a duration in a unit, so that Grafana displays it as one
+type durationField struct {
+	Field string `json:"field"`
+	// Unit is the unit the field is stored in, one of ns, us, ms, s, m, h, or d
+	Unit string `json:"unit"`
+	// ISO8601, if true, converts ISO-8601 duration strings in the field into seconds, in which case unit must be s or omitted
+	ISO8601 bool `json:"iso8601,omitempty"`
+}
+
+func (d *durationField) grafanaUnit() (string, error) {
+	if d.ISO8601 {
+		if d.Unit != "" && d.Unit != "s" {
+			return "", fmt.Errorf("Duration field %s is converted from ISO-8601 to seconds, so its unit must be s", d.Field)
+		}
+		return "s", nil
+	}
+	unit, ok := durationUnits[d.Unit]
+	if !ok {
+		return "", fmt.Errorf("Duration field %s has unknown unit %q, must be one of ns, us, ms, s, m, h, d", d.Field, d.Unit)
+	}
+	return unit, nil
+}
+
+// applyDurationFields validates the duration fields of a query, and makes any fields converted from ISO-8601 into nullable numbers
+func (m *QueryModel) applyDurationFields(fields []bsonframe.Column) ([]bsonframe.Column, error) {
+	iso := make(map[string]struct{}, len(m.DurationFields))
+	for _, duration := range m.DurationFields {
+		if _, err := duration.grafanaUnit(); err != nil {
+			return nil, err
+		}
+		if duration.ISO8601 {
+			iso[duration.Field] = struct{}{}
+		}
+	}
+	if len(iso) == 0 {
+		return fields, nil
+	}
+	converted := make([]bsonframe.Column, len(fields))
+	for ix, f := range fields {
+		if _, ok := iso[f.Name]; ok {
+			f = bsonframe.NewColumn(f.Name, data.FieldTypeNullableFloat64)
+			f.ISODuration = true
+			// Durations which are already numbers are assumed to be seconds
+			f.Coerce = true
+		}
+		converted[ix] = f
+	}
+	return converted, nil
+}
+
+// setDurationUnits sets the unit of each duration field in a set of frames
+func (m *QueryModel) setDurationUnits(frames []*data.Frame) {
+	if len(m.DurationFields) == 0 {
+		return
+	}
+	units := make(map[string]string, len(m.DurationFields))
+	for _, duration := range m.DurationFields {
+		// Already validated by applyDurationFields
+		units[duration.Field], _ = duration.grafanaUnit()
+	}
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			unit, ok := units[field.Name]
+			if !ok {
+				continue
+			}
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.Unit = unit
+		}
+	}
+}
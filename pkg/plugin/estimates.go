@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultEstimateSampleSize = 1000
+	maxEstimateSampleSize     = 10000
+	maxEstimateFields         = 20
+)
+
+type fieldEstimate struct {
+	Field                string `json:"field"`
+	DistinctInSample     int64  `json:"distinctInSample"`
+	EstimatedCardinality int64  `json:"estimatedCardinality"`
+}
+
+type estimatesResponse struct {
+	SampleSize           int64           `json:"sampleSize"`
+	EstimatedDocuments   int64           `json:"estimatedDocuments"`
+	AverageDocumentBytes *float64        `json:"averageDocumentBytes,omitempty"`
+	Fields               []fieldEstimate `json:"fields"`
+}
+
+// EstimateCardinality estimates the number of distinct values of a field in a collection of total documents,
+// from a random sample of sampled documents, given the number of distinct values which occurred exactly k times
+// in the sample, for each k. This is the Guaranteed-Error Estimator of Charikar et al.,
+// which scales up the values seen only once, as those are the ones which suggest more unseen values exist.
+func EstimateCardinality(total, sampled int64, frequencies map[int64]int64) int64 {
+	if sampled <= 0 {
+		return 0
+	}
+	scale := 1.0
+	if total > sampled {
+		scale = math.Sqrt(float64(total) / float64(sampled))
+	}
+	estimate := 0.0
+	for k, count := range frequencies {
+		if k == 1 {
+			estimate += scale * float64(count)
+		} else {
+			estimate += float64(count)
+		}
+	}
+	if total > 0 && estimate > float64(total) {
+		return total
+	}
+	return int64(math.Round(estimate))
+}
+
+// estimatesPipeline samples a collection, and, for each field, counts how many values occur exactly k times, for each k.
+// If includeSize is true, the average size of the sampled documents is also computed, which requires MongoDB 4.4.
+func estimatesPipeline(fields []string, sampleSize int, includeSize bool) mongo.Pipeline {
+	summary := bson.D{
+		bson.E{Key: "_id", Value: nil},
+		bson.E{Key: "count", Value: bson.D{bson.E{Key: "$sum", Value: 1}}},
+	}
+	if includeSize {
+		summary = append(summary, bson.E{Key: "avgSize", Value: bson.D{bson.E{Key: "$avg", Value: bson.D{bson.E{Key: "$bsonSize", Value: "$$ROOT"}}}}})
+	}
+	facets := bson.D{
+		bson.E{Key: "summary", Value: mongo.Pipeline{bson.D{bson.E{Key: "$group", Value: summary}}}},
+	}
+	for ix, field := range fields {
+		facets = append(facets, bson.E{Key: fmt.Sprintf("f%d", ix), Value: mongo.Pipeline{
+			bson.D{bson.E{Key: "$group", Value: bson.D{
+				bson.E{Key: "_id", Value: "$" + field},
+				bson.E{Key: "count", Value: bson.D{bson.E{Key: "$sum", Value: 1}}},
+			}}},
+			bson.D{bson.E{Key: "$group", Value: bson.D{
+				bson.E{Key: "_id", Value: "$count"},
+				bson.E{Key: "values", Value: bson.D{bson.E{Key: "$sum", Value: 1}}},
+			}}},
+		}})
+	}
+	return mongo.Pipeline{
+		bson.D{bson.E{Key: "$sample", Value: bson.D{bson.E{Key: "size", Value: sampleSize}}}},
+		bson.D{bson.E{Key: "$facet", Value: facets}},
+	}
+}
+
+// handleEstimates estimates the average document size of a collection, and the cardinality of a set of fields, from a random sample,
+// so that the query editor can warn about grouping by high-cardinality fields before the query is run
+func (d *MongoDBDatasource) handleEstimates(w http.ResponseWriter, r *http.Request, database, collection string) {
+	sampleSize, err := queryIntParam(r, "sample", defaultEstimateSampleSize, maxEstimateSampleSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	fields := []string{}
+	if raw := r.URL.Query().Get("fields"); raw != "" {
+		for _, field := range strings.Split(raw, ",") {
+			field = strings.TrimPrefix(strings.TrimSpace(field), "$")
+			if field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	if len(fields) > maxEstimateFields {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("At most %d fields may be estimated at once", maxEstimateFields))
+		return
+	}
+
+	ctx, client, _, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	coll := client.Database(database).Collection(collection)
+	total, err := coll.EstimatedDocumentCount(ctx)
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to count documents"))
+		return
+	}
+	version, err := getServerVersion(ctx, client)
+	if err != nil {
+		writeMongoError(w, err)
+		return
+	}
+	includeSize := version.atLeast(4, 4)
+
+	cursor, err := coll.Aggregate(ctx, estimatesPipeline(fields, sampleSize, includeSize), mongoOpts.Aggregate().SetComment(newQueryComment()))
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to sample collection"))
+		return
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		writeMongoError(w, errors.Wrap(cursor.Err(), "Failed to sample collection"))
+		return
+	}
+
+	var summary []struct {
+		Count   int64    `bson:"count"`
+		AvgSize *float64 `bson:"avgSize"`
+	}
+	err = cursor.Current.Lookup("summary").Unmarshal(&summary)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "Failed to decode sample summary"))
+		return
+	}
+	response := estimatesResponse{
+		EstimatedDocuments: total,
+		Fields:             make([]fieldEstimate, len(fields)),
+	}
+	if len(summary) != 0 {
+		response.SampleSize = summary[0].Count
+		response.AverageDocumentBytes = summary[0].AvgSize
+	}
+	for ix, field := range fields {
+		var buckets []struct {
+			Count  int64 `bson:"_id"`
+			Values int64 `bson:"values"`
+		}
+		err = cursor.Current.Lookup(fmt.Sprintf("f%d", ix)).Unmarshal(&buckets)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errors.Wrap(err, fmt.Sprintf("Failed to decode estimate for %s", field)))
+			return
+		}
+		frequencies := make(map[int64]int64, len(buckets))
+		estimate := fieldEstimate{Field: field}
+		for _, bucket := range buckets {
+			frequencies[bucket.Count] = bucket.Values
+			estimate.DistinctInSample += bucket.Values
+		}
+		estimate.EstimatedCardinality = EstimateCardinality(total, response.SampleSize, frequencies)
+		response.Fields[ix] = estimate
+	}
+	writeJSON(w, http.StatusOK, response)
+}
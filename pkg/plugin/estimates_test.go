@@ -0,0 +1,23 @@
+package plugin_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EstimateCardinality", func() {
+	It("Should not scale up a field whose values all repeat in the sample", func() {
+		Expect(plugin.EstimateCardinality(1000000, 1000, map[int64]int64{100: 10})).To(Equal(int64(10)))
+	})
+
+	It("Should scale up values seen only once", func() {
+		Expect(plugin.EstimateCardinality(1000000, 1000, map[int64]int64{1: 1000})).To(Equal(int64(31623)))
+	})
+
+	It("Should not exceed the number of documents", func() {
+		Expect(plugin.EstimateCardinality(1200, 1000, map[int64]int64{1: 1000})).To(Equal(int64(1095)))
+		Expect(plugin.EstimateCardinality(1000, 1000, map[int64]int64{1: 1000})).To(Equal(int64(1000)))
+	})
+})
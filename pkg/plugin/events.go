@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+const (
+	healthEventFailure  = "failure"
+	healthEventRestored = "restored"
+
+	// maxHealthEvents is the number of most recent health events retained by each datasource instance
+	maxHealthEvents = 1000
+
+	// queryTypeHealthEvents is an annotation query type which returns datasource outages as regions
+	queryTypeHealthEvents = "HealthEvents"
+)
+
+// healthEvent records a change in whether MongoDB is reachable
+type healthEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Message string    `json:"message,omitempty"`
+}
+
+// healthEventLog records connection failures and restorations observed by queries and health checks.
+// Events are only held in memory, and so are lost when the datasource instance is recreated.
+// A nil log records nothing.
+type healthEventLog struct {
+	lock   sync.Mutex
+	down   bool
+	events []healthEvent
+}
+
+func newHealthEventLog() *healthEventLog {
+	return &healthEventLog{events: make([]healthEvent, 0)}
+}
+
+// isConnectionError returns true if an error indicates MongoDB could not be reached,
+// as opposed to, for example, an invalid query
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var selectionErr topology.ServerSelectionError
+	return mongo.IsNetworkError(err) || stderrors.As(err, &selectionErr) || stderrors.Is(err, topology.ErrServerSelectionTimeout)
+}
+
+// observe records a failure event if err is a connection error and MongoDB was previously reachable,
+// or a restored event if err is nil and MongoDB was previously unreachable.
+// Other errors say nothing about reachability, and are ignored.
+func (l *healthEventLog) observe(err error) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	switch {
+	case err == nil && l.down:
+		l.down = false
+		l.append(healthEvent{Time: time.Now(), Type: healthEventRestored})
+	case isConnectionError(err) && !l.down:
+		l.down = true
+		l.append(healthEvent{Time: time.Now(), Type: healthEventFailure, Message: err.Error()})
+	}
+}
+
+func (l *healthEventLog) append(event healthEvent) {
+	if len(l.events) == maxHealthEvents {
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, event)
+}
+
+// between returns the events within a time range, oldest first.
+// The event immediately before the range is also included, so that an outage spanning the start of the range is not lost.
+func (l *healthEventLog) between(from, to time.Time) []healthEvent {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	start := 0
+	for ix, event := range l.events {
+		if !event.Time.Before(from) {
+			break
+		}
+		start = ix
+	}
+	events := make([]healthEvent, 0)
+	for _, event := range l.events[start:] {
+		if event.Time.After(to) {
+			break
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// annotationFrame produces an annotation frame with a region for each outage overlapping a time range
+func (l *healthEventLog) annotationFrame(timeRange backend.TimeRange) *data.Frame {
+	times := []time.Time{}
+	timeEnds := []time.Time{}
+	texts := []string{}
+	tags := []string{}
+
+	var start *healthEvent
+	for _, event := range l.between(timeRange.From, timeRange.To) {
+		event := event
+		switch event.Type {
+		case healthEventFailure:
+			start = &event
+		case healthEventRestored:
+			if start == nil {
+				continue
+			}
+			times = append(times, start.Time)
+			timeEnds = append(timeEnds, event.Time)
+			texts = append(texts, fmt.Sprintf("MongoDB unreachable: %s", start.Message))
+			tags = append(tags, "outage")
+			start = nil
+		}
+	}
+	if start != nil {
+		times = append(times, start.Time)
+		timeEnds = append(timeEnds, time.Now())
+		texts = append(texts, fmt.Sprintf("MongoDB unreachable (ongoing): %s", start.Message))
+		tags = append(tags, "outage")
+	}
+	return data.NewFrame("events",
+		data.NewField("time", nil, times),
+		data.NewField("timeEnd", nil, timeEnds),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	)
+}
+
+// healthEventsResponse answers a HealthEvents annotation query
+func (d *MongoDBDatasource) healthEventsResponse(timeRange backend.TimeRange) backend.DataResponse {
+	if d.health == nil {
+		return backend.DataResponse{Error: fmt.Errorf("Health events are not enabled for this datasource")}
+	}
+	return backend.DataResponse{Frames: data.Frames{d.health.annotationFrame(timeRange)}}
+}
+
+type healthEventsResponse struct {
+	Down   bool          `json:"down"`
+	Events []healthEvent `json:"events"`
+}
+
+// handleEvents returns the connection failures and restorations recorded by this datasource instance,
+// optionally restricted to a time range given by the from and to query parameters, as milliseconds since the epoch
+func (d *MongoDBDatasource) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only GET is supported"))
+		return
+	}
+	if d.health == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Health events are not enabled for this datasource"))
+		return
+	}
+	from := time.Time{}
+	to := time.Now()
+	for name, dest := range map[string]*time.Time{"from": &from, "to": &to} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		millis, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s must be milliseconds since the epoch", name))
+			return
+		}
+		*dest = time.Unix(0, millis*int64(time.Millisecond))
+	}
+	events := d.health.between(from, to)
+	d.health.lock.Lock()
+	down := d.health.down
+	d.health.lock.Unlock()
+	writeJSON(w, http.StatusOK, healthEventsResponse{Down: down, Events: events})
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("healthEventLog", func() {
+	connectionErr := fmt.Errorf("Failed to run query: %w", topology.ErrServerSelectionTimeout)
+	at := func(seconds int64) time.Time { return time.Unix(seconds, 0).UTC() }
+	eventTypes := func(events []healthEvent) []string {
+		types := []string{}
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		return types
+	}
+
+	It("Should only record changes in whether MongoDB is reachable", func() {
+		l := newHealthEventLog()
+		l.observe(nil)
+		l.observe(fmt.Errorf("Invalid query"))
+		Expect(l.events).To(BeEmpty())
+		l.observe(connectionErr)
+		l.observe(connectionErr)
+		Expect(l.down).To(BeTrue())
+		l.observe(fmt.Errorf("Invalid query"))
+		Expect(l.down).To(BeTrue())
+		l.observe(nil)
+		l.observe(nil)
+		Expect(l.down).To(BeFalse())
+		Expect(eventTypes(l.events)).To(Equal([]string{healthEventFailure, healthEventRestored}))
+		Expect(l.events[0].Message).To(ContainSubstring("server selection timeout"))
+	})
+
+	It("Should record nothing when disabled", func() {
+		var l *healthEventLog
+		Expect(func() { l.observe(connectionErr) }).ToNot(Panic())
+	})
+
+	It("Should only retain the most recent events", func() {
+		l := newHealthEventLog()
+		for ix := 0; ix < maxHealthEvents+10; ix++ {
+			l.append(healthEvent{Time: at(int64(ix)), Type: healthEventFailure})
+		}
+		Expect(l.events).To(HaveLen(maxHealthEvents))
+		Expect(l.events[0].Time).To(Equal(at(10)))
+	})
+
+	It("Should include the event before a time range", func() {
+		l := newHealthEventLog()
+		l.events = []healthEvent{
+			{Time: at(10), Type: healthEventFailure},
+			{Time: at(20), Type: healthEventRestored},
+			{Time: at(30), Type: healthEventFailure},
+			{Time: at(40), Type: healthEventRestored},
+			{Time: at(50), Type: healthEventFailure},
+		}
+		Expect(l.between(at(25), at(45))).To(Equal(l.events[1:4]))
+		Expect(l.between(at(0), at(5))).To(BeEmpty())
+	})
+
+	It("Should produce a region for each outage, including one which is ongoing", func() {
+		l := newHealthEventLog()
+		l.events = []healthEvent{
+			{Time: at(10), Type: healthEventFailure, Message: "first"},
+			{Time: at(20), Type: healthEventRestored},
+			{Time: at(30), Type: healthEventFailure, Message: "second"},
+		}
+		frame := l.annotationFrame(backend.TimeRange{From: at(15), To: at(40)})
+		Expect(frame.Rows()).To(Equal(2))
+		Expect(frame.Fields[0].At(0)).To(Equal(at(10)))
+		Expect(frame.Fields[1].At(0)).To(Equal(at(20)))
+		Expect(frame.Fields[2].At(0)).To(Equal("MongoDB unreachable: first"))
+		Expect(frame.Fields[0].At(1)).To(Equal(at(30)))
+		Expect(frame.Fields[2].At(1)).To(Equal("MongoDB unreachable (ongoing): second"))
+	})
+
+	It("Should reject health event queries when disabled", func() {
+		d := &MongoDBDatasource{}
+		Expect(d.healthEventsResponse(backend.TimeRange{}).Error).To(MatchError(ContainSubstring("not enabled")))
+	})
+})
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const executedQueryIndent = "  "
+
+// FormatPipeline pretty-prints a pipeline as relaxed extended JSON, in the same form it is entered in the query editor
+func FormatPipeline(pipeline mongo.Pipeline) (string, error) {
+	// Only documents may be marshaled as extended JSON, so the array is assembled from its stages
+	var compact bytes.Buffer
+	compact.WriteByte('[')
+	for ix, stage := range pipeline {
+		if ix != 0 {
+			compact.WriteByte(',')
+		}
+		stageJSON, err := bson.MarshalExtJSON(stage, false, false)
+		if err != nil {
+			return "", err
+		}
+		compact.Write(stageJSON)
+	}
+	compact.WriteByte(']')
+	var indented bytes.Buffer
+	err := json.Indent(&indented, compact.Bytes(), "", executedQueryIndent)
+	if err != nil {
+		return "", err
+	}
+	return indented.String(), nil
+}
+
+// formatFilter pretty-prints the filter of a command as relaxed extended JSON
+func formatFilter(filter bson.D) (string, error) {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	bytes, err := bson.MarshalExtJSONIndent(filter, false, false, "", executedQueryIndent)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// setExecutedQueryString shows the query which was sent to MongoDB, after macros, variables, and ad-hoc filters were applied,
+// in the query inspector
+func setExecutedQueryString(frames []*data.Frame, executed string) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.ExecutedQueryString = executed
+	}
+}
+
+// setExecutedFilter shows the filter of a query which runs a single command in the query inspector
+func (m *QueryModel) setExecutedFilter(frames []*data.Frame, filter bson.D) {
+	if m.HideFromInspector {
+		return
+	}
+	executed, err := formatFilter(filter)
+	if err != nil {
+		log.DefaultLogger.Warn("Could not format executed filter", "error", err)
+		return
+	}
+	setExecutedQueryString(frames, executed)
+}
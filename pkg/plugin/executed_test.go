@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FormatPipeline", func() {
+	It("Should pretty-print the pipeline with macros expanded", func() {
+		pipeline, err := buildPipeline(
+			[]byte(`{"version":2,"aggregation":"[{\"$match\": $__timeFilter(ts)}, {\"$limit\": 5}]"}`),
+			MacroContext{From: time.Unix(0, 0).UTC(), To: time.Unix(60, 0).UTC()},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		formatted, err := FormatPipeline(pipeline)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(formatted).To(Equal(`[
+  {
+    "$match": {
+      "ts": {
+        "$gte": {
+          "$date": "1970-01-01T00:00:00Z"
+        },
+        "$lt": {
+          "$date": "1970-01-01T00:01:00Z"
+        }
+      }
+    }
+  },
+  {
+    "$limit": 5
+  }
+]`))
+	})
+
+	It("Should format an empty pipeline", func() {
+		Expect(FormatPipeline(mongo.Pipeline{})).To(Equal(`[]`))
+	})
+
+	It("Should keep types which JSON cannot represent", func() {
+		oid, err := bsonPrim.ObjectIDFromHex("5f1d7b2e9c3a4b0012345678")
+		Expect(err).ToNot(HaveOccurred())
+		formatted, err := FormatPipeline(mongo.Pipeline{bson.D{{Key: "$match", Value: bson.D{{Key: "_id", Value: oid}}}}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(formatted).To(ContainSubstring(`"$oid": "5f1d7b2e9c3a4b0012345678"`))
+	})
+})
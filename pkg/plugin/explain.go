@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"encoding/json"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExplainSummary is the part of an explain result shown in the query inspector
+type ExplainSummary struct {
+	// Stages are the aggregation stages executed after the query planner, such as $group. A pipeline which is entirely
+	// pushed down into the query planner has none.
+	Stages []string `json:"stages"`
+	// IndexesUsed are the names of the indexes scanned by the winning plans
+	IndexesUsed []string `json:"indexesUsed"`
+	// CollectionScan is true if any winning plan scans the whole collection
+	CollectionScan bool `json:"collectionScan"`
+	// WinningPlans are the outermost winning plans of the query planner, as relaxed extended JSON. Sharded collections may
+	// have one per shard, or a single plan merging those of each shard, depending on the server version.
+	WinningPlans []json.RawMessage `json:"winningPlans"`
+}
+
+// explainWalker collects the parts of an explain result summarized by ExplainSummary
+type explainWalker struct {
+	summary ExplainSummary
+	stages  map[string]struct{}
+	indexes map[string]struct{}
+}
+
+// walk visits every value of an explain result. Each map and slice type the driver may decode into is handled,
+// as the layout of explain results differs between server versions, and between sharded and unsharded collections.
+func (w *explainWalker) walk(key string, value interface{}, inWinningPlan bool) error {
+	if key == "winningPlan" && !inWinningPlan {
+		plan, err := bson.MarshalExtJSON(bson.M{"plan": value}, false, false)
+		if err != nil {
+			return err
+		}
+		// The wrapper only exists because only documents may be marshaled
+		var wrapper struct {
+			Plan json.RawMessage `json:"plan"`
+		}
+		err = json.Unmarshal(plan, &wrapper)
+		if err != nil {
+			return err
+		}
+		w.summary.WinningPlans = append(w.summary.WinningPlans, wrapper.Plan)
+		inWinningPlan = true
+	}
+	if key == "indexName" && inWinningPlan {
+		if name, ok := value.(string); ok {
+			if _, seen := w.indexes[name]; !seen {
+				w.indexes[name] = struct{}{}
+				w.summary.IndexesUsed = append(w.summary.IndexesUsed, name)
+			}
+		}
+	}
+	switch v := value.(type) {
+	case bson.M:
+		for elemKey, elem := range v {
+			err := w.walk(elemKey, elem, inWinningPlan)
+			if err != nil {
+				return err
+			}
+		}
+	case bson.D:
+		for _, elem := range v {
+			err := w.walk(elem.Key, elem.Value, inWinningPlan)
+			if err != nil {
+				return err
+			}
+		}
+	case bsonPrim.A:
+		for _, elem := range v {
+			if key == "stages" {
+				w.addStage(elem)
+			}
+			err := w.walk("", elem, inWinningPlan)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addStage records the name of an entry of the stages of an aggregation explain result, which is its only $-prefixed key
+func (w *explainWalker) addStage(stage interface{}) {
+	var keys []string
+	switch v := stage.(type) {
+	case bson.M:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case bson.D:
+		for _, elem := range v {
+			keys = append(keys, elem.Key)
+		}
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "$") {
+			continue
+		}
+		if _, seen := w.stages[key]; !seen {
+			w.stages[key] = struct{}{}
+			w.summary.Stages = append(w.summary.Stages, key)
+		}
+	}
+}
+
+// SummarizeExplain extracts the winning plans, the stages, and the indexes used from an explain result
+func SummarizeExplain(explain interface{}) (ExplainSummary, error) {
+	w := explainWalker{
+		summary: ExplainSummary{Stages: []string{}, IndexesUsed: []string{}, WinningPlans: []json.RawMessage{}},
+		stages:  map[string]struct{}{},
+		indexes: map[string]struct{}{},
+	}
+	err := w.walk("", explain, false)
+	if err != nil {
+		return ExplainSummary{}, err
+	}
+	w.summary.CollectionScan = UsesCollectionScan(explain)
+	return w.summary, nil
+}
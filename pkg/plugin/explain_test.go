@@ -0,0 +1,56 @@
+package plugin_test
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SummarizeExplain", func() {
+	It("Should summarize an aggregation which uses an index", func() {
+		explain := bson.D{
+			{Key: "explainVersion", Value: "1"},
+			{Key: "stages", Value: bson.A{
+				bson.D{{Key: "$cursor", Value: bson.D{{Key: "queryPlanner", Value: bson.D{
+					{Key: "winningPlan", Value: bson.D{
+						{Key: "stage", Value: "FETCH"},
+						{Key: "inputStage", Value: bson.D{{Key: "stage", Value: "IXSCAN"}, {Key: "indexName", Value: "ts_1"}}},
+					}},
+					{Key: "rejectedPlans", Value: bson.A{
+						bson.D{{Key: "stage", Value: "IXSCAN"}, {Key: "indexName", Value: "host_1"}},
+					}},
+				}}}}},
+				bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$host"}}}},
+			}},
+		}
+		summary, err := plugin.SummarizeExplain(explain)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Stages).To(Equal([]string{"$cursor", "$group"}))
+		Expect(summary.IndexesUsed).To(Equal([]string{"ts_1"}))
+		Expect(summary.CollectionScan).To(BeFalse())
+		Expect(summary.WinningPlans).To(HaveLen(1))
+		Expect(string(summary.WinningPlans[0])).To(MatchJSON(`{"stage": "FETCH", "inputStage": {"stage": "IXSCAN", "indexName": "ts_1"}}`))
+	})
+
+	It("Should report collection scans of each shard", func() {
+		explain := bson.D{{Key: "queryPlanner", Value: bson.D{{Key: "winningPlan", Value: bson.D{
+			{Key: "stage", Value: "SHARD_MERGE"},
+			{Key: "shards", Value: bson.A{
+				bson.D{{Key: "shardName", Value: "a"}, {Key: "winningPlan", Value: bson.D{{Key: "stage", Value: "COLLSCAN"}}}},
+				bson.D{{Key: "shardName", Value: "b"}, {Key: "winningPlan", Value: bson.D{{Key: "stage", Value: "COLLSCAN"}}}},
+			}},
+		}}}}}
+		summary, err := plugin.SummarizeExplain(explain)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Stages).To(BeEmpty())
+		Expect(summary.CollectionScan).To(BeTrue())
+		bytes, err := json.Marshal(summary)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(bytes)).To(ContainSubstring(`"indexesUsed":[]`))
+	})
+})
@@ -0,0 +1,398 @@
+package plugin
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+
+	// defaultExportTimeRange is the time range exported if neither a relative nor an absolute range is given
+	defaultExportTimeRange = time.Hour
+	// exportFlushInterval is how many documents are written between flushes, so that the response is streamed in chunks
+	// rather than one message per document
+	exportFlushInterval = 1000
+)
+
+// exportRequest is the body of a request to /export
+type exportRequest struct {
+	// Query is the query JSON, as it appears in a panel
+	Query json.RawMessage `json:"query"`
+	// Format is either csv or ndjson
+	Format string `json:"format"`
+	// TimeRange is how far back from the current time the query should cover, e.g. "24h". It is ignored if From and To are set.
+	TimeRange string `json:"timeRange,omitempty"`
+	// From and To are an absolute time range, for reports covering a fixed period
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+	// Columns are the (possibly dotted) paths of the fields written to CSV. If empty, the top-level fields of the first document are used.
+	Columns []string `json:"columns,omitempty"`
+}
+
+func (r *exportRequest) timeRange(now time.Time) (from, to time.Time, err error) {
+	if r.From != nil || r.To != nil {
+		if r.From == nil || r.To == nil {
+			return from, to, fmt.Errorf("From and to must be set together")
+		}
+		if !r.From.Before(*r.To) {
+			return from, to, fmt.Errorf("From must be before to")
+		}
+		return *r.From, *r.To, nil
+	}
+	timeRange := defaultExportTimeRange
+	if r.TimeRange != "" {
+		timeRange, err = time.ParseDuration(r.TimeRange)
+		if err != nil || timeRange <= 0 {
+			return from, to, fmt.Errorf("Time range must be a positive duration")
+		}
+	}
+	return now.Add(-timeRange), now, nil
+}
+
+// documentWriter writes exported documents in a single format
+type documentWriter interface {
+	write(doc bson.D) error
+	flush() error
+}
+
+type ndjsonWriter struct {
+	w io.Writer
+}
+
+func (n *ndjsonWriter) write(doc bson.D) error {
+	line, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return err
+	}
+	_, err = n.w.Write(append(line, '\n'))
+	return err
+}
+
+func (n *ndjsonWriter) flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (c *csvWriter) write(doc bson.D) error {
+	if c.columns == nil {
+		// Without explicit columns, the first document decides them, and fields of later documents not in it are left out
+		c.columns = make([]string, len(doc))
+		for ix, elem := range doc {
+			c.columns[ix] = elem.Key
+		}
+		err := c.w.Write(c.columns)
+		if err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(c.columns))
+	for ix, column := range c.columns {
+		cell, err := FormatCSVCell(lookupPath(doc, column))
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Failed to format %s", column))
+		}
+		record[ix] = cell
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// lookupPath finds the value of a dotted path in a document, or nil if it is missing
+func lookupPath(doc bson.D, path string) interface{} {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		nested, ok := value.(bson.D)
+		if !ok {
+			return nil
+		}
+		value = nil
+		for _, elem := range nested {
+			if elem.Key == key {
+				value = elem.Value
+				break
+			}
+		}
+	}
+	return value
+}
+
+// FormatCSVCell formats a BSON value as a CSV cell. Missing and null values are empty, times are RFC 3339,
+// and documents and arrays are relaxed extended JSON
+func FormatCSVCell(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil, bsonPrim.Null, bsonPrim.Undefined:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bsonPrim.Decimal128:
+		return v.String(), nil
+	case bsonPrim.ObjectID:
+		return v.Hex(), nil
+	case bsonPrim.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), nil
+	default:
+		// Relaxed extended JSON can only be produced for documents, so other values are marshaled as a field and extracted
+		wrapped, err := bson.MarshalExtJSON(bson.D{bson.E{Key: "v", Value: v}}, false, false)
+		if err != nil {
+			return "", err
+		}
+		var unwrapped struct {
+			V json.RawMessage `json:"v"`
+		}
+		err = json.Unmarshal(wrapped, &unwrapped)
+		if err != nil {
+			return "", err
+		}
+		return string(unwrapped.V), nil
+	}
+}
+
+// exportPipeline produces the pipeline of an exported query, with macros expanded and ad-hoc filters applied,
+// capped to the maximum number of rows allowed by the datasource
+func exportPipeline(qm *QueryModel, mctx MacroContext, limits queryLimits) (mongo.Pipeline, error) {
+	switch qm.Command {
+	case "", commandAggregate, commandFind:
+	default:
+		return nil, fmt.Errorf("Only the %s and %s commands can be exported", commandAggregate, commandFind)
+	}
+	pipeline, err := qm.getPipeline(mctx)
+	if err != nil {
+		return nil, err
+	}
+	adhoc, err := AdhocFilterMatch(qm.AdhocFilters)
+	if err != nil {
+		return nil, err
+	}
+	if adhoc != nil {
+		pipeline = append(mongo.Pipeline{bson.D{bson.E{Key: "$match", Value: adhoc}}}, pipeline...)
+	}
+	if limits.maxRows > 0 {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$limit", Value: limits.maxRows}})
+	}
+	return pipeline, nil
+}
+
+// export is a validated exportRequest
+type export struct {
+	qm       QueryModel
+	pipeline mongo.Pipeline
+}
+
+// prepare validates a request and builds the pipeline of the exported query, subject to the limits and time range guard of the datasource
+func (r *exportRequest) prepare(settings *datasource, now time.Time) (export, error) {
+	if len(r.Query) == 0 {
+		return export{}, fmt.Errorf("A query is required")
+	}
+	if r.Format != exportFormatCSV && r.Format != exportFormatNDJSON {
+		return export{}, fmt.Errorf("Format must be one of: %s, %s", exportFormatCSV, exportFormatNDJSON)
+	}
+	from, to, err := r.timeRange(now)
+	if err != nil {
+		return export{}, err
+	}
+	var header struct {
+		IntervalMS    int64 `json:"intervalMs"`
+		MaxDataPoints int64 `json:"maxDataPoints"`
+	}
+	err = json.Unmarshal(r.Query, &header)
+	if err != nil {
+		return export{}, errors.Wrap(err, "Invalid query")
+	}
+	e := export{}
+	e.qm, err = parseQueryModel(r.Query)
+	if err != nil {
+		return export{}, err
+	}
+	limits, err := e.qm.getQueryLimits(settings)
+	if err != nil {
+		return export{}, err
+	}
+	e.pipeline, err = exportPipeline(&e.qm, MacroContext{
+		From:          from,
+		To:            to,
+		Interval:      time.Duration(header.IntervalMS) * time.Millisecond,
+		MaxDataPoints: header.MaxDataPoints,
+		Snippets:      settings.Snippets,
+	}, limits)
+	if err != nil {
+		return export{}, err
+	}
+	err = settings.checkTimeGuard(&e.qm, e.pipeline)
+	if err != nil {
+		return export{}, err
+	}
+	return e, nil
+}
+
+// open runs the exported query, returning its cursor, and a function to close it and disconnect
+func (e *export) open(ctx context.Context, pCtx backend.PluginContext, origin requestOrigin) (context.Context, *mongo.Cursor, func(), error) {
+	hint, err := e.qm.getHint()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, client, limits, done, err := simpleCommandClient(ctx, pCtx, origin, &e.qm, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	opts := mongoOpts.Aggregate().SetComment(newQueryComment())
+	if limits.timeout > 0 {
+		opts.SetMaxTime(limits.timeout)
+	}
+	if hint != nil {
+		opts.SetHint(hint)
+	}
+	cursor, err := client.Database(e.qm.Database).Collection(e.qm.Collection).Aggregate(ctx, e.pipeline, opts)
+	if err != nil {
+		done()
+		return nil, nil, nil, errors.Wrap(err, "Failed to send query to mongo")
+	}
+	return ctx, cursor, func() {
+		cursor.Close(ctx)
+		done()
+	}, nil
+}
+
+// newDocumentWriter creates the writer for the format of an export
+func (r *exportRequest) newDocumentWriter(w io.Writer) documentWriter {
+	if r.Format == exportFormatCSV {
+		return &csvWriter{w: csv.NewWriter(w), columns: r.Columns}
+	}
+	return &ndjsonWriter{w: w}
+}
+
+// contentType is the media type of the format of an export
+func (r *exportRequest) contentType() string {
+	if r.Format == exportFormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// handleExport runs a query and streams the resulting documents as CSV or newline-delimited relaxed extended JSON,
+// so that reporting tools can pull large results through the datasource, with its credentials, read preference, limits, and
+// access control, instead of connecting to MongoDB directly:
+//
+//	POST /export {"query": {...}, "format": "csv", "from": "2022-01-01T00:00:00Z", "to": "2022-01-02T00:00:00Z"}
+//
+// Documents are exported as returned by MongoDB, before they are converted to frames. Documents are only read from the cursor
+// as fast as they are written, so a slow client slows down the query rather than the plugin buffering the result.
+// Once streaming starts, the status can no longer change, so a failure part way through is reported by ending the response early.
+func (d *MongoDBDatasource) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	ctx := r.Context()
+	pCtx := httpadapter.PluginConfigFromContext(ctx)
+
+	var req exportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	origin := requestOrigin{app: requestAppExplore, orgID: pCtx.OrgID}
+	settings = settings.forReadIntent(origin.readIntent())
+	e, err := req.prepare(&settings, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cursor, done, err := e.open(ctx, pCtx, origin)
+	if err != nil {
+		writeMongoError(w, err)
+		return
+	}
+	defer done()
+
+	w.Header().Set("Content-Type", req.contentType())
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	count, err := exportCursor(ctx, cursor, req.newDocumentWriter(w), func() error {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Warn("Export failed part way through", "documents", count, "error", err)
+		return
+	}
+	log.DefaultLogger.Info("Exported query", "documents", count, "format", req.Format)
+}
+
+// exportCursor writes every document of a cursor, flushing the writer, then flush, periodically and at the end
+func exportCursor(ctx context.Context, cursor *mongo.Cursor, writer documentWriter, flush func() error) (int, error) {
+	flushAll := func() error {
+		err := writer.flush()
+		if err != nil {
+			return err
+		}
+		return flush()
+	}
+	count := 0
+	for cursor.Next(ctx) {
+		var doc bson.D
+		err := cursor.Decode(&doc)
+		if err != nil {
+			return count, err
+		}
+		err = writer.write(doc)
+		if err != nil {
+			return count, err
+		}
+		count++
+		if count%exportFlushInterval == 0 {
+			err = flushAll()
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+	if cursor.Err() != nil {
+		return count, cursor.Err()
+	}
+	return count, flushAll()
+}
@@ -0,0 +1,33 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FormatCSVCell", func() {
+	oid, _ := bsonPrim.ObjectIDFromHex("5f1d7b2e9c3a4b0012345678")
+	decimal, _ := bsonPrim.ParseDecimal128("1.50")
+
+	DescribeTable("Should format", func(value interface{}, expected string) {
+		Expect(plugin.FormatCSVCell(value)).To(Equal(expected))
+	},
+		Entry("missing values as empty", nil, ""),
+		Entry("nulls as empty", bsonPrim.Null{}, ""),
+		Entry("strings as themselves", `a,"b"`, `a,"b"`),
+		Entry("booleans", true, "true"),
+		Entry("integers", int64(-42), "-42"),
+		Entry("floats without trailing zeros", 2.5, "2.5"),
+		Entry("decimals exactly", decimal, "1.50"),
+		Entry("object IDs as hex", oid, "5f1d7b2e9c3a4b0012345678"),
+		Entry("dates as RFC 3339", bsonPrim.NewDateTimeFromTime(time.Unix(90, 0)), "1970-01-01T00:01:30Z"),
+		Entry("documents as relaxed extended JSON", bson.D{{Key: "a", Value: int32(1)}, {Key: "id", Value: oid}}, `{"a":1,"id":{"$oid":"5f1d7b2e9c3a4b0012345678"}}`),
+		Entry("arrays as relaxed extended JSON", bson.A{"x", int32(2)}, `["x",2]`),
+	)
+})
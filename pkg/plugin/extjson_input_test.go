@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extended JSON input flavors", func() {
+	build := func(flavor, aggregation string) (bson.D, error) {
+		query, err := json.Marshal(map[string]interface{}{"version": 2, "extJSONInputFlavor": flavor, "aggregation": aggregation})
+		Expect(err).ToNot(HaveOccurred())
+		pipeline, err := buildPipeline(query, MacroContext{})
+		if err != nil {
+			return nil, err
+		}
+		return pipeline[0], nil
+	}
+
+	It("Should parse canonical extended JSON", func() {
+		stage, err := build("canonical", `[{"$limit": {"$numberLong": "5"}}]`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stage).To(Equal(bson.D{{Key: "$limit", Value: int64(5)}}))
+	})
+
+	It("Should reject relaxed dates and shell syntax when canonical", func() {
+		_, err := build("canonical", `[{"$match": {"ts": {"$date": "2020-01-01T00:00:00Z"}}}]`)
+		Expect(err).To(HaveOccurred())
+		_, err = build("canonical", `[{$limit: NumberLong(5)}]`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should fall back to shell syntax when relaxed", func() {
+		stage, err := build("relaxed", `[{$limit: NumberLong(5)}, // comment
+		]`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stage).To(Equal(bson.D{{Key: "$limit", Value: int64(5)}}))
+	})
+
+	It("Should reject unknown flavors", func() {
+		_, err := build("strict", `[]`)
+		Expect(err).To(HaveOccurred())
+	})
+})
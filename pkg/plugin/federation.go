@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	topologyDataFederation = "dataFederation"
+
+	// defaultFederatedResourceTimeout replaces defaultResourceTimeout for Atlas Data Federation endpoints,
+	// as even listing collections may read from object storage
+	defaultFederatedResourceTimeout = 2 * time.Minute
+
+	// federatedWildcardCollection is the name of a collection in a storage configuration which is generated
+	// from the paths of the files in a store, and so cannot be listed without asking the endpoint
+	federatedWildcardCollection = "*"
+)
+
+// federationIndexAdviceNotice replaces index advice for Atlas Data Federation, which scans the files of its stores rather than indexes
+var federationIndexAdviceNotice = data.Notice{
+	Severity: data.NoticeSeverityInfo,
+	Text:     "Index advice is not available for Atlas Data Federation, which does not use indexes",
+}
+
+// federationUnsupportedStages are read-only stages which Atlas Data Federation rejects, as they depend on indexes or Atlas Search
+var federationUnsupportedStages = map[string]struct{}{
+	"$geoNear": {}, "$indexStats": {}, "$search": {}, "$searchMeta": {},
+}
+
+// ValidateFederatedPipelineStages returns a description of every stage of a pipeline, including those of its sub-pipelines,
+// which is not supported by Atlas Data Federation
+func ValidateFederatedPipelineStages(pipeline mongo.Pipeline) []string {
+	return checkStages(pipeline, func(name string) string {
+		if _, ok := federationUnsupportedStages[name]; ok {
+			return "is not supported by Atlas Data Federation"
+		}
+		return ""
+	})
+}
+
+// getResourceTimeout returns the timeout of requests to MongoDB made by resource routes when no maximum query timeout is configured
+func (d *datasource) getResourceTimeout() time.Duration {
+	if d.DataFederation {
+		return defaultFederatedResourceTimeout
+	}
+	return defaultResourceTimeout
+}
+
+// storageConfig is the reply to the storageGetConfig command of Atlas Data Federation
+type storageConfig struct {
+	Storage struct {
+		Databases []struct {
+			Name        string `bson:"name"`
+			Collections []struct {
+				Name string `bson:"name"`
+			} `bson:"collections"`
+			Views []struct {
+				Name string `bson:"name"`
+			} `bson:"views"`
+		} `bson:"databases"`
+	} `bson:"storage"`
+}
+
+// FederatedCollections returns the sorted names of the collections and views of a database in the reply to storageGetConfig,
+// and whether the database also has wildcard collections, whose names must be listed from the endpoint
+func FederatedCollections(reply bson.Raw, database string) ([]string, bool, error) {
+	config := storageConfig{}
+	err := bson.Unmarshal(reply, &config)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Failed to decode storage configuration")
+	}
+	names := []string{}
+	wildcard := false
+	for _, db := range config.Storage.Databases {
+		if db.Name != database {
+			continue
+		}
+		for _, collection := range db.Collections {
+			if collection.Name == federatedWildcardCollection {
+				wildcard = true
+				continue
+			}
+			names = append(names, collection.Name)
+		}
+		for _, view := range db.Views {
+			names = append(names, view.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, wildcard, nil
+}
+
+// listFederatedCollections lists the collections of a database of an Atlas Data Federation endpoint from its storage configuration,
+// which includes collections backed by object storage and online archives. Collections are also listed from the endpoint
+// if the configuration has wildcard collections, or cannot be read, as storageGetConfig requires an additional privilege.
+func listFederatedCollections(ctx context.Context, client *mongo.Client, database string) ([]string, error) {
+	var names []string
+	wildcard := true
+	reply, err := client.Database("admin").RunCommand(ctx, bson.D{bson.E{Key: "storageGetConfig", Value: 1}}).DecodeBytes()
+	if err == nil {
+		names, wildcard, err = FederatedCollections(reply, database)
+	}
+	if err != nil {
+		log.DefaultLogger.Warn("Could not read storage configuration, listing collections instead", "error", err)
+	}
+	if !wildcard {
+		return names, nil
+	}
+	// Data Federation does not accept the authorizedCollections option
+	listed, err := client.Database(database).ListCollectionNames(ctx, bson.D{}, mongoOpts.ListCollections().SetNameOnly(true))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list collections")
+	}
+	seen := map[string]struct{}{}
+	for _, name := range names {
+		seen[name] = struct{}{}
+	}
+	for _, name := range listed {
+		if _, ok := seen[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// checkFederation pings an Atlas Data Federation endpoint, and checks that the configured user can list the collections
+// of the default database. Federation endpoints are not replica sets, so the topology is not checked.
+func checkFederation(ctx context.Context, client *mongo.Client, uri string) (healthDetails, error) {
+	details := healthDetails{Topology: topologyDataFederation}
+	start := time.Now()
+	err := client.Ping(ctx, nil)
+	if err != nil {
+		return details, errors.Wrap(err, "Ping failed")
+	}
+	details.Latency = time.Since(start)
+
+	version, err := getServerVersion(ctx, client)
+	if err != nil {
+		return details, err
+	}
+	details.Version = version.String()
+
+	details.Database = defaultDatabase(uri)
+	if details.Database != "" {
+		_, err := listFederatedCollections(ctx, client, details.Database)
+		canList := err == nil
+		details.CanListCollections = &canList
+		if err != nil {
+			details.ListCollectionsError = err.Error()
+		}
+	}
+	return details, nil
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FederatedCollections", func() {
+	reply, err := bson.Marshal(bson.M{
+		"ok": 1,
+		"storage": bson.M{
+			"stores": bson.A{bson.M{"name": "archive", "provider": "s3"}},
+			"databases": bson.A{
+				bson.M{
+					"name": "metrics",
+					"collections": bson.A{
+						bson.M{"name": "requests", "dataSources": bson.A{bson.M{"storeName": "archive", "path": "/requests/*"}}},
+						bson.M{"name": "errors"},
+					},
+					"views": bson.A{bson.M{"name": "slow", "source": "requests"}},
+				},
+				bson.M{
+					"name":        "logs",
+					"collections": bson.A{bson.M{"name": "*", "dataSources": bson.A{bson.M{"storeName": "archive", "path": "/logs/{collectionName()}/*"}}}},
+				},
+			},
+		},
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	It("Should list the collections and views of a database", func() {
+		names, wildcard, err := FederatedCollections(reply, "metrics")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names).To(Equal([]string{"errors", "requests", "slow"}))
+		Expect(wildcard).To(BeFalse())
+	})
+
+	It("Should report wildcard collections", func() {
+		names, wildcard, err := FederatedCollections(reply, "logs")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names).To(BeEmpty())
+		Expect(wildcard).To(BeTrue())
+	})
+
+	It("Should list nothing for an unknown database", func() {
+		names, wildcard, err := FederatedCollections(reply, "nope")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names).To(BeEmpty())
+		Expect(wildcard).To(BeFalse())
+	})
+})
+
+var _ = Describe("ValidateFederatedPipelineStages", func() {
+	DescribeTable("Should report", func(stages string, count int) {
+		query, err := json.Marshal(map[string]interface{}{"aggregation": stages})
+		Expect(err).ToNot(HaveOccurred())
+		pipeline, err := buildPipeline(query, MacroContext{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ValidateFederatedPipelineStages(pipeline)).To(HaveLen(count))
+	},
+		Entry("nothing for supported stages", `[{"$match": {"a": 1}}, {"$group": {"_id": "$b"}}]`, 0),
+		Entry("stages which need indexes", `[{"$indexStats": {}}]`, 1),
+		Entry("search stages nested in unions", `[{"$unionWith": {"coll": "b", "pipeline": [{"$search": {}}]}}]`, 1),
+	)
+})
@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultFieldsSample   = 100
+	maxFieldsSample       = 10000
+	maxFieldsNestingDepth = 8
+)
+
+// bsonTypeAliases are the names of BSON types used by the $type query operator, which users will recognize from MongoDB
+var bsonTypeAliases = map[bsontype.Type]string{
+	bsontype.Double:           "double",
+	bsontype.String:           "string",
+	bsontype.EmbeddedDocument: "object",
+	bsontype.Array:            "array",
+	bsontype.Binary:           "binData",
+	bsontype.Undefined:        "undefined",
+	bsontype.ObjectID:         "objectId",
+	bsontype.Boolean:          "bool",
+	bsontype.DateTime:         "date",
+	bsontype.Null:             "null",
+	bsontype.Regex:            "regex",
+	bsontype.DBPointer:        "dbPointer",
+	bsontype.JavaScript:       "javascript",
+	bsontype.Symbol:           "symbol",
+	bsontype.CodeWithScope:    "javascriptWithScope",
+	bsontype.Int32:            "int",
+	bsontype.Timestamp:        "timestamp",
+	bsontype.Int64:            "long",
+	bsontype.Decimal128:       "decimal",
+	bsontype.MinKey:           "minKey",
+	bsontype.MaxKey:           "maxKey",
+}
+
+// InferredField is a field seen in sampled documents
+type InferredField struct {
+	// Path is the dotted path of the field. Fields of documents within arrays have the path of the array, as in MongoDB queries.
+	Path string `json:"path"`
+	// Types are the BSON types the field was seen with, by their $type alias
+	Types []string `json:"types"`
+	// Count is the number of sampled documents with the field
+	Count int `json:"count"`
+}
+
+// fieldInference accumulates the fields of sampled documents
+type fieldInference struct {
+	types  map[string]map[string]struct{}
+	counts map[string]int
+}
+
+func (f *fieldInference) visit(prefix string, doc bson.Raw, depth int, seen map[string]struct{}) error {
+	elems, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	for _, elem := range elems {
+		f.visitValue(prefix+elem.Key(), elem.Value(), depth, seen)
+	}
+	return nil
+}
+
+func (f *fieldInference) visitValue(path string, value bson.RawValue, depth int, seen map[string]struct{}) {
+	alias, ok := bsonTypeAliases[value.Type]
+	if !ok {
+		alias = value.Type.String()
+	}
+	if f.types[path] == nil {
+		f.types[path] = map[string]struct{}{}
+	}
+	f.types[path][alias] = struct{}{}
+	if _, ok := seen[path]; !ok {
+		seen[path] = struct{}{}
+		f.counts[path]++
+	}
+	if depth >= maxFieldsNestingDepth {
+		return
+	}
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		// Malformed nested documents are reported by their type alone
+		_ = f.visit(path+".", value.Document(), depth+1, seen)
+	case bsontype.Array:
+		items, err := value.Array().Values()
+		if err != nil {
+			return
+		}
+		for _, item := range items {
+			if item.Type == bsontype.EmbeddedDocument {
+				_ = f.visit(path+".", item.Document(), depth+1, seen)
+			}
+		}
+	}
+}
+
+// InferFields describes the fields of sampled documents, including nested fields, sorted by path
+func InferFields(docs []bson.Raw) ([]InferredField, error) {
+	inference := fieldInference{types: map[string]map[string]struct{}{}, counts: map[string]int{}}
+	for ix, doc := range docs {
+		err := inference.visit("", doc, 1, map[string]struct{}{})
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid document number %d", ix+1))
+		}
+	}
+	fields := make([]InferredField, 0, len(inference.types))
+	for path, seen := range inference.types {
+		field := InferredField{Path: path, Types: make([]string, 0, len(seen)), Count: inference.counts[path]}
+		for alias := range seen {
+			field.Types = append(field.Types, alias)
+		}
+		sort.Strings(field.Types)
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return fields, nil
+}
+
+type fieldsResponse struct {
+	Fields []InferredField `json:"fields"`
+	// Sampled is the number of documents the fields were inferred from
+	Sampled int `json:"sampled"`
+}
+
+// handleFields infers the fields of a collection from a random sample of its documents, for autocompletion of field paths:
+//
+//	GET /fields?database=...&collection=...&sample=100
+func (d *MongoDBDatasource) handleFields(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only GET is supported"))
+		return
+	}
+	database := r.URL.Query().Get("database")
+	collection := r.URL.Query().Get("collection")
+	if database == "" || collection == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("The database and collection query parameters are required"))
+		return
+	}
+	sample, err := queryIntParam(r, "sample", defaultFieldsSample, maxFieldsSample)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, client, _, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	pipeline := mongo.Pipeline{bson.D{bson.E{Key: "$sample", Value: bson.D{bson.E{Key: "size", Value: sample}}}}}
+	cursor, err := client.Database(database).Collection(collection).Aggregate(ctx, pipeline, mongoOpts.Aggregate().SetComment(newQueryComment()))
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to sample documents"))
+		return
+	}
+	defer cursor.Close(ctx)
+	docs := []bson.Raw{}
+	for cursor.Next(ctx) {
+		// Current is only valid until the next call to Next
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if cursor.Err() != nil {
+		writeMongoError(w, errors.Wrap(cursor.Err(), "Failed to sample documents"))
+		return
+	}
+	fields, err := InferFields(docs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fieldsResponse{Fields: fields, Sampled: len(docs)})
+}
@@ -0,0 +1,48 @@
+package plugin_test
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InferFields", func() {
+	raw := func(doc bson.D) bson.Raw {
+		bytes, err := bson.Marshal(doc)
+		Expect(err).ToNot(HaveOccurred())
+		return bytes
+	}
+
+	It("Should report nested fields with their BSON types and counts", func() {
+		fields, err := plugin.InferFields([]bson.Raw{
+			raw(bson.D{
+				{Key: "ts", Value: time.Unix(0, 0)},
+				{Key: "code", Value: int32(200)},
+				{Key: "meta", Value: bson.D{{Key: "region", Value: "us"}}},
+				{Key: "items", Value: bson.A{bson.D{{Key: "sku", Value: "a"}}, bson.D{{Key: "sku", Value: int64(1)}}}},
+			}),
+			raw(bson.D{
+				{Key: "code", Value: "ok"},
+				{Key: "meta", Value: nil},
+			}),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(Equal([]plugin.InferredField{
+			{Path: "code", Types: []string{"int", "string"}, Count: 2},
+			{Path: "items", Types: []string{"array"}, Count: 1},
+			{Path: "items.sku", Types: []string{"long", "string"}, Count: 1},
+			{Path: "meta", Types: []string{"null", "object"}, Count: 2},
+			{Path: "meta.region", Types: []string{"string"}, Count: 1},
+			{Path: "ts", Types: []string{"date"}, Count: 1},
+		}))
+	})
+
+	It("Should return nothing without documents", func() {
+		Expect(plugin.InferFields(nil)).To(BeEmpty())
+	})
+})
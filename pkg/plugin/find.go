@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// commandAggregate runs the aggregation pipeline of a query. This is the default.
+	commandAggregate = "aggregate"
+	// commandFind runs a find with the filter, projection, sort, skip, and limit of a query, instead of a pipeline
+	commandFind = "find"
+)
+
+// findQuery is the parsed form of a query using the find command
+type findQuery struct {
+	filter     bson.D
+	projection bson.D
+	sort       bson.D
+	skip       int64
+	limit      int64
+}
+
+// parseFindDocument expands macros in, and parses, one of the JSON documents of a find query. Empty text, or only comments,
+// is an empty document.
+func parseFindDocument(name, text string, canonical bool, mctx MacroContext) (bson.D, error) {
+	doc := bson.D{}
+	text = NormalizeJSON5(text)
+	if strings.TrimSpace(text) == "" {
+		return doc, nil
+	}
+	expanded, err := ExpandMacros(text, mctx)
+	if err != nil {
+		return nil, err
+	}
+	err = unmarshalQueryText(expanded, canonical, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to parse %s", name))
+	}
+	return doc, nil
+}
+
+// getFind produces the find equivalent of getPipeline. The automatic time bound is combined with the filter,
+// the automatic time sort is only used if no sort is given, and the column whitelist is only used if no projection is given.
+func (m *QueryModel) getFind(mctx MacroContext) (*findQuery, error) {
+	err := m.requireCurrentModel("The find command")
+	if err != nil {
+		return nil, err
+	}
+	if m.Skip < 0 {
+		return nil, fmt.Errorf("Skip must not be negative")
+	}
+	if m.Limit < 0 {
+		return nil, fmt.Errorf("Limit must not be negative")
+	}
+	find := &findQuery{skip: m.Skip, limit: m.Limit}
+	find.filter, err = parseFindDocument("filter", m.Filter, m.canonicalInput(), mctx)
+	if err != nil {
+		return nil, err
+	}
+	find.projection, err = parseFindDocument("projection", m.Projection, m.canonicalInput(), mctx)
+	if err != nil {
+		return nil, err
+	}
+	find.sort, err = parseFindDocument("sort", m.Sort, m.canonicalInput(), mctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.QueryType == queryTypeTimeseries && m.AutoTimeBound {
+		timeBoundStage, err := m.getTimeBoundPipelineStage(mctx.From, mctx.To)
+		if err != nil {
+			return nil, err
+		}
+		find.and(timeBoundStage[0].Value.(bson.D))
+	}
+	if m.QueryType == queryTypeTimeseries && m.AutoTimeSort && len(find.sort) == 0 {
+		find.sort = bson.D{bson.E{Key: m.TimestampField, Value: 1}}
+	}
+	if len(m.Columns) != 0 && len(find.projection) == 0 {
+		find.projection = m.getProjectionPipelineStage()[0].Value.(bson.D)
+	}
+	return find, nil
+}
+
+// and restricts the filter to documents which also match another filter
+func (f *findQuery) and(filter bson.D) {
+	if len(f.filter) == 0 {
+		f.filter = filter
+		return
+	}
+	f.filter = bson.D{bson.E{Key: "$and", Value: bson.A{f.filter, filter}}}
+}
+
+// capLimit reduces the limit to at most max documents
+func (f *findQuery) capLimit(max int64) {
+	if f.limit == 0 || f.limit > max {
+		f.limit = max
+	}
+}
+
+// pipeline produces the aggregation pipeline which returns the same documents as the find,
+// so that features which inspect or execute pipelines also apply to find queries
+func (f *findQuery) pipeline() mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if len(f.filter) != 0 {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$match", Value: f.filter}})
+	}
+	if len(f.sort) != 0 {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$sort", Value: f.sort}})
+	}
+	if f.skip != 0 {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$skip", Value: f.skip}})
+	}
+	if f.limit != 0 {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$limit", Value: f.limit}})
+	}
+	if len(f.projection) != 0 {
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$project", Value: f.projection}})
+	}
+	return pipeline
+}
+
+// options produces the options of the find, carrying over the comment, time limit, collation, and hint of the aggregate it replaces
+func (f *findQuery) options(aggregateOpts *mongoOpts.AggregateOptions) *mongoOpts.FindOptions {
+	opts := mongoOpts.Find()
+	if len(f.projection) != 0 {
+		opts.SetProjection(f.projection)
+	}
+	if len(f.sort) != 0 {
+		opts.SetSort(f.sort)
+	}
+	if f.skip != 0 {
+		opts.SetSkip(f.skip)
+	}
+	if f.limit != 0 {
+		opts.SetLimit(f.limit)
+	}
+	if aggregateOpts.Comment != nil {
+		opts.SetComment(*aggregateOpts.Comment)
+	}
+	if aggregateOpts.MaxTime != nil {
+		opts.SetMaxTime(*aggregateOpts.MaxTime)
+	}
+	if aggregateOpts.Collation != nil {
+		opts.SetCollation(aggregateOpts.Collation)
+	}
+	if aggregateOpts.Hint != nil {
+		opts.SetHint(aggregateOpts.Hint)
+	}
+	return opts
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Find queries", func() {
+	mctx := MacroContext{From: time.Unix(0, 0).UTC(), To: time.Unix(3600, 0).UTC()}
+
+	pipelineJSON := func(query string) (string, error) {
+		pipeline, err := buildPipeline([]byte(query), mctx)
+		if err != nil {
+			return "", err
+		}
+		bytes, err := bson.MarshalExtJSON(bson.D{{Key: "pipeline", Value: pipeline}}, false, false)
+		return string(bytes), err
+	}
+
+	It("Should produce the equivalent pipeline", func() {
+		pipeline, err := pipelineJSON(`{"version":2,"command":"find","filter":"{\"level\":\"error\"}","projection":"{\"_id\":0}","sort":"{\"level\":-1}","skip":5,"limit":10}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pipeline).To(MatchJSON(`{"pipeline": [
+			{"$match": {"level": "error"}},
+			{"$sort": {"level": -1}},
+			{"$skip": 5},
+			{"$limit": 10},
+			{"$project": {"_id": 0}}
+		]}`))
+	})
+
+	It("Should combine the automatic time bound with the filter", func() {
+		pipeline, err := pipelineJSON(`{"version":2,"command":"find","queryType":"Timeseries","timestampField":"ts","autoTimeBound":true,"autoTimeSort":true,"filter":"{\"host\":\"a\"}"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pipeline).To(MatchJSON(`{"pipeline": [
+			{"$match": {"$and": [
+				{"host": "a"},
+				{"ts": {"$gte": {"$date": "1970-01-01T00:00:00Z"}, "$lte": {"$date": "1970-01-01T01:00:00Z"}}}
+			]}},
+			{"$sort": {"ts": 1}}
+		]}`))
+	})
+
+	It("Should reject negative limits", func() {
+		_, err := pipelineJSON(`{"version":2,"command":"find","limit":-1}`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should not be available to legacy queries", func() {
+		_, err := pipelineJSON(`{"version":1,"command":"find","filter":"{}"}`)
+		Expect(err).To(MatchError(ContainSubstring("/migrate-query")))
+	})
+
+	It("Should reject unknown commands", func() {
+		_, err := pipelineJSON(`{"version":2,"command":"mapReduce"}`)
+		Expect(err).To(HaveOccurred())
+	})
+})
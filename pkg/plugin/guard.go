@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// isLargeCollection returns true if an admin has marked a collection as large, either as database.collection, or by name in any database
+func (d *datasource) isLargeCollection(database, collection string) bool {
+	for _, large := range d.LargeCollections {
+		if large == collection || large == database+"."+collection {
+			return true
+		}
+	}
+	return false
+}
+
+// HasLeadingTimeFilter returns true if the $match stages at the start of a pipeline, before any other stage,
+// filter on the timestamp field, or compare any field to a date. Only these stages can limit how much of a collection is read.
+func HasLeadingTimeFilter(pipeline mongo.Pipeline, timestampField string) bool {
+	filtered := false
+	for _, stage := range pipeline {
+		if !hasStage(mongo.Pipeline{stage}, "$match") {
+			break
+		}
+		walkFilter(stage[0].Value, "", func(field, key string, value interface{}) {
+			if _, ok := value.(bsonPrim.DateTime); ok {
+				filtered = true
+			}
+			if timestampField != "" && (field == timestampField || value == "$"+timestampField) {
+				filtered = true
+			}
+		})
+	}
+	return filtered
+}
+
+// checkTimeGuard rejects pipelines which would read a large collection without filtering it by time
+func (d *datasource) checkTimeGuard(qm *QueryModel, pipeline mongo.Pipeline) error {
+	if !d.isLargeCollection(qm.Database, qm.Collection) || HasLeadingTimeFilter(pipeline, qm.TimestampField) {
+		return nil
+	}
+	hints := []string{"add a $match using $__timeFilter(field) as the first stage"}
+	if qm.Command == "" || qm.Command == commandAggregate {
+		hints = append(hints, "enable Auto Time Bound at the start of the pipeline")
+	} else {
+		hints = append(hints, "enable Auto Time Bound")
+	}
+	return fmt.Errorf(
+		"The collection %s.%s is marked as large for this datasource, so queries must filter it by time before any other stage. To fix this, %s",
+		qm.Database, qm.Collection, strings.Join(hints, ", or "),
+	)
+}
+
+// checkFilterTimeGuard rejects filters of commands which would read a large collection without filtering it by time
+func (d *datasource) checkFilterTimeGuard(qm *QueryModel, filter bson.D) error {
+	return d.checkTimeGuard(qm, mongo.Pipeline{bson.D{bson.E{Key: "$match", Value: filter}}})
+}
@@ -0,0 +1,26 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HasLeadingTimeFilter", func() {
+	DescribeTable("Should detect", func(text, timestampField string, expected bool) {
+		pipeline := mongo.Pipeline{}
+		Expect(bson.UnmarshalExtJSON([]byte(text), false, &pipeline)).To(Succeed())
+		Expect(plugin.HasLeadingTimeFilter(pipeline, timestampField)).To(Equal(expected))
+	},
+		Entry("a match on the timestamp field", `[{"$match": {"ts": {"$gte": 0}}}]`, "ts", true),
+		Entry("a match on any field against a date", `[{"$match": {"host": "a"}}, {"$match": {"created": {"$gte": {"$date": "2022-01-01T00:00:00Z"}}}}]`, "", true),
+		Entry("a date in an expression", `[{"$match": {"$expr": {"$lt": ["$a", {"$date": "2022-01-01T00:00:00Z"}]}}}]`, "", true),
+		Entry("no time filter", `[{"$match": {"host": "a"}}]`, "ts", false),
+		Entry("a time filter after another stage", `[{"$project": {"ts": 1}}, {"$match": {"ts": {"$gte": 0}}}]`, "ts", false),
+		Entry("an empty pipeline", `[]`, "ts", false),
+	)
+})
@@ -0,0 +1,141 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	topologyStandalone = "standalone"
+	topologyReplicaSet = "replicaSet"
+	topologySharded    = "sharded"
+)
+
+// healthDetails describes the server found by a health check
+type healthDetails struct {
+	Version string `json:"version"`
+	// Topology is standalone, replicaSet, sharded, or dataFederation
+	Topology   string `json:"topology"`
+	ReplicaSet string `json:"replicaSet,omitempty"`
+	// Latency is the round trip time of a ping
+	Latency time.Duration `json:"latencyNs"`
+	// Database is the default database of the connection string, if any
+	Database string `json:"database,omitempty"`
+	// CanListCollections is whether the configured user may list the collections of Database
+	CanListCollections   *bool  `json:"canListCollections,omitempty"`
+	ListCollectionsError string `json:"listCollectionsError,omitempty"`
+}
+
+// HealthMessage summarizes the details of a health check in a sentence
+func (h *healthDetails) HealthMessage() string {
+	topology := "a standalone server"
+	switch h.Topology {
+	case topologyReplicaSet:
+		topology = fmt.Sprintf("replica set %s", h.ReplicaSet)
+	case topologySharded:
+		topology = "a sharded cluster"
+	case topologyDataFederation:
+		topology = "an Atlas Data Federation endpoint"
+	}
+	message := fmt.Sprintf("MongoDB %s is responding from %s in %s", h.Version, topology, h.Latency.Round(time.Millisecond))
+	switch {
+	case h.CanListCollections == nil:
+		message += ". No default database is configured, so permissions were not checked"
+	case *h.CanListCollections:
+		message += fmt.Sprintf(". The user can list the collections of %s", h.Database)
+	default:
+		message += fmt.Sprintf(". The user cannot list the collections of %s: %s", h.Database, h.ListCollectionsError)
+	}
+	return message
+}
+
+// DescribeTopology determines the topology of a deployment from the reply to a hello or isMaster command
+func DescribeTopology(hello bson.M) (topology, replicaSet string) {
+	if msg, _ := hello["msg"].(string); msg == "isdbgrid" {
+		return topologySharded, ""
+	}
+	if name, _ := hello["setName"].(string); name != "" {
+		return topologyReplicaSet, name
+	}
+	return topologyStandalone, ""
+}
+
+// defaultDatabase returns the database named in the path of a connection string, if any
+func defaultDatabase(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(parsed.Path, "/")
+}
+
+// checkServer pings a server, and describes its version and topology, and the permissions of the configured user
+func checkServer(ctx context.Context, client *mongo.Client, uri string) (healthDetails, error) {
+	details := healthDetails{}
+	start := time.Now()
+	err := client.Ping(ctx, nil)
+	if err != nil {
+		return details, errors.Wrap(err, "Ping failed")
+	}
+	details.Latency = time.Since(start)
+
+	version, err := getServerVersion(ctx, client)
+	if err != nil {
+		return details, err
+	}
+	details.Version = version.String()
+
+	// hello replaced isMaster in MongoDB 4.4.2
+	hello := bson.M{}
+	admin := client.Database("admin")
+	err = admin.RunCommand(ctx, bson.D{bson.E{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		err = admin.RunCommand(ctx, bson.D{bson.E{Key: "isMaster", Value: 1}}).Decode(&hello)
+	}
+	if err != nil {
+		return details, errors.Wrap(err, "Failed to determine topology")
+	}
+	details.Topology, details.ReplicaSet = DescribeTopology(hello)
+
+	details.Database = defaultDatabase(uri)
+	if details.Database != "" {
+		// The same options as the editor uses to list collections
+		opts := mongoOpts.ListCollections().SetNameOnly(true).SetAuthorizedCollections(true)
+		_, err = client.Database(details.Database).ListCollectionNames(ctx, bson.D{}, opts)
+		canList := err == nil
+		details.CanListCollections = &canList
+		if err != nil {
+			details.ListCollectionsError = err.Error()
+		}
+	}
+	return details, nil
+}
+
+// healthResult reports the outcome of a health check. Errors are reported as they are, as each describes the step which failed.
+func healthResult(details healthDetails, err error) *backend.CheckHealthResult {
+	if err != nil {
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: err.Error(),
+		}
+	}
+	result := &backend.CheckHealthResult{
+		Status:  backend.HealthStatusOk,
+		Message: details.HealthMessage(),
+	}
+	jsonDetails, err := json.Marshal(details)
+	if err == nil {
+		result.JSONDetails = jsonDetails
+	}
+	return result
+}
@@ -0,0 +1,49 @@
+package plugin_test
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DescribeTopology", func() {
+	DescribeTable("Should recognize", func(hello bson.M, topology, replicaSet string) {
+		actualTopology, actualReplicaSet := plugin.DescribeTopology(hello)
+		Expect(actualTopology).To(Equal(topology))
+		Expect(actualReplicaSet).To(Equal(replicaSet))
+	},
+		Entry("standalone servers", bson.M{"isWritablePrimary": true}, "standalone", ""),
+		Entry("replica set members", bson.M{"isWritablePrimary": true, "setName": "rs0"}, "replicaSet", "rs0"),
+		Entry("mongos routers", bson.M{"isWritablePrimary": true, "msg": "isdbgrid"}, "sharded", ""),
+	)
+})
+
+var _ = Describe("CheckHealth", func() {
+	check := func(jsonData string) *backend.CheckHealthResult {
+		settings := backend.DataSourceInstanceSettings{UID: "health", JSONData: []byte(jsonData)}
+		instance, err := plugin.NewMongoDBDatasource(settings)
+		Expect(err).ToNot(HaveOccurred())
+		result, err := instance.(*plugin.MongoDBDatasource).CheckHealth(context.Background(), &backend.CheckHealthRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &settings},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Status).To(Equal(backend.HealthStatusError))
+		return result
+	}
+
+	It("Should report servers which cannot be reached as failing to ping", func() {
+		result := check(`{"url": "mongodb://localhost:1/?serverSelectionTimeoutMS=100"}`)
+		Expect(result.Message).To(HavePrefix("Ping failed: "))
+	})
+
+	It("Should report other failures without claiming the ping failed", func() {
+		result := check(`{"url": "not a url"}`)
+		Expect(result.Message).ToNot(ContainSubstring("Ping failed"))
+	})
+})
@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UsesCollectionScan returns true if the winning plan of any part of an explain result (including those of each shard,
+// and of each stage of an aggregation) scans the whole collection
+func UsesCollectionScan(explain interface{}) bool {
+	return findCollectionScan(explain, false)
+}
+
+func findCollectionScan(value interface{}, inWinningPlan bool) bool {
+	switch v := value.(type) {
+	case bson.M:
+		if stage, ok := v["stage"].(string); ok && inWinningPlan && stage == "COLLSCAN" {
+			return true
+		}
+		for key, elem := range v {
+			if findCollectionScan(elem, inWinningPlan || key == "winningPlan") {
+				return true
+			}
+		}
+	case bson.D:
+		for _, elem := range v {
+			if elem.Key == "stage" && inWinningPlan && elem.Value == "COLLSCAN" {
+				return true
+			}
+			if findCollectionScan(elem.Value, inWinningPlan || elem.Key == "winningPlan") {
+				return true
+			}
+		}
+	case bsonPrim.A:
+		for _, elem := range v {
+			if findCollectionScan(elem, inWinningPlan) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rangeOperators are the query operators which select a range of values, rather than specific values
+var rangeOperators = map[string]struct{}{
+	"$gt": {}, "$gte": {}, "$lt": {}, "$lte": {}, "$ne": {}, "$nin": {}, "$regex": {}, "$exists": {},
+}
+
+// matchFields classifies the fields filtered by a $match as equality or range conditions.
+// Top-level operators other than $and, such as $expr and $or, cannot be served by a single index, and are ignored.
+func matchFields(filter interface{}, equality, ranges *[]string) {
+	doc, ok := filter.(bson.D)
+	if !ok {
+		return
+	}
+	for _, elem := range doc {
+		if elem.Key == "$and" {
+			clauses, _ := elem.Value.(bsonPrim.A)
+			for _, clause := range clauses {
+				matchFields(clause, equality, ranges)
+			}
+			continue
+		}
+		if strings.HasPrefix(elem.Key, "$") {
+			continue
+		}
+		isRange := false
+		if condition, ok := elem.Value.(bson.D); ok {
+			for _, op := range condition {
+				if _, ok := rangeOperators[op.Key]; ok {
+					isRange = true
+				}
+			}
+		}
+		if _, ok := elem.Value.(bsonPrim.Regex); ok {
+			isRange = true
+		}
+		if isRange {
+			*ranges = append(*ranges, elem.Key)
+		} else {
+			*equality = append(*equality, elem.Key)
+		}
+	}
+}
+
+// SuggestIndex derives an index which could serve the leading $match and $sort stages of a pipeline,
+// following the equality, sort, range rule: fields filtered by equality first, then sorted fields, then fields filtered by range.
+// An empty result means the pipeline does not begin with stages which could use an index.
+func SuggestIndex(pipeline mongo.Pipeline) bson.D {
+	equality := []string{}
+	ranges := []string{}
+	var sort bson.D
+	for _, stage := range pipeline {
+		if len(stage) != 1 {
+			break
+		}
+		if stage[0].Key == "$match" {
+			if sort != nil {
+				break
+			}
+			matchFields(stage[0].Value, &equality, &ranges)
+			continue
+		}
+		if stage[0].Key == "$sort" && sort == nil {
+			sort, _ = stage[0].Value.(bson.D)
+			continue
+		}
+		break
+	}
+
+	index := bson.D{}
+	seen := map[string]struct{}{}
+	add := func(field string, direction interface{}) {
+		if _, ok := seen[field]; ok {
+			return
+		}
+		seen[field] = struct{}{}
+		index = append(index, bson.E{Key: field, Value: direction})
+	}
+	for _, field := range equality {
+		add(field, 1)
+	}
+	for _, elem := range sort {
+		if direction, ok := toInt64(elem.Value); ok {
+			add(elem.Key, direction)
+		}
+	}
+	for _, field := range ranges {
+		add(field, 1)
+	}
+	return index
+}
+
+// explainAggregate explains a pipeline with the given verbosity. The queryPlanner verbosity plans it without executing it.
+func explainAggregate(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, hint interface{}, verbosity string) (bson.D, error) {
+	aggregate := bson.D{
+		bson.E{Key: "aggregate", Value: collection.Name()},
+		bson.E{Key: "pipeline", Value: pipeline},
+		bson.E{Key: "cursor", Value: bson.D{}},
+	}
+	if hint != nil {
+		aggregate = append(aggregate, bson.E{Key: "hint", Value: hint})
+	}
+	// Decoded in order, so that summaries list the plans of shards in the order the server reports them
+	var explain bson.D
+	err := collection.Database().RunCommand(ctx, bson.D{
+		bson.E{Key: "explain", Value: aggregate},
+		bson.E{Key: "verbosity", Value: verbosity},
+	}).Decode(&explain)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to explain query")
+	}
+	return explain, nil
+}
+
+// indexAdvice produces a notice suggesting an index if the explain result of a pipeline scans the whole collection
+func indexAdvice(explain bson.D, collection string, pipeline mongo.Pipeline) (*data.Notice, error) {
+	if !UsesCollectionScan(explain) {
+		return nil, nil
+	}
+	index := SuggestIndex(pipeline)
+	if len(index) == 0 {
+		return &data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("The query scans the whole of collection %s. Beginning the pipeline with a $match on indexed fields would allow it to use an index", collection),
+		}, nil
+	}
+	keys, err := bson.MarshalExtJSON(index, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return &data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("The query scans the whole of collection %s. An index such as %s may allow it to use an index scan instead", collection, keys),
+	}, nil
+}
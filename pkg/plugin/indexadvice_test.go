@@ -0,0 +1,52 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SuggestIndex", func() {
+	DescribeTable("Should follow the equality, sort, range rule", func(text, expected string) {
+		pipeline := mongo.Pipeline{}
+		Expect(bson.UnmarshalExtJSON([]byte(text), false, &pipeline)).To(Succeed())
+		Expect(bson.MarshalExtJSON(plugin.SuggestIndex(pipeline), false, false)).To(MatchJSON(expected))
+	},
+		Entry("equality, sort, and range",
+			`[{"$match": {"ts": {"$gte": 1}}}, {"$match": {"host": "a", "$expr": {"$eq": ["$x", 1]}}}, {"$sort": {"ts": -1}}, {"$limit": 1}]`,
+			`{"host": 1, "ts": -1}`,
+		),
+		Entry("$and clauses and regexes",
+			`[{"$match": {"$and": [{"status": {"$in": [1, 2]}}, {"name": {"$regex": "^a"}}]}}]`,
+			`{"status": 1, "name": 1}`,
+		),
+		Entry("no leading $match",
+			`[{"$group": {"_id": "$host"}}, {"$match": {"_id": "a"}}]`,
+			`{}`,
+		),
+	)
+})
+
+var _ = Describe("UsesCollectionScan", func() {
+	It("Should find collection scans in the winning plan of aggregation stages", func() {
+		explain := bson.M{}
+		Expect(bson.UnmarshalExtJSON([]byte(`{"stages": [{"$cursor": {"queryPlanner": {
+			"winningPlan": {"stage": "PROJECTION", "inputStage": {"stage": "COLLSCAN"}},
+			"rejectedPlans": []
+		}}}]}`), false, &explain)).To(Succeed())
+		Expect(plugin.UsesCollectionScan(explain)).To(BeTrue())
+	})
+
+	It("Should ignore collection scans in rejected plans", func() {
+		explain := bson.M{}
+		Expect(bson.UnmarshalExtJSON([]byte(`{"queryPlanner": {
+			"winningPlan": {"stage": "FETCH", "inputStage": {"stage": "IXSCAN"}},
+			"rejectedPlans": [{"stage": "COLLSCAN"}]
+		}}`), false, &explain)).To(Succeed())
+		Expect(plugin.UsesCollectionScan(explain)).To(BeFalse())
+	})
+})
@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ParseIndexHint parses the index hint of a query, which is either the name of an index, or its key pattern as a document,
+// such as {"ts": 1}. An empty hint is nil, leaving the choice of index to the query planner.
+func ParseIndexHint(text string, canonical bool) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(text, "{") {
+		return text, nil
+	}
+	keys := bson.D{}
+	err := unmarshalQueryText(NormalizeJSON5(text), canonical, &keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse index hint")
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Index hints must have at least one key")
+	}
+	return keys, nil
+}
+
+// getHint returns the index hint of a query, or nil if it has none
+func (m *QueryModel) getHint() (interface{}, error) {
+	if m.Hint == "" {
+		return nil, nil
+	}
+	err := m.requireCurrentModel("Index hints")
+	if err != nil {
+		return nil, err
+	}
+	return ParseIndexHint(m.Hint, m.canonicalInput())
+}
+
+// indexInfo describes an index of a collection
+type indexInfo struct {
+	Name string `json:"name"`
+	// Key is the key pattern of the index, as relaxed extended JSON, which may be used as a hint as-is
+	Key                     json.RawMessage `json:"key"`
+	Unique                  bool            `json:"unique,omitempty"`
+	Sparse                  bool            `json:"sparse,omitempty"`
+	ExpireAfterSeconds      *int64          `json:"expireAfterSeconds,omitempty"`
+	PartialFilterExpression json.RawMessage `json:"partialFilterExpression,omitempty"`
+}
+
+type indexesResponse struct {
+	Indexes []indexInfo `json:"indexes"`
+}
+
+// indexSpecification is the subset of the output of listIndexes described by the indexes route
+type indexSpecification struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.Raw `bson:"key"`
+	Unique                  bool     `bson:"unique"`
+	Sparse                  bool     `bson:"sparse"`
+	ExpireAfterSeconds      *int64   `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+}
+
+// info converts an index specification for the response of the indexes route
+func (s *indexSpecification) info() (indexInfo, error) {
+	info := indexInfo{Name: s.Name, Unique: s.Unique, Sparse: s.Sparse, ExpireAfterSeconds: s.ExpireAfterSeconds}
+	key, err := bson.MarshalExtJSON(s.Key, false, false)
+	if err != nil {
+		return indexInfo{}, err
+	}
+	info.Key = key
+	if len(s.PartialFilterExpression) != 0 {
+		info.PartialFilterExpression, err = bson.MarshalExtJSON(s.PartialFilterExpression, false, false)
+		if err != nil {
+			return indexInfo{}, err
+		}
+	}
+	return info, nil
+}
+
+// handleIndexes lists the indexes of a collection, so that users can choose an index hint
+func (d *MongoDBDatasource) handleIndexes(w http.ResponseWriter, r *http.Request, database, collection string) {
+	ctx, client, _, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+
+	cursor, err := client.Database(database).Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to list indexes"))
+		return
+	}
+	specs := []indexSpecification{}
+	err = cursor.All(ctx, &specs)
+	if err != nil {
+		writeMongoError(w, errors.Wrap(err, "Failed to decode indexes"))
+		return
+	}
+	indexes := make([]indexInfo, len(specs))
+	for ix := range specs {
+		indexes[ix], err = specs[ix].info()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errors.Wrap(err, fmt.Sprintf("Failed to convert index %s", specs[ix].Name)))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, indexesResponse{Indexes: indexes})
+}
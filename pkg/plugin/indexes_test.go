@@ -0,0 +1,32 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseIndexHint", func() {
+	It("Should treat plain text as an index name", func() {
+		Expect(plugin.ParseIndexHint(" ts_1 ", false)).To(Equal("ts_1"))
+	})
+
+	It("Should parse key patterns in order", func() {
+		Expect(plugin.ParseIndexHint(`{host: 1, "ts": -1,}`, false)).To(Equal(bson.D{{Key: "host", Value: int32(1)}, {Key: "ts", Value: int32(-1)}}))
+	})
+
+	It("Should leave the index to the planner without a hint", func() {
+		Expect(plugin.ParseIndexHint("", false)).To(BeNil())
+	})
+
+	DescribeTable("Should reject", func(text string) {
+		_, err := plugin.ParseIndexHint(text, false)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("empty key patterns", `{}`),
+		Entry("malformed key patterns", `{"ts": }`),
+	)
+})
@@ -0,0 +1,367 @@
+//go:build integration
+// +build integration
+
+package plugin_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// These tests start MongoDB in Docker using testcontainers, and so require a Docker daemon.
+// Run them with
+//
+//	go test -tags integration ./pkg/plugin/...
+//
+// MONGODB_VERSIONS is a comma-separated list of the server versions to test, each of which is tested both standalone and as a single-node replica set.
+
+const (
+	defaultIntegrationVersions = "5.0,6.0,7.0"
+	integrationDatabase        = "integration"
+	integrationStartupTimeout  = 2 * time.Minute
+)
+
+func integrationVersions() []string {
+	versions := os.Getenv("MONGODB_VERSIONS")
+	if versions == "" {
+		versions = defaultIntegrationVersions
+	}
+	return strings.Split(versions, ",")
+}
+
+// mongoContainer is a MongoDB server running in Docker
+type mongoContainer struct {
+	container testcontainers.Container
+	url       string
+}
+
+// startMongo starts a MongoDB server, optionally as a single-node replica set, and waits for it to accept writes
+func startMongo(ctx context.Context, version string, replicaSet bool) (*mongoContainer, error) {
+	req := testcontainers.ContainerRequest{
+		Image:        "mongo:" + version,
+		ExposedPorts: []string{"27017/tcp"},
+		WaitingFor:   wait.ForLog("Waiting for connections").WithStartupTimeout(integrationStartupTimeout),
+	}
+	if replicaSet {
+		req.Cmd = []string{"--replSet", "rs0", "--bind_ip_all"}
+	}
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{ContainerRequest: req, Started: true})
+	if err != nil {
+		return nil, err
+	}
+	m := &mongoContainer{container: container}
+	host, err := container.Host(ctx)
+	if err != nil {
+		return m, err
+	}
+	port, err := container.MappedPort(ctx, "27017/tcp")
+	if err != nil {
+		return m, err
+	}
+	m.url = fmt.Sprintf("mongodb://%s:%s/?directConnection=true", host, port.Port())
+
+	if replicaSet {
+		code, output, err := container.Exec(ctx, []string{"mongosh", "--quiet", "--eval", `rs.initiate({_id: "rs0", members: [{_id: 0, host: "localhost:27017"}]})`})
+		if err != nil {
+			return m, err
+		}
+		if code != 0 {
+			message, _ := io.ReadAll(output)
+			return m, fmt.Errorf("Failed to initiate replica set: %s", message)
+		}
+	}
+	return m, m.waitWritable(ctx)
+}
+
+// waitWritable waits for the server to become primary, which takes a few seconds after a replica set is initiated
+func (m *mongoContainer) waitWritable(ctx context.Context) error {
+	client, err := mongo.Connect(ctx, mongoOpts.Client().ApplyURI(m.url))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+	deadline := time.Now().Add(integrationStartupTimeout)
+	for {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+			IsMaster          bool `bson:"ismaster"`
+		}
+		err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+		if err == nil && (hello.IsWritablePrimary || hello.IsMaster) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("MongoDB did not become writable: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// seed inserts the demo data into the integration database
+func (m *mongoContainer) seed(ctx context.Context, end time.Time) error {
+	client, err := mongo.Connect(ctx, mongoOpts.Client().ApplyURI(m.url))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+	demo := plugin.GenerateDemoData(end, 1, 10*time.Minute)
+	for name, docs := range map[string][]interface{}{"metrics": demo.Metrics, "events": demo.Events} {
+		_, err = client.Database(integrationDatabase).Collection(name).InsertMany(ctx, docs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mongoContainer) pluginContext() backend.PluginContext {
+	return m.pluginContextWith(map[string]interface{}{})
+}
+
+// pluginContextWith produces the context of a datasource for the container with extra settings
+func (m *mongoContainer) pluginContextWith(settings map[string]interface{}) backend.PluginContext {
+	settings["url"] = m.url
+	jsonData, _ := json.Marshal(settings)
+	return backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			UID:                     "integration",
+			JSONData:                jsonData,
+			DecryptedSecureJSONData: map[string]string{},
+		},
+	}
+}
+
+// fieldJSON renders the values of a field of a frame as JSON, so that frames can be compared regardless of the field types
+func fieldJSON(frame *data.Frame, name string) []string {
+	field, _ := frame.FieldByName(name)
+	Expect(field).ToNot(BeNil(), "frame has no field %s", name)
+	values := make([]string, field.Len())
+	for ix := range values {
+		value, err := json.Marshal(field.At(ix))
+		Expect(err).ToNot(HaveOccurred())
+		values[ix] = string(value)
+	}
+	return values
+}
+
+func integrationQuery(refID string, timeRange backend.TimeRange, model map[string]interface{}) backend.DataQuery {
+	model["database"] = integrationDatabase
+	if _, ok := model["version"]; !ok {
+		model["version"] = plugin.CurrentQueryModelVersion
+	}
+	bytes, _ := json.Marshal(model)
+	return backend.DataQuery{RefID: refID, TimeRange: timeRange, JSON: bytes}
+}
+
+var _ = Describe("Integration", Label("integration"), func() {
+	for _, version := range integrationVersions() {
+		for _, replicaSet := range []bool{false, true} {
+			version, replicaSet := version, replicaSet
+			mode := "standalone"
+			if replicaSet {
+				mode = "replica set"
+			}
+			Describe(fmt.Sprintf("MongoDB %s (%s)", version, mode), Ordered, func() {
+				var mongoDB *mongoContainer
+				var ds *plugin.MongoDBDatasource
+				end := time.Now().Truncate(time.Minute)
+				timeRange := backend.TimeRange{From: end.Add(-24 * time.Hour), To: end}
+
+				BeforeAll(func(ctx SpecContext) {
+					var err error
+					mongoDB, err = startMongo(ctx, version, replicaSet)
+					DeferCleanup(func(ctx SpecContext) {
+						if mongoDB != nil {
+							Expect(mongoDB.container.Terminate(ctx)).To(Succeed())
+						}
+					})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(mongoDB.seed(ctx, end)).To(Succeed())
+					instance, err := plugin.NewMongoDBDatasource(*mongoDB.pluginContext().DataSourceInstanceSettings)
+					Expect(err).ToNot(HaveOccurred())
+					ds = instance.(*plugin.MongoDBDatasource)
+				}, NodeTimeout(5*time.Minute))
+
+				It("Should report healthy", func(ctx SpecContext) {
+					result, err := ds.CheckHealth(ctx, &backend.CheckHealthRequest{PluginContext: mongoDB.pluginContext()})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(result.Status).To(Equal(backend.HealthStatusOk), result.Message)
+				})
+
+				It("Should run table and timeseries queries", func(ctx SpecContext) {
+					resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+						PluginContext: mongoDB.pluginContext(),
+						Queries: []backend.DataQuery{
+							integrationQuery("table", timeRange, map[string]interface{}{
+								"collection":           "events",
+								"queryType":            "Table",
+								"timestampField":       "timestamp",
+								"aggregation":          `[{"$match": {"level": "error"}}, {"$project": {"_id": 0}}]`,
+								"autoTimeBound":        true,
+								"schemaInference":      true,
+								"schemaInferenceDepth": 20,
+							}),
+							integrationQuery("timeseries", timeRange, map[string]interface{}{
+								"collection":      "metrics",
+								"queryType":       "Timeseries",
+								"timestampField":  "timestamp",
+								"labelFields":     []string{"host"},
+								"valueFields":     []string{"cpu"},
+								"valueFieldTypes": []string{"float64"},
+								"aggregation":     `[{"$project": {"_id": 0, "timestamp": 1, "host": 1, "cpu": 1}}]`,
+								"autoTimeBound":   true,
+								"autoTimeSort":    true,
+							}),
+						},
+					})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(resp.Responses["table"].Error).ToNot(HaveOccurred())
+					Expect(resp.Responses["timeseries"].Error).ToNot(HaveOccurred())
+					Expect(resp.Responses["timeseries"].Frames).To(HaveLen(4))
+					for _, frame := range resp.Responses["timeseries"].Frames {
+						Expect(frame.Rows()).To(BeNumerically(">", 0))
+					}
+				})
+
+				It("Should run legacy queries exactly as before", func(ctx SpecContext) {
+					client, err := mongo.Connect(ctx, mongoOpts.Client().ApplyURI(mongoDB.url))
+					Expect(err).ToNot(HaveOccurred())
+					defer client.Disconnect(ctx)
+					var deep interface{} = int64(0)
+					for i := 0; i < 40; i++ {
+						deep = bson.D{{Key: "a", Value: deep}}
+					}
+					deepJSON := strings.Repeat(`{"a":`, 40) + "0" + strings.Repeat("}", 40)
+					db := client.Database(integrationDatabase)
+					_, err = db.Collection("legacy").InsertMany(ctx, []interface{}{
+						bson.D{{Key: "n", Value: int64(1)}, {Key: "doc", Value: deep}},
+						bson.D{{Key: "n", Value: int64(2)}, {Key: "doc", Value: bson.D{{Key: "a", Value: int64(1)}}}},
+					})
+					Expect(err).ToNot(HaveOccurred())
+					_, err = db.Collection("mismatched").InsertMany(ctx, []interface{}{
+						bson.D{{Key: "n", Value: int64(1)}},
+						bson.D{{Key: "n", Value: float64(2)}},
+					})
+					Expect(err).ToNot(HaveOccurred())
+
+					model := func(collection string, version int) map[string]interface{} {
+						return map[string]interface{}{
+							"version":              version,
+							"collection":           collection,
+							"queryType":            "Table",
+							"aggregation":          `[{"$sort": {"_id": 1}}, {"$project": {"_id": 0}}]`,
+							"schemaInference":      true,
+							"schemaInferenceDepth": 1,
+						}
+					}
+					// The cell limits of the datasource only apply to the current query model
+					resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+						PluginContext: mongoDB.pluginContextWith(map[string]interface{}{"maxCellDepth": 2}),
+						Queries: []backend.DataQuery{
+							integrationQuery("legacy", timeRange, model("legacy", 1)),
+							integrationQuery("current", timeRange, model("legacy", plugin.CurrentQueryModelVersion)),
+							integrationQuery("legacyMismatched", timeRange, model("mismatched", 1)),
+							integrationQuery("currentMismatched", timeRange, model("mismatched", plugin.CurrentQueryModelVersion)),
+						},
+					})
+					Expect(err).ToNot(HaveOccurred())
+
+					legacy := resp.Responses["legacy"]
+					Expect(legacy.Error).ToNot(HaveOccurred())
+					Expect(legacy.Frames).To(HaveLen(1))
+					Expect(legacy.Frames[0].Fields).To(HaveLen(2))
+					Expect(fieldJSON(legacy.Frames[0], "n")).To(Equal([]string{"1", "2"}))
+					docs := fieldJSON(legacy.Frames[0], "doc")
+					Expect(docs).To(HaveLen(2))
+					Expect(docs[0]).To(MatchJSON(deepJSON))
+					Expect(docs[1]).To(MatchJSON(`{"a": 1}`))
+
+					current := resp.Responses["current"]
+					Expect(current.Error).ToNot(HaveOccurred())
+					Expect(fieldJSON(current.Frames[0], "doc")[0]).To(ContainSubstring("$truncated"))
+
+					// Inferred types are only coerced in the current query model
+					Expect(resp.Responses["legacyMismatched"].Error).To(MatchError(ContainSubstring("Type mismatch for field n")))
+					Expect(resp.Responses["currentMismatched"].Error).ToNot(HaveOccurred())
+					Expect(fieldJSON(resp.Responses["currentMismatched"].Frames[0], "n")).To(Equal([]string{"1", "2"}))
+				})
+
+				It("Should kill queries which exceed the hard ceiling", func(ctx SpecContext) {
+					pCtx := mongoDB.pluginContextWith(map[string]interface{}{"hardQueryTimeout": "2s"})
+					started := time.Now()
+					resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+						PluginContext: pCtx,
+						Queries: []backend.DataQuery{
+							integrationQuery("slow", timeRange, map[string]interface{}{
+								"collection":           "events",
+								"queryType":            "Table",
+								"aggregation":          `[{"$match": {"$expr": {"$function": {"body": "function() { sleep(1000); return true; }", "args": [], "lang": "js"}}}}]`,
+								"schemaInference":      true,
+								"schemaInferenceDepth": 1,
+							}),
+						},
+					})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(resp.Responses["slow"].Error).To(MatchError(ContainSubstring("Query was killed after exceeding the hard ceiling of 2s")))
+					Expect(time.Since(started)).To(BeNumerically("<", time.Minute))
+
+					// Nothing started by the query is left running on the server
+					client, err := mongo.Connect(ctx, mongoOpts.Client().ApplyURI(mongoDB.url))
+					Expect(err).ToNot(HaveOccurred())
+					defer client.Disconnect(ctx)
+					Eventually(func() ([]bson.M, error) {
+						cursor, err := client.Database("admin").Aggregate(ctx, mongo.Pipeline{
+							{{Key: "$currentOp", Value: bson.D{}}},
+							{{Key: "$match", Value: bson.D{{Key: "command.comment", Value: bson.D{{Key: "$regex", Value: "^grafana-mongodb-community:"}}}}}},
+						})
+						if err != nil {
+							return nil, err
+						}
+						var ops []bson.M
+						err = cursor.All(ctx, &ops)
+						return ops, err
+					}).WithContext(ctx).WithTimeout(10 * time.Second).Should(BeEmpty())
+				}, SpecTimeout(2*time.Minute))
+
+				if replicaSet {
+					It("Should read a snapshot as of the end of the time range", func(ctx SpecContext) {
+						resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+							PluginContext: mongoDB.pluginContext(),
+							Queries: []backend.DataQuery{
+								integrationQuery("snapshot", backend.TimeRange{From: timeRange.From, To: time.Now()}, map[string]interface{}{
+									"collection":           "events",
+									"queryType":            "Table",
+									"aggregation":          `[{"$count": "count"}]`,
+									"schemaInference":      true,
+									"schemaInferenceDepth": 1,
+									"snapshot":             "dashboard",
+								}),
+							},
+						})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(resp.Responses["snapshot"].Error).ToNot(HaveOccurred())
+						Expect(resp.Responses["snapshot"].Frames[0].Rows()).To(Equal(1))
+					})
+				}
+			})
+		}
+	}
+})
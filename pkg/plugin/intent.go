@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	readIntentDashboard = "dashboard"
+	readIntentAlert     = "alert"
+
+	// minMaxStaleness is the smallest maxStalenessSeconds accepted by MongoDB
+	minMaxStaleness = 90 * time.Second
+)
+
+// readIntentSettings override the datasource-wide settings for queries with a particular read intent
+type readIntentSettings struct {
+	ReadPreference   string `json:"readPreference"`
+	MaxQueryTimeout  string `json:"maxQueryTimeout"`
+	MaxQueryRows     int    `json:"maxQueryRows"`
+	MaxQueryBytes    int64  `json:"maxQueryBytes"`
+	HardQueryTimeout string `json:"hardQueryTimeout"`
+	// MaxStalenessSeconds excludes secondaries lagging the primary by more than this from serving queries
+	MaxStalenessSeconds int `json:"maxStalenessSeconds"`
+}
+
+// forReadIntent returns a copy of the datasource settings with any overrides for a read intent applied
+func (d datasource) forReadIntent(intent string) datasource {
+	var overrides readIntentSettings
+	switch intent {
+	case readIntentAlert:
+		overrides = d.AlertReadIntent
+	default:
+		overrides = d.DashboardReadIntent
+	}
+	if overrides.ReadPreference != "" {
+		d.ReadPreference = overrides.ReadPreference
+	}
+	if overrides.MaxQueryTimeout != "" {
+		d.MaxQueryTimeout = overrides.MaxQueryTimeout
+	}
+	if overrides.MaxQueryRows != 0 {
+		d.MaxQueryRows = overrides.MaxQueryRows
+	}
+	if overrides.MaxQueryBytes != 0 {
+		d.MaxQueryBytes = overrides.MaxQueryBytes
+	}
+	if overrides.HardQueryTimeout != "" {
+		d.HardQueryTimeout = overrides.HardQueryTimeout
+	}
+	if overrides.MaxStalenessSeconds != 0 {
+		d.MaxStalenessSeconds = overrides.MaxStalenessSeconds
+	}
+	return d
+}
+
+// NewReadPreference builds a read preference from its mode, and a staleness bound in seconds, which is ignored if zero.
+// A staleness bound may not be combined with the primary mode, and must be at least 90 seconds.
+func NewReadPreference(mode string, maxStalenessSeconds int) (*readpref.ReadPref, error) {
+	parsed, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, err
+	}
+	if maxStalenessSeconds == 0 {
+		return readpref.New(parsed)
+	}
+	if parsed == readpref.PrimaryMode {
+		return nil, fmt.Errorf("Max staleness cannot be used with the primary read preference")
+	}
+	maxStaleness := time.Duration(maxStalenessSeconds) * time.Second
+	if maxStaleness < minMaxStaleness {
+		return nil, fmt.Errorf("Max staleness must be at least %d seconds", int(minMaxStaleness.Seconds()))
+	}
+	return readpref.New(parsed, readpref.WithMaxStaleness(maxStaleness))
+}
+
+// getReadPreference returns the configured read preference, or nil if the default (or the one in the URL) should be used
+func (d *datasource) getReadPreference() (*readpref.ReadPref, error) {
+	if d.ReadPreference == "" {
+		if d.MaxStalenessSeconds != 0 {
+			return nil, fmt.Errorf("Max staleness requires a read preference other than primary")
+		}
+		return nil, nil
+	}
+	readPref, err := NewReadPreference(d.ReadPreference, d.MaxStalenessSeconds)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid read preference in datasource settings")
+	}
+	return readPref, nil
+}
+
+// ServerAddress extracts the address of the server from the connection ID reported by command monitoring,
+// which has the form host:port[-n]
+func ServerAddress(connectionID string) string {
+	if ix := strings.LastIndex(connectionID, "[-"); ix != -1 && strings.HasSuffix(connectionID, "]") {
+		return connectionID[:ix]
+	}
+	return connectionID
+}
+
+// servedByTracker uses command monitoring to record the members of the deployment which served a query,
+// so that users can tell when results came from a lagging secondary
+type servedByTracker struct {
+	lock    sync.Mutex
+	members map[string]struct{}
+}
+
+func newServedByTracker() *servedByTracker {
+	return &servedByTracker{members: map[string]struct{}{}}
+}
+
+func (t *servedByTracker) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			if _, tracked := trackedCommands[evt.CommandName]; !tracked {
+				return
+			}
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.members[ServerAddress(evt.ConnectionID)] = struct{}{}
+		},
+	}
+}
+
+// servedBy returns the sorted addresses of the members which served the query
+func (t *servedByTracker) servedBy() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	members := make([]string, 0, len(t.members))
+	for member := range t.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
@@ -0,0 +1,48 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewReadPreference", func() {
+	It("Should apply a staleness bound to secondary reads", func() {
+		readPref, err := plugin.NewReadPreference("secondaryPreferred", 120)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readPref.Mode()).To(Equal(readpref.SecondaryPreferredMode))
+		maxStaleness, ok := readPref.MaxStaleness()
+		Expect(ok).To(BeTrue())
+		Expect(maxStaleness).To(Equal(120 * time.Second))
+	})
+
+	It("Should leave the staleness unbounded when zero", func() {
+		readPref, err := plugin.NewReadPreference("nearest", 0)
+		Expect(err).ToNot(HaveOccurred())
+		_, ok := readPref.MaxStaleness()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("Should reject a staleness bound with the primary mode", func() {
+		_, err := plugin.NewReadPreference("primary", 120)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should reject a staleness bound below the server minimum", func() {
+		_, err := plugin.NewReadPreference("secondary", 30)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ServerAddress", func() {
+	DescribeTable("Should extract the address", func(connectionID, expected string) {
+		Expect(plugin.ServerAddress(connectionID)).To(Equal(expected))
+	},
+		Entry("with a connection number", "mongo-1.example.com:27017[-12]", "mongo-1.example.com:27017"),
+		Entry("without a connection number", "localhost:27017", "localhost:27017"),
+	)
+})
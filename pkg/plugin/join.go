@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	joinKeyCaseLower = "lower"
+	joinKeyCaseUpper = "upper"
+)
+
+// joinKey prepares a column for Grafana's "join by field" transformation, so that it matches the key column of frames from other datasources
+type joinKey struct {
+	Field string `json:"field"`
+	// As, if set, renames the field, so that it has the same name as the key of the other frames
+	As string `json:"as,omitempty"`
+	// Trim removes leading and trailing whitespace from the key
+	Trim bool `json:"trim,omitempty"`
+	// Case is either lower or upper to convert the key to that case, or empty to leave it unchanged
+	Case string `json:"case,omitempty"`
+}
+
+func (k *joinKey) validate() error {
+	if k.Field == "" {
+		return fmt.Errorf("Join key field cannot be empty")
+	}
+	switch k.Case {
+	case "", joinKeyCaseLower, joinKeyCaseUpper:
+		return nil
+	default:
+		return fmt.Errorf("Join key case must be one of: %s, %s", joinKeyCaseLower, joinKeyCaseUpper)
+	}
+}
+
+func (k *joinKey) normalize(value string) string {
+	if k.Trim {
+		value = strings.TrimSpace(value)
+	}
+	switch k.Case {
+	case joinKeyCaseLower:
+		value = strings.ToLower(value)
+	case joinKeyCaseUpper:
+		value = strings.ToUpper(value)
+	}
+	return value
+}
+
+// ApplyJoinKey normalizes and renames the join key field of each frame.
+// The transformation matches fields by their display name, so any display name set by the legend format is removed from the key.
+func ApplyJoinKey(frames []*data.Frame, key joinKey) error {
+	err := key.validate()
+	if err != nil {
+		return err
+	}
+	normalize := key.Trim || key.Case != ""
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if field.Name != key.Field {
+				continue
+			}
+			if normalize {
+				if field.Type().NonNullableType() != data.FieldTypeString {
+					return fmt.Errorf("Join key %s must be a string to be normalized, but is %s", field.Name, field.Type())
+				}
+				for row := 0; row < field.Len(); row++ {
+					value, ok := field.ConcreteAt(row)
+					if !ok {
+						continue
+					}
+					field.SetConcrete(row, key.normalize(value.(string)))
+				}
+			}
+			if key.As != "" {
+				field.Name = key.As
+			}
+			if field.Config != nil {
+				field.Config.DisplayNameFromDS = ""
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package plugin_test
+
+import (
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyJoinKey", func() {
+	parse := func(text string) plugin.QueryModel {
+		qm := plugin.QueryModel{}
+		Expect(json.Unmarshal([]byte(text), &qm)).To(Succeed())
+		return qm
+	}
+
+	It("Should normalize and rename the key", func() {
+		qm := parse(`{"joinKey": {"field": "host", "as": "instance", "trim": true, "case": "lower"}}`)
+		key := data.NewField("host", nil, []string{" Web-1 ", "DB"})
+		key.Config = &data.FieldConfig{DisplayNameFromDS: "host"}
+		frame := data.NewFrame("", key, data.NewField("value", nil, []float64{1, 2}))
+		Expect(plugin.ApplyJoinKey([]*data.Frame{frame}, *qm.JoinKey)).To(Succeed())
+		Expect(frame.Fields[0].Name).To(Equal("instance"))
+		Expect(frame.Fields[0].Config.DisplayNameFromDS).To(BeEmpty())
+		Expect(frame.Fields[0].At(0)).To(Equal("web-1"))
+		Expect(frame.Fields[0].At(1)).To(Equal("db"))
+	})
+
+	It("Should refuse to normalize a key which is not a string", func() {
+		qm := parse(`{"joinKey": {"field": "id", "trim": true}}`)
+		frame := data.NewFrame("", data.NewField("id", nil, []int64{1}))
+		Expect(plugin.ApplyJoinKey([]*data.Frame{frame}, *qm.JoinKey)).ToNot(Succeed())
+	})
+
+	It("Should reject unknown cases", func() {
+		qm := parse(`{"joinKey": {"field": "id", "case": "title"}}`)
+		Expect(plugin.ApplyJoinKey(nil, *qm.JoinKey)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"strings"
+)
+
+// NormalizeJSON5 removes the comments and trailing commas which JSON5 permits from the text of a query, so that stages
+// may be commented out while iterating on a pipeline. Both // line comments and /* block */ comments are removed,
+// except within strings. This is done before macros are expanded, so that macros within comments are ignored.
+func NormalizeJSON5(text string) string {
+	return removeTrailingCommas(removeComments(text))
+}
+
+// scanJSON5 calls visit with the index of every character of the text outside of a string literal, single or double quoted,
+// and copies the text to the output wherever visit returns false
+func scanJSON5(text string, visit func(out *strings.Builder, ix int) (next int, handled bool)) string {
+	var out strings.Builder
+	out.Grow(len(text))
+	var quote byte
+	for ix := 0; ix < len(text); ix++ {
+		c := text[ix]
+		if quote != 0 || c == '\\' {
+			out.WriteByte(c)
+			switch {
+			case c == '\\' && ix+1 < len(text):
+				// Escapes are copied as is, including outside of strings, such as within regular expression literals
+				ix++
+				out.WriteByte(text[ix])
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+		if c == '"' || c == '\'' {
+			quote = c
+			out.WriteByte(c)
+			continue
+		}
+		next, handled := visit(&out, ix)
+		if handled {
+			ix = next
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// removeComments replaces each comment with a single space, so that the tokens on either side remain separate
+func removeComments(text string) string {
+	return scanJSON5(text, func(out *strings.Builder, ix int) (int, bool) {
+		if text[ix] != '/' || ix+1 >= len(text) {
+			return ix, false
+		}
+		switch text[ix+1] {
+		case '/':
+			end := strings.IndexByte(text[ix:], '\n')
+			if end == -1 {
+				return len(text), true
+			}
+			out.WriteByte(' ')
+			// Keep the newline, so that line numbers in errors are unchanged
+			return ix + end - 1, true
+		case '*':
+			end := strings.Index(text[ix+2:], "*/")
+			if end == -1 {
+				return len(text), true
+			}
+			out.WriteByte(' ')
+			return ix + 2 + end + 1, true
+		default:
+			return ix, false
+		}
+	})
+}
+
+// removeTrailingCommas removes each comma followed only by whitespace and then the end of an array or document
+func removeTrailingCommas(text string) string {
+	return scanJSON5(text, func(out *strings.Builder, ix int) (int, bool) {
+		if text[ix] != ',' {
+			return ix, false
+		}
+		rest := strings.TrimLeft(text[ix+1:], " \t\r\n")
+		if rest != "" && (rest[0] == ']' || rest[0] == '}') {
+			return ix, true
+		}
+		return ix, false
+	})
+}
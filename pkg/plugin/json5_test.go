@@ -0,0 +1,36 @@
+package plugin_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NormalizeJSON5", func() {
+	DescribeTable("Should remove", func(text, expected string) {
+		Expect(plugin.NormalizeJSON5(text)).To(MatchJSON(expected))
+	},
+		Entry("line comments",
+			"[\n  // {\"$limit\": 1},\n  {\"$match\": {}} // the rest\n]",
+			`[{"$match": {}}]`,
+		),
+		Entry("block comments, including macros",
+			`[/* {"$match": $__nope(x)}, */ {"$count": "n"}]`,
+			`[{"$count": "n"}]`,
+		),
+		Entry("trailing commas",
+			`[{"$match": {"a": 1,},}, ]`,
+			`[{"$match": {"a": 1}}]`,
+		),
+		Entry("trailing commas before comments",
+			"[{\"$count\": \"n\"}, // {\"$limit\": 1}\n]",
+			`[{"$count": "n"}]`,
+		),
+	)
+
+	It("Should leave strings unchanged", func() {
+		text := `[{"$match": {"url": "http://a/*b*/", "s": "x,]", "q": "\"//"}}]`
+		Expect(plugin.NormalizeJSON5(text)).To(Equal(text))
+	})
+})
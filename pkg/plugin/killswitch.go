@@ -0,0 +1,194 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// queryCommentPrefix is prepended to the comment attached to each query so that it can be found in $currentOp
+	queryCommentPrefix = "grafana-mongodb-community:"
+	// killOpTimeout bounds how long the kill switch will spend finding and killing an operation
+	killOpTimeout = 10 * time.Second
+)
+
+// trackedCommands are the commands which make up the execution of a query, and are candidates to be killed
+var trackedCommands = map[string]struct{}{
+	"aggregate": {},
+	"find":      {},
+	"getMore":   {},
+	"count":     {},
+	"distinct":  {},
+}
+
+// newQueryComment produces a comment unique to a single query execution
+func newQueryComment() string {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		// Not unique, but still identifies the plugin, and the kill switch is best-effort anyway
+		log.DefaultLogger.Warn("Failed to generate query comment", "error", err)
+	}
+	return queryCommentPrefix + hex.EncodeToString(id)
+}
+
+// inflightTracker uses command monitoring to track which query commands are currently executing on a client
+type inflightTracker struct {
+	lock     sync.Mutex
+	commands map[int64]string
+}
+
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{commands: make(map[int64]string)}
+}
+
+func (t *inflightTracker) monitor() *event.CommandMonitor {
+	finished := func(requestID int64) {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		delete(t.commands, requestID)
+	}
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			if _, tracked := trackedCommands[evt.CommandName]; !tracked {
+				return
+			}
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			t.commands[evt.RequestID] = evt.CommandName
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) { finished(evt.RequestID) },
+		Failed:    func(_ context.Context, evt *event.CommandFailedEvent) { finished(evt.RequestID) },
+	}
+}
+
+func (t *inflightTracker) inflight() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.commands) != 0
+}
+
+// killSwitch kills the server-side operation for a query if it is still executing after a hard ceiling,
+// regardless of whether or not the driver honored the cancellation of its context
+type killSwitch struct {
+	killed int32
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func startKillSwitch(client *mongo.Client, tracker *inflightTracker, comment string, ceiling time.Duration) *killSwitch {
+	k := &killSwitch{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(k.done)
+		timer := time.NewTimer(ceiling)
+		defer timer.Stop()
+		select {
+		case <-k.stop:
+			return
+		case <-timer.C:
+		}
+		if !tracker.inflight() {
+			return
+		}
+		log.DefaultLogger.Warn("Query exceeded hard ceiling, killing it", "comment", comment, "ceiling", ceiling)
+		ctx, cancel := context.WithTimeout(context.Background(), killOpTimeout)
+		defer cancel()
+		killed, err := killOpsWithComment(ctx, client, comment)
+		if err != nil {
+			log.DefaultLogger.Error("Failed to kill query which exceeded hard ceiling", "comment", comment, "error", err)
+		}
+		if killed != 0 {
+			log.DefaultLogger.Warn("Killed query which exceeded hard ceiling", "comment", comment, "operations", killed)
+			atomic.StoreInt32(&k.killed, 1)
+		}
+	}()
+	return k
+}
+
+// Stop stops the kill switch if it hasn't already fired, and waits for it to finish if it has
+func (k *killSwitch) Stop() {
+	close(k.stop)
+	<-k.done
+}
+
+// Killed returns true if the kill switch killed the query
+func (k *killSwitch) Killed() bool {
+	return atomic.LoadInt32(&k.killed) != 0
+}
+
+// currentOp is the part of an operation reported by $currentOp which identifies the query it belongs to
+type currentOp struct {
+	OpID    interface{} `bson:"opid"`
+	Command struct {
+		Comment interface{} `bson:"comment"`
+	} `bson:"command"`
+	Cursor struct {
+		OriginatingCommand struct {
+			Comment interface{} `bson:"comment"`
+		} `bson:"originatingCommand"`
+	} `bson:"cursor"`
+}
+
+// opsWithComment selects the operations started with a comment, or the getMore's for cursors started with that comment.
+// Comments are compared exactly, as every query of the plugin shares the same prefix.
+func opsWithComment(ops []currentOp, comment string) []interface{} {
+	selected := []interface{}{}
+	for _, op := range ops {
+		if op.Command.Comment == comment || op.Cursor.OriginatingCommand.Comment == comment {
+			selected = append(selected, op.OpID)
+		}
+	}
+	return selected
+}
+
+// killOpsWithComment finds all operations started with a comment (or getMore's for cursors started with that comment),
+// and kills them, returning how many were killed
+func killOpsWithComment(ctx context.Context, client *mongo.Client, comment string) (int, error) {
+	admin := client.Database("admin")
+	// Only the operations of this plugin are listed, and the exact comment is matched by opsWithComment
+	prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(queryCommentPrefix)}
+	cursor, err := admin.Aggregate(ctx, mongo.Pipeline{
+		bson.D{bson.E{Key: "$currentOp", Value: bson.D{}}},
+		bson.D{bson.E{Key: "$match", Value: bson.D{bson.E{Key: "$or", Value: bson.A{
+			bson.D{bson.E{Key: "command.comment", Value: prefix}},
+			bson.D{bson.E{Key: "cursor.originatingCommand.comment", Value: prefix}},
+		}}}}},
+		bson.D{bson.E{Key: "$project", Value: bson.D{
+			bson.E{Key: "opid", Value: 1},
+			bson.E{Key: "command.comment", Value: 1},
+			bson.E{Key: "cursor.originatingCommand.comment", Value: 1},
+		}}},
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to list current operations")
+	}
+	var ops []currentOp
+	err = cursor.All(ctx, &ops)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to list current operations")
+	}
+	killed := 0
+	for _, opID := range opsWithComment(ops, comment) {
+		err = admin.RunCommand(ctx, bson.D{bson.E{Key: "killOp", Value: 1}, bson.E{Key: "op", Value: opID}}).Err()
+		if err != nil {
+			return killed, errors.Wrap(err, "Failed to kill operation")
+		}
+		killed++
+	}
+	return killed, nil
+}
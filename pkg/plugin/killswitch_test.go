@@ -0,0 +1,49 @@
+package plugin
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Kill switch", func() {
+	// currentOps parses operations as they are reported by $currentOp
+	currentOps := func(docs ...bson.D) []currentOp {
+		ops := make([]currentOp, len(docs))
+		for ix, doc := range docs {
+			raw, err := bson.Marshal(doc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bson.Unmarshal(raw, &ops[ix])).To(Succeed())
+		}
+		return ops
+	}
+
+	It("Should tag each query with a unique comment", func() {
+		first := newQueryComment()
+		second := newQueryComment()
+		Expect(first).To(HavePrefix(queryCommentPrefix))
+		Expect(second).To(HavePrefix(queryCommentPrefix))
+		Expect(first).ToNot(Equal(second))
+	})
+
+	It("Should only select the operations of the query being killed", func() {
+		comment := newQueryComment()
+		other := newQueryComment()
+		ops := currentOps(
+			bson.D{{Key: "opid", Value: int32(1)}, {Key: "command", Value: bson.D{{Key: "aggregate", Value: "events"}, {Key: "comment", Value: comment}}}},
+			bson.D{{Key: "opid", Value: int32(2)}, {Key: "command", Value: bson.D{{Key: "getMore", Value: int64(42)}}},
+				{Key: "cursor", Value: bson.D{{Key: "originatingCommand", Value: bson.D{{Key: "comment", Value: comment}}}}}},
+			bson.D{{Key: "opid", Value: int32(3)}, {Key: "command", Value: bson.D{{Key: "aggregate", Value: "events"}, {Key: "comment", Value: other}}}},
+			bson.D{{Key: "opid", Value: int32(4)}, {Key: "command", Value: bson.D{{Key: "find", Value: "events"}, {Key: "comment", Value: comment + "0"}}}},
+			bson.D{{Key: "opid", Value: int32(5)}, {Key: "command", Value: bson.D{{Key: "find", Value: "events"}, {Key: "comment", Value: bson.D{{Key: "app", Value: comment}}}}}},
+			bson.D{{Key: "opid", Value: int32(6)}, {Key: "command", Value: bson.D{{Key: "insert", Value: "events"}}}},
+		)
+		Expect(opsWithComment(ops, comment)).To(Equal([]interface{}{int32(1), int32(2)}))
+	})
+
+	It("Should select nothing when the query has no operations left", func() {
+		ops := currentOps(bson.D{{Key: "opid", Value: int32(1)}, {Key: "command", Value: bson.D{{Key: "aggregate", Value: "events"}}}})
+		Expect(opsWithComment(ops, newQueryComment())).To(BeEmpty())
+	})
+})
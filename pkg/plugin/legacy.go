@@ -0,0 +1,28 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Queries saved with a version up to legacyQueryModelVersion are run by the legacy executor, which is the same query
+// path with every feature introduced by the current model switched off, so that existing dashboards keep producing
+// exactly the same frames. Features which change how a query is interpreted must call requireCurrentModel, rather than
+// silently changing the behavior of saved queries. Features which change how results are converted, such as cell
+// limits and the coercion of inferred types, are skipped for legacy queries instead, as is the typed interpolation of
+// multi-value variables by the frontend.
+
+// legacyQueryNotice is attached to every frame produced by the legacy executor
+var legacyQueryNotice = data.Notice{
+	Severity: data.NoticeSeverityInfo,
+	Text:     fmt.Sprintf("This query uses a deprecated version of the query model. Use the /migrate-query route to upgrade it to version %d", CurrentQueryModelVersion),
+}
+
+// requireCurrentModel returns an error if a feature which is only available in the current query model is used by a legacy query
+func (m *QueryModel) requireCurrentModel(feature string) error {
+	if !m.legacy {
+		return nil
+	}
+	return fmt.Errorf("%s requires query model version %d. Use the /migrate-query route to upgrade this query", feature, CurrentQueryModelVersion)
+}
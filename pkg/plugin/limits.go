@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// queryLimits bound the resources a single query may use. A zero value for any limit means unlimited.
+type queryLimits struct {
+	timeout  time.Duration
+	maxRows  int
+	maxBytes int64
+}
+
+// clampInt64 returns the requested value, unless it is unset or exceeds a non-zero maximum, in which case the maximum is returned
+func clampInt64(requested, max int64) int64 {
+	if max > 0 && (requested <= 0 || requested > max) {
+		return max
+	}
+	return requested
+}
+
+// getQueryLimits resolves the limits requested by a query against the maximums configured for the datasource.
+// Queries may lower, but never raise, the configured maximums.
+func (m *QueryModel) getQueryLimits(settings *datasource) (queryLimits, error) {
+	var err error
+	var requestedTimeout, maxTimeout time.Duration
+	if m.Timeout != "" {
+		requestedTimeout, err = time.ParseDuration(m.Timeout)
+		if err != nil {
+			return queryLimits{}, errors.Wrap(err, "Invalid query timeout")
+		}
+	}
+	if settings.MaxQueryTimeout != "" {
+		maxTimeout, err = time.ParseDuration(settings.MaxQueryTimeout)
+		if err != nil {
+			return queryLimits{}, errors.Wrap(err, "Invalid maximum query timeout in datasource settings")
+		}
+	}
+	return queryLimits{
+		timeout:  time.Duration(clampInt64(int64(requestedTimeout), int64(maxTimeout))),
+		maxRows:  int(clampInt64(int64(m.MaxRows), int64(settings.MaxQueryRows))),
+		maxBytes: clampInt64(m.MaxBytes, settings.MaxQueryBytes),
+	}, nil
+}
+
+// capToDataPoints lowers the row limit of a time series query without labels, which produces a single series,
+// to the max data points of the panel, as any more rows could not be displayed.
+// This only applies to pipelines which bucket by time, as the limit keeps the oldest rows, which would otherwise
+// drop the newest points of a raw series sorted by time.
+func (l *queryLimits) capToDataPoints(m *QueryModel, pipeline mongo.Pipeline, maxDataPoints int64) {
+	if m.QueryType != queryTypeTimeseries || len(m.LabelFields) != 0 || len(m.LabelsFrom) != 0 || maxDataPoints <= 0 {
+		return
+	}
+	if !bucketsByTime(pipeline) {
+		return
+	}
+	l.maxRows = int(clampInt64(int64(l.maxRows), maxDataPoints))
+}
+
+// bucketsByTime returns true if a pipeline groups documents by a truncated date, as $__timeGroup does
+func bucketsByTime(pipeline mongo.Pipeline) bool {
+	for _, stage := range pipeline {
+		if len(stage) != 1 || stage[0].Key != "$group" {
+			continue
+		}
+		spec, ok := stage[0].Value.(bson.D)
+		if !ok {
+			continue
+		}
+		for _, elem := range spec {
+			if elem.Key == "_id" && containsOperator(elem.Value, "$dateTrunc") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cursorLimits tracks the rows and bytes read from a cursor against a set of limits
+type cursorLimits struct {
+	queryLimits
+	rows      int
+	bytes     int64
+	truncated string
+}
+
+// admit returns true if a document read from the cursor is within the limits,
+// or false (and records why) if it would exceed them, in which case no further documents should be read
+func (l *cursorLimits) admit(doc bson.Raw) bool {
+	if l == nil {
+		return true
+	}
+	if l.maxRows > 0 && l.rows >= l.maxRows {
+		l.truncated = fmt.Sprintf("Results were truncated to the first %d rows", l.maxRows)
+		return false
+	}
+	if l.maxBytes > 0 && l.bytes+int64(len(doc)) > l.maxBytes {
+		l.truncated = fmt.Sprintf("Results were truncated to the first %d rows, as the next would exceed the limit of %d bytes", l.rows, l.maxBytes)
+		return false
+	}
+	l.rows++
+	l.bytes += int64(len(doc))
+	return true
+}
+
+func (l *cursorLimits) notice() *data.Notice {
+	if l == nil || l.truncated == "" {
+		return nil
+	}
+	return &data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     l.truncated,
+	}
+}
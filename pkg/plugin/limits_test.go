@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("clampInt64", func() {
+	DescribeTable("Should clamp", func(requested, max, expected int64) {
+		Expect(clampInt64(requested, max)).To(Equal(expected))
+	},
+		Entry("requests above the maximum to the maximum", int64(20), int64(10), int64(10)),
+		Entry("requests below the maximum to themselves", int64(5), int64(10), int64(5)),
+		Entry("missing requests to the maximum", int64(0), int64(10), int64(10)),
+		Entry("requests to themselves without a maximum", int64(20), int64(0), int64(20)),
+	)
+})
+
+var _ = Describe("getQueryLimits", func() {
+	capped := datasource{jsonData: jsonData{MaxQueryTimeout: "30s", MaxQueryRows: 1000, MaxQueryBytes: 1 << 20}}
+
+	DescribeTable("Should resolve", func(settings datasource, qm QueryModel, expected queryLimits) {
+		limits, err := qm.getQueryLimits(&settings)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(limits).To(Equal(expected))
+	},
+		Entry("the maximums when a query requests more", capped, QueryModel{Timeout: "1m", MaxRows: 5000, MaxBytes: 2 << 20},
+			queryLimits{timeout: 30 * time.Second, maxRows: 1000, maxBytes: 1 << 20}),
+		Entry("the requested limits when a query requests less", capped, QueryModel{Timeout: "10s", MaxRows: 10, MaxBytes: 1024},
+			queryLimits{timeout: 10 * time.Second, maxRows: 10, maxBytes: 1024}),
+		Entry("the maximums when a query requests nothing", capped, QueryModel{},
+			queryLimits{timeout: 30 * time.Second, maxRows: 1000, maxBytes: 1 << 20}),
+		Entry("the requested limits when there are no maximums", datasource{}, QueryModel{Timeout: "1m", MaxRows: 5000, MaxBytes: 2 << 20},
+			queryLimits{timeout: time.Minute, maxRows: 5000, maxBytes: 2 << 20}),
+		Entry("no limits when neither sets any", datasource{}, QueryModel{}, queryLimits{}),
+	)
+
+	It("Should reject invalid timeouts", func() {
+		_, err := (&QueryModel{Timeout: "soon"}).getQueryLimits(&capped)
+		Expect(err).To(MatchError(ContainSubstring("Invalid query timeout")))
+		invalid := datasource{jsonData: jsonData{MaxQueryTimeout: "soon"}}
+		_, err = (&QueryModel{}).getQueryLimits(&invalid)
+		Expect(err).To(MatchError(ContainSubstring("Invalid maximum query timeout")))
+	})
+})
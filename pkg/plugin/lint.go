@@ -0,0 +1,334 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	lintSeverityOff   = "off"
+	lintSeverityInfo  = "info"
+	lintSeverityWarn  = "warn"
+	lintSeverityBlock = "block"
+
+	lintRuleLeadingProject    = "leading-project"
+	lintRuleUnanchoredRegex   = "unanchored-regex"
+	lintRuleUnboundedLookup   = "unbounded-lookup"
+	lintRuleMissingTimeFilter = "missing-time-filter"
+)
+
+// LintContext describes the query a pipeline belongs to
+type LintContext struct {
+	// TimestampField is the field which the pipeline is expected to filter by time, if any
+	TimestampField string
+	// IndexedFields are the fields of the indexes of the collection. If nil, rules which depend on indexes are skipped.
+	IndexedFields []string
+}
+
+// LintFinding is a single problem found in a pipeline
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type lintRule struct {
+	id              string
+	defaultSeverity string
+	check           func(pipeline mongo.Pipeline, lctx *LintContext) []string
+}
+
+// lintRules are evaluated in order. Admins may change the severity of each by its id in the datasource settings.
+var lintRules = []lintRule{
+	{id: lintRuleLeadingProject, defaultSeverity: lintSeverityWarn, check: lintLeadingProject},
+	{id: lintRuleUnanchoredRegex, defaultSeverity: lintSeverityInfo, check: lintUnanchoredRegex},
+	{id: lintRuleUnboundedLookup, defaultSeverity: lintSeverityWarn, check: lintUnboundedLookup},
+	{id: lintRuleMissingTimeFilter, defaultSeverity: lintSeverityWarn, check: lintMissingTimeFilter},
+}
+
+// validateLintSeverities checks the severities configured for each rule
+func validateLintSeverities(severities map[string]string) error {
+	for id, severity := range severities {
+		known := false
+		for _, rule := range lintRules {
+			known = known || rule.id == id
+		}
+		if !known {
+			return fmt.Errorf("Unknown lint rule %s in datasource settings", id)
+		}
+		switch severity {
+		case lintSeverityOff, lintSeverityInfo, lintSeverityWarn, lintSeverityBlock:
+		default:
+			return fmt.Errorf("Lint rule %s severity must be one of: %s, %s, %s, %s", id, lintSeverityOff, lintSeverityInfo, lintSeverityWarn, lintSeverityBlock)
+		}
+	}
+	return nil
+}
+
+// LintPipeline evaluates every rule which is not turned off against a pipeline.
+// severities overrides the default severity of rules by their id.
+func LintPipeline(pipeline mongo.Pipeline, lctx LintContext, severities map[string]string) ([]LintFinding, error) {
+	err := validateLintSeverities(severities)
+	if err != nil {
+		return nil, err
+	}
+	findings := []LintFinding{}
+	for _, rule := range lintRules {
+		severity, ok := severities[rule.id]
+		if !ok {
+			severity = rule.defaultSeverity
+		}
+		if severity == lintSeverityOff {
+			continue
+		}
+		for _, message := range rule.check(pipeline, &lctx) {
+			findings = append(findings, LintFinding{Rule: rule.id, Severity: severity, Message: message})
+		}
+	}
+	return findings, nil
+}
+
+// lintNotices converts findings to notices, or, if any finding blocks the query, an error listing every blocking finding
+func lintNotices(findings []LintFinding) ([]data.Notice, error) {
+	notices := make([]data.Notice, 0, len(findings))
+	blocked := []string{}
+	for _, finding := range findings {
+		text := fmt.Sprintf("%s (%s)", finding.Message, finding.Rule)
+		switch finding.Severity {
+		case lintSeverityBlock:
+			blocked = append(blocked, text)
+		case lintSeverityWarn:
+			notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: text})
+		default:
+			notices = append(notices, data.Notice{Severity: data.NoticeSeverityInfo, Text: text})
+		}
+	}
+	if len(blocked) != 0 {
+		return nil, fmt.Errorf("Query was blocked by the lint rules of this datasource: %s", strings.Join(blocked, "; "))
+	}
+	return notices, nil
+}
+
+// lintQuery lints the effective pipeline of a query with the severities configured for the datasource.
+// Indexes are only listed if the pipeline starts with a $project, as only the leading-project rule needs them,
+// and never for Atlas Data Federation, which has no indexes.
+func lintQuery(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, qm *QueryModel, settings *datasource) ([]data.Notice, error) {
+	lctx := LintContext{}
+	if qm.QueryType == queryTypeTimeseries {
+		lctx.TimestampField = qm.TimestampField
+	}
+	if !settings.DataFederation && settings.LintRules[lintRuleLeadingProject] != lintSeverityOff && len(pipeline) != 0 && hasStage(pipeline[:1], "$project") {
+		fields, err := listIndexedFields(ctx, collection)
+		if err != nil {
+			log.DefaultLogger.Warn("Could not list indexes, skipping lint rules which depend on them", "error", err)
+		} else {
+			lctx.IndexedFields = fields
+		}
+	}
+	findings, err := LintPipeline(pipeline, lctx, settings.LintRules)
+	if err != nil {
+		return nil, err
+	}
+	return lintNotices(findings)
+}
+
+// listIndexedFields returns every field of every index of a collection, other than _id
+func listIndexedFields(ctx context.Context, collection *mongo.Collection) ([]string, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	seen := map[string]struct{}{}
+	fields := []string{}
+	for cursor.Next(ctx) {
+		var index indexSpec
+		err = cursor.Decode(&index)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range index.Key {
+			if _, ok := seen[key.Key]; ok || key.Key == "_id" {
+				continue
+			}
+			seen[key.Key] = struct{}{}
+			fields = append(fields, key.Key)
+		}
+	}
+	return fields, cursor.Err()
+}
+
+// projectionKeeps returns true if a field is passed through unchanged by a projection
+func projectionKeeps(projection bson.D, field string) bool {
+	exclusion := true
+	for _, elem := range projection {
+		if elem.Key == "_id" {
+			continue
+		}
+		if isProjectionFlag(elem.Value, true) || !isProjectionFlag(elem.Value, false) {
+			exclusion = false
+		}
+	}
+	for _, elem := range projection {
+		if elem.Key != field && !strings.HasPrefix(field, elem.Key+".") {
+			continue
+		}
+		if exclusion {
+			return false
+		}
+		return isProjectionFlag(elem.Value, true)
+	}
+	return exclusion
+}
+
+// isProjectionFlag returns true if a projection value is a literal inclusion (1 or true) or exclusion (0 or false)
+func isProjectionFlag(value interface{}, include bool) bool {
+	switch v := value.(type) {
+	case bool:
+		return v == include
+	case int32:
+		return (v != 0) == include
+	case int64:
+		return (v != 0) == include
+	case float64:
+		return (v != 0) == include
+	}
+	return false
+}
+
+func lintLeadingProject(pipeline mongo.Pipeline, lctx *LintContext) []string {
+	if lctx.IndexedFields == nil || len(pipeline) == 0 || !hasStage(pipeline[:1], "$project") {
+		return nil
+	}
+	projection, ok := pipeline[0][0].Value.(bson.D)
+	if !ok {
+		return nil
+	}
+	removed := []string{}
+	for _, field := range lctx.IndexedFields {
+		if !projectionKeeps(projection, field) {
+			removed = append(removed, field)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	sort.Strings(removed)
+	return []string{fmt.Sprintf("The leading $project removes or replaces the indexed field(s) %s, so later stages cannot use their indexes", strings.Join(removed, ", "))}
+}
+
+// walkFilter calls visit for every field and value in a filter, including those nested in logical operators
+func walkFilter(filter interface{}, field string, visit func(field string, key string, value interface{})) {
+	switch v := filter.(type) {
+	case bson.D:
+		for _, elem := range v {
+			if strings.HasPrefix(elem.Key, "$") {
+				visit(field, elem.Key, elem.Value)
+				walkFilter(elem.Value, field, visit)
+				continue
+			}
+			visit(elem.Key, "", elem.Value)
+			walkFilter(elem.Value, elem.Key, visit)
+		}
+	case bson.A:
+		for _, elem := range v {
+			visit(field, "", elem)
+			walkFilter(elem, field, visit)
+		}
+	}
+}
+
+func isAnchored(pattern string) bool {
+	return strings.HasPrefix(pattern, "^") || strings.HasPrefix(pattern, `\A`)
+}
+
+func lintUnanchoredRegex(pipeline mongo.Pipeline, lctx *LintContext) []string {
+	messages := []string{}
+	for _, stage := range pipeline {
+		if !hasStage(mongo.Pipeline{stage}, "$match") {
+			continue
+		}
+		walkFilter(stage[0].Value, "", func(field, key string, value interface{}) {
+			pattern := ""
+			switch v := value.(type) {
+			case bsonPrim.Regex:
+				pattern = v.Pattern
+			case string:
+				if key != "$regex" {
+					return
+				}
+				pattern = v
+			default:
+				return
+			}
+			if !isAnchored(pattern) {
+				messages = append(messages, fmt.Sprintf("The regular expression /%s/ on %s is not anchored with ^, so it cannot use an index efficiently", pattern, field))
+			}
+		})
+	}
+	return messages
+}
+
+func lintUnboundedLookup(pipeline mongo.Pipeline, lctx *LintContext) []string {
+	messages := []string{}
+	for _, stage := range pipeline {
+		if !hasStage(mongo.Pipeline{stage}, "$lookup") {
+			continue
+		}
+		lookup, ok := stage[0].Value.(bson.D)
+		if !ok {
+			continue
+		}
+		from, as := "", ""
+		var subPipeline mongo.Pipeline
+		for _, elem := range lookup {
+			switch elem.Key {
+			case "from":
+				from, _ = elem.Value.(string)
+			case "as":
+				as, _ = elem.Value.(string)
+			case "pipeline":
+				if stages, ok := elem.Value.(bson.A); ok {
+					for _, sub := range stages {
+						if doc, ok := sub.(bson.D); ok {
+							subPipeline = append(subPipeline, doc)
+						}
+					}
+				}
+			}
+		}
+		if !hasStage(subPipeline, "$limit") {
+			messages = append(messages, fmt.Sprintf("The $lookup from %s into %s has no $limit, so each document may join an unbounded number of documents", from, as))
+		}
+	}
+	return messages
+}
+
+func lintMissingTimeFilter(pipeline mongo.Pipeline, lctx *LintContext) []string {
+	if lctx.TimestampField == "" {
+		return nil
+	}
+	filtered := false
+	for _, stage := range pipeline {
+		if !hasStage(mongo.Pipeline{stage}, "$match") {
+			continue
+		}
+		walkFilter(stage[0].Value, "", func(field, key string, value interface{}) {
+			if field == lctx.TimestampField || value == "$"+lctx.TimestampField {
+				filtered = true
+			}
+		})
+	}
+	if filtered {
+		return nil
+	}
+	return []string{fmt.Sprintf("No $match filters on the timestamp field %s, so the query reads the entire collection regardless of the dashboard time range", lctx.TimestampField)}
+}
@@ -0,0 +1,95 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LintPipeline", func() {
+	parse := func(text string) mongo.Pipeline {
+		pipeline := mongo.Pipeline{}
+		Expect(bson.UnmarshalExtJSON([]byte(text), false, &pipeline)).To(Succeed())
+		return pipeline
+	}
+	rules := func(findings []plugin.LintFinding) []string {
+		ids := make([]string, len(findings))
+		for ix, finding := range findings {
+			ids[ix] = finding.Rule
+		}
+		return ids
+	}
+
+	DescribeTable("Should find", func(pipeline string, lctx plugin.LintContext, expected []string) {
+		findings, err := plugin.LintPipeline(parse(pipeline), lctx, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rules(findings)).To(Equal(expected))
+	},
+		Entry("a leading inclusion $project dropping an indexed field",
+			`[{"$project": {"name": 1, "_id": 0}}, {"$match": {"host": "a"}}]`,
+			plugin.LintContext{IndexedFields: []string{"host", "name"}},
+			[]string{"leading-project"},
+		),
+		Entry("nothing for a leading exclusion $project keeping indexed fields",
+			`[{"$project": {"payload": 0}}]`,
+			plugin.LintContext{IndexedFields: []string{"host"}},
+			[]string{},
+		),
+		Entry("a leading exclusion $project dropping an indexed field",
+			`[{"$project": {"host": 0}}]`,
+			plugin.LintContext{IndexedFields: []string{"host"}},
+			[]string{"leading-project"},
+		),
+		Entry("nothing for a leading $project when indexes are unknown",
+			`[{"$project": {"name": 1}}]`,
+			plugin.LintContext{},
+			[]string{},
+		),
+		Entry("unanchored regular expressions, in operators and literals",
+			`[{"$match": {"$or": [{"a": {"$regex": "foo"}}, {"b": {"$regularExpression": {"pattern": "bar", "options": ""}}}, {"c": {"$regex": "^ok"}}]}}]`,
+			plugin.LintContext{},
+			[]string{"unanchored-regex", "unanchored-regex"},
+		),
+		Entry("a $lookup without a limit",
+			`[{"$lookup": {"from": "b", "localField": "x", "foreignField": "y", "as": "joined"}}]`,
+			plugin.LintContext{},
+			[]string{"unbounded-lookup"},
+		),
+		Entry("nothing for a $lookup with a limit",
+			`[{"$lookup": {"from": "b", "pipeline": [{"$limit": 10}], "as": "joined"}}]`,
+			plugin.LintContext{},
+			[]string{},
+		),
+		Entry("a missing time filter",
+			`[{"$match": {"host": "a"}}]`,
+			plugin.LintContext{TimestampField: "ts"},
+			[]string{"missing-time-filter"},
+		),
+		Entry("nothing for a time filter in an expression",
+			`[{"$match": {"$expr": {"$gte": ["$ts", 0]}}}]`,
+			plugin.LintContext{TimestampField: "ts"},
+			[]string{},
+		),
+	)
+
+	It("Should apply configured severities", func() {
+		findings, err := plugin.LintPipeline(parse(`[{"$match": {"a": {"$regex": "x"}}}]`), plugin.LintContext{TimestampField: "ts"}, map[string]string{
+			"unanchored-regex":    "block",
+			"missing-time-filter": "off",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Severity).To(Equal("block"))
+	})
+
+	It("Should reject unknown rules and severities", func() {
+		_, err := plugin.LintPipeline(mongo.Pipeline{}, plugin.LintContext{}, map[string]string{"no-such-rule": "warn"})
+		Expect(err).To(HaveOccurred())
+		_, err = plugin.LintPipeline(mongo.Pipeline{}, plugin.LintContext{}, map[string]string{"unbounded-lookup": "fatal"})
+		Expect(err).To(HaveOccurred())
+	})
+})
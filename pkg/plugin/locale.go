@@ -0,0 +1,14 @@
+package plugin
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+func (m *QueryModel) getLocaleOptions() (bsonframe.LocaleOptions, error) {
+	opts := bsonframe.LocaleOptions{DecimalSeparator: m.DecimalSeparator, DateFormat: m.DateFormat}
+	err := opts.Validate()
+	if err != nil {
+		return bsonframe.LocaleOptions{}, err
+	}
+	return opts, nil
+}
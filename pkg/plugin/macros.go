@@ -0,0 +1,558 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// macroPrefix begins every macro in an aggregation pipeline
+const macroPrefix = "$__"
+
+// MacroContext is the information about a query available to macros
+type MacroContext struct {
+	From time.Time
+	To   time.Time
+	// Interval is the interval between points suggested by the panel, or zero if not known
+	Interval time.Duration
+	// MaxDataPoints is the most points the panel can display, or zero if not known. See bucketInterval.
+	MaxDataPoints int64
+	// Snippets are the saved snippets which may be included by name. See ExpandIncludes.
+	Snippets map[string]string
+}
+
+// macroFunc expands a macro, given its raw (but trimmed) arguments, into ExtJSON
+type macroFunc func(mctx *MacroContext, args []string) (string, error)
+
+// macros are the macros which may be used in aggregation pipelines, by name, without the leading $__
+var macros = map[string]macroFunc{
+	"contains":   containsMacro,
+	"startsWith": startsWithMacro,
+	"densify":    densifyMacro,
+	"percentile": percentileMacro,
+	"timeFilter": timeFilterMacro,
+	"timeFrom":   timeFromMacro,
+	"timeTo":     timeToMacro,
+
+	"interval":    intervalMacro,
+	"interval_ms": intervalMSMacro,
+	"timeGroup":   timeGroupMacro,
+}
+
+// ExpandMacros replaces all macros in the text of an aggregation pipeline with their expansions.
+// Macros are expanded after Grafana has interpolated variables, which use the JSON format,
+// so arguments are typically either JSON literals or bare field names.
+func ExpandMacros(text string, mctx MacroContext) (string, error) {
+	if !strings.Contains(text, macroPrefix) {
+		return text, nil
+	}
+	text, err := ExpandIncludes(text, mctx.Snippets)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	rest := text
+	for {
+		start := strings.Index(rest, macroPrefix)
+		if start == -1 {
+			out.WriteString(rest)
+			return out.String(), nil
+		}
+		out.WriteString(rest[:start])
+		rest = rest[start+len(macroPrefix):]
+		nameLen := 0
+		for nameLen < len(rest) && isMacroNameChar(rest[nameLen]) {
+			nameLen++
+		}
+		name := rest[:nameLen]
+		rest = rest[nameLen:]
+		macro, ok := macros[name]
+		if !ok {
+			return "", fmt.Errorf("Unknown macro %s%s", macroPrefix, name)
+		}
+		var args []string
+		if strings.HasPrefix(rest, "(") {
+			var argsLen int
+			var err error
+			args, argsLen, err = splitMacroArgs(rest)
+			if err != nil {
+				return "", errors.Wrap(err, fmt.Sprintf("Invalid arguments to %s%s", macroPrefix, name))
+			}
+			rest = rest[argsLen:]
+		}
+		expanded, err := macro(&mctx, args)
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("Failed to expand %s%s", macroPrefix, name))
+		}
+		out.WriteString(expanded)
+	}
+}
+
+func isMacroNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// splitMacroArgs splits a parenthesized argument list at its top-level commas, ignoring any commas within
+// nested brackets or string literals. It returns the trimmed arguments, and the length of the list including parentheses.
+func splitMacroArgs(text string) ([]string, int, error) {
+	args := []string{}
+	depth := 0
+	inString := false
+	argStart := 1
+	for ix := 0; ix < len(text); ix++ {
+		c := text[ix]
+		if inString {
+			switch c {
+			case '\\':
+				ix++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '(', '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ')':
+			depth--
+			if depth == 0 {
+				arg := strings.TrimSpace(text[argStart:ix])
+				if arg != "" || len(args) != 0 {
+					args = append(args, arg)
+				}
+				return args, ix + 1, nil
+			}
+		case ',':
+			if depth == 1 {
+				args = append(args, strings.TrimSpace(text[argStart:ix]))
+				argStart = ix + 1
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("Unterminated argument list")
+}
+
+// macroFieldArg interprets a macro argument which names a document field, which may be quoted, and may have a leading $
+func macroFieldArg(arg string) (string, error) {
+	field := arg
+	if strings.HasPrefix(arg, `"`) {
+		err := json.Unmarshal([]byte(arg), &field)
+		if err != nil {
+			return "", errors.Wrap(err, "Invalid field name")
+		}
+	}
+	field = strings.TrimPrefix(field, "$")
+	if field == "" {
+		return "", fmt.Errorf("Field name cannot be empty")
+	}
+	return field, nil
+}
+
+// macroStringsArg interprets a macro argument as a list of strings.
+// JSON arrays, such as those produced by multi-value variables, produce one string per element, with ObjectIDs as their hex strings,
+// other JSON literals produce a single string, and anything which is not valid JSON is used verbatim.
+func macroStringsArg(arg string) []string {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	decoder.UseNumber()
+	if decoder.Decode(&value) != nil || decoder.More() {
+		return []string{arg}
+	}
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	strs := make([]string, len(values))
+	for ix, value := range values {
+		switch value := value.(type) {
+		case string:
+			strs[ix] = value
+		case nil:
+			strs[ix] = ""
+		case map[string]interface{}:
+			// Multi-value variables format ObjectIDs as extended JSON
+			if oid, ok := value["$oid"].(string); ok && len(value) == 1 {
+				strs[ix] = oid
+				break
+			}
+			strs[ix] = fmt.Sprint(value)
+		default:
+			strs[ix] = fmt.Sprint(value)
+		}
+	}
+	return strs
+}
+
+// regexMatchMacro produces a filter which matches documents where a field matches any of the values of an argument,
+// each of which is escaped, so that user input cannot alter the meaning of the regular expression
+func regexMatchMacro(args []string, anchored bool) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("Expected 2 arguments (field, value), got %d", len(args))
+	}
+	field, err := macroFieldArg(args[0])
+	if err != nil {
+		return "", err
+	}
+	values := macroStringsArg(args[1])
+	patterns := make([]string, len(values))
+	for ix, value := range values {
+		patterns[ix] = regexp.QuoteMeta(value)
+		if anchored {
+			// Anchored prefix expressions can use an index
+			patterns[ix] = "^" + patterns[ix]
+		}
+	}
+	var condition interface{}
+	if len(patterns) == 1 {
+		condition = map[string]interface{}{"$regex": patterns[0]}
+	} else {
+		regexes := make([]interface{}, len(patterns))
+		for ix, pattern := range patterns {
+			regexes[ix] = map[string]interface{}{
+				"$regularExpression": map[string]string{"pattern": pattern, "options": ""},
+			}
+		}
+		condition = map[string]interface{}{"$in": regexes}
+	}
+	filter, err := json.Marshal(map[string]interface{}{field: condition})
+	if err != nil {
+		return "", err
+	}
+	return string(filter), nil
+}
+
+// containsMacro implements $__contains(field, value), matching documents where the field contains the value
+func containsMacro(_ *MacroContext, args []string) (string, error) {
+	return regexMatchMacro(args, false)
+}
+
+// startsWithMacro implements $__startsWith(field, value), matching documents where the field starts with the value
+func startsWithMacro(_ *MacroContext, args []string) (string, error) {
+	return regexMatchMacro(args, true)
+}
+
+// macroDate formats a time as an extended JSON date, with millisecond precision
+func macroDate(t time.Time) map[string]interface{} {
+	return map[string]interface{}{"$date": map[string]string{"$numberLong": strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)}}
+}
+
+// requireTimeRange checks that the time range is known, so that time macros never expand to the zero time,
+// such as for materialized views, which have no time range
+func (mctx *MacroContext) requireTimeRange() error {
+	if mctx.From.IsZero() && mctx.To.IsZero() {
+		return fmt.Errorf("The time range is not known")
+	}
+	return nil
+}
+
+// timeFilterMacro implements $__timeFilter(field), a filter matching documents where the field is within the panel time range,
+// including the start, and excluding the end
+func timeFilterMacro(mctx *MacroContext, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("Expected 1 argument (field), got %d", len(args))
+	}
+	err := mctx.requireTimeRange()
+	if err != nil {
+		return "", err
+	}
+	field, err := macroFieldArg(args[0])
+	if err != nil {
+		return "", err
+	}
+	filter, err := json.Marshal(map[string]interface{}{
+		field: map[string]interface{}{
+			"$gte": macroDate(mctx.From),
+			"$lt":  macroDate(mctx.To),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(filter), nil
+}
+
+const (
+	timeFormatDate = "date"
+	timeFormatMS   = "ms"
+	timeFormatISO  = "iso"
+)
+
+// timeMacro formats a bound of the time range, either as an extended JSON date (the default), milliseconds since the epoch,
+// or an ISO-8601 string, as timestamps may be stored in any of these forms
+func timeMacro(t time.Time, args []string) (string, error) {
+	if len(args) > 1 {
+		return "", fmt.Errorf("Expected at most 1 argument (format), got %d", len(args))
+	}
+	format := timeFormatDate
+	if len(args) == 1 {
+		formats := macroStringsArg(args[0])
+		if len(formats) != 1 {
+			return "", fmt.Errorf("Expected a single format, got %s", args[0])
+		}
+		format = formats[0]
+	}
+	var value interface{}
+	switch format {
+	case timeFormatDate:
+		value = macroDate(t)
+	case timeFormatMS:
+		value = t.UnixNano() / int64(time.Millisecond)
+	case timeFormatISO:
+		value = t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	default:
+		return "", fmt.Errorf("Time format must be one of: %s, %s, %s", timeFormatDate, timeFormatMS, timeFormatISO)
+	}
+	formatted, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+// timeFromMacro implements $__timeFrom or $__timeFrom(format), the start of the panel time range. See timeMacro.
+func timeFromMacro(mctx *MacroContext, args []string) (string, error) {
+	err := mctx.requireTimeRange()
+	if err != nil {
+		return "", err
+	}
+	return timeMacro(mctx.From, args)
+}
+
+// timeToMacro implements $__timeTo or $__timeTo(format), the end of the panel time range. See timeMacro.
+func timeToMacro(mctx *MacroContext, args []string) (string, error) {
+	err := mctx.requireTimeRange()
+	if err != nil {
+		return "", err
+	}
+	return timeMacro(mctx.To, args)
+}
+
+// bucketInterval is the panel interval, widened if needed so that the time range has at most MaxDataPoints buckets.
+// Buckets are aligned to the interval, so the first and last are usually partial, and the range is divided into
+// one less than MaxDataPoints whole buckets to leave room for them. If the panel interval is not known, the
+// interval is derived from MaxDataPoints alone.
+func (mctx *MacroContext) bucketInterval() time.Duration {
+	interval := mctx.Interval
+	if mctx.MaxDataPoints <= 0 || !mctx.To.After(mctx.From) {
+		return interval
+	}
+	wholeBuckets := mctx.MaxDataPoints
+	if wholeBuckets > 1 {
+		wholeBuckets--
+	}
+	minInterval := mctx.To.Sub(mctx.From) / time.Duration(wholeBuckets)
+	if interval < minInterval {
+		// Round up to a whole millisecond, as bucket sizes are given in milliseconds
+		interval = (minInterval + time.Millisecond - 1).Truncate(time.Millisecond)
+	}
+	return interval
+}
+
+// intervalUnit is a unit of time, with its suffix as formatted by Grafana, and its name as a $dateTrunc unit
+type intervalUnit struct {
+	suffix string
+	name   string
+	size   time.Duration
+}
+
+var intervalUnits = []intervalUnit{
+	{"d", "day", 24 * time.Hour},
+	{"h", "hour", time.Hour},
+	{"m", "minute", time.Minute},
+	{"s", "second", time.Second},
+	{"ms", "millisecond", time.Millisecond},
+}
+
+// largestIntervalUnit returns the largest unit which an interval is a whole number of, and that number
+func largestIntervalUnit(interval time.Duration) (intervalUnit, int64) {
+	for _, unit := range intervalUnits {
+		if interval >= unit.size && interval%unit.size == 0 {
+			return unit, int64(interval / unit.size)
+		}
+	}
+	unit := intervalUnits[len(intervalUnits)-1]
+	return unit, int64(interval / unit.size)
+}
+
+// FormatInterval formats an interval as Grafana does, in the largest unit which it is a whole number of, e.g. 30s, 5m, or 1d
+func FormatInterval(interval time.Duration) string {
+	unit, count := largestIntervalUnit(interval)
+	return strconv.FormatInt(count, 10) + unit.suffix
+}
+
+// intervalMacro implements $__interval, the interval suggested by the panel as a duration string, e.g. "5m".
+// Like $__interval_ms, this is widened if needed so that the time range has at most the max data points of the panel.
+func intervalMacro(mctx *MacroContext, args []string) (string, error) {
+	if len(args) != 0 {
+		return "", fmt.Errorf("Expected no arguments, got %d", len(args))
+	}
+	if mctx.Interval <= 0 {
+		return "", fmt.Errorf("The panel interval is not known")
+	}
+	return strconv.Quote(FormatInterval(mctx.bucketInterval())), nil
+}
+
+// intervalMSMacro implements $__interval_ms, the interval suggested by the panel in milliseconds,
+// e.g. for the binSize of $dateTrunc with a unit of millisecond
+func intervalMSMacro(mctx *MacroContext, args []string) (string, error) {
+	if len(args) != 0 {
+		return "", fmt.Errorf("Expected no arguments, got %d", len(args))
+	}
+	if mctx.Interval <= 0 {
+		return "", fmt.Errorf("The panel interval is not known")
+	}
+	return strconv.FormatInt(int64(mctx.bucketInterval()/time.Millisecond), 10), nil
+}
+
+// timeGroupMacro implements $__timeGroup(timeField), which expands to a $dateTrunc expression for use as the _id of a
+// $group stage, which truncates the time field to buckets of the panel interval. The interval is widened as needed,
+// so that no more buckets are returned than the panel can display. See bucketInterval.
+func timeGroupMacro(mctx *MacroContext, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("Expected 1 argument (timeField), got %d", len(args))
+	}
+	timeField, err := macroFieldArg(args[0])
+	if err != nil {
+		return "", err
+	}
+	interval := mctx.bucketInterval()
+	if interval < time.Millisecond {
+		return "", fmt.Errorf("The panel interval is not known")
+	}
+	unit, binSize := largestIntervalUnit(interval)
+	expr, err := json.Marshal(map[string]interface{}{
+		"$dateTrunc": map[string]interface{}{
+			"date":    "$" + timeField,
+			"unit":    unit.name,
+			"binSize": binSize,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(expr), nil
+}
+
+const (
+	fillModeLinear = "linear"
+	fillModeLOCF   = "locf"
+)
+
+// densifyMacro implements $__densify(timeField, mode, field...), which expands to a $densify stage that adds a document
+// for each panel interval in the time range with no data, followed by a $fill stage that fills the given fields of the added documents.
+// mode is either "linear" (interpolate), "locf" (repeat the last observation), or a JSON literal to fill with, such as 0.
+// As this expands to two stages, it must be used directly within the pipeline array. Requires MongoDB 5.1 or later.
+func densifyMacro(mctx *MacroContext, args []string) (string, error) {
+	if len(args) < 3 {
+		return "", fmt.Errorf("Expected at least 3 arguments (timeField, mode, field...), got %d", len(args))
+	}
+	err := mctx.requireTimeRange()
+	if err != nil {
+		return "", err
+	}
+	if mctx.Interval <= 0 {
+		return "", fmt.Errorf("The panel interval is not known")
+	}
+	timeField, err := macroFieldArg(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	var method interface{}
+	mode := macroStringsArg(args[1])
+	switch {
+	case len(mode) == 1 && mode[0] == fillModeLinear:
+		method = map[string]string{"method": fillModeLinear}
+	case len(mode) == 1 && mode[0] == fillModeLOCF:
+		method = map[string]string{"method": fillModeLOCF}
+	default:
+		var value interface{}
+		if json.Unmarshal([]byte(args[1]), &value) != nil {
+			return "", fmt.Errorf("Fill mode must be %s, %s, or a JSON value, got %s", fillModeLinear, fillModeLOCF, args[1])
+		}
+		method = map[string]json.RawMessage{"value": json.RawMessage(args[1])}
+	}
+	output := make(map[string]interface{}, len(args)-2)
+	for _, arg := range args[2:] {
+		field, err := macroFieldArg(arg)
+		if err != nil {
+			return "", err
+		}
+		output[field] = method
+	}
+
+	// $densify steps from the lower bound, so align it to the interval for consistent buckets
+	step := mctx.bucketInterval().Milliseconds()
+	if step <= 0 {
+		step = 1
+	}
+	from := mctx.From.Truncate(time.Duration(step) * time.Millisecond)
+	densify, err := json.Marshal(map[string]interface{}{
+		"$densify": map[string]interface{}{
+			"field": timeField,
+			"range": map[string]interface{}{
+				"step": step,
+				"unit": "millisecond",
+				"bounds": []interface{}{
+					macroDate(from),
+					macroDate(mctx.To),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	fill, err := json.Marshal(map[string]interface{}{
+		"$fill": map[string]interface{}{
+			"sortBy": map[string]int{timeField: 1},
+			"output": output,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(densify) + "," + string(fill), nil
+}
+
+// percentileMarker is the placeholder accumulator produced by $__percentile.
+// It is replaced once the server version is known, see RewritePercentiles.
+const percentileMarker = "$__percentile"
+
+// percentileMacro implements $__percentile(field, p), an accumulator for use in $group which produces the p-th percentile
+// (between 0 and 1) of a numeric field as a single number. It expands to a placeholder, which is replaced by $percentile
+// on MongoDB 7.0 or later, and an approximation using $sort and $push on older servers.
+func percentileMacro(_ *MacroContext, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("Expected 2 arguments (field, p), got %d", len(args))
+	}
+	field, err := macroFieldArg(args[0])
+	if err != nil {
+		return "", err
+	}
+	ps := macroStringsArg(args[1])
+	if len(ps) != 1 {
+		return "", fmt.Errorf("Expected a single percentile, got %s", args[1])
+	}
+	p, err := strconv.ParseFloat(ps[0], 64)
+	if err != nil || p < 0 || p > 1 {
+		return "", fmt.Errorf("Percentile must be a number between 0 and 1, got %s", args[1])
+	}
+	marker, err := json.Marshal(map[string]interface{}{
+		percentileMarker: map[string]interface{}{"input": "$" + field, "p": p},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(marker), nil
+}
@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExpandMacros", func() {
+	mctx := MacroContext{From: time.Unix(90, 0), To: time.Unix(3600, 0), Interval: time.Minute}
+
+	DescribeTable("Should expand", func(text, expected string) {
+		expanded, err := ExpandMacros(text, mctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expanded).To(MatchJSON(expected))
+	},
+		Entry("contains with a quoted value",
+			`[{"$match": $__contains(name, "a.b")}]`,
+			`[{"$match": {"name": {"$regex": "a\\.b"}}}]`,
+		),
+		Entry("startsWith with a quoted field and regex metacharacters",
+			`[{"$match": $__startsWith("$host", "web-(1)*")}]`,
+			`[{"$match": {"host": {"$regex": "^web-\\(1\\)\\*"}}}]`,
+		),
+		Entry("contains with a multi-value variable",
+			`[{"$match": $__contains(name, ["a", "b,c"])}]`,
+			`[{"$match": {"name": {"$in": [
+				{"$regularExpression": {"pattern": "a", "options": ""}},
+				{"$regularExpression": {"pattern": "b,c", "options": ""}}
+			]}}}]`,
+		),
+		Entry("contains with a typed multi-value variable",
+			`[{"$match": $__contains(name, [1, {"$oid": "5f1d7b2e9c3a4b0012345678"}])}]`,
+			`[{"$match": {"name": {"$in": [
+				{"$regularExpression": {"pattern": "1", "options": ""}},
+				{"$regularExpression": {"pattern": "5f1d7b2e9c3a4b0012345678", "options": ""}}
+			]}}}]`,
+		),
+		Entry("timeFilter",
+			`[{"$match": $__timeFilter(ts)}]`,
+			`[{"$match": {"ts": {
+				"$gte": {"$date": {"$numberLong": "90000"}},
+				"$lt": {"$date": {"$numberLong": "3600000"}}
+			}}}]`,
+		),
+		Entry("timeFrom and timeTo in every format",
+			`[{"$match": {"a": {"$gte": $__timeFrom}, "b": {"$lt": $__timeTo(date)}, "c": $__timeFrom(ms), "d": $__timeTo("iso")}}]`,
+			`[{"$match": {
+				"a": {"$gte": {"$date": {"$numberLong": "90000"}}},
+				"b": {"$lt": {"$date": {"$numberLong": "3600000"}}},
+				"c": 90000,
+				"d": "1970-01-01T01:00:00.000Z"
+			}}]`,
+		),
+		Entry("interval and interval_ms",
+			`[{"$group": {"_id": {"$dateTrunc": {"date": "$ts", "unit": "millisecond", "binSize": $__interval_ms}}, "interval": {"$first": $__interval}}}]`,
+			`[{"$group": {"_id": {"$dateTrunc": {"date": "$ts", "unit": "millisecond", "binSize": 60000}}, "interval": {"$first": "1m"}}}]`,
+		),
+		Entry("timeGroup",
+			`[{"$group": {"_id": $__timeGroup(ts), "n": {"$sum": 1}}}]`,
+			`[{"$group": {"_id": {"$dateTrunc": {"date": "$ts", "unit": "minute", "binSize": 1}}, "n": {"$sum": 1}}}]`,
+		),
+		Entry("contains with an unquoted value",
+			`[{"$match": $__contains(name, foo)}]`,
+			`[{"$match": {"name": {"$regex": "foo"}}}]`,
+		),
+		Entry("densify with linear fill",
+			`[$__densify(ts, linear, a, b)]`,
+			`[
+				{"$densify": {"field": "ts", "range": {"step": 60000, "unit": "millisecond", "bounds": [
+					{"$date": {"$numberLong": "60000"}},
+					{"$date": {"$numberLong": "3600000"}}
+				]}}},
+				{"$fill": {"sortBy": {"ts": 1}, "output": {"a": {"method": "linear"}, "b": {"method": "linear"}}}}
+			]`,
+		),
+		Entry("densify with a constant fill",
+			`[$__densify(ts, 0, a)]`,
+			`[
+				{"$densify": {"field": "ts", "range": {"step": 60000, "unit": "millisecond", "bounds": [
+					{"$date": {"$numberLong": "60000"}},
+					{"$date": {"$numberLong": "3600000"}}
+				]}}},
+				{"$fill": {"sortBy": {"ts": 1}, "output": {"a": {"value": 0}}}}
+			]`,
+		),
+		Entry("percentile",
+			`[{"$group": {"_id": null, "p95": $__percentile(latency, 0.95)}}]`,
+			`[{"$group": {"_id": null, "p95": {"$__percentile": {"input": "$latency", "p": 0.95}}}}]`,
+		),
+	)
+
+	It("Should leave text without macros unchanged", func() {
+		Expect(ExpandMacros(`[{"$match": {"x": "$y"}}]`, mctx)).To(Equal(`[{"$match": {"x": "$y"}}]`))
+	})
+
+	It("Should reject unknown macros", func() {
+		_, err := ExpandMacros(`[{"$match": $__nope(x)}]`, mctx)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should reject time filters without a time range", func() {
+		_, err := ExpandMacros(`[{"$match": $__timeFilter(ts)}]`, MacroContext{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("Should reject time bounds without a time range", func(text string) {
+		_, err := ExpandMacros(text, MacroContext{Interval: time.Minute})
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("timeFrom", `[{"$match": {"ts": {"$gte": $__timeFrom}}}]`),
+		Entry("timeTo", `[{"$match": {"ts": {"$lt": $__timeTo(ms)}}}]`),
+		Entry("densify", `[$__densify(ts, 0, a)]`),
+	)
+
+	It("Should reject intervals when the panel interval is not known", func() {
+		_, err := ExpandMacros(`[{"$limit": $__interval_ms}]`, MacroContext{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should widen intervals to fit the max data points", func() {
+		capped := mctx
+		capped.MaxDataPoints = 10
+		expanded, err := ExpandMacros(`[{"$limit": $__interval_ms}, {"$sort": {"x": $__interval}}]`, capped)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expanded).To(MatchJSON(`[{"$limit": 390000}, {"$sort": {"x": "390s"}}]`))
+	})
+
+	It("Should size time groups from max data points alone", func() {
+		expanded, err := ExpandMacros(`{"x": $__timeGroup(ts)}`, MacroContext{From: time.Unix(0, 0), To: time.Unix(7200, 0), MaxDataPoints: 3})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expanded).To(MatchJSON(`{"x": {"$dateTrunc": {"date": "$ts", "unit": "hour", "binSize": 1}}}`))
+	})
+
+	DescribeTable("Should format intervals in their largest whole unit", func(interval time.Duration, expected string) {
+		Expect(FormatInterval(interval)).To(Equal(expected))
+	},
+		Entry("milliseconds", 500*time.Millisecond, "500ms"),
+		Entry("seconds", 30*time.Second, "30s"),
+		Entry("minutes", 90*time.Minute, "90m"),
+		Entry("days", 48*time.Hour, "2d"),
+	)
+
+	It("Should reject unknown time formats", func() {
+		_, err := ExpandMacros(`[{"$match": {"a": $__timeFrom(seconds)}}]`, mctx)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should reject the wrong number of arguments", func() {
+		_, err := ExpandMacros(`[{"$match": $__contains(x)}]`, mctx)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("bucketsByTime", func() {
+	mctx := MacroContext{From: time.Unix(0, 0), To: time.Unix(3600, 0), Interval: time.Minute}
+
+	DescribeTable("Should recognize", func(stages string, expected bool) {
+		pipeline, err := buildPipeline([]byte(`{"aggregation": `+stages+`}`), mctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bucketsByTime(pipeline)).To(Equal(expected))
+	},
+		Entry("time groups", `"[{\"$group\": {\"_id\": $__timeGroup(ts), \"n\": {\"$sum\": 1}}}]"`, true),
+		Entry("time groups within compound keys", `"[{\"$group\": {\"_id\": {\"t\": $__timeGroup(ts), \"h\": \"$host\"}}}]"`, true),
+		Entry("groups by other fields", `"[{\"$group\": {\"_id\": \"$host\", \"n\": {\"$sum\": 1}}}]"`, false),
+		Entry("raw points", `"[{\"$sort\": {\"ts\": 1}}]"`, false),
+	)
+})
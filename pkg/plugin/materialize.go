@@ -0,0 +1,251 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	materializeModeView  = "view"
+	materializeModeMerge = "merge"
+
+	grafanaAdminRole = "Admin"
+)
+
+// materializeRequest describes how to materialize the result of a query, either as a view, or by merging it into a collection
+type materializeRequest struct {
+	// Query is the query JSON, as it appears in the panel's query inspector
+	Query json.RawMessage `json:"query"`
+	// Mode is either "view" or "merge"
+	Mode string `json:"mode"`
+	// Database is the database to create the view or collection in. Defaults to the database of the query.
+	Database string `json:"database"`
+	// Target is the name of the view or collection to create
+	Target string `json:"target"`
+	// TimeRange is, for merges of timeseries queries with an automatic time bound, how far back from the current time to include.
+	// If empty, the automatic time bound is not applied. Views never apply the automatic time bound,
+	// queries against them should apply their own.
+	TimeRange string `json:"timeRange"`
+}
+
+// scheduledMerge is a merge materialization that is run periodically in the background
+type scheduledMerge struct {
+	materializeRequest
+	// Interval is how often the merge should be run
+	Interval string `json:"interval"`
+}
+
+type materializeResult struct {
+	Mode     string `json:"mode"`
+	Database string `json:"database"`
+	Target   string `json:"target"`
+}
+
+// materialization is a validated materializeRequest
+type materialization struct {
+	mode       string
+	database   string
+	collection string
+	target     string
+	pipeline   mongo.Pipeline
+}
+
+// prepare validates a request and builds the pipeline which defines the view, or is merged into the target collection
+func (r *materializeRequest) prepare(now time.Time, snippets map[string]string) (materialization, error) {
+	qm, err := parseQueryModel(r.Query)
+	if err != nil {
+		return materialization{}, err
+	}
+	if r.Mode != materializeModeView && r.Mode != materializeModeMerge {
+		return materialization{}, fmt.Errorf("Materialization mode must be %s or %s, got %s", materializeModeView, materializeModeMerge, r.Mode)
+	}
+	if r.Target == "" {
+		return materialization{}, fmt.Errorf("A target view or collection name is required")
+	}
+	m := materialization{
+		mode:       r.Mode,
+		database:   qm.Database,
+		collection: qm.Collection,
+		target:     r.Target,
+	}
+	if r.Database != "" {
+		m.database = r.Database
+	}
+	if r.Mode == materializeModeView && m.database != qm.Database {
+		return materialization{}, fmt.Errorf("Views must be created in the same database as their source collection")
+	}
+
+	var from, to time.Time
+	if r.Mode == materializeModeMerge && r.TimeRange != "" {
+		timeRange, err := time.ParseDuration(r.TimeRange)
+		if err != nil {
+			return materialization{}, errors.Wrap(err, "Invalid time range")
+		}
+		from, to = now.Add(-timeRange), now
+	} else {
+		if r.TimeRange != "" {
+			return materialization{}, fmt.Errorf("Views cannot have a time range")
+		}
+		qm.AutoTimeBound = false
+	}
+	m.pipeline, err = qm.getPipeline(MacroContext{From: from, To: to, Snippets: snippets})
+	if err != nil {
+		return materialization{}, err
+	}
+	if hasStage(m.pipeline, "$out") || hasStage(m.pipeline, "$merge") {
+		return materialization{}, fmt.Errorf("Queries which already write their results cannot be materialized")
+	}
+	if r.Mode == materializeModeMerge {
+		m.pipeline = append(m.pipeline, bson.D{bson.E{Key: "$merge", Value: bson.D{
+			bson.E{Key: "into", Value: bson.D{
+				bson.E{Key: "db", Value: m.database},
+				bson.E{Key: "coll", Value: m.target},
+			}},
+			bson.E{Key: "whenMatched", Value: "replace"},
+			bson.E{Key: "whenNotMatched", Value: "insert"},
+		}}})
+	}
+	return m, nil
+}
+
+// run creates the view, or runs the merge
+func (m *materialization) run(ctx context.Context, client *mongo.Client) error {
+	switch m.mode {
+	case materializeModeView:
+		err := client.Database(m.database).CreateView(ctx, m.target, m.collection, m.pipeline)
+		if err != nil {
+			return errors.Wrap(err, "Failed to create view")
+		}
+	case materializeModeMerge:
+		cursor, err := client.Database(m.database).Collection(m.collection).Aggregate(ctx, m.pipeline)
+		if err != nil {
+			return errors.Wrap(err, "Failed to merge query results")
+		}
+		// $merge produces no documents, but the cursor must be closed
+		err = cursor.Close(ctx)
+		if err != nil {
+			return errors.Wrap(err, "Failed to merge query results")
+		}
+	}
+	return nil
+}
+
+// handleMaterialize creates a view from a query, or merges its results into a collection.
+// This is disabled unless explicitly enabled in the datasource settings, and is only available to Grafana admins,
+// as it writes to MongoDB.
+func (d *MongoDBDatasource) handleMaterialize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	ctx := r.Context()
+	pCtx := httpadapter.PluginConfigFromContext(ctx)
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !settings.MaterializeEnabled {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Materialization is not enabled for this datasource"))
+		return
+	}
+	user := httpadapter.UserFromContext(ctx)
+	if user == nil || user.Role != grafanaAdminRole {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Only admins may materialize queries"))
+		return
+	}
+
+	var req materializeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+	m, err := req.prepare(time.Now(), settings.Snippets)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	mongoClient, err, internalErr := connect(ctx, pCtx)
+	if internalErr != nil {
+		writeError(w, http.StatusInternalServerError, internalErr)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer mongoClient.Disconnect(ctx)
+
+	err = m.run(ctx, mongoClient)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	log.DefaultLogger.Info("Materialized query", "user", user.Login, "mode", m.mode, "database", m.database, "target", m.target)
+	writeJSON(w, http.StatusOK, materializeResult{Mode: m.mode, Database: m.database, Target: m.target})
+}
+
+// mergeScheduledJob periodically merges the results of a query into a collection
+func mergeScheduledJob(pCtx backend.PluginContext, merge scheduledMerge, interval time.Duration, snippets map[string]string) scheduledJob {
+	return scheduledJob{
+		name:     "scheduled merge into " + merge.Target,
+		interval: interval,
+		run: func(ctx context.Context) error {
+			m, err := merge.prepare(time.Now(), snippets)
+			if err != nil {
+				return err
+			}
+			mongoClient, err, internalErr := connect(ctx, pCtx)
+			if internalErr != nil {
+				return internalErr
+			}
+			if err != nil {
+				return err
+			}
+			defer mongoClient.Disconnect(ctx)
+			return m.run(ctx, mongoClient)
+		},
+	}
+}
+
+// getScheduledMergeJobs validates the configured scheduled merges
+func (d *datasource) getScheduledMergeJobs(pCtx backend.PluginContext) ([]scheduledJob, error) {
+	if len(d.ScheduledMerges) != 0 && !d.MaterializeEnabled {
+		return nil, fmt.Errorf("Scheduled merges require materialization to be enabled")
+	}
+	jobs := make([]scheduledJob, 0, len(d.ScheduledMerges))
+	for ix, merge := range d.ScheduledMerges {
+		if merge.Mode == "" {
+			merge.Mode = materializeModeMerge
+		}
+		if merge.Mode != materializeModeMerge {
+			return nil, fmt.Errorf("Scheduled materialization %d must be a merge", ix)
+		}
+		// Catch invalid queries now, rather than every interval
+		_, err := merge.prepare(time.Now(), d.Snippets)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid scheduled merge %d", ix))
+		}
+		interval, err := time.ParseDuration(merge.Interval)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid interval for scheduled merge %d", ix))
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("Scheduled merge %d must have a positive interval", ix)
+		}
+		jobs = append(jobs, mergeScheduledJob(pCtx, merge, interval, d.Snippets))
+	}
+	return jobs, nil
+}
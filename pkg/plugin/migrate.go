@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+// CurrentQueryModelVersion is the version of the query model produced by MigrateQuery.
+// Queries saved before versioning was introduced are considered to be version 0.
+const CurrentQueryModelVersion = 2
+
+// legacyQueryModelVersion is the latest version of the query model which is run by the legacy executor.
+// Queries saved at or before this version keep their exact behavior, and are only opted in to the current model
+// when they are explicitly upgraded, e.g. through the /migrate-query route.
+const legacyQueryModelVersion = 1
+
+// queryMigrations[i] upgrades a query from version i to version i+1.
+// Migrations operate on the raw JSON object so that they can handle fields which no longer exist in QueryModel.
+var queryMigrations = []func(query map[string]interface{}){
+	migrateQueryV0ToV1,
+	migrateQueryV1ToV2,
+}
+
+// migrateQueryV0ToV1 makes all defaults which were previously implied by the backend or editor explicit
+func migrateQueryV0ToV1(query map[string]interface{}) {
+	if queryType, _ := query["queryType"].(string); queryType == "" {
+		query["queryType"] = defaultQueryType
+	}
+	if inference, _ := query["schemaInference"].(bool); inference {
+		if depth, _ := query["schemaInferenceDepth"].(float64); depth <= 0 {
+			query["schemaInferenceDepth"] = float64(20)
+		}
+	}
+	for _, key := range []string{"autoTimeBound", "autoTimeBoundAtStart", "autoTimeSort", "schemaInference"} {
+		if _, ok := query[key]; !ok {
+			query[key] = false
+		}
+	}
+}
+
+// migrateQueryV1ToV2 changes no fields, as version 2 only adds to the model.
+// The version number itself is what opts a query in to features which change how a query is interpreted.
+func migrateQueryV1ToV2(query map[string]interface{}) {}
+
+// queryModelVersion returns the version a query was saved with
+func queryModelVersion(query map[string]interface{}) int {
+	if v, ok := query["version"].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// MigrateQuery upgrades a query from any previous version of the query model to the current one,
+// returning the upgraded query and a human-readable list of the changes made
+func MigrateQuery(raw []byte) (upgraded map[string]interface{}, diff []string, err error) {
+	original := make(map[string]interface{})
+	err = json.Unmarshal(raw, &original)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Invalid query JSON")
+	}
+	upgraded = make(map[string]interface{})
+	// Unmarshaling twice is the easiest deep copy
+	err = json.Unmarshal(raw, &upgraded)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Invalid query JSON")
+	}
+
+	version := queryModelVersion(upgraded)
+	if version > CurrentQueryModelVersion {
+		return nil, nil, fmt.Errorf("Query model version %d is newer than the latest supported version %d", version, CurrentQueryModelVersion)
+	}
+	for ; version < CurrentQueryModelVersion; version++ {
+		queryMigrations[version](upgraded)
+	}
+	upgraded["version"] = float64(CurrentQueryModelVersion)
+
+	return upgraded, diffQueries(original, upgraded), nil
+}
+
+// diffQueries produces a line for each top-level key which was added (+), removed (-), or changed (~), in key order
+func diffQueries(before, after map[string]interface{}) []string {
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	diff := make([]string, 0)
+	for _, key := range keys {
+		oldValue, hadOld := before[key]
+		newValue, hasNew := after[key]
+		oldJSON, _ := json.Marshal(oldValue)
+		newJSON, _ := json.Marshal(newValue)
+		switch {
+		case !hadOld:
+			diff = append(diff, fmt.Sprintf("+ %s: %s", key, newJSON))
+		case !hasNew:
+			diff = append(diff, fmt.Sprintf("- %s: %s", key, oldJSON))
+		case !bytes.Equal(oldJSON, newJSON):
+			diff = append(diff, fmt.Sprintf("~ %s: %s -> %s", key, oldJSON, newJSON))
+		}
+	}
+	return diff
+}
+
+// parseQueryModel migrates a query to the current version before parsing it,
+// recording whether it was saved with a version which must be run by the legacy executor
+func parseQueryModel(raw []byte) (QueryModel, error) {
+	var qm QueryModel
+	original := make(map[string]interface{})
+	err := json.Unmarshal(raw, &original)
+	if err != nil {
+		return qm, errors.Wrap(err, "Invalid query JSON")
+	}
+	upgraded, _, err := MigrateQuery(raw)
+	if err != nil {
+		return qm, err
+	}
+	upgradedJSON, err := json.Marshal(upgraded)
+	if err != nil {
+		return qm, err
+	}
+	err = json.Unmarshal(upgradedJSON, &qm)
+	if err != nil {
+		return qm, errors.Wrap(err, "Invalid query JSON")
+	}
+	qm.legacy = queryModelVersion(original) <= legacyQueryModelVersion
+	switch qm.ExtJSONInputFlavor {
+	case "", bsonframe.ExtJSONRelaxed, bsonframe.ExtJSONCanonical:
+	default:
+		return qm, fmt.Errorf("Extended JSON input flavor must be one of: %s, %s", bsonframe.ExtJSONRelaxed, bsonframe.ExtJSONCanonical)
+	}
+	return qm, nil
+}
+
+type migratedQuery struct {
+	Query map[string]interface{} `json:"query"`
+	Diff  []string               `json:"diff"`
+}
+
+// handleMigrateQuery upgrades a single query, or a list of queries, to the current query model version
+func (d *MongoDBDatasource) handleMigrateQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	var body json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+
+	if len(body) == 0 || body[0] != '[' {
+		upgraded, diff, err := MigrateQuery(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, migratedQuery{Query: upgraded, Diff: diff})
+		return
+	}
+
+	var queries []json.RawMessage
+	err = json.Unmarshal(body, &queries)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+	results := make([]migratedQuery, len(queries))
+	for ix, query := range queries {
+		results[ix].Query, results[ix].Diff, err = MigrateQuery(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, fmt.Sprintf("Query %d", ix)))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,41 @@
+package plugin_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MigrateQuery", func() {
+	It("Should make implied defaults explicit for unversioned queries", func() {
+		upgraded, diff, err := plugin.MigrateQuery([]byte(`{"database":"db","collection":"coll","schemaInference":true,"autoTimeBound":true,"autoTimeBoundAtStart":false,"autoTimeSort":false}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(upgraded).To(HaveKeyWithValue("queryType", "Table"))
+		Expect(upgraded).To(HaveKeyWithValue("schemaInferenceDepth", float64(20)))
+		Expect(upgraded).To(HaveKeyWithValue("version", float64(plugin.CurrentQueryModelVersion)))
+		Expect(diff).To(Equal([]string{
+			`+ queryType: "Table"`,
+			`+ schemaInferenceDepth: 20`,
+			`+ version: 2`,
+		}))
+	})
+
+	It("Should only change the version of version 1 queries", func() {
+		upgraded, diff, err := plugin.MigrateQuery([]byte(`{"version":1,"queryType":"Timeseries","aggregation":"[]"}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(upgraded).To(HaveKeyWithValue("aggregation", "[]"))
+		Expect(diff).To(Equal([]string{`~ version: 1 -> 2`}))
+	})
+
+	It("Should not change queries that are already current", func() {
+		_, diff, err := plugin.MigrateQuery([]byte(`{"version":2,"queryType":"Timeseries"}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(diff).To(BeEmpty())
+	})
+
+	It("Should reject queries from a newer version", func() {
+		_, _, err := plugin.MigrateQuery([]byte(`{"version":1000}`))
+		Expect(err).To(HaveOccurred())
+	})
+})
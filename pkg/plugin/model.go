@@ -12,6 +12,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
 )
 
 type queryType = string
@@ -23,6 +25,7 @@ const (
 )
 
 type QueryModel struct {
+	Version              int       `json:"version,omitempty"`
 	Database             string    `json:"database"`
 	Collection           string    `json:"collection"`
 	QueryType            queryType `json:"queryType"`
@@ -38,9 +41,121 @@ type QueryModel struct {
 	Aggregation          string    `json:"aggregation"`
 	SchemaInference      bool      `json:"schemaInference"`
 	SchemaInferenceDepth int       `json:"schemaInferenceDepth,omitempty"`
+	MaxColumns           int       `json:"maxColumns,omitempty"`
+	OverflowColumn       string    `json:"overflowColumn,omitempty"`
+	Columns              []string  `json:"columns,omitempty"`
+	ColumnOrder          []string  `json:"columnOrder,omitempty"`
+	DecodeParallelism    int       `json:"decodeParallelism,omitempty"`
+	DecimalReducer       string    `json:"decimalReducer,omitempty"`
+	DBRefFormat          string    `json:"dbRefFormat,omitempty"`
+	Timeout              string    `json:"timeout,omitempty"`
+	MaxRows              int       `json:"maxRows,omitempty"`
+	MaxBytes             int64     `json:"maxBytes,omitempty"`
+
+	CaseInsensitiveFilters []caseInsensitiveFilter `json:"caseInsensitiveFilters,omitempty"`
+	NullRepresentation     string                  `json:"nullRepresentation,omitempty"`
+	NullPlaceholder        string                  `json:"nullPlaceholder,omitempty"`
+	SSECompatible          bool                    `json:"sseCompatible,omitempty"`
+	LabelsFrom             []string                `json:"labelsFrom,omitempty"`
+	NoDataMode             string                  `json:"noDataMode,omitempty"`
+	AfterClusterTime       string                  `json:"afterClusterTime,omitempty"`
+	Snapshot               string                  `json:"snapshot,omitempty"`
+	TopN                   int                     `json:"topN,omitempty"`
+	TopNBy                 string                  `json:"topNBy,omitempty"`
+	MaxSeries              int                     `json:"maxSeries,omitempty"`
+	DecimalSeparator       string                  `json:"decimalSeparator,omitempty"`
+	DateFormat             string                  `json:"dateFormat,omitempty"`
+	DurationFields         []durationField         `json:"durationFields,omitempty"`
+	UnitFields             []unitField             `json:"unitFields,omitempty"`
+	BoolFields             []string                `json:"boolFields,omitempty"`
+	IPFields               []string                `json:"ipFields,omitempty"`
+	MACFields              []string                `json:"macFields,omitempty"`
+	JoinKey                *joinKey                `json:"joinKey,omitempty"`
+	IndexAdvice            bool                    `json:"indexAdvice,omitempty"`
+	Explain                bool                    `json:"explain,omitempty"`
+	ExecutionStats         bool                    `json:"executionStats,omitempty"`
+	Timezone               string                  `json:"timezone,omitempty"`
+	DecimalMode            string                  `json:"decimalMode,omitempty"`
+	BinaryMode             string                  `json:"binaryMode,omitempty"`
+	ExtJSONFlavor          string                  `json:"extJSONFlavor,omitempty"`
+	ExtJSONInputFlavor     string                  `json:"extJSONInputFlavor,omitempty"`
+	MaxCellDepth           int                     `json:"maxCellDepth,omitempty"`
+	Command                string                  `json:"command,omitempty"`
+	Filter                 string                  `json:"filter,omitempty"`
+	Projection             string                  `json:"projection,omitempty"`
+	Sort                   string                  `json:"sort,omitempty"`
+	Skip                   int64                   `json:"skip,omitempty"`
+	Limit                  int64                   `json:"limit,omitempty"`
+	Hint                   string                  `json:"hint,omitempty"`
+	Stages                 []builderStage          `json:"stages,omitempty"`
+	Builder                *builderQuery           `json:"builder,omitempty"`
+	DistinctField          string                  `json:"distinctField,omitempty"`
+	Stream                 string                  `json:"stream,omitempty"`
+	TextField              string                  `json:"textField,omitempty"`
+	ValueField             string                  `json:"valueField,omitempty"`
+	Transforms             []frameTransform        `json:"transforms,omitempty"`
+	CompressCells          *cellCompression        `json:"compressCells,omitempty"`
+	DocumentLinks          bool                    `json:"documentLinks,omitempty"`
+	HideFromInspector      bool                    `json:"hideFromInspector,omitempty"`
+	AdhocFilters           []adhocFilter           `json:"adhocFilters,omitempty"`
+	RelativeTime           string                  `json:"relativeTime,omitempty"`
+	TimeShift              string                  `json:"timeShift,omitempty"`
+	Cache                  string                  `json:"cache,omitempty"`
+
+	// legacy is set for queries saved with a version run by the legacy executor, see legacy.go
+	legacy bool
+}
+
+// canonicalInput returns true if the text of the query should be parsed as canonical, rather than relaxed, extended JSON
+func (m *QueryModel) canonicalInput() bool {
+	return m.ExtJSONInputFlavor == bsonframe.ExtJSONCanonical
 }
 
-func (m *QueryModel) resolve(fields []field) (resolvedQueryModel, error) {
+func (m *QueryModel) getConversionOptions() (bsonframe.ConversionOptions, error) {
+	opts := bsonframe.ConversionOptions{
+		DBRefFormat:   m.DBRefFormat,
+		DecimalMode:   m.DecimalMode,
+		BinaryMode:    m.BinaryMode,
+		ExtJSONFlavor: m.ExtJSONFlavor,
+		MaxCellDepth:  m.MaxCellDepth,
+	}
+	if m.Timezone != "" {
+		location, err := time.LoadLocation(m.Timezone)
+		if err != nil {
+			return bsonframe.ConversionOptions{}, errors.Wrap(err, fmt.Sprintf("Invalid timezone %q", m.Timezone))
+		}
+		opts.Location = location
+	}
+	var placeholder string
+	switch m.NullRepresentation {
+	case "", nullRepresentationNull:
+	case nullRepresentationEmpty:
+		opts.NullString = &placeholder
+	case nullRepresentationText:
+		placeholder = "null"
+		opts.NullString = &placeholder
+	case nullRepresentationCustom:
+		placeholder = m.NullPlaceholder
+		opts.NullString = &placeholder
+	default:
+		return bsonframe.ConversionOptions{}, fmt.Errorf("Null representation must be one of: %s, %s, %s, %s", nullRepresentationNull, nullRepresentationEmpty, nullRepresentationText, nullRepresentationCustom)
+	}
+	if opts.NullString != nil && m.QueryType == queryTypeTimeseries {
+		return bsonframe.ConversionOptions{}, fmt.Errorf("Null representations are only supported for %s queries", queryTypeTable)
+	}
+	locale, err := m.getLocaleOptions()
+	if err != nil {
+		return bsonframe.ConversionOptions{}, err
+	}
+	opts.Locale = locale
+	err = opts.Validate()
+	if err != nil {
+		return bsonframe.ConversionOptions{}, err
+	}
+	return opts, nil
+}
+
+func (m *QueryModel) resolve(fields []bsonframe.Column) (resolvedQueryModel, error) {
 	var err error
 
 	queryType := m.QueryType
@@ -49,9 +164,18 @@ func (m *QueryModel) resolve(fields []field) (resolvedQueryModel, error) {
 	}
 	switch queryType {
 	case queryTypeTable:
-		return &tableQueryModel{
-			fields: fields,
-		}, nil
+		overflow, err := m.getOverflowColumn(fields)
+		if err != nil {
+			return nil, err
+		}
+		model := &tableQueryModel{
+			fields:   fields,
+			overflow: overflow,
+		}
+		if overflow == nil {
+			model.inferred = m.inferredFieldNames(fields)
+		}
+		return model, nil
 	case queryTypeTimeseries:
 		var legendTemplate *template.Template
 		if m.LegendFormat != "" {
@@ -66,64 +190,56 @@ func (m *QueryModel) resolve(fields []field) (resolvedQueryModel, error) {
 			timestampFieldFormat: m.TimestampFormat,
 			labelFieldNames:      m.LabelFields,
 			legendTemplate:       legendTemplate,
+			inferred:             m.inferredFieldNames(fields),
 		}, nil
+	case queryTypeVariable:
+		return m.resolveVariable()
 	default:
-		return nil, fmt.Errorf("Query type must be one of: %s, %s", queryTypeTable, queryTypeTimeseries)
+		return nil, fmt.Errorf("Query type must be one of: %s, %s, %s", queryTypeTable, queryTypeTimeseries, queryTypeVariable)
 	}
 }
 
 type resolvedQueryModel interface {
 	makeFrame(id string, labels data.Labels) (*data.Frame, error)
 	getLabels(doc timestepDocument) (labels data.Labels, labelsID string)
-	getValues(doc timestepDocument) ([]interface{}, error)
+	getValues(doc timestepDocument, opts *bsonframe.ConversionOptions, stats *bsonframe.Stats) ([]interface{}, error)
 }
 
 type tableQueryModel struct {
-	fields []field
+	fields []bsonframe.Column
+	// overflow, if not nil, is an additional column holding the fields which are not in fields
+	overflow *overflowColumn
+	// inferred, if not nil, are the names of the fields found by schema inference, and any others are reported when dropped
+	inferred map[string]struct{}
 }
 
 func (m *tableQueryModel) makeFrame(id string, labels data.Labels) (*data.Frame, error) {
-	names := make([]string, len(m.fields))
-	types := make([]data.FieldType, len(m.fields))
-
-	for ix, field := range m.fields {
-		names[ix] = field.Name
-		types[ix] = field.Type
+	if m.overflow != nil {
+		return bsonframe.NewFrame(id, append(append([]bsonframe.Column{}, m.fields...), m.overflow.column)), nil
 	}
-	frame := data.NewFrameOfFieldTypes(id, 0, types...)
-	frame.SetFieldNames(names...)
-
-	return frame, nil
+	return bsonframe.NewFrame(id, m.fields), nil
 }
 
 func (m *tableQueryModel) getLabels(doc timestepDocument) (data.Labels, string) {
 	return make(data.Labels), ""
 }
 
-func (m *tableQueryModel) getValues(doc timestepDocument) ([]interface{}, error) {
+func (m *tableQueryModel) getValues(doc timestepDocument, opts *bsonframe.ConversionOptions, stats *bsonframe.Stats) ([]interface{}, error) {
 	var err error
-	var actualType data.FieldType
-	values := make([]interface{}, len(m.fields))
+	values := make([]interface{}, len(m.fields), len(m.fields)+1)
 	for ix, field := range m.fields {
-		name := field.Name
-		type_ := field.Type
-		value, ok := doc[name]
-		if !ok || value == nil {
-			if !type_.Nullable() {
-				return nil, fmt.Errorf("Field %s was null or absent, but is not nullable. If using schema inference, please increase the depth to the first document missing this field, or manually specify the schema", name)
-			}
-			values[ix] = nil
-			continue
-		}
-
-		values[ix], actualType, err = convertValue(value, type_.Nullable())
+		values[ix], err = field.Convert(doc[field.Name], opts, stats)
 		if err != nil {
-			return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s", name))
+			return nil, err
 		}
-
-		if values[ix] != nil && actualType != type_ {
-			return nil, fmt.Errorf("Type mismatch for field %s: expected %s, got %s (%#v, %#v)", name, type_, actualType, value, values[ix])
+	}
+	recordDroppedFields(doc, m.inferred, stats)
+	if m.overflow != nil {
+		overflow, err := m.overflow.convert(doc, opts, stats)
+		if err != nil {
+			return nil, err
 		}
+		values = append(values, overflow)
 	}
 	return values, nil
 }
@@ -135,7 +251,8 @@ type timeseriesQueryModel struct {
 	timestampFieldFormat string
 	labelFieldNames      []string
 	legendTemplate       *template.Template
-	fields               []field
+	fields               []bsonframe.Column
+	inferred             map[string]struct{}
 }
 
 var _ = resolvedQueryModel(&timeseriesQueryModel{})
@@ -211,12 +328,16 @@ func (m *timeseriesQueryModel) getDisplayName(valueField string, labels data.Lab
 	return builder.String(), nil
 }
 
-func (m *timeseriesQueryModel) convertTimestamp(timestamp interface{}) (time.Time, error) {
+// convertTimestamp converts the timestamp of a document, parsing it in location if it is a string without a timezone
+func (m *timeseriesQueryModel) convertTimestamp(timestamp interface{}, location *time.Location) (time.Time, error) {
 	if m.timestampFieldFormat == "" {
 		primTimestamp, isPrim := timestamp.(bsonPrim.DateTime)
 		if !isPrim {
 			return time.Time{}, fmt.Errorf("Timestamps must be bson DateTimes")
 		}
+		if isPrim && location != nil {
+			return primTimestamp.Time().In(location), nil
+		}
 		if isPrim {
 			return primTimestamp.Time(), nil
 		}
@@ -225,14 +346,17 @@ func (m *timeseriesQueryModel) convertTimestamp(timestamp interface{}) (time.Tim
 	if !isString {
 		return time.Time{}, fmt.Errorf("Timestamps must be strings when Timestamp Format is supplied")
 	}
-	convertedTimestamp, err := time.Parse(m.timestampFieldFormat, stringTimestamp)
+	if location == nil {
+		location = time.UTC
+	}
+	convertedTimestamp, err := time.ParseInLocation(m.timestampFieldFormat, stringTimestamp, location)
 	if err != nil {
 		return time.Time{}, errors.Wrap(err, "Could not parse timestamp")
 	}
 	return convertedTimestamp, nil
 }
 
-func (m *timeseriesQueryModel) getValues(doc timestepDocument) ([]interface{}, error) {
+func (m *timeseriesQueryModel) getValues(doc timestepDocument, opts *bsonframe.ConversionOptions, stats *bsonframe.Stats) ([]interface{}, error) {
 	var err error
 	values := make([]interface{}, 1+len(m.fields))
 
@@ -240,39 +364,55 @@ func (m *timeseriesQueryModel) getValues(doc timestepDocument) ([]interface{}, e
 	if !ok {
 		return nil, fmt.Errorf("All documents must have the Timestamp Field present")
 	}
-	values[0], err = m.convertTimestamp(timestamp)
+	values[0], err = m.convertTimestamp(timestamp, opts.Location)
 	if err != nil {
 		return nil, err
 	}
 
 	valueValues := values[1:]
-	var actualType data.FieldType
 	for ix, field := range m.fields {
-		name := field.Name
-		value := doc[name]
-		type_ := field.Type
-		value, ok := doc[name]
-		if !ok || value == nil {
-			if !type_.Nullable() {
-				return nil, fmt.Errorf("Field %s was null or absent, but is not nullable. If using schema inference, please increase the depth to the first document missing this field, or manually specify the schema", name)
-			}
-			valueValues[ix] = nil
-			continue
-		}
-
-		valueValues[ix], actualType, err = convertValue(value, type_.Nullable())
+		valueValues[ix], err = field.Convert(doc[field.Name], opts, stats)
 		if err != nil {
-			return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert value for %s (%#v)", name, value))
-		}
-		if actualType != type_ {
-			return nil, fmt.Errorf("Type mismatch for field %s: expected %s, got %s (%#v, %#v)", name, type_, actualType, value, valueValues[ix])
+			return nil, err
 		}
 	}
+	recordDroppedFields(doc, m.inferred, stats)
 
 	return values, nil
 }
 
-func (m *QueryModel) getFields() ([]field, error) {
+// inferredFieldNames returns the names of the fields a document may have without any of its values being dropped,
+// or nil if the schema was not inferred, or fields are deliberately left out by listing the columns to keep
+func (m *QueryModel) inferredFieldNames(fields []bsonframe.Column) map[string]struct{} {
+	if !m.SchemaInference || m.columnSet() != nil {
+		return nil
+	}
+	names := make(map[string]struct{}, len(fields)+len(m.LabelFields)+1)
+	for _, field := range fields {
+		names[field.Name] = struct{}{}
+	}
+	if m.QueryType == queryTypeTimeseries {
+		names[m.TimestampField] = struct{}{}
+		for _, name := range m.LabelFields {
+			names[name] = struct{}{}
+		}
+	}
+	return names
+}
+
+// recordDroppedFields records the fields of a document which are not in the inferred schema, if reporting them
+func recordDroppedFields(doc timestepDocument, inferred map[string]struct{}, stats *bsonframe.Stats) {
+	if inferred == nil {
+		return
+	}
+	for name := range doc {
+		if _, ok := inferred[name]; !ok {
+			stats.RecordDroppedField(name)
+		}
+	}
+}
+
+func (m *QueryModel) getFields() ([]bsonframe.Column, error) {
 	if len(m.ValueFields) != len(m.ValueFields) {
 		return nil, fmt.Errorf(
 			"Value Fields and Value Field Types must be the same length (%d vs %d)",
@@ -280,18 +420,45 @@ func (m *QueryModel) getFields() ([]field, error) {
 			len(m.ValueFields),
 		)
 	}
-	fields := make([]field, len(m.ValueFields))
-	var ok bool
+	fields := make([]bsonframe.Column, len(m.ValueFields))
 	for ix, typeStr := range m.ValueFieldTypes {
-		fields[ix].Name = m.ValueFields[ix]
-		fields[ix].Type, ok = data.FieldTypeFromItemTypeString(typeStr)
+		type_, ok := data.FieldTypeFromItemTypeString(typeStr)
 		if !ok {
 			return nil, fmt.Errorf("Invalid Type: %s", typeStr)
 		}
+		fields[ix] = bsonframe.NewColumn(m.ValueFields[ix], type_)
 	}
 	return fields, nil
 }
 
+// columnSet returns the Columns whitelist as a set, or nil if no whitelist is provided
+func (m *QueryModel) columnSet() map[string]struct{} {
+	if len(m.Columns) == 0 {
+		return nil
+	}
+	columns := make(map[string]struct{}, len(m.Columns))
+	for _, name := range m.Columns {
+		columns[name] = struct{}{}
+	}
+	return columns
+}
+
+// pruneColumns removes any fields not present in the Columns whitelist, if one is provided.
+// Order of the original fields is preserved.
+func (m *QueryModel) pruneColumns(fields []bsonframe.Column) []bsonframe.Column {
+	columns := m.columnSet()
+	if columns == nil {
+		return fields
+	}
+	pruned := make([]bsonframe.Column, 0, len(fields))
+	for _, field := range fields {
+		if _, ok := columns[field.Name]; ok {
+			pruned = append(pruned, field)
+		}
+	}
+	return pruned
+}
+
 func (m *QueryModel) getTimeBoundPipelineStage(from time.Time, to time.Time) (bson.D, error) {
 	fromTime := bsonPrim.NewDateTimeFromTime(from)
 	toTime := bsonPrim.NewDateTimeFromTime(to)
@@ -339,8 +506,23 @@ func (m *QueryModel) getTimeBoundPipelineStage(from time.Time, to time.Time) (bs
 	}}, nil
 }
 
-func (m *QueryModel) getPipeline(from time.Time, to time.Time) (mongo.Pipeline, error) {
+func (m *QueryModel) getPipeline(mctx MacroContext) (mongo.Pipeline, error) {
+	switch m.Command {
+	case "", commandAggregate:
+	case commandDistinct, commandCount, commandEstimatedCount:
+		return nil, fmt.Errorf("The %s command does not use a pipeline", m.Command)
+	case commandFind:
+		find, err := m.getFind(mctx)
+		if err != nil {
+			return nil, err
+		}
+		return find.pipeline(), nil
+	default:
+		return nil, fmt.Errorf("Command must be one of: %s, %s, %s, %s, %s", commandAggregate, commandFind, commandDistinct, commandCount, commandEstimatedCount)
+	}
+
 	pipeline := mongo.Pipeline{}
+	from, to := mctx.From, mctx.To
 
 	if m.QueryType == queryTypeTimeseries && m.AutoTimeBound && m.AutoTimeBoundAtStart {
 		timeBoundStage, err := m.getTimeBoundPipelineStage(from, to)
@@ -350,10 +532,9 @@ func (m *QueryModel) getPipeline(from time.Time, to time.Time) (mongo.Pipeline,
 		pipeline = append(pipeline, timeBoundStage)
 	}
 
-	userPipeline := mongo.Pipeline{}
-	err := bson.UnmarshalExtJSON([]byte(m.Aggregation), false, &userPipeline)
+	userPipeline, err := m.getUserPipeline(mctx)
 	if err != nil {
-		return mongo.Pipeline{}, errors.Wrap(err, "Failed to parse aggregation pipeline")
+		return mongo.Pipeline{}, err
 	}
 	pipeline = append(pipeline, userPipeline...)
 
@@ -372,5 +553,80 @@ func (m *QueryModel) getPipeline(from time.Time, to time.Time) (mongo.Pipeline,
 			},
 		})
 	}
+	if len(m.Columns) != 0 && !hasStage(userPipeline, "$project") {
+		pipeline = append(pipeline, m.getProjectionPipelineStage())
+	}
 	return pipeline, nil
 }
+
+// getUserPipeline produces the pipeline written by the user, either from a builder query or builder stages, if there are any, or the aggregation
+func (m *QueryModel) getUserPipeline(mctx MacroContext) (mongo.Pipeline, error) {
+	if m.Builder != nil {
+		err := m.requireCurrentModel("Builder queries")
+		if err != nil {
+			return nil, err
+		}
+		if len(m.Stages) != 0 {
+			return nil, fmt.Errorf("A builder query and builder stages cannot be used together")
+		}
+		stages, err := m.Builder.stages()
+		if err != nil {
+			return nil, err
+		}
+		return compileBuilderStages(stages)
+	}
+	if len(m.Stages) != 0 {
+		err := m.requireCurrentModel("Builder stages")
+		if err != nil {
+			return nil, err
+		}
+		return compileBuilderStages(m.Stages)
+	}
+	aggregation, err := ExpandMacros(NormalizeJSON5(m.Aggregation), mctx)
+	if err != nil {
+		return nil, err
+	}
+	userPipeline := mongo.Pipeline{}
+	err = unmarshalQueryText(aggregation, m.canonicalInput(), &userPipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse aggregation pipeline")
+	}
+	return userPipeline, nil
+}
+
+// getProjectionPipelineStage produces a $project stage which only includes the whitelisted columns,
+// as well as the timestamp and label fields for timeseries queries, so that unused fields aren't sent by the server
+func (m *QueryModel) getProjectionPipelineStage() bson.D {
+	projection := bson.D{}
+	included := make(map[string]struct{}, len(m.Columns)+len(m.LabelFields)+1)
+	include := func(name string) {
+		if _, ok := included[name]; ok || name == "" {
+			return
+		}
+		included[name] = struct{}{}
+		projection = append(projection, bson.E{Key: name, Value: 1})
+	}
+	if m.QueryType == queryTypeTimeseries {
+		include(m.TimestampField)
+		for _, name := range m.LabelFields {
+			include(name)
+		}
+	}
+	for _, name := range m.Columns {
+		include(name)
+	}
+	if _, ok := included["_id"]; !ok {
+		projection = append(projection, bson.E{Key: "_id", Value: 0})
+	}
+	return bson.D{bson.E{Key: "$project", Value: projection}}
+}
+
+// hasStage returns true if a pipeline contains at least one stage of the given kind, e.g. "$project"
+func hasStage(pipeline mongo.Pipeline, name string) bool {
+	for _, stage := range pipeline {
+		if len(stage) == 1 && stage[0].Key == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// buildPipeline produces the effective pipeline of a query, before any limits or server version specific rewrites are applied.
+// For queries using the find command, this is the pipeline which returns the same documents as the find.
+func buildPipeline(rawQuery []byte, mctx MacroContext) (mongo.Pipeline, error) {
+	qm, err := parseQueryModel(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	return qm.getPipeline(mctx)
+}
+
+var _ = Describe("Column whitelist", func() {
+	DescribeTable("columnSet", func(columns []string, expected map[string]struct{}) {
+		qm := QueryModel{Columns: columns}
+		Expect(qm.columnSet()).To(Equal(expected))
+	},
+		Entry("is nil without a whitelist", nil, nil),
+		Entry("is nil with an empty whitelist", []string{}, nil),
+		Entry("contains each whitelisted column once", []string{"a", "b", "a"}, map[string]struct{}{"a": {}, "b": {}}),
+	)
+
+	DescribeTable("pruneColumns", func(columns []string, expected []string) {
+		qm := QueryModel{Columns: columns}
+		fields := []bsonframe.Column{
+			bsonframe.NewColumn("_id", data.FieldTypeString),
+			bsonframe.NewColumn("a", data.FieldTypeInt64),
+			bsonframe.NewColumn("b", data.FieldTypeString),
+			bsonframe.NewColumn("c", data.FieldTypeBool),
+		}
+		names := []string{}
+		for _, field := range qm.pruneColumns(fields) {
+			names = append(names, field.Name)
+		}
+		Expect(names).To(Equal(expected))
+	},
+		Entry("keeps every field without a whitelist", nil, []string{"_id", "a", "b", "c"}),
+		Entry("keeps whitelisted fields in their original order", []string{"c", "a"}, []string{"a", "c"}),
+		Entry("keeps _id only if whitelisted", []string{"b", "_id"}, []string{"_id", "b"}),
+		Entry("ignores whitelisted fields which are missing", []string{"b", "missing"}, []string{"b"}),
+	)
+
+	DescribeTable("getProjectionPipelineStage", func(qm QueryModel, expected bson.D) {
+		Expect(qm.getProjectionPipelineStage()).To(Equal(bson.D{{Key: "$project", Value: expected}}))
+	},
+		Entry("includes only the whitelisted columns and excludes _id for table queries",
+			QueryModel{QueryType: queryTypeTable, TimestampField: "ts", LabelFields: []string{"host"}, Columns: []string{"a", "b"}},
+			bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}, {Key: "_id", Value: 0}},
+		),
+		Entry("keeps the timestamp and label fields for timeseries queries",
+			QueryModel{QueryType: queryTypeTimeseries, TimestampField: "ts", LabelFields: []string{"host", "region"}, Columns: []string{"value"}},
+			bson.D{{Key: "ts", Value: 1}, {Key: "host", Value: 1}, {Key: "region", Value: 1}, {Key: "value", Value: 1}, {Key: "_id", Value: 0}},
+		),
+		Entry("does not repeat fields which are both whitelisted and timestamp or label fields",
+			QueryModel{QueryType: queryTypeTimeseries, TimestampField: "ts", LabelFields: []string{"host"}, Columns: []string{"host", "ts", "value"}},
+			bson.D{{Key: "ts", Value: 1}, {Key: "host", Value: 1}, {Key: "value", Value: 1}, {Key: "_id", Value: 0}},
+		),
+		Entry("includes _id if whitelisted",
+			QueryModel{QueryType: queryTypeTable, Columns: []string{"_id", "a"}},
+			bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}},
+		),
+		Entry("includes _id if it is the timestamp field",
+			QueryModel{QueryType: queryTypeTimeseries, TimestampField: "_id", Columns: []string{"a"}},
+			bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}},
+		),
+	)
+
+	DescribeTable("getPipeline", func(query string, expected bson.D) {
+		pipeline, err := buildPipeline([]byte(query), MacroContext{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pipeline).ToNot(BeEmpty())
+		Expect(pipeline[len(pipeline)-1]).To(Equal(expected))
+	},
+		Entry("appends the projection when the pipeline has no $project",
+			`{"version":2,"queryType":"Table","aggregation":"[{\"$match\":{\"a\":1}}]","columns":["a"]}`,
+			bson.D{{Key: "$project", Value: bson.D{{Key: "a", Value: 1}, {Key: "_id", Value: 0}}}},
+		),
+		Entry("does not append the projection when the pipeline has its own $project",
+			`{"version":2,"queryType":"Table","aggregation":"[{\"$project\":{\"b\":1}}]","columns":["a"]}`,
+			bson.D{{Key: "$project", Value: bson.D{{Key: "b", Value: int32(1)}}}},
+		),
+		Entry("does not append a projection without a whitelist",
+			`{"version":2,"queryType":"Table","aggregation":"[{\"$match\":{\"a\":1}}]"}`,
+			bson.D{{Key: "$match", Value: bson.D{{Key: "a", Value: int32(1)}}}},
+		),
+	)
+})
@@ -6,14 +6,20 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/pkg/errors"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
 )
 
 // MongoDBDateSpecifierReplacements is a mapping from date specifiers used by
@@ -97,20 +103,36 @@ func ConvertGoTimeFormatToMongo(goFormat string) (mongoFormat string, err error)
 	return mongoFormatBuilder.String(), nil
 }
 
-func connect(ctx context.Context, pCtx backend.PluginContext) (client *mongo.Client, err error, internalErr error) {
-	data := datasource{}
+func loadDatasource(pCtx backend.PluginContext) (data datasource, err error) {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return datasource{}, fmt.Errorf("No data source settings provided")
+	}
 	err = json.Unmarshal([]byte(pCtx.DataSourceInstanceSettings.JSONData), &data.jsonData)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to parse data source settings")
+		return datasource{}, errors.Wrap(err, "Failed to parse data source settings")
 	}
 	secureJsonData, err := json.Marshal(pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to remarshal secure data source settings")
+		return datasource{}, errors.Wrap(err, "Failed to remarshal secure data source settings")
 	}
 	err = json.Unmarshal(secureJsonData, &data.secureJsonData)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "Failed to parse data source settings")
+		return datasource{}, errors.Wrap(err, "Failed to parse data source settings")
+	}
+	return data, nil
+}
+
+// connect creates a new client for the datasource. Any extra options provided are merged with those from the datasource settings.
+func connect(ctx context.Context, pCtx backend.PluginContext, extraOpts ...*mongoOpts.ClientOptions) (client *mongo.Client, err error, internalErr error) {
+	data, err := loadDatasource(pCtx)
+	if err != nil {
+		return nil, nil, err
 	}
+	return connectDatasource(ctx, data, extraOpts...)
+}
+
+// connectDatasource creates a new client for already loaded datasource settings
+func connectDatasource(ctx context.Context, data datasource, extraOpts ...*mongoOpts.ClientOptions) (client *mongo.Client, err error, internalErr error) {
 	opts := mongoOpts.Client()
 
 	mongoURL, err := url.Parse(data.URL)
@@ -131,6 +153,9 @@ func connect(ctx context.Context, pCtx backend.PluginContext) (client *mongo.Cli
 	if tlsConfig != nil {
 		opts.SetTLSConfig(tlsConfig)
 	}
+	if len(extraOpts) != 0 {
+		opts = mongoOpts.MergeClientOptions(append([]*mongoOpts.ClientOptions{opts}, extraOpts...)...)
+	}
 	log.DefaultLogger.Debug("Connecting with options", "opts", opts)
 
 	mongoClient, err := mongo.Connect(ctx, opts)
@@ -141,31 +166,190 @@ func connect(ctx context.Context, pCtx backend.PluginContext) (client *mongo.Cli
 	return mongoClient, nil, nil
 }
 
-func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
-	log.DefaultLogger.Info("query called", "context", pCtx, "query", query)
-	response := backend.DataResponse{}
+func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContext, origin requestOrigin, query backend.DataQuery) (response backend.DataResponse) {
+	log.DefaultLogger.Info("query called", append(origin.logFields(), "context", pCtx, "query", query)...)
+	start := time.Now()
 
 	// Unmarshal the JSON into our QueryModel and parse values into usable representations
-	var qm QueryModel
-
-	var err error
-	err = json.Unmarshal(query.JSON, &qm)
+	qm, err := parseQueryModel(query.JSON)
 	if err != nil {
-		response.Error = errors.Wrap(err, "Invalid query JSON")
+		response.Error = err
 		return response
 	}
 
 	log.DefaultLogger.Debug("Query Model Parsed", "QueryModel", qm)
+	defer func() {
+		recordSLO(ctx, pCtx, &qm, start, response.Error)
+	}()
 
-	pipeline, err := qm.getPipeline(query.TimeRange.From, query.TimeRange.To)
+	if qm.QueryType == queryTypeHealthEvents {
+		return d.healthEventsResponse(query.TimeRange)
+	}
+	if qm.QueryType == queryTypeAnnotations {
+		return d.annotationsQuery(ctx, pCtx, query.TimeRange)
+	}
+	defer func() {
+		d.health.observe(response.Error)
+		origin.recordQuery(query.RefID, response.Error)
+	}()
+	var timeShift time.Duration
+	if qm.RelativeTime != "" || qm.TimeShift != "" {
+		err = qm.requireCurrentModel("Relative time and time shift")
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		query.TimeRange, timeShift, err = ShiftTimeRange(query.TimeRange, qm.RelativeTime, qm.TimeShift, time.Now())
+		if err != nil {
+			response.Error = err
+			return response
+		}
+	}
+	switch qm.Command {
+	case commandDistinct:
+		return d.distinctQuery(ctx, pCtx, origin, query, &qm)
+	case commandCount, commandEstimatedCount:
+		return d.countQuery(ctx, pCtx, origin, query, &qm)
+	}
+	timer := &commandTimer{}
+	var cost *queryCost
+	defer func() {
+		if cost != nil {
+			cost.serverTime = timer.elapsed()
+			d.budget.record(origin, query.RefID, *cost)
+		}
+	}()
+
+	conversionOpts, err := qm.getConversionOptions()
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	err = validateNoDataMode(qm.NoDataMode)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	err = qm.validateStream()
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	err = qm.validateTopN()
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if qm.MaxSeries < 0 {
+		response.Error = fmt.Errorf("Max series must be positive")
+		return response
+	}
+
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	settings = settings.forReadIntent(origin.readIntent())
+	// Legacy queries convert cells as they always have, however large or deep they are
+	if !qm.legacy {
+		settings.applyCellLimits(&conversionOpts)
+	}
+
+	limits, err := qm.getQueryLimits(&settings)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if limits.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.timeout)
+		defer cancel()
+	}
+
+	macroCtx := MacroContext{
+		From:          query.TimeRange.From,
+		To:            query.TimeRange.To,
+		Interval:      query.Interval,
+		MaxDataPoints: query.MaxDataPoints,
+		Snippets:      settings.Snippets,
+	}
+	pipeline, err := qm.getPipeline(macroCtx)
 	if err != nil {
 		response.Error = errors.Wrap(err, "Failed to produce final pipeline")
 		return response
 	}
+	limits.capToDataPoints(&qm, pipeline, query.MaxDataPoints)
+	err = settings.checkTimeGuard(&qm, pipeline)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	// find is nil unless the query uses the find command, in which case pipeline is only used to inspect the query
+	var find *findQuery
+	if qm.Command == commandFind {
+		find, err = qm.getFind(macroCtx)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+	}
+	adhoc, err := AdhocFilterMatch(qm.AdhocFilters)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if adhoc != nil {
+		pipeline = append(mongo.Pipeline{bson.D{bson.E{Key: "$match", Value: adhoc}}}, pipeline...)
+		if find != nil {
+			find.and(adhoc)
+		}
+	}
+	if limits.maxRows > 0 {
+		// Fetch one extra document so that truncation can be detected
+		pipeline = append(pipeline, bson.D{bson.E{Key: "$limit", Value: limits.maxRows + 1}})
+		if find != nil {
+			find.capLimit(int64(limits.maxRows + 1))
+		}
+	}
 
 	log.DefaultLogger.Debug("Effective pipeline", "pipeline", pipeline)
 
-	mongoClient, err, internalErr := connect(ctx, pCtx)
+	hardTimeout, err := settings.getHardQueryTimeout()
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	clientOpts := mongoOpts.Client()
+	readPref, err := settings.getReadPreference()
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if readPref != nil {
+		clientOpts.SetReadPreference(readPref)
+	}
+	monitors := []*event.CommandMonitor{timer.monitor()}
+	// Only reported when secondaries may serve the query, as the primary is otherwise always used
+	var servedBy *servedByTracker
+	if readPref != nil && readPref.Mode() != readpref.PrimaryMode {
+		servedBy = newServedByTracker()
+		monitors = append(monitors, servedBy.monitor())
+	}
+	var tracker *inflightTracker
+	if hardTimeout > 0 {
+		tracker = newInflightTracker()
+		monitors = append(monitors, tracker.monitor())
+	}
+	if commandLogEnabled() {
+		monitors = append(monitors, commandLogMonitor(query.RefID))
+	}
+	clientOpts.SetMonitor(combineMonitors(monitors...))
+
+	mongoClient, err, internalErr := connect(ctx, pCtx, clientOpts)
 	if internalErr != nil {
 		response.Error = errors.Wrap(internalErr, "Internal failure while connecting to mongo")
 		return response
@@ -176,25 +360,178 @@ func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContex
 	}
 	defer mongoClient.Disconnect(ctx)
 
+	if containsOperator(pipeline, "$densify") || containsOperator(pipeline, "$fill") {
+		version, err := getServerVersion(ctx, mongoClient)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		if !version.atLeast(5, 1) {
+			response.Error = fmt.Errorf("$densify and $fill require MongoDB 5.1 or later, but the server is %s", version)
+			return response
+		}
+	}
+
+	if containsOperator(pipeline, "$dateTrunc") {
+		version, err := getServerVersion(ctx, mongoClient)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		if !version.atLeast(5, 0) {
+			pipeline, err = RewriteDateTruncForLegacyServers(pipeline)
+			if err != nil {
+				response.Error = errors.Wrap(err, fmt.Sprintf("Failed to rewrite $dateTrunc for MongoDB %s", version))
+				return response
+			}
+			log.DefaultLogger.Debug("Rewrote $dateTrunc for legacy server", "version", version, "pipeline", pipeline)
+		}
+	}
+
+	if containsOperator(pipeline, percentileMarker) {
+		version, err := getServerVersion(ctx, mongoClient)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		pipeline, err = RewritePercentiles(pipeline, version.atLeast(7, 0))
+		if err != nil {
+			response.Error = errors.Wrap(err, fmt.Sprintf("Failed to rewrite %s for MongoDB %s", percentileMarker, version))
+			return response
+		}
+		log.DefaultLogger.Debug("Rewrote percentiles", "version", version, "pipeline", pipeline)
+	}
+
 	collection := mongoClient.Database(qm.Database).Collection(qm.Collection)
 
+	notices := make([]data.Notice, 0)
+
+	timeField, err := getTimeseriesTimeField(ctx, collection.Database(), qm.Collection)
+	if err != nil {
+		log.DefaultLogger.Warn("Could not determine if collection is a time series, skipping pushdown check", "error", err)
+	} else if notice := checkTimeseriesPushdown(pipeline, qm.Collection, timeField); notice != nil {
+		notices = append(notices, *notice)
+	}
+
+	lintResults, err := lintQuery(ctx, collection, pipeline, &qm, &settings)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	notices = append(notices, lintResults...)
+
 	log.DefaultLogger.Info("Querying MongoDB", "context", pCtx, "query", query, "pipeline", pipeline)
-	cursor, err := collection.Aggregate(ctx, pipeline)
+	comment := newQueryComment()
+	aggregateOpts := mongoOpts.Aggregate().SetComment(comment)
+	if limits.timeout > 0 {
+		aggregateOpts.SetMaxTime(limits.timeout)
+	}
+	hint, err := qm.getHint()
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if hint != nil {
+		aggregateOpts.SetHint(hint)
+	}
+
+	// custom is included in the metadata of every frame, to report decisions made while executing the query
+	custom := map[string]interface{}{}
+
+	if len(qm.CaseInsensitiveFilters) != 0 {
+		stage, collation, strategies, err := planCaseInsensitiveFilters(ctx, collection, qm.CaseInsensitiveFilters, !settings.DataFederation)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		pipeline = append(mongo.Pipeline{stage}, pipeline...)
+		if find != nil {
+			find.and(stage[0].Value.(bson.D))
+		}
+		if collation != nil {
+			aggregateOpts.SetCollation(collation)
+		}
+		custom["caseInsensitiveStrategies"] = strategies
+	}
+
+	// Find queries are shown as their equivalent pipeline
+	executed, err := FormatPipeline(pipeline)
+	if err != nil {
+		log.DefaultLogger.Warn("Could not format executed query", "error", err)
+	}
+
+	if hardTimeout > 0 {
+		killSwitch := startKillSwitch(mongoClient, tracker, comment, hardTimeout)
+		defer func() {
+			killSwitch.Stop()
+			if killSwitch.Killed() {
+				response.Frames = nil
+				response.Error = fmt.Errorf("Query was killed after exceeding the hard ceiling of %s configured for this datasource", hardTimeout)
+			}
+		}()
+	}
+
+	ctx, endSession, err := startQuerySession(ctx, mongoClient, &qm)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	defer endSession()
+
+	snapshotTime, err := qm.getSnapshotTime(query.TimeRange)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	var cursor *mongo.Cursor
+	// Counts of the documents matching a filter are run with a hint of the index which best covers the filter,
+	// which is otherwise left to the query planner.
+	// Atlas Data Federation has no indexes to hint.
+	if !settings.DataFederation && find == nil && snapshotTime == nil && hint == nil && aggregateOpts.Collation == nil {
+		if filter, countField, ok := CountFastPath(pipeline); ok {
+			var fastPath *countFastPathResult
+			cursor, fastPath, err = countWithIndex(ctx, collection, filter, countField, aggregateOpts)
+			if err != nil {
+				response.Error = err
+				return response
+			}
+			if fastPath != nil {
+				custom["countFastPath"] = fastPath
+			}
+		}
+	}
+	switch {
+	case cursor != nil:
+	case snapshotTime != nil:
+		// Snapshot reads of find queries use the equivalent pipeline
+		cursor, err = aggregateAtSnapshot(ctx, collection, pipeline, aggregateOpts, readPref, *snapshotTime)
+	case find != nil:
+		cursor, err = collection.Find(ctx, find.filter, find.options(aggregateOpts))
+	default:
+		cursor, err = collection.Aggregate(ctx, pipeline, aggregateOpts)
+	}
 	if err != nil {
 		response.Error = errors.Wrap(err, "Failed to send query to mongo")
 		return response
 	}
+	defer cursor.Close(ctx)
 
+	cursorLimits := &cursorLimits{queryLimits: limits}
+	defer func() {
+		cost = &queryCost{documentsReturned: cursorLimits.rows, bytesReturned: cursorLimits.bytes}
+	}()
 	buffered := bufferedCursor{
 		Cursor: cursor,
+		limits: cursorLimits,
 	}
 
-	var fields []field
+	var fields []bsonframe.Column
 
 	if qm.SchemaInference {
 		buffering := bufferingCursor{
 			Cursor: cursor,
 			buffer: make([]timestepDocument, 0, qm.SchemaInferenceDepth),
+			limits: cursorLimits,
 		}
 
 		ignored := make(map[string]struct{}, 1+len(qm.LabelFields))
@@ -205,22 +542,49 @@ func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContex
 			}
 		}
 
-		state := NewSchemaInference(ignored)
+		state := bsonframe.NewSchemaInference(ignored)
+		state.Columns = qm.columnSet()
+		state.Opts = conversionOpts
+
+		err = qm.validateMaxColumns()
+		if err != nil {
+			response.Error = err
+			return response
+		}
 
 		doc, more, err := buffering.Next(ctx)
 		for len(buffering.buffer) < qm.SchemaInferenceDepth && more {
-			err = state.updateDoc(doc)
-			if err != nil {
-				break
+			// With a maximum number of columns, the types are only inferred once the most common fields are known,
+			// so that fields in the overflow column are free to have inconsistent types
+			if qm.MaxColumns == 0 {
+				err = state.UpdateDoc(doc)
+				if err != nil {
+					break
+				}
 			}
 
 			doc, more, err = buffering.Next(ctx)
 		}
+		if err == nil && qm.MaxColumns != 0 {
+			state.Columns = TopFields(buffering.buffer, qm.MaxColumns, ignored, qm.columnSet())
+			for _, doc := range buffering.buffer {
+				err = state.UpdateDoc(doc)
+				if err != nil {
+					break
+				}
+			}
+		}
 		if err != nil {
 			response.Error = errors.Wrap(err, "Schema Inference Failed")
 			return response
 		}
-		fields = state.finish()
+		fields = state.Finish()
+		if qm.legacy {
+			// Legacy queries fail on values which do not match the inferred types, rather than coercing them
+			for ix := range fields {
+				fields[ix].Coerce = false
+			}
+		}
 		log.DefaultLogger.Debug(
 			"Inferred schema",
 			"requestedDocs", qm.SchemaInferenceDepth,
@@ -237,6 +601,19 @@ func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContex
 		}
 	}
 
+	fields = qm.pruneColumns(fields)
+	fields, err = qm.applyDurationFields(fields)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	fields, err = qm.applyUnitFields(fields)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	fields = qm.applyBoolFields(fields)
+
 	resolvedModel, err := qm.resolve(fields)
 	if err != nil {
 		response.Error = err
@@ -251,48 +628,257 @@ func (d *MongoDBDatasource) query(ctx context.Context, pCtx backend.PluginContex
 	parser := resultParser{
 		frames: map[string]*data.Frame{},
 		model:  resolvedModel,
+		opts:   conversionOpts,
 	}
 
-	docCount := 0
-	doc, more, decodeErr, err := buffered.Next(ctx)
-	for more {
-		err = parser.parseQueryResultDocument(doc)
+	if qm.DecimalReducer != "" {
+		if qm.QueryType == queryTypeTimeseries {
+			response.Error = fmt.Errorf("Decimal reducers are only supported for %s queries", queryTypeTable)
+			return response
+		}
+		parser.reducer, err = newDecimalReducer(qm.DecimalReducer, fields)
 		if err != nil {
-			response.Error = fmt.Errorf("Failed to convert document number %d: %s, %v", docCount, err, doc)
+			response.Error = err
 			return response
 		}
-		doc, more, decodeErr, err = buffered.Next(ctx)
-		docCount++
 	}
+
+	var docCount int
+	if workers := decodeWorkers(qm.DecodeParallelism); workers > 1 && parser.reducer == nil {
+		docCount, err = parser.parseCursorParallel(ctx, &buffered, workers)
+	} else {
+		docCount, err = parser.parseCursor(ctx, &buffered)
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded && limits.timeout > 0 {
+			err = errors.Wrap(err, fmt.Sprintf("Query exceeded its timeout of %s", limits.timeout))
+		}
+		response.Error = err
+		return response
+	}
+	log.DefaultLogger.Info(fmt.Sprintf("Processed %d documents", docCount))
+	var stats []data.QueryStat
+	if qm.IndexAdvice || qm.Explain || qm.ExecutionStats {
+		// Planning the query again is cheap compared to executing it, and the explain is only requested when debugging.
+		// Execution statistics require executing it again, so are only collected when asked for.
+		verbosity := explainQueryPlanner
+		if qm.ExecutionStats {
+			verbosity = explainExecutionStats
+		}
+		explain, err := explainAggregate(ctx, collection, pipeline, hint, verbosity)
+		if err != nil {
+			log.DefaultLogger.Warn("Could not explain query, skipping index advice, explain, and execution statistics", "error", err)
+			if qm.Explain || qm.ExecutionStats {
+				notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: err.Error()})
+			}
+		}
+		if err == nil && qm.IndexAdvice && settings.DataFederation {
+			notices = append(notices, federationIndexAdviceNotice)
+		} else if err == nil && qm.IndexAdvice {
+			notice, err := indexAdvice(explain, collection.Name(), pipeline)
+			if err != nil {
+				log.DefaultLogger.Warn("Could not check query for collection scans, skipping index advice", "error", err)
+			} else if notice != nil {
+				notices = append(notices, *notice)
+			}
+		}
+		if err == nil && qm.Explain {
+			summary, err := SummarizeExplain(explain)
+			if err != nil {
+				log.DefaultLogger.Warn("Could not summarize explain", "error", err)
+			} else {
+				custom["explain"] = summary
+			}
+		}
+		if err == nil && qm.ExecutionStats {
+			if executionStats, ok := SummarizeExecutionStats(explain); ok {
+				stats = executionStats.queryStats(docCount)
+			} else {
+				log.DefaultLogger.Warn("Explain did not include execution statistics")
+			}
+		}
+	}
+	if notice := cursorLimits.notice(); notice != nil {
+		notices = append(notices, *notice)
+	}
+
+	if parser.reducer != nil {
+		parser.frames = map[string]*data.Frame{"": parser.reducer.frame("")}
+	}
+
+	frames := make([]*data.Frame, 0, len(parser.frames))
+	for _, frame := range parser.frames {
+		frames = append(frames, frame)
+	}
+	frames, noDataNotices, err := d.applyNoDataMode(qm.NoDataMode, origin.readIntent(), query, resolvedModel, frames)
 	if err != nil {
-		if decodeErr {
-			response.Error = errors.Wrap(err, fmt.Sprintf("Failed to decode document number %d", docCount))
+		response.Error = err
+		return response
+	}
+	notices = append(notices, noDataNotices...)
+
+	if qm.TopN > 0 {
+		var collapsed int
+		frames, collapsed, err = CollapseTopN(frames, qm.TopN, qm.TopNBy)
+		if err != nil {
+			response.Error = err
 			return response
-		} else {
-			response.Error = errors.Wrap(err, fmt.Sprintf("Failed to fetch result document number %d", docCount+1))
+		}
+		if collapsed != 0 {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityInfo,
+				Text:     fmt.Sprintf("%d series were collapsed into %s", collapsed, topNOther),
+			})
+		}
+	}
+
+	if qm.MaxSeries > 0 {
+		var dropped int
+		frames, dropped = CapSeries(frames, qm.MaxSeries)
+		if dropped != 0 {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("The query produced %d series, which exceeds the limit of %d. Only the first %d, ordered by their labels, are shown", qm.MaxSeries+dropped, qm.MaxSeries, qm.MaxSeries),
+			})
+		}
+	}
+
+	qm.setDurationUnits(frames)
+	qm.setUnits(frames)
+	ShiftTimeFields(frames, timeShift)
+	err = AddAddressSortKeys(frames, qm.IPFields, qm.MACFields)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if qm.JoinKey != nil {
+		err = ApplyJoinKey(frames, *qm.JoinKey)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	if len(qm.Transforms) != 0 {
+		err = qm.requireCurrentModel("Transforms")
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		var transformNotices []data.Notice
+		frames, transformNotices, err = ApplyTransforms(frames, qm.Transforms)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		notices = append(notices, transformNotices...)
+	}
+
+	if len(qm.ColumnOrder) != 0 {
+		// Applied after transforms, so that the fields they produce can be ordered too
+		err = qm.requireCurrentModel("Column order")
+		if err == nil {
+			err = OrderFields(frames, qm.ColumnOrder)
+		}
+		if err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	if qm.CompressCells != nil {
+		err = qm.requireCurrentModel("Cell compression")
+		if err == nil {
+			err = qm.CompressCells.validate()
+		}
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		compressNotices, err := qm.compressQueryCells(frames, pCtx.DataSourceInstanceSettings.UID)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		notices = append(notices, compressNotices...)
+	}
+
+	if qm.DocumentLinks {
+		AddDocumentLinks(frames, pCtx.DataSourceInstanceSettings.UID, qm.Database, qm.Collection)
+	}
+
+	if qm.SSECompatible || len(qm.LabelsFrom) != 0 {
+		var sseNotices []data.Notice
+		frames, sseNotices, err = ReshapeForSSE(frames, qm.LabelsFrom)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		notices = append(notices, sseNotices...)
+	}
+
+	if qm.Stream != "" {
+		frames, err = d.attachStream(pCtx, query, qm, resolvedModel, frames)
+		if err != nil {
+			response.Error = err
 			return response
 		}
 	}
-	log.DefaultLogger.Info(fmt.Sprintf("Processed %d documents", docCount))
+
+	if servedBy != nil {
+		custom["servedBy"] = servedBy.servedBy()
+		if maxStaleness, ok := readPref.MaxStaleness(); ok {
+			custom["maxStalenessSeconds"] = int(maxStaleness.Seconds())
+		}
+	}
 
 	// add the frames to the response.
-	response.Frames = make([]*data.Frame, 0, len(parser.frames))
-	for _, frame := range parser.frames {
+	notices = append(notices, parser.stats.Notices()...)
+	if notice := parser.skippedRowsNotice(); notice != nil {
+		notices = append(notices, *notice)
+	}
+	if qm.legacy {
+		notices = append(notices, legacyQueryNotice)
+	}
+	response.Frames = make([]*data.Frame, 0, len(frames))
+	for _, frame := range frames {
+		if len(notices) != 0 {
+			frame.AppendNotices(notices...)
+		}
+		if len(custom) != 0 && !qm.HideFromInspector {
+			if frame.Meta == nil {
+				frame.Meta = &data.FrameMeta{}
+			}
+			frame.Meta.Custom = mergeCustomMeta(frame.Meta.Custom, custom)
+		}
 		response.Frames = append(response.Frames, frame)
 	}
+	if executed != "" && !qm.HideFromInspector {
+		setExecutedQueryString(response.Frames, executed)
+	}
+	if len(stats) != 0 && !qm.HideFromInspector {
+		setQueryStats(response.Frames, stats)
+	}
 
 	log.DefaultLogger.Debug("query finished", "context", pCtx, "query", query, "response", response)
 	return response
 }
 
-func (d *MongoDBDatasource) ping(ctx context.Context, req *backend.CheckHealthRequest) error {
-	mongoClient, err, internalErr := connect(ctx, req.PluginContext)
+func (d *MongoDBDatasource) ping(ctx context.Context, req *backend.CheckHealthRequest) (healthDetails, error) {
+	settings, err := loadDatasource(req.PluginContext)
+	if err != nil {
+		return healthDetails{}, err
+	}
+	mongoClient, err, internalErr := connectDatasource(ctx, settings)
 	if internalErr != nil {
-		return errors.Wrap(err, "Failed to connect to mongo")
+		return healthDetails{}, errors.Wrap(internalErr, "Failed to connect to mongo")
 	}
 	if err != nil {
-		return err
+		return healthDetails{}, err
 	}
 	defer mongoClient.Disconnect(ctx)
-	return mongoClient.Ping(ctx, nil)
+	if settings.DataFederation {
+		return checkFederation(ctx, mongoClient, settings.URL)
+	}
+	return checkServer(ctx, mongoClient, settings.URL)
 }
@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+const (
+	// noDataModeEmpty returns no data for an empty result, which alert rules treat as "No Data"
+	noDataModeEmpty = "empty"
+	// noDataModeZero returns a single point, at the end of the time range for timeseries queries, with all numeric fields zero
+	noDataModeZero = "zero"
+	// noDataModeLastValue returns the last row of the most recent non-empty result for the same query, with a notice that it is stale
+	noDataModeLastValue = "lastValue"
+)
+
+func validateNoDataMode(mode string) error {
+	switch mode {
+	case "", noDataModeEmpty, noDataModeZero, noDataModeLastValue:
+		return nil
+	default:
+		return fmt.Errorf("No data mode must be one of: %s, %s, %s", noDataModeEmpty, noDataModeZero, noDataModeLastValue)
+	}
+}
+
+// countRows returns the total number of rows in a set of frames
+func countRows(frames []*data.Frame) int {
+	rows := 0
+	for _, frame := range frames {
+		rows += frame.Rows()
+	}
+	return rows
+}
+
+// zeroFrame produces a frame with a single row, where the time field is the end of the time range, and all other fields are their zero value
+func zeroFrame(model resolvedQueryModel, timeRange backend.TimeRange) (*data.Frame, error) {
+	frame, err := model.makeFrame("", nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range frame.Fields {
+		field.Extend(1)
+		type_ := field.Type().NonNullableType()
+		if type_ == data.FieldTypeTime {
+			field.SetConcrete(0, timeRange.To)
+			continue
+		}
+		field.SetConcrete(0, data.NewFieldFromFieldType(type_, 1).At(0))
+	}
+	return frame, nil
+}
+
+type lastValue struct {
+	frames []*data.Frame
+	at     time.Time
+}
+
+// lastValueStore holds the last row of each frame of the most recent non-empty result of queries using the lastValue no data mode.
+// The zero value is ready to use.
+type lastValueStore struct {
+	lock   sync.Mutex
+	values map[string]lastValue
+}
+
+// lastRows copies the last row of each non-empty frame, so that the copies can be modified independently
+func lastRows(frames []*data.Frame) []*data.Frame {
+	last := make([]*data.Frame, 0, len(frames))
+	for _, frame := range frames {
+		rows := frame.Rows()
+		if rows == 0 {
+			continue
+		}
+		copied := frame.EmptyCopy()
+		copied.AppendRow(frame.RowCopy(rows - 1)...)
+		last = append(last, copied)
+	}
+	return last
+}
+
+func (s *lastValueStore) set(key string, frames []*data.Frame) {
+	last := lastRows(frames)
+	bsonframe.DetachCells(last)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]lastValue)
+	}
+	s.values[key] = lastValue{frames: last, at: time.Now()}
+}
+
+func (s *lastValueStore) get(key string) (lastValue, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	value, ok := s.values[key]
+	if ok {
+		value.frames = lastRows(value.frames)
+	}
+	return value, ok
+}
+
+// applyNoDataMode handles a possibly empty result according to the no data mode of a query
+func (d *MongoDBDatasource) applyNoDataMode(mode string, intent string, query backend.DataQuery, model resolvedQueryModel, frames []*data.Frame) ([]*data.Frame, []data.Notice, error) {
+	empty := countRows(frames) == 0
+	switch mode {
+	case noDataModeZero:
+		if !empty {
+			return frames, nil, nil
+		}
+		frame, err := zeroFrame(model, query.TimeRange)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*data.Frame{frame}, []data.Notice{{
+			Severity: data.NoticeSeverityInfo,
+			Text:     "The query returned no data, so a zero value was returned instead",
+		}}, nil
+	case noDataModeLastValue:
+		// The last value is shared between all time ranges of the same query
+		key, err := QueryCacheKey(intent, backend.DataQuery{JSON: query.JSON})
+		if err != nil {
+			return nil, nil, err
+		}
+		if !empty {
+			d.lastValues.set(key, frames)
+			return frames, nil, nil
+		}
+		last, ok := d.lastValues.get(key)
+		if !ok {
+			return frames, nil, nil
+		}
+		return last.frames, []data.Notice{{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("The query returned no data, showing the last value from %s", last.at.Format(time.RFC3339)),
+		}}, nil
+	default:
+		return frames, nil, nil
+	}
+}
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("No data mode", func() {
+	end := time.Unix(3600, 0).UTC()
+	model := &tableQueryModel{fields: []bsonframe.Column{
+		bsonframe.NewColumn("ts", data.FieldTypeNullableTime),
+		bsonframe.NewColumn("value", data.FieldTypeNullableFloat64),
+		bsonframe.NewColumn("host", data.FieldTypeString),
+	}}
+	query := func(from, to time.Time) backend.DataQuery {
+		return backend.DataQuery{JSON: []byte(`{"collection": "c", "noDataMode": "lastValue"}`), TimeRange: backend.TimeRange{From: from, To: to}}
+	}
+	frame := func(values ...float64) *data.Frame {
+		frame, err := model.makeFrame("", nil)
+		Expect(err).ToNot(HaveOccurred())
+		for ix, value := range values {
+			ts := end.Add(time.Duration(ix) * time.Second)
+			value := value
+			frame.AppendRow(&ts, &value, "a")
+		}
+		return frame
+	}
+
+	DescribeTable("validateNoDataMode", func(mode string, valid bool) {
+		err := validateNoDataMode(mode)
+		if valid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("should accept no mode", "", true),
+		Entry("should accept empty", noDataModeEmpty, true),
+		Entry("should accept zero", noDataModeZero, true),
+		Entry("should accept lastValue", noDataModeLastValue, true),
+		Entry("should reject unknown modes", "guess", false),
+	)
+
+	It("Should return a single zero row at the end of the time range in zero mode", func() {
+		d := &MongoDBDatasource{}
+		frames, notices, err := d.applyNoDataMode(noDataModeZero, readIntentDashboard, query(end.Add(-time.Hour), end), model, []*data.Frame{frame()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notices).To(HaveLen(1))
+		Expect(frames).To(HaveLen(1))
+		Expect(frames[0].Rows()).To(Equal(1))
+		Expect(frames[0].Fields[0].At(0)).To(Equal(&end))
+		Expect(frames[0].Fields[1].At(0)).To(Equal(new(float64)))
+		Expect(frames[0].Fields[2].At(0)).To(Equal(""))
+	})
+
+	It("Should return non-empty results unchanged in zero mode", func() {
+		d := &MongoDBDatasource{}
+		result := []*data.Frame{frame(1, 2)}
+		frames, notices, err := d.applyNoDataMode(noDataModeZero, readIntentDashboard, query(end.Add(-time.Hour), end), model, result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notices).To(BeEmpty())
+		Expect(frames).To(Equal(result))
+	})
+
+	It("Should return the last row of the previous result for any time range in lastValue mode", func() {
+		d := &MongoDBDatasource{}
+		frames, notices, err := d.applyNoDataMode(noDataModeLastValue, readIntentDashboard, query(end.Add(-time.Hour), end), model, []*data.Frame{frame()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notices).To(BeEmpty())
+		Expect(countRows(frames)).To(Equal(0))
+
+		_, _, err = d.applyNoDataMode(noDataModeLastValue, readIntentDashboard, query(end.Add(-time.Hour), end), model, []*data.Frame{frame(1, 2)})
+		Expect(err).ToNot(HaveOccurred())
+
+		frames, notices, err = d.applyNoDataMode(noDataModeLastValue, readIntentDashboard, query(end, end.Add(time.Hour)), model, []*data.Frame{frame()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notices).To(HaveLen(1))
+		Expect(notices[0].Severity).To(Equal(data.NoticeSeverityWarning))
+		Expect(frames).To(HaveLen(1))
+		Expect(frames[0].Rows()).To(Equal(1))
+		two := 2.0
+		Expect(frames[0].Fields[1].At(0)).To(Equal(&two))
+
+		// Changes to a returned last value must not affect the stored one
+		frames[0].Fields[1].Set(0, new(float64))
+		frames, _, err = d.applyNoDataMode(noDataModeLastValue, readIntentDashboard, query(end, end.Add(time.Hour)), model, []*data.Frame{frame()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frames[0].Fields[1].At(0)).To(Equal(&two))
+	})
+
+	It("Should return empty results unchanged in empty mode", func() {
+		d := &MongoDBDatasource{}
+		result := []*data.Frame{frame()}
+		frames, notices, err := d.applyNoDataMode(noDataModeEmpty, readIntentDashboard, query(end.Add(-time.Hour), end), model, result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notices).To(BeEmpty())
+		Expect(frames).To(Equal(result))
+	})
+})
@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// OrderFields reorders the fields of each frame so that the named fields come first, in the given order,
+// followed by the remaining fields in their original order. Names which are not fields of a frame are skipped.
+func OrderFields(frames []*data.Frame, order []string) error {
+	if len(order) == 0 {
+		return nil
+	}
+	positions := make(map[string]int, len(order))
+	for ix, name := range order {
+		if _, ok := positions[name]; ok {
+			return fmt.Errorf("Column %s appears more than once in the column order", name)
+		}
+		positions[name] = ix
+	}
+	for _, frame := range frames {
+		ordered := make([]*data.Field, len(order))
+		rest := make([]*data.Field, 0, len(frame.Fields))
+		for _, field := range frame.Fields {
+			if ix, ok := positions[field.Name]; ok && ordered[ix] == nil {
+				ordered[ix] = field
+				continue
+			}
+			rest = append(rest, field)
+		}
+		fields := make([]*data.Field, 0, len(frame.Fields))
+		for _, field := range ordered {
+			if field != nil {
+				fields = append(fields, field)
+			}
+		}
+		frame.Fields = append(fields, rest...)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package plugin_test
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OrderFields", func() {
+	names := func(frame *data.Frame) []string {
+		names := make([]string, len(frame.Fields))
+		for ix, field := range frame.Fields {
+			names[ix] = field.Name
+		}
+		return names
+	}
+
+	It("Should put the ordered fields first, and keep the rest in place", func() {
+		frame := data.NewFrame("",
+			data.NewField("a", nil, []int64{1}),
+			data.NewField("b", nil, []int64{2}),
+			data.NewField("c", nil, []int64{3}),
+			data.NewField("d", nil, []int64{4}),
+		)
+		Expect(plugin.OrderFields([]*data.Frame{frame}, []string{"c", "missing", "a"})).To(Succeed())
+		Expect(names(frame)).To(Equal([]string{"c", "a", "b", "d"}))
+		Expect(frame.Fields[0].At(0)).To(Equal(int64(3)))
+	})
+
+	It("Should reject duplicate names", func() {
+		Expect(plugin.OrderFields(nil, []string{"a", "a"})).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// requestAppDashboard is a query issued while rendering a dashboard panel
+	requestAppDashboard = "dashboard"
+	// requestAppExplore is an interactive query not issued by a dashboard, such as from Explore or the query inspector
+	requestAppExplore = "explore"
+	// requestAppAlerting is a query issued by the alerting engine, including recording rules
+	requestAppAlerting = "alerting"
+	// requestAppPlugin is a query issued by the plugin itself, such as to warm the cache or materialize a result
+	requestAppPlugin = "plugin"
+
+	fromAlertHeader = "FromAlert"
+	orgIDHeader     = "X-Grafana-Org-Id"
+	panelIDHeader   = "X-Panel-Id"
+)
+
+// requestOrigin describes which Grafana app a query was issued by, and on whose behalf.
+// It determines the read intent of a query, which selects its limits and read preference, and whether it can share cached results.
+type requestOrigin struct {
+	app          string
+	orgID        int64
+	dashboardUID string
+	panelID      string
+}
+
+// requestOriginFromHeaders determines the origin of a query from the headers Grafana sends with it.
+// Grafana does not identify Explore explicitly, so any query not issued by the alerting engine or a dashboard is assumed to be interactive.
+func requestOriginFromHeaders(headers map[string]string, pCtx backend.PluginContext) requestOrigin {
+	origin := requestOrigin{
+		app:          requestAppExplore,
+		orgID:        pCtx.OrgID,
+		dashboardUID: dashboardUIDFromHeaders(headers),
+		panelID:      headerValue(headers, panelIDHeader),
+	}
+	if orgID, err := strconv.ParseInt(headerValue(headers, orgIDHeader), 10, 64); err == nil && origin.orgID == 0 {
+		origin.orgID = orgID
+	}
+	switch {
+	case headerValue(headers, fromAlertHeader) == "true":
+		origin.app = requestAppAlerting
+	case origin.dashboardUID != "":
+		origin.app = requestAppDashboard
+	}
+	return origin
+}
+
+// readIntent returns the read intent of queries from this origin. Only the alerting engine has its own read intent.
+func (o requestOrigin) readIntent() string {
+	if o.app == requestAppAlerting {
+		return readIntentAlert
+	}
+	return readIntentDashboard
+}
+
+// logFields are the key-value pairs identifying the origin in log messages
+func (o requestOrigin) logFields() []interface{} {
+	return []interface{}{"app", o.app, "orgId", o.orgID, "dashboardUid", o.dashboardUID, "panelId", o.panelID}
+}
+
+// queriesTotal counts executed queries by the app they were issued by, and whether they failed
+var queriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "grafana_plugin",
+	Name:      "mongodb_queries_total",
+	Help:      "Number of queries executed by the MongoDB datasource, by originating app and status",
+}, []string{"app", "status"})
+
+func init() {
+	prometheus.MustRegister(queriesTotal)
+}
+
+// recordQuery counts an executed query, and logs it for auditing
+func (o requestOrigin) recordQuery(refID string, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	queriesTotal.WithLabelValues(o.app, status).Inc()
+	log.DefaultLogger.Info("Query executed", append(o.logFields(), "refId", refID, "status", status)...)
+}
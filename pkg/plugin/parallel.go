@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+// parallelDecodeBatchSize is the number of raw documents read from the cursor before they are handed to workers
+const parallelDecodeBatchSize = 1024
+
+// decodeWorkers bounds the number of workers requested by a query to the number of CPUs the plugin may use,
+// as more would only add overhead to every batch
+func decodeWorkers(requested int) int {
+	if max := runtime.GOMAXPROCS(0); requested > max {
+		return max
+	}
+	return requested
+}
+
+type extractedRow struct {
+	doc       timestepDocument
+	labels    data.Labels
+	labelsID  string
+	row       []interface{}
+	err       error
+	decodeErr bool
+}
+
+// parseCursorParallel parses all remaining documents in a cursor, decoding and converting them with
+// the given number of workers. Rows are still appended to frames in the order they were returned.
+// It returns the number of documents that were processed.
+func (p *resultParser) parseCursorParallel(ctx context.Context, cursor *bufferedCursor, workers int) (docCount int, err error) {
+	// Documents buffered for schema inference are already decoded, so there's nothing to gain
+	for _, doc := range cursor.buffer {
+		err = p.parseQueryResultDocument(doc)
+		if err != nil && p.skipPanickedRow(err, docCount) {
+			err = nil
+		}
+		if err != nil {
+			return docCount, fmt.Errorf("Failed to convert document number %d: %s, %v", docCount, err, doc)
+		}
+		docCount++
+	}
+	cursor.buffer = nil
+
+	workerStats := make([]bsonframe.Stats, workers)
+	defer func() {
+		for ix := range workerStats {
+			p.stats.Merge(&workerStats[ix])
+		}
+	}()
+
+	batch := make([]bson.Raw, 0, parallelDecodeBatchSize)
+	rows := make([]extractedRow, parallelDecodeBatchSize)
+	more := true
+	for more {
+		batch = batch[:0]
+		for len(batch) < parallelDecodeBatchSize {
+			more = cursor.Cursor.Next(ctx) && cursor.limits.admit(cursor.Cursor.Current)
+			if !more {
+				break
+			}
+			// Current is only valid until the next call to Next
+			batch = append(batch, append(bson.Raw(nil), cursor.Cursor.Current...))
+		}
+
+		p.extractBatch(batch, rows[:len(batch)], workerStats)
+
+		for ix := range batch {
+			row := &rows[ix]
+			if row.decodeErr {
+				return docCount, errors.Wrap(row.err, fmt.Sprintf("Failed to decode document number %d", docCount))
+			}
+			if row.err != nil && p.skipPanickedRow(row.err, docCount) {
+				docCount++
+				continue
+			}
+			if row.err == nil {
+				row.err = p.appendRow(row.labels, row.labelsID, row.row)
+			}
+			if row.err != nil {
+				return docCount, fmt.Errorf("Failed to convert document number %d: %s, %v", docCount, row.err, row.doc)
+			}
+			docCount++
+		}
+	}
+
+	err = cursor.Cursor.Err()
+	if err != nil {
+		return docCount, errors.Wrap(err, fmt.Sprintf("Failed to fetch result document number %d", docCount+1))
+	}
+	return docCount, nil
+}
+
+// extractBatch decodes and extracts a batch of raw documents into the slot of the same index in rows,
+// with one worker per entry in stats.
+func (p *resultParser) extractBatch(batch []bson.Raw, rows []extractedRow, stats []bsonframe.Stats) {
+	workers := len(stats)
+	wg := sync.WaitGroup{}
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for ix := worker; ix < len(batch); ix += workers {
+				row := extractedRow{doc: make(timestepDocument)}
+				row.err = bson.Unmarshal(batch[ix], &row.doc)
+				if row.err != nil {
+					row.decodeErr = true
+				} else {
+					row.labels, row.labelsID, row.row, row.err = p.extractRow(row.doc, &stats[worker])
+				}
+				rows[ix] = row
+			}
+		}(worker)
+	}
+	wg.Wait()
+}
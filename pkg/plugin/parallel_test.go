@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// convertDocuments converts documents into frames, sorted by name, as a query with explicit value fields does,
+// decoding them with the given number of workers if it is more than one, and returns the conversion notices
+func convertDocuments(rawQuery []byte, docs []interface{}, workers int) ([]*data.Frame, []data.Notice, error) {
+	qm, err := parseQueryModel(rawQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields, err := qm.getFields()
+	if err != nil {
+		return nil, nil, err
+	}
+	resolved, err := qm.resolve(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts, err := qm.getConversionOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx := context.Background()
+	defer cursor.Close(ctx)
+	parser := resultParser{frames: map[string]*data.Frame{}, model: resolved, opts: opts}
+	if workers > 1 {
+		_, err = parser.parseCursorParallel(ctx, &bufferedCursor{Cursor: cursor}, workers)
+	} else {
+		_, err = parser.parseCursor(ctx, &bufferedCursor{Cursor: cursor})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	frames := make([]*data.Frame, 0, len(parser.frames))
+	for _, frame := range parser.frames {
+		frames = append(frames, frame)
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Name < frames[j].Name })
+	return frames, parser.stats.Notices(), nil
+}
+
+var _ = Describe("parseCursorParallel", func() {
+	// Enough documents to span several batches, some too deeply nested, so that truncations are recorded by every worker
+	docs := make([]interface{}, 3000)
+	start := time.Unix(1600000000, 0)
+	for ix := range docs {
+		var nested interface{} = bson.D{{Key: "a", Value: ix}}
+		if ix%3 == 0 {
+			nested = bson.D{{Key: "a", Value: bson.D{{Key: "b", Value: bson.D{{Key: "c", Value: ix}}}}}}
+		}
+		docs[ix] = bson.D{
+			{Key: "timestamp", Value: start.Add(time.Duration(ix) * time.Second)},
+			{Key: "host", Value: []string{"a", "b", "c", "d"}[ix%4]},
+			{Key: "n", Value: int64(ix)},
+			{Key: "nested", Value: nested},
+		}
+	}
+
+	DescribeTable("Should produce the same rows and notices in parallel as serially", func(query string) {
+		serialFrames, serialNotices, err := convertDocuments([]byte(query), docs, 1)
+		Expect(err).ToNot(HaveOccurred())
+		parallelFrames, parallelNotices, err := convertDocuments([]byte(query), docs, 4)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(parallelFrames).To(HaveLen(len(serialFrames)))
+		for ix := range serialFrames {
+			serial, err := json.Marshal(serialFrames[ix])
+			Expect(err).ToNot(HaveOccurred())
+			parallel, err := json.Marshal(parallelFrames[ix])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parallel).To(MatchJSON(serial))
+		}
+		Expect(serialNotices).ToNot(BeEmpty())
+		Expect(parallelNotices).To(Equal(serialNotices))
+	},
+		Entry("for tables", `{"version": 2, "queryType": "Table", "maxCellDepth": 2,
+			"valueFields": ["timestamp", "host", "n", "nested"], "valueFieldTypes": ["time.Time", "string", "int64", "json"]}`),
+		Entry("for time series split by labels", `{"version": 2, "queryType": "Timeseries", "maxCellDepth": 2, "timestampField": "timestamp",
+			"labelFields": ["host"], "valueFields": ["n", "nested"], "valueFieldTypes": ["int64", "json"]}`),
+	)
+})
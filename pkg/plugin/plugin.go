@@ -2,6 +2,9 @@ package plugin
 
 import (
 	"context"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
@@ -20,17 +23,74 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*MongoDBDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*MongoDBDatasource)(nil)
+	_ backend.CallResourceHandler   = (*MongoDBDatasource)(nil)
+	_ backend.StreamHandler         = (*MongoDBDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*MongoDBDatasource)(nil)
 )
 
 // NewMongoDBDatasource creates a new datasource instance.
-func NewMongoDBDatasource(_ backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	return &MongoDBDatasource{}, nil
+func NewMongoDBDatasource(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	d := &MongoDBDatasource{}
+	d.resources = d.newResourceHandler()
+
+	// Problems with the settings for caching and background jobs should not prevent the datasource from being used for queries
+	pCtx := backend.PluginContext{DataSourceInstanceSettings: &settings}
+	ds, err := loadDatasource(pCtx)
+	if err != nil {
+		log.DefaultLogger.Warn("Could not load datasource settings, caching and background jobs are disabled", "error", err)
+		return d, nil
+	}
+	if ds.HealthEventsEnabled {
+		d.health = newHealthEventLog()
+	}
+	jobs := []scheduledJob{}
+	ttl, err := ds.getCacheTTL()
+	if err != nil {
+		log.DefaultLogger.Warn("Caching is disabled", "error", err)
+	} else if ttl > 0 {
+		d.cache = newQueryCache(ttl)
+	}
+	warmupJobs, err := ds.getWarmupJobs(ttl)
+	if err != nil {
+		log.DefaultLogger.Warn("Warm-up queries are disabled", "error", err)
+	} else {
+		for _, job := range warmupJobs {
+			jobs = append(jobs, d.warmupScheduledJob(pCtx, job))
+		}
+	}
+	mergeJobs, err := ds.getScheduledMergeJobs(pCtx)
+	if err != nil {
+		log.DefaultLogger.Warn("Scheduled merges are disabled", "error", err)
+	} else {
+		jobs = append(jobs, mergeJobs...)
+	}
+	exports := newExportHistory()
+	exportJobs, err := ds.getScheduledExportJobs(pCtx, exports)
+	if err != nil {
+		log.DefaultLogger.Warn("Scheduled exports are disabled", "error", err)
+	} else if len(exportJobs) != 0 {
+		d.exports = exports
+		jobs = append(jobs, exportJobs...)
+	}
+	if len(jobs) != 0 {
+		d.scheduler = startScheduler(jobs)
+	}
+	return d, nil
 }
 
 // MongoDBDatasource is a datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type MongoDBDatasource struct {
+	resources  backend.CallResourceHandler
+	cache      *queryCache
+	scheduler  *scheduler
+	coalescer  QueryCoalescer
+	lastValues lastValueStore
+	health     *healthEventLog
+	exports    *exportHistory
+	budget     queryBudget
+	// streams are the streamed queries seen by QueryData, by channel path
+	streams sync.Map
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -38,6 +98,9 @@ type MongoDBDatasource struct {
 // be disposed and a new one will be created using NewMongoDBDatasource factory function.
 func (d *MongoDBDatasource) Dispose() {
 	// Clean up datasource instance resources.
+	if d.scheduler != nil {
+		d.scheduler.Stop()
+	}
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -50,9 +113,26 @@ func (d *MongoDBDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
+	origin := requestOriginFromHeaders(req.Headers, req.PluginContext)
+	wave := NewQueryWave(req.Headers)
+
+	var budget *requestBudget
+	settings, err := loadDatasource(req.PluginContext)
+	if err == nil {
+		var total time.Duration
+		total, err = settings.getRequestTimeBudget()
+		budget = newRequestBudget(ctx, total, len(req.Queries), time.Now())
+	}
+	if err != nil {
+		// Queries report invalid settings themselves
+		log.DefaultLogger.Warn("Request time budget is disabled", "error", err)
+	}
+
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, q)
+		res := budget.run(ctx, q, func(ctx context.Context) backend.DataResponse {
+			return d.dedupedQuery(ctx, req.PluginContext, origin, wave, q)
+		})
 
 		// save the response in a hashmap
 		// based on with RefID as identifier
@@ -62,6 +142,15 @@ func (d *MongoDBDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 	return response, nil
 }
 
+// CallResource handles requests to the resource routes of the datasource, such as those used by the query editor.
+func (d *MongoDBDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	log.DefaultLogger.Debug("CallResource called", "path", req.Path, "method", req.Method)
+	if d.resources == nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusNotFound})
+	}
+	return d.resources.CallResource(ctx, req, sender)
+}
+
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
@@ -69,16 +158,7 @@ func (d *MongoDBDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 func (d *MongoDBDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	log.DefaultLogger.Info("CheckHealth called", "request", req)
 
-	err := d.ping(ctx, req)
-	if err != nil {
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "Ping failed: " + err.Error(),
-		}, nil
-	}
-
-	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "MongoDB is Responding",
-	}, nil
+	details, err := d.ping(ctx, req)
+	d.health.observe(err)
+	return healthResult(details, err), nil
 }
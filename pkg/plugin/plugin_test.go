@@ -52,7 +52,11 @@ var _ = Describe("ToGrafanaValue", func() {
 				return
 			}
 			Expect(err).ToNot(HaveOccurred())
-			Expect(actualOutValue).To(Equal(expectedOutValue))
+			if expectedOutValue == nil {
+				Expect(actualOutValue).To(BeNil())
+			} else {
+				Expect(actualOutValue).To(Equal(expectedOutValue))
+			}
 			Expect(actualType).To(Equal(expectedType))
 		},
 		Entry("an int32 to an int32", int32(1), int32(1), data.FieldTypeInt32, true),
@@ -116,5 +120,21 @@ var _ = Describe("ToGrafanaValue", func() {
 			data.FieldTypeFloat64,
 			true,
 		),
+		Entry("a Null to nil",
+			bsonprim.Null{},
+			nil,
+			data.FieldTypeUnknown,
+			true,
+		),
+		Entry("a DBRef to a canonical json.RawMessage",
+			bson.D{
+				bson.E{Key: "$db", Value: "app"},
+				bson.E{Key: "$id", Value: int32(7)},
+				bson.E{Key: "$ref", Value: "users"},
+			},
+			json.RawMessage(`{"$ref":"users","$id":7,"$db":"app"}`),
+			data.FieldTypeJSON,
+			true,
+		),
 	)
 })
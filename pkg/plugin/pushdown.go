@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// getTimeseriesTimeField returns the time field of a collection if it is a time series collection,
+// or an empty string if it is not
+func getTimeseriesTimeField(ctx context.Context, db *mongo.Database, collection string) (string, error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{bson.E{Key: "name", Value: collection}})
+	if err != nil {
+		return "", err
+	}
+	for _, spec := range specs {
+		if spec.Name != collection || spec.Options == nil {
+			continue
+		}
+		timeField, ok := spec.Options.Lookup("timeseries", "timeField").StringValueOK()
+		if ok {
+			return timeField, nil
+		}
+	}
+	return "", nil
+}
+
+// checkTimeseriesPushdown produces a warning if the first $match stage referencing the time field of a time series
+// collection is preceded by stages other than $match, as this prevents the server from using it to filter buckets
+// before unpacking them
+func checkTimeseriesPushdown(pipeline mongo.Pipeline, collection, timeField string) *data.Notice {
+	if timeField == "" {
+		return nil
+	}
+	firstBlocker := -1
+	for ix, stage := range pipeline {
+		if len(stage) != 1 {
+			continue
+		}
+		if stage[0].Key != "$match" {
+			if firstBlocker == -1 {
+				firstBlocker = ix
+			}
+			continue
+		}
+		if !referencesField(stage[0].Value, timeField) {
+			continue
+		}
+		if firstBlocker == -1 {
+			return nil
+		}
+		return &data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf(
+				"The $match on time field %s of time series collection %s (stage %d) appears after a %s stage (stage %d), "+
+					"which prevents it from being pushed down to the stored buckets. "+
+					"Moving it to the start of the pipeline (e.g. by using Auto Time Bound at Start) can make this query significantly faster",
+				timeField, collection, ix+1, pipeline[firstBlocker][0].Key, firstBlocker+1,
+			),
+		}
+	}
+	return nil
+}
+
+// referencesField returns true if a query expression filters on a field by name or by "$field" path
+func referencesField(value interface{}, name string) bool {
+	switch v := value.(type) {
+	case bson.D:
+		for _, elem := range v {
+			if elem.Key == name || strings.HasPrefix(elem.Key, name+".") || referencesField(elem.Value, name) {
+				return true
+			}
+		}
+	case bson.M:
+		for key, elem := range v {
+			if key == name || strings.HasPrefix(key, name+".") || referencesField(elem, name) {
+				return true
+			}
+		}
+	case bsonPrim.A:
+		for _, elem := range v {
+			if referencesField(elem, name) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if referencesField(elem, name) {
+				return true
+			}
+		}
+	case string:
+		return v == "$"+name || strings.HasPrefix(v, "$"+name+".")
+	}
+	return false
+}
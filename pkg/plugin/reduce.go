@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+const (
+	decimalReducerSum  = "sum"
+	decimalReducerMean = "mean"
+)
+
+// decimalReducer reduces value fields across all documents into a single row using exact rational arithmetic,
+// so that e.g. summing many Decimal128 values doesn't accumulate float64 rounding error.
+// Values are only converted to float64 once, at the very end.
+type decimalReducer struct {
+	reducer string
+	fields  []bsonframe.Column
+	totals  []*big.Rat
+	counts  []int64
+}
+
+func newDecimalReducer(reducer string, fields []bsonframe.Column) (*decimalReducer, error) {
+	switch reducer {
+	case decimalReducerSum, decimalReducerMean:
+	default:
+		return nil, fmt.Errorf("Decimal reducer must be one of: %s, %s", decimalReducerSum, decimalReducerMean)
+	}
+	r := &decimalReducer{
+		reducer: reducer,
+		fields:  fields,
+		totals:  make([]*big.Rat, len(fields)),
+		counts:  make([]int64, len(fields)),
+	}
+	for ix := range r.totals {
+		r.totals[ix] = new(big.Rat)
+	}
+	return r, nil
+}
+
+func (r *decimalReducer) add(doc timestepDocument) error {
+	for ix, field := range r.fields {
+		value, ok := doc[field.Name]
+		if !ok || value == nil {
+			continue
+		}
+		rat, err := toRat(value)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Failed to reduce field %s", field.Name))
+		}
+		r.totals[ix].Add(r.totals[ix], rat)
+		r.counts[ix]++
+	}
+	return nil
+}
+
+// frame produces a single-row frame with one nullable float64 field per value field,
+// which is null if no documents had a value for that field
+func (r *decimalReducer) frame(id string) *data.Frame {
+	frame := data.NewFrame(id)
+	for ix, field := range r.fields {
+		var value *float64
+		if r.counts[ix] != 0 {
+			result := new(big.Rat).Set(r.totals[ix])
+			if r.reducer == decimalReducerMean {
+				result.Quo(result, new(big.Rat).SetInt64(r.counts[ix]))
+			}
+			f, _ := result.Float64()
+			value = &f
+		}
+		frame.Fields = append(frame.Fields, data.NewField(field.Name, nil, []*float64{value}))
+	}
+	return frame
+}
+
+// toRat exactly converts a BSON numeric value to a rational number
+func toRat(value interface{}) (*big.Rat, error) {
+	switch v := value.(type) {
+	case int32:
+		return new(big.Rat).SetInt64(int64(v)), nil
+	case int64:
+		return new(big.Rat).SetInt64(v), nil
+	case float64:
+		rat := new(big.Rat)
+		if rat.SetFloat64(v) == nil {
+			return nil, fmt.Errorf("Cannot reduce non-finite value %v", v)
+		}
+		return rat, nil
+	case bsonPrim.Decimal128:
+		if v.IsNaN() || v.IsInf() != 0 {
+			return nil, fmt.Errorf("Cannot reduce non-finite value %s", v)
+		}
+		mantissa, exp, err := v.BigInt()
+		if err != nil {
+			return nil, err
+		}
+		rat := new(big.Rat).SetInt(mantissa)
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exp))), nil)
+		if exp >= 0 {
+			rat.Mul(rat, new(big.Rat).SetInt(scale))
+		} else {
+			rat.Quo(rat, new(big.Rat).SetInt(scale))
+		}
+		return rat, nil
+	}
+	return nil, fmt.Errorf("Cannot reduce non-numeric value %#v", value)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
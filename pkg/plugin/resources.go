@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// defaultResourceTimeout bounds requests to MongoDB made by resource routes when no maximum query timeout is configured
+const defaultResourceTimeout = 30 * time.Second
+
+// newResourceHandler builds the handler for all resource routes served by the datasource,
+// available from Grafana at /api/datasources/uid/<uid>/resources/<route>
+func (d *MongoDBDatasource) newResourceHandler() backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alerts", d.handleAlerts)
+	mux.HandleFunc("/migrate-query", d.handleMigrateQuery)
+	mux.HandleFunc("/materialize", d.handleMaterialize)
+	mux.HandleFunc("/test-alert-query", d.handleTestAlertQuery)
+	mux.HandleFunc("/validate", d.handleValidate)
+	mux.HandleFunc("/export", d.handleExport)
+	mux.HandleFunc("/scheduled-exports", d.handleScheduledExports)
+	mux.HandleFunc("/events", d.handleEvents)
+	mux.HandleFunc("/budget", d.handleBudget)
+	mux.HandleFunc("/templates", d.handleTemplates)
+	mux.HandleFunc("/templates/", d.handleTemplates)
+	mux.HandleFunc("/databases", d.handleDatabases)
+	mux.HandleFunc("/databases/", d.handleDatabases)
+	mux.HandleFunc("/fields", d.handleFields)
+	mux.HandleFunc("/collections", d.handleCollections)
+	mux.HandleFunc("/collections/", d.handleCollections)
+	mux.HandleFunc("/demo-data", d.handleDemoData)
+	mux.HandleFunc("/suggest", d.handleSuggest)
+	mux.HandleFunc("/annotations", d.handleAnnotations)
+	mux.HandleFunc("/annotations/", d.handleAnnotations)
+	mux.HandleFunc("/benchmark", d.handleBenchmark)
+	return httpadapter.New(mux)
+}
+
+const (
+	// resourceErrorReasonPermission indicates the datasource user lacks the privileges for a request,
+	// in which case the editor should fall back to free-text input
+	resourceErrorReasonPermission = "permission"
+
+	// mongoErrorCodeUnauthorized is returned by MongoDB when a user lacks the privileges for a command
+	mongoErrorCodeUnauthorized = 13
+)
+
+// resourceError is the body of all non-2xx responses from resource routes
+type resourceError struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to write resource response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, resourceError{Error: err.Error()})
+}
+
+// isPermissionError returns true if an error from MongoDB indicates the user lacks the required privileges
+func isPermissionError(err error) bool {
+	var serverErr mongo.ServerError
+	return stderrors.As(err, &serverErr) && serverErr.HasErrorCode(mongoErrorCodeUnauthorized)
+}
+
+// writeMongoError writes an error response for a failed request to MongoDB,
+// distinguishing missing privileges from other failures
+func writeMongoError(w http.ResponseWriter, err error) {
+	if isPermissionError(err) {
+		writeJSON(w, http.StatusForbidden, resourceError{Error: err.Error(), Reason: resourceErrorReasonPermission})
+		return
+	}
+	writeError(w, http.StatusBadGateway, err)
+}
+
+// resourcePathSegments splits the path of a resource request after a prefix into its unescaped segments
+func resourcePathSegments(r *http.Request, prefix string) ([]string, error) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	if path == "" {
+		return []string{}, nil
+	}
+	segments := strings.Split(path, "/")
+	for ix, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid path")
+		}
+		segments[ix] = unescaped
+	}
+	return segments, nil
+}
+
+// queryIntParam parses an optional integer query parameter, clamping it to a maximum
+func queryIntParam(r *http.Request, name string, def, max int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	if value > max {
+		value = max
+	}
+	return value, nil
+}
+
+// resourceClient loads the datasource settings and connects to MongoDB on behalf of a resource request.
+// The returned context is bounded by the maximum query timeout of the datasource.
+// If either fails, an error response is written, and ok is false. Otherwise, the caller must call done when finished.
+func resourceClient(w http.ResponseWriter, r *http.Request) (ctx context.Context, client *mongo.Client, settings datasource, done func(), ok bool) {
+	ctx = r.Context()
+	pCtx := httpadapter.PluginConfigFromContext(ctx)
+	settings, err := loadDatasource(pCtx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, nil, datasource{}, nil, false
+	}
+	limits, err := (&QueryModel{}).getQueryLimits(&settings)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, nil, datasource{}, nil, false
+	}
+	timeout := limits.timeout
+	if timeout <= 0 {
+		timeout = settings.getResourceTimeout()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	client, err, internalErr := connect(ctx, pCtx)
+	if internalErr != nil {
+		cancel()
+		writeError(w, http.StatusInternalServerError, internalErr)
+		return nil, nil, datasource{}, nil, false
+	}
+	if err != nil {
+		cancel()
+		writeError(w, http.StatusBadGateway, err)
+		return nil, nil, datasource{}, nil, false
+	}
+	done = func() {
+		client.Disconnect(ctx)
+		cancel()
+	}
+	return ctx, client, settings, done, true
+}
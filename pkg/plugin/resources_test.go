@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// resourceRecorder records the status and body of a resource response
+type resourceRecorder struct {
+	status int
+	body   []byte
+}
+
+func (r *resourceRecorder) Send(resp *backend.CallResourceResponse) error {
+	if resp.Status != 0 {
+		r.status = resp.Status
+	}
+	r.body = append(r.body, resp.Body...)
+	return nil
+}
+
+// callResource makes a GET request to a resource route of a datasource with the given settings, without any running MongoDB
+func callResource(jsonData string, url string) (int, string) {
+	d := &MongoDBDatasource{}
+	d.resources = d.newResourceHandler()
+	settings := backend.DataSourceInstanceSettings{UID: "resources", JSONData: []byte(jsonData)}
+	recorder := &resourceRecorder{}
+	err := d.CallResource(context.Background(), &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &settings},
+		Path:          strings.SplitN(url, "?", 2)[0],
+		Method:        http.MethodGet,
+		URL:           url,
+	}, recorder)
+	Expect(err).ToNot(HaveOccurred())
+	return recorder.status, string(recorder.body)
+}
+
+var _ = Describe("resourcePathSegments", func() {
+	DescribeTable("Should split", func(path string, expected []string) {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		Expect(resourcePathSegments(r, "/collections")).To(Equal(expected))
+	},
+		Entry("nothing after the prefix into no segments", "/collections", []string{}),
+		Entry("each segment", "/collections/c/fields/host/values", []string{"c", "fields", "host", "values"}),
+		Entry("escaped slashes and dots into a single segment", "/collections/c/fields/a%2Fb.c/values", []string{"c", "fields", "a/b.c", "values"}),
+	)
+})
+
+var _ = Describe("queryIntParam", func() {
+	DescribeTable("Should parse", func(query string, expected int) {
+		r := httptest.NewRequest(http.MethodGet, "/route"+query, nil)
+		Expect(queryIntParam(r, "limit", 50, 1000)).To(Equal(expected))
+	},
+		Entry("a missing parameter as the default", "", 50),
+		Entry("a value below the maximum as itself", "?limit=10", 10),
+		Entry("a value above the maximum as the maximum", "?limit=5000", 1000),
+	)
+
+	DescribeTable("Should reject", func(query string) {
+		r := httptest.NewRequest(http.MethodGet, "/route"+query, nil)
+		_, err := queryIntParam(r, "limit", 50, 1000)
+		Expect(err).To(MatchError("limit must be a positive integer"))
+	},
+		Entry("non-integers", "?limit=many"),
+		Entry("zero", "?limit=0"),
+		Entry("negative values", "?limit=-1"),
+	)
+})
@@ -0,0 +1,265 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+)
+
+// maxExportRuns is the number of most recent scheduled export runs retained by each datasource instance
+const maxExportRuns = 100
+
+// defaultMaxExportFiles is the number of most recent files kept for a scheduled export which does not set its own limit
+const defaultMaxExportFiles = 30
+
+// exportNamePattern restricts the names of scheduled exports to those which are safe to use in file names
+var exportNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// scheduledExport is an export that is run periodically in the background, writing its result to a file in the export directory
+type scheduledExport struct {
+	exportRequest
+	// Name identifies the export in its run history, and prefixes the names of the files it writes
+	Name string `json:"name"`
+	// Interval is how often the export should be run
+	Interval string `json:"interval"`
+	// MaxFiles is the number of most recent files of the export kept in the export directory. Older files are deleted after
+	// each successful run. Zero uses defaultMaxExportFiles.
+	MaxFiles int `json:"maxFiles"`
+}
+
+// maxFiles returns the number of most recent files of the export to keep
+func (s *scheduledExport) maxFiles() int {
+	if s.MaxFiles == 0 {
+		return defaultMaxExportFiles
+	}
+	return s.MaxFiles
+}
+
+// exportRun records a single run of a scheduled export
+type exportRun struct {
+	Name      string        `json:"name"`
+	Started   time.Time     `json:"started"`
+	Duration  time.Duration `json:"durationNs"`
+	Documents int           `json:"documents"`
+	// File is the path of the file written, if the run succeeded
+	File  string `json:"file,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// exportHistory records the most recent runs of scheduled exports. Runs are only held in memory, and so are lost when the
+// datasource instance is recreated.
+type exportHistory struct {
+	lock sync.Mutex
+	runs []exportRun
+}
+
+func newExportHistory() *exportHistory {
+	return &exportHistory{runs: make([]exportRun, 0)}
+}
+
+func (h *exportHistory) append(run exportRun) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.runs) == maxExportRuns {
+		h.runs = append(h.runs[:0], h.runs[1:]...)
+	}
+	h.runs = append(h.runs, run)
+}
+
+// list returns the recorded runs, oldest first
+func (h *exportHistory) list() []exportRun {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]exportRun{}, h.runs...)
+}
+
+// exportFileTimeFormat is the format of the start time in the names of export files, which sort in the order the runs started
+const exportFileTimeFormat = "20060102T150405Z"
+
+// exportFileName is the name of the file written by a run of a scheduled export started at a given time
+func exportFileName(name, format string, started time.Time) string {
+	return fmt.Sprintf("%s-%s.%s", name, started.UTC().Format(exportFileTimeFormat), format)
+}
+
+// pruneExportFiles deletes all but the most recent files written by a scheduled export, returning how many were deleted.
+// Only files named as the export names them are considered, so other files in the directory are never deleted.
+func pruneExportFiles(directory, name string, keep int) (int, error) {
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `-[0-9]{8}T[0-9]{6}Z\.[A-Za-z0-9]+$`)
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to list export directory")
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && pattern.MatchString(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	if len(files) <= keep {
+		return 0, nil
+	}
+	sort.Strings(files)
+	deleted := 0
+	for _, file := range files[:len(files)-keep] {
+		err = os.Remove(filepath.Join(directory, file))
+		if err != nil {
+			return deleted, errors.Wrap(err, "Failed to delete old export file")
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// writeExportFile runs an export, and writes its result to a file in a directory. The result is written to a temporary file
+// which is renamed once complete, so that tools watching the directory never see a partial export.
+func writeExportFile(ctx context.Context, pCtx backend.PluginContext, e export, req *exportRequest, path string) (int, error) {
+	ctx, cursor, done, err := e.open(ctx, pCtx, requestOrigin{app: requestAppPlugin, orgID: pCtx.OrgID})
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to create export file")
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	buffered := bufio.NewWriter(file)
+	count, err := exportCursor(ctx, cursor, req.newDocumentWriter(buffered), buffered.Flush)
+	if err != nil {
+		return count, errors.Wrap(err, "Failed to write export file")
+	}
+	err = file.Close()
+	if err != nil {
+		return count, errors.Wrap(err, "Failed to write export file")
+	}
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return count, errors.Wrap(err, "Failed to write export file")
+	}
+	return count, nil
+}
+
+// exportScheduledJob periodically writes the result of a query to a new file in the export directory
+func exportScheduledJob(pCtx backend.PluginContext, scheduled scheduledExport, interval time.Duration, directory string, history *exportHistory) scheduledJob {
+	return scheduledJob{
+		name:     "scheduled export " + scheduled.Name,
+		interval: interval,
+		run: func(ctx context.Context) error {
+			run := exportRun{Name: scheduled.Name, Started: time.Now()}
+			defer func() {
+				run.Duration = time.Since(run.Started)
+				history.append(run)
+			}()
+			settings, err := loadDatasource(pCtx)
+			if err != nil {
+				run.Error = err.Error()
+				return err
+			}
+			settings = settings.forReadIntent(requestOrigin{app: requestAppPlugin}.readIntent())
+			e, err := scheduled.prepare(&settings, run.Started)
+			if err != nil {
+				run.Error = err.Error()
+				return err
+			}
+			path := filepath.Join(directory, exportFileName(scheduled.Name, scheduled.Format, run.Started))
+			run.Documents, err = writeExportFile(ctx, pCtx, e, &scheduled.exportRequest, path)
+			if err != nil {
+				run.Error = err.Error()
+				return err
+			}
+			run.File = path
+			log.DefaultLogger.Info("Wrote scheduled export", "name", scheduled.Name, "file", path, "documents", run.Documents)
+			deleted, err := pruneExportFiles(directory, scheduled.Name, scheduled.maxFiles())
+			if err != nil {
+				// The export itself succeeded, so this is only reported
+				log.DefaultLogger.Warn("Failed to delete old scheduled export files", "name", scheduled.Name, "error", err)
+			} else if deleted != 0 {
+				log.DefaultLogger.Info("Deleted old scheduled export files", "name", scheduled.Name, "files", deleted)
+			}
+			return nil
+		},
+	}
+}
+
+// getScheduledExportJobs validates the configured scheduled exports
+func (d *datasource) getScheduledExportJobs(pCtx backend.PluginContext, history *exportHistory) ([]scheduledJob, error) {
+	if len(d.ScheduledExports) == 0 {
+		return nil, nil
+	}
+	if d.ExportDirectory == "" {
+		return nil, fmt.Errorf("Scheduled exports require an export directory")
+	}
+	info, err := os.Stat(d.ExportDirectory)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid export directory")
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Export directory %s is not a directory", d.ExportDirectory)
+	}
+	names := make(map[string]struct{}, len(d.ScheduledExports))
+	jobs := make([]scheduledJob, 0, len(d.ScheduledExports))
+	for ix, scheduled := range d.ScheduledExports {
+		if !exportNamePattern.MatchString(scheduled.Name) {
+			return nil, fmt.Errorf("Scheduled export %d must have a name of only letters, digits, underscores, and dashes", ix)
+		}
+		if _, ok := names[scheduled.Name]; ok {
+			return nil, fmt.Errorf("There is more than one scheduled export named %s", scheduled.Name)
+		}
+		names[scheduled.Name] = struct{}{}
+		// Catch invalid queries now, rather than every interval
+		_, err := scheduled.prepare(d, time.Now())
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid scheduled export %s", scheduled.Name))
+		}
+		interval, err := time.ParseDuration(scheduled.Interval)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid interval for scheduled export %s", scheduled.Name))
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("Scheduled export %s must have a positive interval", scheduled.Name)
+		}
+		if scheduled.MaxFiles < 0 {
+			return nil, fmt.Errorf("Scheduled export %s must keep a positive number of files", scheduled.Name)
+		}
+		jobs = append(jobs, exportScheduledJob(pCtx, scheduled, interval, d.ExportDirectory, history))
+	}
+	return jobs, nil
+}
+
+type scheduledExportsResponse struct {
+	Runs []exportRun `json:"runs"`
+}
+
+// handleScheduledExports returns the most recent runs of the scheduled exports of this datasource instance.
+// This is only available to Grafana admins, as it reveals paths on the Grafana server.
+func (d *MongoDBDatasource) handleScheduledExports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only GET is supported"))
+		return
+	}
+	user := httpadapter.UserFromContext(r.Context())
+	if user == nil || user.Role != grafanaAdminRole {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Only admins may view scheduled exports"))
+		return
+	}
+	if d.exports == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No scheduled exports are configured for this datasource"))
+		return
+	}
+	writeJSON(w, http.StatusOK, scheduledExportsResponse{Runs: d.exports.list()})
+}
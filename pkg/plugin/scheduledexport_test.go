@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("exportFileName", func() {
+	It("Should name files by the UTC start time of the run", func() {
+		started := time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("UTC+2", 2*60*60))
+		Expect(exportFileName("daily-report", "csv", started)).To(Equal("daily-report-20220304T030607Z.csv"))
+	})
+})
+
+var _ = Describe("pruneExportFiles", func() {
+	var directory string
+
+	BeforeEach(func() {
+		var err error
+		directory, err = ioutil.TempDir("", "exports")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(os.RemoveAll, directory)
+	})
+
+	files := func() []string {
+		entries, err := ioutil.ReadDir(directory)
+		Expect(err).ToNot(HaveOccurred())
+		names := make([]string, len(entries))
+		for ix, entry := range entries {
+			names[ix] = entry.Name()
+		}
+		return names
+	}
+
+	It("Should only keep the most recent files of the export", func() {
+		started := time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC)
+		// Written out of order, to show that files are ordered by their names rather than when they were written
+		for _, hours := range []int{2, 0, 3, 1} {
+			name := exportFileName("daily", "csv", started.Add(time.Duration(hours)*time.Hour))
+			Expect(ioutil.WriteFile(filepath.Join(directory, name), nil, 0644)).To(Succeed())
+		}
+		others := []string{
+			".daily-20220304T040000Z.csv.123456",
+			"daily-extra-20220304T000000Z.csv",
+			"daily-report.csv",
+			"weekly-20220301T000000Z.csv",
+		}
+		for _, name := range others {
+			Expect(ioutil.WriteFile(filepath.Join(directory, name), nil, 0644)).To(Succeed())
+		}
+
+		deleted, err := pruneExportFiles(directory, "daily", 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(Equal(2))
+		Expect(files()).To(ConsistOf(append(others, "daily-20220304T020000Z.csv", "daily-20220304T030000Z.csv")))
+
+		deleted, err = pruneExportFiles(directory, "daily", 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted).To(Equal(0))
+	})
+})
@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// scheduledJob is a task which is run periodically in the background for the lifetime of a datasource instance
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// scheduler runs a set of scheduled jobs until stopped
+type scheduler struct {
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// startScheduler starts a goroutine for each job, which runs it immediately, then once per interval
+func startScheduler(jobs []scheduledJob) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &scheduler{cancel: cancel}
+	for _, job := range jobs {
+		s.done.Add(1)
+		go func(job scheduledJob) {
+			defer s.done.Done()
+			ticker := time.NewTicker(job.interval)
+			defer ticker.Stop()
+			for {
+				started := time.Now()
+				err := job.run(ctx)
+				if err != nil && ctx.Err() == nil {
+					log.DefaultLogger.Warn("Scheduled job failed", "job", job.name, "error", err)
+				} else if err == nil {
+					log.DefaultLogger.Debug("Scheduled job finished", "job", job.name, "duration", time.Since(started))
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(job)
+	}
+	return s
+}
+
+// Stop cancels any running jobs and waits for them to exit
+func (s *scheduler) Stop() {
+	s.cancel()
+	s.done.Wait()
+}
@@ -0,0 +1,469 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// unmarshalQueryText parses extended JSON written by a user. If it is not valid relaxed extended JSON, it is parsed as
+// mongosh syntax instead, so that queries may be pasted from Compass or mongosh. See ParseShellSyntax.
+// Canonical extended JSON is parsed strictly, without falling back to mongosh syntax.
+func unmarshalQueryText(text string, canonical bool, val interface{}) error {
+	err := bson.UnmarshalExtJSON([]byte(text), canonical, val)
+	if err == nil || canonical {
+		return err
+	}
+	converted, shellErr := ParseShellSyntax(text)
+	if shellErr != nil {
+		// Report the original error, as most queries are meant to be extended JSON
+		return err
+	}
+	return bson.UnmarshalExtJSON([]byte(converted), false, val)
+}
+
+// ParseShellSyntax converts a document or array written in mongosh syntax to extended JSON. This permits unquoted keys,
+// single quoted strings, trailing commas, regular expression literals, and the constructors of the shell,
+// such as ISODate("..."), ObjectId("..."), NumberLong(...), and NumberDecimal("...").
+func ParseShellSyntax(text string) (string, error) {
+	p := shellParser{text: text}
+	var out strings.Builder
+	err := p.value(&out)
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace()
+	if p.pos != len(p.text) {
+		return "", p.errorf("Unexpected %q after end of value", p.text[p.pos])
+	}
+	return out.String(), nil
+}
+
+type shellParser struct {
+	text string
+	pos  int
+}
+
+func (p *shellParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid shell syntax at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *shellParser) skipSpace() {
+	for p.pos < len(p.text) && strings.IndexByte(" \t\r\n", p.text[p.pos]) != -1 {
+		p.pos++
+	}
+}
+
+func (p *shellParser) peek() byte {
+	if p.pos >= len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+func (p *shellParser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return p.errorf("Expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func isShellIdentChar(c byte, first bool) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && (c >= '0' && c <= '9' || c == '.'))
+}
+
+func (p *shellParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.text) && isShellIdentChar(p.text[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+func (p *shellParser) value(out *strings.Builder) error {
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case c == '{':
+		return p.object(out)
+	case c == '[':
+		return p.array(out)
+	case c == '"' || c == '\'':
+		str, err := p.str()
+		if err != nil {
+			return err
+		}
+		return writeShellJSON(out, str)
+	case c == '/':
+		return p.regex(out)
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number(out)
+	case isShellIdentChar(c, true):
+		return p.identValue(out)
+	case c == 0:
+		return p.errorf("Unexpected end of input")
+	default:
+		return p.errorf("Unexpected %q", c)
+	}
+}
+
+func (p *shellParser) object(out *strings.Builder) error {
+	p.pos++
+	out.WriteByte('{')
+	first := true
+	for {
+		p.skipSpace()
+		if p.peek() == '}' {
+			p.pos++
+			out.WriteByte('}')
+			return nil
+		}
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+		var key string
+		var err error
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			key, err = p.str()
+		case isShellIdentChar(c, true) || (c >= '0' && c <= '9'):
+			key = p.identifier()
+			if key == "" {
+				// Numeric keys
+				start := p.pos
+				for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+					p.pos++
+				}
+				key = p.text[start:p.pos]
+			}
+		default:
+			err = p.errorf("Expected a key")
+		}
+		if err != nil {
+			return err
+		}
+		err = writeShellJSON(out, key)
+		if err != nil {
+			return err
+		}
+		err = p.expect(':')
+		if err != nil {
+			return err
+		}
+		out.WriteByte(':')
+		err = p.value(out)
+		if err != nil {
+			return err
+		}
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return p.errorf("Expected ',' or '}'")
+		}
+	}
+}
+
+func (p *shellParser) array(out *strings.Builder) error {
+	p.pos++
+	out.WriteByte('[')
+	first := true
+	for {
+		p.skipSpace()
+		if p.peek() == ']' {
+			p.pos++
+			out.WriteByte(']')
+			return nil
+		}
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+		err := p.value(out)
+		if err != nil {
+			return err
+		}
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return p.errorf("Expected ',' or ']'")
+		}
+	}
+}
+
+// str parses a single or double quoted string literal
+func (p *shellParser) str() (string, error) {
+	quote := p.text[p.pos]
+	p.pos++
+	var str strings.Builder
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return str.String(), nil
+		case c == '\\':
+			if p.pos >= len(p.text) {
+				return "", p.errorf("Unterminated string")
+			}
+			escaped := p.text[p.pos]
+			p.pos++
+			switch escaped {
+			case 'n':
+				str.WriteByte('\n')
+			case 't':
+				str.WriteByte('\t')
+			case 'r':
+				str.WriteByte('\r')
+			case 'b':
+				str.WriteByte('\b')
+			case 'f':
+				str.WriteByte('\f')
+			case 'u':
+				if p.pos+4 > len(p.text) {
+					return "", p.errorf("Invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(p.text[p.pos:p.pos+4], 16, 16)
+				if err != nil {
+					return "", p.errorf("Invalid unicode escape")
+				}
+				str.WriteRune(rune(code))
+				p.pos += 4
+			default:
+				str.WriteByte(escaped)
+			}
+		default:
+			str.WriteByte(c)
+		}
+	}
+	return "", p.errorf("Unterminated string")
+}
+
+func (p *shellParser) number(out *strings.Builder) error {
+	start := p.pos
+	if p.peek() == '+' {
+		start++
+		p.pos++
+	}
+	for p.pos < len(p.text) && strings.IndexByte("+-.0123456789eE", p.text[p.pos]) != -1 {
+		p.pos++
+	}
+	number := p.text[start:p.pos]
+	if strings.HasPrefix(number, ".") || strings.HasPrefix(number, "-.") {
+		number = strings.Replace(number, ".", "0.", 1)
+	}
+	if _, err := strconv.ParseFloat(number, 64); err != nil {
+		return p.errorf("Invalid number %s", number)
+	}
+	out.WriteString(number)
+	return nil
+}
+
+// regex parses a regular expression literal, such as /^a/i
+func (p *shellParser) regex(out *strings.Builder) error {
+	p.pos++
+	start := p.pos
+	inClass := false
+	for {
+		if p.pos >= len(p.text) {
+			return p.errorf("Unterminated regular expression")
+		}
+		c := p.text[p.pos]
+		if c == '\\' {
+			p.pos += 2
+			continue
+		}
+		if c == '[' {
+			inClass = true
+		} else if c == ']' {
+			inClass = false
+		} else if c == '/' && !inClass {
+			break
+		}
+		p.pos++
+	}
+	pattern := p.text[start:p.pos]
+	p.pos++
+	flagsStart := p.pos
+	for p.pos < len(p.text) && p.text[p.pos] >= 'a' && p.text[p.pos] <= 'z' {
+		p.pos++
+	}
+	return writeShellJSON(out, map[string]interface{}{
+		"$regularExpression": map[string]string{"pattern": pattern, "options": p.text[flagsStart:p.pos]},
+	})
+}
+
+// identValue parses a keyword, or a call to a shell constructor
+func (p *shellParser) identValue(out *strings.Builder) error {
+	name := p.identifier()
+	switch name {
+	case "true", "false", "null":
+		out.WriteString(name)
+		return nil
+	case "undefined":
+		out.WriteString(`{"$undefined":true}`)
+		return nil
+	case "NaN", "Infinity":
+		return writeShellJSON(out, map[string]string{"$numberDouble": name})
+	case "new":
+		p.skipSpace()
+		name = p.identifier()
+	}
+	p.skipSpace()
+	if p.peek() != '(' {
+		return p.errorf("Unexpected identifier %s", name)
+	}
+	args, err := p.args()
+	if err != nil {
+		return err
+	}
+	value, err := shellConstructor(name, args)
+	if err != nil {
+		return p.errorf("%s", err)
+	}
+	return writeShellJSON(out, value)
+}
+
+// args parses the parenthesized arguments of a constructor call as generic JSON values
+func (p *shellParser) args() ([]interface{}, error) {
+	p.pos++
+	var list strings.Builder
+	list.WriteByte('[')
+	for {
+		p.skipSpace()
+		if p.peek() == ')' {
+			p.pos++
+			break
+		}
+		if list.Len() > 1 {
+			list.WriteByte(',')
+		}
+		err := p.value(&list)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+		default:
+			return nil, p.errorf("Expected ',' or ')'")
+		}
+	}
+	list.WriteByte(']')
+	var args []interface{}
+	decoder := json.NewDecoder(strings.NewReader(list.String()))
+	decoder.UseNumber()
+	err := decoder.Decode(&args)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid constructor arguments")
+	}
+	return args, nil
+}
+
+func writeShellJSON(out *strings.Builder, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	out.Write(encoded)
+	return nil
+}
+
+// shellConstructor converts a call to a shell constructor to extended JSON
+func shellConstructor(name string, args []interface{}) (interface{}, error) {
+	arg := func(ix int) string {
+		if ix >= len(args) {
+			return ""
+		}
+		switch value := args[ix].(type) {
+		case string:
+			return value
+		case json.Number:
+			return value.String()
+		default:
+			return ""
+		}
+	}
+	switch name {
+	case "ISODate", "Date":
+		if len(args) == 0 {
+			return map[string]interface{}{"$date": map[string]string{"$numberLong": strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)}}, nil
+		}
+		if millis, ok := args[0].(json.Number); ok {
+			return map[string]interface{}{"$date": map[string]string{"$numberLong": millis.String()}}, nil
+		}
+		date, err := parseShellDate(arg(0))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$date": map[string]string{"$numberLong": strconv.FormatInt(date.UnixNano()/int64(time.Millisecond), 10)}}, nil
+	case "ObjectId":
+		return map[string]string{"$oid": arg(0)}, nil
+	case "NumberLong", "Long":
+		return map[string]string{"$numberLong": arg(0)}, nil
+	case "NumberInt", "Int32":
+		return map[string]string{"$numberInt": arg(0)}, nil
+	case "NumberDecimal", "Decimal128":
+		return map[string]string{"$numberDecimal": arg(0)}, nil
+	case "Double":
+		return map[string]string{"$numberDouble": arg(0)}, nil
+	case "Timestamp":
+		t, err := strconv.ParseUint(arg(0), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Timestamp requires a time in seconds")
+		}
+		i, err := strconv.ParseUint(arg(1), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Timestamp requires an increment")
+		}
+		return map[string]interface{}{"$timestamp": map[string]uint64{"t": t, "i": i}}, nil
+	case "BinData":
+		subType, err := strconv.ParseUint(arg(0), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("BinData requires a subtype")
+		}
+		return map[string]interface{}{"$binary": map[string]string{"base64": arg(1), "subType": fmt.Sprintf("%02x", subType)}}, nil
+	case "UUID":
+		raw, err := hex.DecodeString(strings.ReplaceAll(arg(0), "-", ""))
+		if err != nil || len(raw) != 16 {
+			return nil, fmt.Errorf("UUID requires 32 hexadecimal digits")
+		}
+		return map[string]interface{}{"$binary": map[string]string{"base64": base64.StdEncoding.EncodeToString(raw), "subType": "04"}}, nil
+	case "RegExp":
+		return map[string]interface{}{"$regularExpression": map[string]string{"pattern": arg(0), "options": arg(1)}}, nil
+	case "MinKey":
+		return map[string]int{"$minKey": 1}, nil
+	case "MaxKey":
+		return map[string]int{"$maxKey": 1}, nil
+	default:
+		return nil, fmt.Errorf("Unknown shell constructor %s", name)
+	}
+}
+
+// parseShellDate parses the date formats accepted by ISODate
+func parseShellDate(text string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05Z0700", "2006-01-02"} {
+		date, err := time.Parse(layout, text)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid date %q", text)
+}
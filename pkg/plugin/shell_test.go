@@ -0,0 +1,42 @@
+package plugin_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseShellSyntax", func() {
+	DescribeTable("Should convert", func(text, expected string) {
+		converted, err := plugin.ParseShellSyntax(text)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(converted).To(MatchJSON(expected))
+	},
+		Entry("unquoted keys, single quotes, and trailing commas",
+			`[{$match: {'host': 'web-1', "n": +2,}},]`,
+			`[{"$match": {"host": "web-1", "n": 2}}]`,
+		),
+		Entry("dates",
+			`{a: ISODate("1970-01-01T00:01:00Z"), b: new Date(60000), c: ISODate('1970-01-02')}`,
+			`{"a": {"$date": {"$numberLong": "60000"}}, "b": {"$date": {"$numberLong": "60000"}}, "c": {"$date": {"$numberLong": "86400000"}}}`,
+		),
+		Entry("numbers",
+			`{a: NumberLong(5), b: NumberLong("6"), c: NumberInt(7), d: NumberDecimal("1.5")}`,
+			`{"a": {"$numberLong": "5"}, "b": {"$numberLong": "6"}, "c": {"$numberInt": "7"}, "d": {"$numberDecimal": "1.5"}}`,
+		),
+		Entry("ObjectIds and UUIDs",
+			`{_id: ObjectId("5f1d7b2e9c3a4b0012345678"), u: UUID("00112233-4455-6677-8899-aabbccddeeff")}`,
+			`{"_id": {"$oid": "5f1d7b2e9c3a4b0012345678"}, "u": {"$binary": {"base64": "ABEiM0RVZneImaq7zN3u/w==", "subType": "04"}}}`,
+		),
+		Entry("regular expression literals",
+			`{name: /^a[/]b\/c/i}`,
+			`{"name": {"$regularExpression": {"pattern": "^a[/]b\\/c", "options": "i"}}}`,
+		),
+	)
+
+	It("Should reject unknown constructors", func() {
+		_, err := plugin.ParseShellSyntax(`{a: Foo(1)}`)
+		Expect(err).To(HaveOccurred())
+	})
+})
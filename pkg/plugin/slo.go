@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	sloStatusOK        = "ok"
+	sloStatusError     = "error"
+	sloStatusCancelled = "cancelled"
+
+	// sloOtherQueryType labels queries with a query type the plugin does not know, so that the type cannot be used
+	// to create an unbounded number of series
+	sloOtherQueryType = "other"
+)
+
+// sloQueryTypes are the query types which are used as metric labels as-is
+var sloQueryTypes = map[queryType]struct{}{
+	queryTypeTimeseries:   {},
+	queryTypeTable:        {},
+	queryTypeVariable:     {},
+	queryTypeHealthEvents: {},
+	queryTypeAnnotations:  {},
+}
+
+// queryResultsTotal and queryDuration measure the success ratio and latency of queries per datasource, for alerting on
+// the health of the datasource itself. Queries cancelled by the client, such as when a dashboard is refreshed or closed,
+// are counted separately, and do not count against the success ratio.
+var (
+	queryResultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Name:      "mongodb_query_results_total",
+		Help:      "Number of queries executed by the MongoDB datasource, by datasource UID, query type, and status (ok, error, cancelled)",
+	}, []string{"datasource_uid", "query_type", "status"})
+
+	queryDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace:  "grafana_plugin",
+		Name:       "mongodb_query_duration_seconds",
+		Help:       "Latency of queries executed by the MongoDB datasource, by datasource UID and query type",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		MaxAge:     10 * time.Minute,
+	}, []string{"datasource_uid", "query_type"})
+)
+
+func init() {
+	prometheus.MustRegister(queryResultsTotal, queryDuration)
+}
+
+// sloQueryType returns the query type label of a query
+func sloQueryType(qm *QueryModel) string {
+	if qm.QueryType == "" {
+		return defaultQueryType
+	}
+	if _, ok := sloQueryTypes[qm.QueryType]; !ok {
+		return sloOtherQueryType
+	}
+	return qm.QueryType
+}
+
+// recordSLO records the outcome and latency of a query. The latency of cancelled queries is not recorded, as it reflects
+// when the client gave up rather than how long the query would have taken.
+func recordSLO(ctx context.Context, pCtx backend.PluginContext, qm *QueryModel, start time.Time, err error) {
+	uid := ""
+	if pCtx.DataSourceInstanceSettings != nil {
+		uid = pCtx.DataSourceInstanceSettings.UID
+	}
+	queryType := sloQueryType(qm)
+	status := sloStatusOK
+	switch {
+	case err != nil && ctx.Err() == context.Canceled:
+		status = sloStatusCancelled
+	case err != nil:
+		status = sloStatusError
+	}
+	queryResultsTotal.WithLabelValues(uid, queryType, status).Inc()
+	if status != sloStatusCancelled {
+		queryDuration.WithLabelValues(uid, queryType).Observe(time.Since(start).Seconds())
+	}
+}
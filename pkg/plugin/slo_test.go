@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Query SLO metrics", func() {
+	DescribeTable("sloQueryType", func(type_ string, expected string) {
+		Expect(sloQueryType(&QueryModel{QueryType: type_})).To(Equal(expected))
+	},
+		Entry("should use the default query type if none is set", "", queryTypeTable),
+		Entry("should use known query types as-is", queryTypeTimeseries, queryTypeTimeseries),
+		Entry("should group unknown query types together", "Anything", sloOtherQueryType),
+	)
+
+	It("Should count queries by status, and only record the latency of queries which were not cancelled", func() {
+		// A datasource UID unique to this test keeps its series separate from any recorded by other tests
+		uid := fmt.Sprintf("slo-%d", time.Now().UnixNano())
+		pCtx := backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: uid}}
+		qm := &QueryModel{QueryType: queryTypeTimeseries}
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		series := testutil.CollectAndCount(queryDuration)
+		recordSLO(cancelled, pCtx, qm, time.Now(), context.Canceled)
+		Expect(testutil.CollectAndCount(queryDuration)).To(Equal(series))
+
+		recordSLO(context.Background(), pCtx, qm, time.Now(), nil)
+		recordSLO(context.Background(), pCtx, qm, time.Now(), fmt.Errorf("Failed"))
+		recordSLO(context.Background(), pCtx, qm, time.Now(), nil)
+		Expect(testutil.CollectAndCount(queryDuration)).To(Equal(series + 1))
+
+		Expect(testutil.ToFloat64(queryResultsTotal.WithLabelValues(uid, queryTypeTimeseries, sloStatusOK))).To(Equal(2.0))
+		Expect(testutil.ToFloat64(queryResultsTotal.WithLabelValues(uid, queryTypeTimeseries, sloStatusError))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(queryResultsTotal.WithLabelValues(uid, queryTypeTimeseries, sloStatusCancelled))).To(Equal(1.0))
+	})
+})
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// includeMacro is replaced by the stages of a snippet saved in the datasource settings,
+	// so that stages shared by many panels, such as tenant filters, are maintained in one place
+	includeMacro = macroPrefix + "include"
+
+	// maxIncludeDepth bounds how deeply snippets may include other snippets
+	maxIncludeDepth = 8
+)
+
+// ExpandIncludes replaces every $__include(name) in the text of an aggregation pipeline with the stages of the named snippet.
+// Snippets are JSON arrays of stages, which may themselves use macros, including other snippets.
+// The stages are spliced into the surrounding array, so $__include must be used in place of a stage.
+func ExpandIncludes(text string, snippets map[string]string) (string, error) {
+	return expandIncludes(text, snippets, nil)
+}
+
+func expandIncludes(text string, snippets map[string]string, stack []string) (string, error) {
+	if !strings.Contains(text, includeMacro) {
+		return text, nil
+	}
+	var out strings.Builder
+	rest := text
+	for {
+		start := strings.Index(rest, includeMacro)
+		// Other macros whose names start with include are left for ExpandMacros
+		for start != -1 && start+len(includeMacro) < len(rest) && isMacroNameChar(rest[start+len(includeMacro)]) {
+			next := strings.Index(rest[start+len(includeMacro):], includeMacro)
+			if next == -1 {
+				start = -1
+			} else {
+				start += len(includeMacro) + next
+			}
+		}
+		if start == -1 {
+			out.WriteString(rest)
+			return out.String(), nil
+		}
+		out.WriteString(rest[:start])
+		rest = rest[start+len(includeMacro):]
+		if !strings.HasPrefix(rest, "(") {
+			return "", fmt.Errorf("%s requires the name of a snippet", includeMacro)
+		}
+		args, argsLen, err := splitMacroArgs(rest)
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("Invalid arguments to %s", includeMacro))
+		}
+		rest = rest[argsLen:]
+		if len(args) != 1 {
+			return "", fmt.Errorf("Expected 1 argument (snippet) to %s, got %d", includeMacro, len(args))
+		}
+		name := args[0]
+		if strings.HasPrefix(name, `"`) {
+			err = json.Unmarshal([]byte(name), &name)
+			if err != nil {
+				return "", errors.Wrap(err, "Invalid snippet name")
+			}
+		}
+		stages, err := includeSnippet(name, snippets, stack)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(stages)
+	}
+}
+
+// includeSnippet returns the stages of a snippet, without the surrounding brackets, with any includes of its own expanded
+func includeSnippet(name string, snippets map[string]string, stack []string) (string, error) {
+	for _, including := range stack {
+		if including == name {
+			return "", fmt.Errorf("Snippet %s includes itself (%s -> %s)", name, strings.Join(stack, " -> "), name)
+		}
+	}
+	if len(stack) >= maxIncludeDepth {
+		return "", fmt.Errorf("Snippets may only be nested %d deep (%s)", maxIncludeDepth, strings.Join(stack, " -> "))
+	}
+	snippet, ok := snippets[name]
+	if !ok {
+		return "", fmt.Errorf("No such snippet %s", name)
+	}
+	snippet = strings.TrimSpace(NormalizeJSON5(snippet))
+	if !strings.HasPrefix(snippet, "[") || !strings.HasSuffix(snippet, "]") {
+		return "", fmt.Errorf("Snippet %s must be an array of stages", name)
+	}
+	stages := strings.TrimSpace(snippet[1 : len(snippet)-1])
+	if stages == "" {
+		return "", fmt.Errorf("Snippet %s has no stages", name)
+	}
+	return expandIncludes(stages, snippets, append(stack[:len(stack):len(stack)], name))
+}
@@ -0,0 +1,53 @@
+package plugin_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExpandIncludes", func() {
+	snippets := map[string]string{
+		"tenant":      `[{"$match": {"tenant": "a"}}]`,
+		"live":        ` [ {"$match": {"deleted": false}}, {"$project": {"deleted": 0}} ] `,
+		"prologue":    `[$__include(tenant), $__include("live")]`,
+		"withMacro":   `[{"$match": $__contains(name, "x")}]`,
+		"empty":       `[]`,
+		"notAnArray":  `{"$match": {}}`,
+		"selfInclude": `[$__include(selfInclude)]`,
+		"cycleA":      `[$__include(cycleB)]`,
+		"cycleB":      `[$__include(cycleA)]`,
+	}
+
+	DescribeTable("Should expand", func(text, expected string) {
+		expanded, err := plugin.ExpandMacros(text, plugin.MacroContext{Snippets: snippets})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expanded).To(MatchJSON(expected))
+	},
+		Entry("a single stage", `[$__include(tenant), {"$limit": 1}]`, `[{"$match": {"tenant": "a"}}, {"$limit": 1}]`),
+		Entry("a quoted name with several stages", `[$__include("live")]`, `[{"$match": {"deleted": false}}, {"$project": {"deleted": 0}}]`),
+		Entry("nested snippets", `[$__include(prologue)]`,
+			`[{"$match": {"tenant": "a"}}, {"$match": {"deleted": false}}, {"$project": {"deleted": 0}}]`,
+		),
+		Entry("other macros in snippets", `[$__include(withMacro)]`, `[{"$match": {"name": {"$regex": "x"}}}]`),
+	)
+
+	DescribeTable("Should reject", func(text, message string) {
+		_, err := plugin.ExpandMacros(text, plugin.MacroContext{Snippets: snippets})
+		Expect(err).To(MatchError(ContainSubstring(message)))
+	},
+		Entry("unknown snippets", `[$__include(missing)]`, "No such snippet missing"),
+		Entry("empty snippets", `[$__include(empty)]`, "has no stages"),
+		Entry("snippets which are not arrays", `[$__include(notAnArray)]`, "must be an array of stages"),
+		Entry("snippets which include themselves", `[$__include(selfInclude)]`, "includes itself"),
+		Entry("cycles between snippets", `[$__include(cycleA)]`, "cycleA -> cycleB -> cycleA"),
+		Entry("a missing name", `[$__include]`, "requires the name of a snippet"),
+		Entry("more than one name", `[$__include(tenant, live)]`, "Expected 1 argument"),
+	)
+
+	It("Should not expand other macros starting with include", func() {
+		_, err := plugin.ExpandMacros(`[$__includes(tenant)]`, plugin.MacroContext{Snippets: snippets})
+		Expect(err).To(MatchError(ContainSubstring("Unknown macro $__includes")))
+	})
+})
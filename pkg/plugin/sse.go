@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+// sseSeries is a single series being built for server-side expressions
+type sseSeries struct {
+	labels data.Labels
+	name   string
+	config *data.FieldConfig
+	times  []time.Time
+	values []*float64
+}
+
+// ReshapeForSSE reshapes frames into the form expected by Grafana server-side expressions (math, reduce, resample, etc),
+// which is one frame per series, with at most one time field, exactly one numeric value field, and the labels of the series on the value field.
+// Columns named in labelsFrom are moved into the labels of the value fields, so that, for example, table queries grouped by a field can be used as numbers.
+// Numeric fields are converted to nullable float64. Fields which are neither the time field, a label column, nor numeric, are dropped, and reported as notices.
+// Rows of series with a time field are sorted by time, and rows with a null time are dropped.
+func ReshapeForSSE(frames []*data.Frame, labelsFrom []string) ([]*data.Frame, []data.Notice, error) {
+	labelColumns := make(map[string]struct{}, len(labelsFrom))
+	for _, name := range labelsFrom {
+		labelColumns[name] = struct{}{}
+	}
+
+	series := make(map[string]*sseSeries)
+	dropped := make(map[string]struct{})
+	for _, frame := range frames {
+		var timeField *data.Field
+		labelFields := make([]*data.Field, 0, len(labelsFrom))
+		valueFields := make([]*data.Field, 0, len(frame.Fields))
+		for _, field := range frame.Fields {
+			if _, ok := labelColumns[field.Name]; ok {
+				labelFields = append(labelFields, field)
+				continue
+			}
+			switch {
+			case field.Type().Time() && timeField == nil:
+				timeField = field
+			case field.Type().Numeric():
+				valueFields = append(valueFields, field)
+			default:
+				dropped[field.Name] = struct{}{}
+			}
+		}
+
+		for row := 0; row < frame.Rows(); row++ {
+			var timestamp time.Time
+			if timeField != nil {
+				value, ok := timeField.ConcreteAt(row)
+				if !ok {
+					continue
+				}
+				timestamp = value.(time.Time)
+			}
+			for _, valueField := range valueFields {
+				labels := valueField.Labels.Copy()
+				if labels == nil {
+					labels = data.Labels{}
+				}
+				for _, labelField := range labelFields {
+					value, ok := labelField.ConcreteAt(row)
+					if !ok {
+						continue
+					}
+					labels[labelField.Name] = fmt.Sprintf("%v", value)
+				}
+				value, err := valueField.NullableFloatAt(row)
+				if err != nil {
+					return nil, nil, errors.Wrap(err, fmt.Sprintf("Failed to convert %s to a number", valueField.Name))
+				}
+				if value != nil {
+					copied := *value
+					value = &copied
+				}
+
+				key := valueField.Name + "{" + labels.String() + "}"
+				s, ok := series[key]
+				if !ok {
+					s = &sseSeries{labels: labels, name: valueField.Name, config: valueField.Config}
+					series[key] = s
+				}
+				if timeField != nil {
+					s.times = append(s.times, timestamp)
+				}
+				s.values = append(s.values, value)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	reshaped := make([]*data.Frame, 0, len(keys))
+	for _, key := range keys {
+		s := series[key]
+		if s.times != nil {
+			sort.Stable(sseSeriesByTime{s})
+		}
+		valueField := data.NewField(s.name, s.labels, s.values)
+		valueField.Config = s.config
+		if s.times == nil {
+			reshaped = append(reshaped, data.NewFrame(s.name, valueField))
+			continue
+		}
+		timeField := data.NewField("time", nil, s.times)
+		reshaped = append(reshaped, data.NewFrame(s.name, timeField, valueField))
+	}
+
+	names := make([]string, 0, len(dropped))
+	for name := range dropped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	notices := make([]data.Notice, 0, len(names))
+	for _, name := range names {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Field %s is not numeric, and was dropped to make the result compatible with server-side expressions", name),
+		})
+	}
+	return reshaped, notices, nil
+}
+
+// sseSeriesByTime sorts the rows of a series by time
+type sseSeriesByTime struct {
+	*sseSeries
+}
+
+func (s sseSeriesByTime) Len() int           { return len(s.times) }
+func (s sseSeriesByTime) Less(i, j int) bool { return s.times[i].Before(s.times[j]) }
+func (s sseSeriesByTime) Swap(i, j int) {
+	s.times[i], s.times[j] = s.times[j], s.times[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
@@ -0,0 +1,51 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReshapeForSSE", func() {
+	It("Should split multiple value fields into one series per frame", func() {
+		t0, t1 := time.Unix(0, 0), time.Unix(60, 0)
+		labels := data.Labels{"host": "a"}
+		frame := data.NewFrame("",
+			data.NewField("ts", labels, []time.Time{t1, t0}),
+			data.NewField("cpu", labels, []int64{2, 1}),
+			data.NewField("mem", labels, []float64{20, 10}),
+			data.NewField("note", labels, []string{"x", "y"}),
+		)
+		frames, notices, err := plugin.ReshapeForSSE([]*data.Frame{frame}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notices).To(HaveLen(1))
+		Expect(frames).To(HaveLen(2))
+		for _, frame := range frames {
+			Expect(frame.Fields).To(HaveLen(2))
+			Expect(frame.Fields[0].Labels).To(BeEmpty())
+			Expect(frame.Fields[0].At(0)).To(Equal(t0))
+			Expect(frame.Fields[1].Labels).To(Equal(labels))
+		}
+		Expect(frames[0].Fields[1].Name).To(Equal("cpu"))
+		Expect(*(frames[0].Fields[1].At(0).(*float64))).To(Equal(1.0))
+	})
+
+	It("Should move labelsFrom columns of a table into labels", func() {
+		frame := data.NewFrame("",
+			data.NewField("host", nil, []string{"a", "b"}),
+			data.NewField("count", nil, []int32{3, 4}),
+		)
+		frames, notices, err := plugin.ReshapeForSSE([]*data.Frame{frame}, []string{"host"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notices).To(BeEmpty())
+		Expect(frames).To(HaveLen(2))
+		Expect(frames[0].Fields).To(HaveLen(1))
+		Expect(frames[0].Fields[0].Labels).To(Equal(data.Labels{"host": "a"}))
+		Expect(*(frames[1].Fields[0].At(0).(*float64))).To(Equal(4.0))
+	})
+})
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// explainQueryPlanner plans a query without executing it
+	explainQueryPlanner = "queryPlanner"
+	// explainExecutionStats executes the winning plan of a query, and reports how much work it did
+	explainExecutionStats = "executionStats"
+)
+
+// ExecutionStats are the totals of every executionStats section of an explain result. Pipelines which are not entirely
+// pushed down into the query planner have one for their $cursor stage, and sharded collections have one per shard.
+type ExecutionStats struct {
+	// ExecutionTimeMillis is the longest time reported, as shards execute in parallel
+	ExecutionTimeMillis int64
+	TotalDocsExamined   int64
+	TotalKeysExamined   int64
+}
+
+// SummarizeExecutionStats totals the execution statistics of an explain result run with the executionStats verbosity,
+// returning false if it has none
+func SummarizeExecutionStats(explain interface{}) (ExecutionStats, bool) {
+	stats := ExecutionStats{}
+	found := false
+	var walk func(key string, value interface{})
+	walk = func(key string, value interface{}) {
+		switch v := value.(type) {
+		case bson.D:
+			if key == explainExecutionStats {
+				found = true
+				for _, elem := range v {
+					n, ok := toInt64(elem.Value)
+					if !ok {
+						continue
+					}
+					switch elem.Key {
+					case "executionTimeMillis":
+						if n > stats.ExecutionTimeMillis {
+							stats.ExecutionTimeMillis = n
+						}
+					case "totalDocsExamined":
+						stats.TotalDocsExamined += n
+					case "totalKeysExamined":
+						stats.TotalKeysExamined += n
+					}
+				}
+				// The execution stages nested within only break down the totals
+				return
+			}
+			for _, elem := range v {
+				walk(elem.Key, elem.Value)
+			}
+		case bsonPrim.A:
+			for _, elem := range v {
+				walk("", elem)
+			}
+		}
+	}
+	walk("", explain)
+	return stats, found
+}
+
+// queryStats formats execution statistics, and the number of documents the query returned, for the query inspector
+func (s ExecutionStats) queryStats(returned int) []data.QueryStat {
+	return []data.QueryStat{
+		{FieldConfig: data.FieldConfig{DisplayName: "Execution time", Unit: "ms"}, Value: float64(s.ExecutionTimeMillis)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Documents examined"}, Value: float64(s.TotalDocsExamined)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Keys examined"}, Value: float64(s.TotalKeysExamined)},
+		{FieldConfig: data.FieldConfig{DisplayName: "Documents returned"}, Value: float64(returned)},
+	}
+}
+
+// setQueryStats shows statistics about a query in the query inspector
+func setQueryStats(frames []*data.Frame, stats []data.QueryStat) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Stats = append(frame.Meta.Stats, stats...)
+	}
+}
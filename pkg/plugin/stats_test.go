@@ -0,0 +1,54 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SummarizeExecutionStats", func() {
+	executionStats := func(millis int32, docs, keys int64) bson.D {
+		return bson.D{
+			{Key: "nReturned", Value: int32(1)},
+			{Key: "executionTimeMillis", Value: millis},
+			{Key: "totalKeysExamined", Value: keys},
+			{Key: "totalDocsExamined", Value: docs},
+			{Key: "executionStages", Value: bson.D{{Key: "stage", Value: "COLLSCAN"}, {Key: "docsExamined", Value: docs}}},
+		}
+	}
+
+	It("Should read the statistics of a pipeline pushed down into the query planner", func() {
+		stats, ok := plugin.SummarizeExecutionStats(bson.D{
+			{Key: "queryPlanner", Value: bson.D{}},
+			{Key: "executionStats", Value: executionStats(12, 500, 0)},
+		})
+		Expect(ok).To(BeTrue())
+		Expect(stats).To(Equal(plugin.ExecutionStats{ExecutionTimeMillis: 12, TotalDocsExamined: 500}))
+	})
+
+	It("Should read the statistics of the cursor stage of a pipeline", func() {
+		stats, ok := plugin.SummarizeExecutionStats(bson.D{{Key: "stages", Value: bson.A{
+			bson.D{{Key: "$cursor", Value: bson.D{{Key: "executionStats", Value: executionStats(3, 40, 40)}}}},
+			bson.D{{Key: "$group", Value: bson.D{}}, {Key: "nReturned", Value: int64(2)}},
+		}}})
+		Expect(ok).To(BeTrue())
+		Expect(stats).To(Equal(plugin.ExecutionStats{ExecutionTimeMillis: 3, TotalDocsExamined: 40, TotalKeysExamined: 40}))
+	})
+
+	It("Should total the statistics of each shard", func() {
+		stats, ok := plugin.SummarizeExecutionStats(bson.D{{Key: "shards", Value: bson.D{
+			{Key: "shard0", Value: bson.D{{Key: "executionStats", Value: executionStats(7, 100, 10)}}},
+			{Key: "shard1", Value: bson.D{{Key: "executionStats", Value: executionStats(9, 200, 20)}}},
+		}}})
+		Expect(ok).To(BeTrue())
+		Expect(stats).To(Equal(plugin.ExecutionStats{ExecutionTimeMillis: 9, TotalDocsExamined: 300, TotalKeysExamined: 30}))
+	})
+
+	It("Should report explains without execution statistics", func() {
+		_, ok := plugin.SummarizeExecutionStats(bson.D{{Key: "queryPlanner", Value: bson.D{}}})
+		Expect(ok).To(BeFalse())
+	})
+})
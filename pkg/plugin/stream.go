@@ -0,0 +1,410 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+const (
+	// streamModeChangeStream pushes documents inserted into the collection which match the filter of the query, using a change stream.
+	// This requires a replica set or sharded cluster.
+	streamModeChangeStream = "changeStream"
+	// streamModeTailable pushes documents appended to a capped collection which match the filter of the query, using a tailable cursor.
+	// Unlike change streams, this works on standalone servers.
+	streamModeTailable = "tailable"
+
+	// tailableRetryInterval is how long to wait before reopening a tailable cursor which the server has closed,
+	// such as when the collection was empty when the cursor was opened
+	tailableRetryInterval = time.Second
+
+	// maxStreamBatch is the most documents sent in a single frame. Documents which are already available are batched,
+	// so that bursts of inserts don't produce a frame each.
+	maxStreamBatch = 1000
+)
+
+// streamPathPrefixes are the prefixes of the channel paths of each stream mode
+var streamPathPrefixes = map[string]string{
+	streamModeChangeStream: "changes/",
+	streamModeTailable:     "tail/",
+}
+
+// validateStream checks that a query can be streamed
+func (m *QueryModel) validateStream() error {
+	switch m.Stream {
+	case "":
+		return nil
+	case streamModeChangeStream, streamModeTailable:
+	default:
+		return fmt.Errorf("Stream must be %s or %s", streamModeChangeStream, streamModeTailable)
+	}
+	err := m.requireCurrentModel("Streaming")
+	if err != nil {
+		return err
+	}
+	// Only a filter can be applied to each new document, so aggregation pipelines, which are the default, cannot be streamed
+	if m.Command != commandFind {
+		return fmt.Errorf("Only queries using the %s command can be streamed", commandFind)
+	}
+	return nil
+}
+
+// streamFilter expands the filter applied to the documents of a stream. Streams only receive new documents, which are
+// never within the time range of the panel, so time macros are rejected rather than expanded to a range matching nothing.
+func (m *QueryModel) streamFilter() (bson.D, error) {
+	filter, err := parseFindDocument("filter", m.Filter, m.canonicalInput(), MacroContext{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid stream filter. Streams only receive new documents, so time macros cannot be used")
+	}
+	return filter, nil
+}
+
+// prefixFilterFields rewrites a filter on documents to a filter on a field containing those documents,
+// such as the fullDocument of change events
+func prefixFilterFields(filter bson.D, prefix string) (bson.D, error) {
+	prefixed := make(bson.D, 0, len(filter))
+	for _, elem := range filter {
+		switch elem.Key {
+		case "$and", "$or", "$nor":
+			clauses, ok := elem.Value.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("%s must be an array", elem.Key)
+			}
+			prefixedClauses := make(bson.A, len(clauses))
+			for ix, clause := range clauses {
+				doc, ok := clause.(bson.D)
+				if !ok {
+					return nil, fmt.Errorf("%s must be an array of documents", elem.Key)
+				}
+				prefixedDoc, err := prefixFilterFields(doc, prefix)
+				if err != nil {
+					return nil, err
+				}
+				prefixedClauses[ix] = prefixedDoc
+			}
+			prefixed = append(prefixed, bson.E{Key: elem.Key, Value: prefixedClauses})
+		default:
+			if strings.HasPrefix(elem.Key, "$") {
+				return nil, fmt.Errorf("Only field conditions, $and, $or, and $nor can be used to filter streams, not %s", elem.Key)
+			}
+			prefixed = append(prefixed, bson.E{Key: prefix + elem.Key, Value: elem.Value})
+		}
+	}
+	return prefixed, nil
+}
+
+// ChangeStreamPipeline produces the pipeline of a change stream which returns the inserted documents matching a filter
+func ChangeStreamPipeline(filter bson.D) (mongo.Pipeline, error) {
+	prefixed, err := prefixFilterFields(filter, "fullDocument.")
+	if err != nil {
+		return nil, err
+	}
+	match := append(bson.D{bson.E{Key: "operationType", Value: "insert"}}, prefixed...)
+	return mongo.Pipeline{bson.D{bson.E{Key: "$match", Value: match}}}, nil
+}
+
+// streamChannel returns the channel which streams a query. The same query always uses the same channel, regardless of time range,
+// so that every panel showing it shares a single change stream or cursor.
+func streamChannel(pCtx backend.PluginContext, query backend.DataQuery, mode string) (live.Channel, error) {
+	key, err := QueryCacheKey(mode, backend.DataQuery{JSON: query.JSON})
+	if err != nil {
+		return live.Channel{}, err
+	}
+	return live.Channel{
+		Scope:     live.ScopeDatasource,
+		Namespace: pCtx.DataSourceInstanceSettings.UID,
+		Path:      streamPathPrefixes[mode] + key,
+	}, nil
+}
+
+// attachStream registers a streamed query, and points the first frame of its result at the channel which streams it
+func (d *MongoDBDatasource) attachStream(pCtx backend.PluginContext, query backend.DataQuery, qm QueryModel, model resolvedQueryModel, frames []*data.Frame) ([]*data.Frame, error) {
+	channel, err := streamChannel(pCtx, query, qm.Stream)
+	if err != nil {
+		return nil, err
+	}
+	d.streams.Store(channel.Path, qm)
+	if len(frames) == 0 {
+		frame, err := model.makeFrame("", nil)
+		if err != nil {
+			return nil, err
+		}
+		frames = []*data.Frame{frame}
+	}
+	if frames[0].Meta == nil {
+		frames[0].Meta = &data.FrameMeta{}
+	}
+	frames[0].Meta.Channel = channel.String()
+	return frames, nil
+}
+
+// streamQuery finds the query streamed by a channel path, either from the data of the subscription, or from the queries seen by QueryData
+func (d *MongoDBDatasource) streamQuery(path string, raw json.RawMessage) (QueryModel, bool, error) {
+	mode := ""
+	for candidate, prefix := range streamPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			mode = candidate
+		}
+	}
+	if mode == "" {
+		return QueryModel{}, false, nil
+	}
+	if len(raw) != 0 && string(raw) != "null" {
+		qm, err := parseQueryModel(raw)
+		if err != nil {
+			return QueryModel{}, true, err
+		}
+		if qm.Stream != mode {
+			return QueryModel{}, true, fmt.Errorf("Channel %s streams queries with the %s mode, not %s", path, mode, qm.Stream)
+		}
+		return qm, true, qm.validateStream()
+	}
+	qm, ok := d.streams.Load(path)
+	if !ok {
+		return QueryModel{}, false, nil
+	}
+	return qm.(QueryModel), true, nil
+}
+
+// SubscribeStream allows subscriptions to channels of streamed queries
+func (d *MongoDBDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	_, ok, err := d.streamQuery(req.Path, req.Data)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects all publications, as streams are read-only
+func (d *MongoDBDatasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// streamColumns returns the columns of the frames sent by a stream, or nil if they should be inferred from the first batch.
+// Label fields are sent as columns, rather than separate series.
+func (m *QueryModel) streamColumns() ([]bsonframe.Column, error) {
+	if m.SchemaInference {
+		return nil, nil
+	}
+	fields, err := m.getFields()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]bsonframe.Column, 0, 1+len(m.LabelFields)+len(fields))
+	if m.QueryType == queryTypeTimeseries {
+		columns = append(columns, bsonframe.NewColumn(m.TimestampField, data.FieldTypeNullableTime))
+		for _, name := range m.LabelFields {
+			column := bsonframe.NewColumn(name, data.FieldTypeNullableString)
+			column.Coerce = true
+			columns = append(columns, column)
+		}
+	}
+	return append(columns, m.pruneColumns(fields)...), nil
+}
+
+// streamCursor is the part of change streams and cursors used to read the documents of a stream
+type streamCursor interface {
+	Next(context.Context) bool
+	TryNext(context.Context) bool
+	Err() error
+}
+
+// streamFrames converts batches of streamed documents to frames, and sends them to subscribers
+type streamFrames struct {
+	qm      *QueryModel
+	opts    bsonframe.ConversionOptions
+	columns []bsonframe.Column
+	sender  *backend.StreamSender
+}
+
+func (s *streamFrames) send(docs []bsonframe.Document) error {
+	if s.columns == nil {
+		inference := bsonframe.NewSchemaInference(nil)
+		inference.Columns = s.qm.columnSet()
+		inference.Opts = s.opts
+		for _, doc := range docs {
+			err := inference.UpdateDoc(doc)
+			if err != nil {
+				return errors.Wrap(err, "Schema Inference Failed")
+			}
+		}
+		s.columns = inference.Finish()
+	}
+	frame, _, err := bsonframe.BuildFrame("", s.columns, docs, &s.opts)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(s.sender.SendFrame(frame, data.IncludeAll), "Failed to send frame")
+}
+
+// readBatches reads documents from a cursor until it is exhausted, sending the documents which are already available together
+func readBatches(ctx context.Context, cursor streamCursor, decode func() (bsonframe.Document, error), send func([]bsonframe.Document) error) error {
+	for cursor.Next(ctx) {
+		docs := make([]bsonframe.Document, 0, 1)
+		doc, err := decode()
+		if err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+		for len(docs) < maxStreamBatch && cursor.TryNext(ctx) {
+			doc, err = decode()
+			if err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+		}
+		err = send(docs)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// RunStream sends a frame for each batch of documents inserted into the collection of a streamed query
+func (d *MongoDBDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	qm, ok, err := d.streamQuery(req.Path, req.Data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("No streamed query for channel %s", req.Path)
+	}
+	filter, err := qm.streamFilter()
+	if err != nil {
+		return err
+	}
+	opts, err := qm.getConversionOptions()
+	if err != nil {
+		return err
+	}
+	settings, err := loadDatasource(req.PluginContext)
+	if err != nil {
+		return err
+	}
+	settings.applyCellLimits(&opts)
+	columns, err := qm.streamColumns()
+	if err != nil {
+		return err
+	}
+	frames := &streamFrames{qm: &qm, opts: opts, columns: columns, sender: sender}
+
+	client, err, internalErr := connect(ctx, req.PluginContext)
+	if internalErr != nil {
+		return errors.Wrap(internalErr, "Internal failure while connecting to mongo")
+	}
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to mongo")
+	}
+	defer client.Disconnect(context.Background())
+	collection := client.Database(qm.Database).Collection(qm.Collection)
+
+	if qm.Stream == streamModeTailable {
+		err = tailCollection(ctx, collection, filter, frames)
+	} else {
+		err = watchCollection(ctx, collection, filter, frames)
+	}
+	if ctx.Err() != nil {
+		// The last subscriber left
+		return nil
+	}
+	log.DefaultLogger.Info("Stream ended", "path", req.Path, "error", err)
+	return err
+}
+
+// watchCollection sends the documents inserted into a collection using a change stream
+func watchCollection(ctx context.Context, collection *mongo.Collection, filter bson.D, frames *streamFrames) error {
+	pipeline, err := ChangeStreamPipeline(filter)
+	if err != nil {
+		return err
+	}
+	stream, err := collection.Watch(ctx, pipeline)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open change stream")
+	}
+	defer stream.Close(context.Background())
+	log.DefaultLogger.Info("Streaming changes", "database", collection.Database().Name(), "collection", collection.Name())
+
+	decode := func() (bsonframe.Document, error) {
+		var event struct {
+			FullDocument bsonframe.Document `bson:"fullDocument"`
+		}
+		err := stream.Decode(&event)
+		return event.FullDocument, errors.Wrap(err, "Failed to decode change event")
+	}
+	err = readBatches(ctx, stream, decode, frames.send)
+	return errors.Wrap(err, "Change stream failed")
+}
+
+// tailCollection sends the documents appended to a capped collection using a tailable cursor.
+// The server closes tailable cursors which reach the end of an empty collection, or fall behind the oldest document,
+// so the cursor is reopened until the stream is cancelled, resuming after the last document seen.
+func tailCollection(ctx context.Context, collection *mongo.Collection, filter bson.D, frames *streamFrames) error {
+	opts := mongoOpts.Find().SetCursorType(mongoOpts.TailableAwait)
+	// Documents which were already in the collection when the stream started are skipped, as the query itself shows them.
+	// If the collection was empty, then anything found when reopening was appended afterwards.
+	skipExisting := true
+	var lastID *bson.RawValue
+	for {
+		cursor, err := collection.Find(ctx, resumeFilter(filter, lastID), opts)
+		if err != nil {
+			return errors.Wrap(err, "Failed to open tailable cursor")
+		}
+		log.DefaultLogger.Info("Tailing collection", "database", collection.Database().Name(), "collection", collection.Name())
+		seen := func() {
+			id := cursor.Current.Lookup("_id")
+			// The current document is only valid until the cursor moves
+			id.Value = append([]byte(nil), id.Value...)
+			lastID = &id
+		}
+		for skipExisting && cursor.TryNext(ctx) {
+			seen()
+		}
+		skipExisting = false
+		decode := func() (bsonframe.Document, error) {
+			seen()
+			var doc bsonframe.Document
+			err := cursor.Decode(&doc)
+			return doc, errors.Wrap(err, "Failed to decode document")
+		}
+		err = readBatches(ctx, cursor, decode, frames.send)
+		cursor.Close(context.Background())
+		if err != nil {
+			return errors.Wrap(err, "Tailable cursor failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(tailableRetryInterval):
+		}
+	}
+}
+
+// resumeFilter restricts the filter of a tailable cursor to the documents after the last one seen by a previous cursor, if any.
+// Capped collections keep documents in insertion order, so this relies on _id increasing with it, as ObjectIDs do.
+func resumeFilter(filter bson.D, lastID *bson.RawValue) bson.D {
+	if lastID == nil {
+		return filter
+	}
+	after := bson.D{bson.E{Key: "_id", Value: bson.D{bson.E{Key: "$gt", Value: *lastID}}}}
+	if len(filter) == 0 {
+		return after
+	}
+	return bson.D{bson.E{Key: "$and", Value: bson.A{filter, after}}}
+}
@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// parseStreamFilter produces the filter applied to the documents of a streamed query
+func parseStreamFilter(rawQuery []byte) (bson.D, error) {
+	qm, err := parseQueryModel(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	err = qm.validateStream()
+	if err != nil {
+		return nil, err
+	}
+	return qm.streamFilter()
+}
+
+var _ = Describe("ChangeStreamPipeline", func() {
+	It("Should match inserted documents by their fields", func() {
+		filter := bson.D{}
+		Expect(bson.UnmarshalExtJSON([]byte(`{"level": "error", "$or": [{"host": "a"}, {"status": {"$gte": 500}}]}`), false, &filter)).To(Succeed())
+		pipeline, err := ChangeStreamPipeline(filter)
+		Expect(err).ToNot(HaveOccurred())
+		bytes, err := bson.MarshalExtJSON(bson.D{{Key: "pipeline", Value: pipeline}}, false, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(bytes)).To(MatchJSON(`{"pipeline": [{"$match": {
+			"operationType": "insert",
+			"fullDocument.level": "error",
+			"$or": [{"fullDocument.host": "a"}, {"fullDocument.status": {"$gte": 500}}]
+		}}]}`))
+	})
+
+	It("Should reject top-level operators which cannot be rewritten", func() {
+		_, err := ChangeStreamPipeline(bson.D{{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$a", "$b"}}}}})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("parseStreamFilter", func() {
+	It("Should expand the filter of a find", func() {
+		filter, err := parseStreamFilter([]byte(`{"version": 2, "stream": "changeStream", "command": "find", "filter": "{\"level\": \"error\"}"}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter).To(Equal(bson.D{{Key: "level", Value: "error"}}))
+	})
+
+	It("Should reject time macros, which would match no new documents", func() {
+		_, err := parseStreamFilter([]byte(`{"version": 2, "stream": "changeStream", "command": "find", "filter": "$__timeFilter(ts)"}`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should reject aggregations, whose pipeline cannot be applied to each new document", func() {
+		_, err := parseStreamFilter([]byte(`{"version": 2, "stream": "tailable", "aggregation": "[{\"$group\": {\"_id\": \"$host\"}}]"}`))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("resumeFilter", func() {
+	lastID := &bson.RawValue{Type: bsontype.Int32, Value: bsoncore.AppendInt32(nil, 42)}
+	filter := bson.D{{Key: "level", Value: "error"}}
+
+	DescribeTable("Should resume", func(filter bson.D, lastID *bson.RawValue, expected string) {
+		bytes, err := bson.MarshalExtJSON(resumeFilter(filter, lastID), false, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(bytes)).To(MatchJSON(expected))
+	},
+		Entry("from the start of the collection when nothing was seen", filter, nil, `{"level": "error"}`),
+		Entry("after the last document seen", bson.D{}, lastID, `{"_id": {"$gt": 42}}`),
+		Entry("after the last document seen which matches the filter", filter, lastID, `{"$and": [{"level": "error"}, {"_id": {"$gt": 42}}]}`),
+	)
+})
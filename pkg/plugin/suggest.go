@@ -0,0 +1,231 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOpts "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultSuggestSampleSize = 20
+	maxSuggestSampleSize     = 200
+	maxSuggestPromptLength   = 4000
+	// maxSuggestResponseBytes bounds the response read from the suggestion endpoint
+	maxSuggestResponseBytes = 1 << 20
+	suggestTimeout          = 60 * time.Second
+
+	grafanaViewerRole = "Viewer"
+)
+
+// SuggestField is a top-level field seen in the sampled documents, with every type it was seen with
+type SuggestField struct {
+	Name  string   `json:"name"`
+	Types []string `json:"types"`
+}
+
+// SuggestPrompt is the body sent to the suggestion endpoint. Only field names and types are sent, never values.
+type SuggestPrompt struct {
+	Prompt     string         `json:"prompt"`
+	Database   string         `json:"database"`
+	Collection string         `json:"collection"`
+	Schema     []SuggestField `json:"schema"`
+	// Model is passed through from the datasource settings, for endpoints serving more than one model
+	Model string `json:"model,omitempty"`
+}
+
+// suggestEndpointResponse is the body expected from the suggestion endpoint
+type suggestEndpointResponse struct {
+	Pipeline    json.RawMessage `json:"pipeline"`
+	Explanation string          `json:"explanation,omitempty"`
+}
+
+// PipelineSuggestion is a candidate pipeline for the editor to insert, which has been checked to parse, but not to run
+type PipelineSuggestion struct {
+	// Pipeline is the pipeline as JSON text, in the same form as the aggregation of a query
+	Pipeline    string `json:"pipeline"`
+	Explanation string `json:"explanation,omitempty"`
+}
+
+// RequestPipelineSuggestion sends a prompt to a suggestion endpoint, which is expected to respond with a JSON object with a pipeline,
+// either as an array or a string, and optionally an explanation. Putting an adapter in front of a language model which speaks this
+// protocol means that no particular provider is assumed. If apiKey is not empty, it is sent as a bearer token.
+func RequestPipelineSuggestion(ctx context.Context, client *http.Client, endpoint, apiKey string, prompt SuggestPrompt) (PipelineSuggestion, error) {
+	body, err := json.Marshal(prompt)
+	if err != nil {
+		return PipelineSuggestion{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return PipelineSuggestion{}, errors.Wrap(err, "Invalid suggestion endpoint")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return PipelineSuggestion{}, errors.Wrap(err, "Failed to reach suggestion endpoint")
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxSuggestResponseBytes))
+	if err != nil {
+		return PipelineSuggestion{}, errors.Wrap(err, "Failed to read suggestion")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return PipelineSuggestion{}, fmt.Errorf("Suggestion endpoint responded with status %d", resp.StatusCode)
+	}
+
+	var suggested suggestEndpointResponse
+	err = json.Unmarshal(respBody, &suggested)
+	if err != nil {
+		return PipelineSuggestion{}, errors.Wrap(err, "Suggestion endpoint returned invalid JSON")
+	}
+	pipeline := string(suggested.Pipeline)
+	var text string
+	if json.Unmarshal(suggested.Pipeline, &text) == nil {
+		pipeline = text
+	}
+	var stages mongo.Pipeline
+	err = bson.UnmarshalExtJSON([]byte(pipeline), false, &stages)
+	if err != nil {
+		return PipelineSuggestion{}, errors.Wrap(err, "Suggestion endpoint returned an invalid pipeline")
+	}
+	return PipelineSuggestion{Pipeline: pipeline, Explanation: suggested.Explanation}, nil
+}
+
+// sampleSchema describes the top-level fields of a random sample of a collection
+func sampleSchema(ctx context.Context, collection *mongo.Collection, size int) ([]SuggestField, error) {
+	pipeline := mongo.Pipeline{bson.D{bson.E{Key: "$sample", Value: bson.D{bson.E{Key: "size", Value: size}}}}}
+	cursor, err := collection.Aggregate(ctx, pipeline, mongoOpts.Aggregate().SetComment(newQueryComment()))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sample collection")
+	}
+	defer cursor.Close(ctx)
+
+	types := map[string]map[string]struct{}{}
+	for cursor.Next(ctx) {
+		var doc timestepDocument
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to decode sampled document")
+		}
+		for name, value := range doc {
+			_, type_, err := ToGrafanaValue(value)
+			if err != nil || type_ == data.FieldTypeUnknown {
+				continue
+			}
+			if types[name] == nil {
+				types[name] = map[string]struct{}{}
+			}
+			types[name][type_.NonNullableType().ItemTypeString()] = struct{}{}
+		}
+	}
+	if cursor.Err() != nil {
+		return nil, errors.Wrap(cursor.Err(), "Failed to sample collection")
+	}
+
+	fields := make([]SuggestField, 0, len(types))
+	for name, seen := range types {
+		field := SuggestField{Name: name, Types: make([]string, 0, len(seen))}
+		for type_ := range seen {
+			field.Types = append(field.Types, type_)
+		}
+		sort.Strings(field.Types)
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields, nil
+}
+
+type suggestRequest struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Prompt     string `json:"prompt"`
+	// Sample is the number of documents sampled to describe the collection
+	Sample int `json:"sample,omitempty"`
+}
+
+// handleSuggest asks the configured suggestion endpoint for a pipeline which answers a natural language prompt about a collection.
+// This is disabled unless a suggestion endpoint is configured, and is not available to viewers, as each request may incur a cost.
+func (d *MongoDBDatasource) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	ctx := r.Context()
+	settings, err := loadDatasource(httpadapter.PluginConfigFromContext(ctx))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if settings.SuggestEndpoint == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Pipeline suggestions are not configured for this datasource"))
+		return
+	}
+	user := httpadapter.UserFromContext(ctx)
+	if user == nil || user.Role == grafanaViewerRole {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Only editors and admins may request pipeline suggestions"))
+		return
+	}
+
+	var req suggestRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+	if req.Database == "" || req.Collection == "" || req.Prompt == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Database, collection, and prompt are required"))
+		return
+	}
+	if len(req.Prompt) > maxSuggestPromptLength {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Prompt must be at most %d characters", maxSuggestPromptLength))
+		return
+	}
+	if req.Sample <= 0 {
+		req.Sample = defaultSuggestSampleSize
+	}
+	if req.Sample > maxSuggestSampleSize {
+		req.Sample = maxSuggestSampleSize
+	}
+
+	mongoCtx, client, _, done, ok := resourceClient(w, r)
+	if !ok {
+		return
+	}
+	defer done()
+	schema, err := sampleSchema(mongoCtx, client.Database(req.Database).Collection(req.Collection), req.Sample)
+	if err != nil {
+		writeMongoError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, suggestTimeout)
+	defer cancel()
+	suggestion, err := RequestPipelineSuggestion(ctx, http.DefaultClient, settings.SuggestEndpoint, settings.SuggestAPIKey, SuggestPrompt{
+		Prompt:     req.Prompt,
+		Database:   req.Database,
+		Collection: req.Collection,
+		Schema:     schema,
+		Model:      settings.SuggestModel,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	log.DefaultLogger.Info("Suggested pipeline", "user", user.Login, "database", req.Database, "collection", req.Collection)
+	writeJSON(w, http.StatusOK, suggestion)
+}
@@ -0,0 +1,60 @@
+package plugin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestPipelineSuggestion", func() {
+	var received plugin.SuggestPrompt
+	var authorization string
+	var response string
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			Expect(json.NewDecoder(r.Body).Decode(&received)).To(Succeed())
+			w.Write([]byte(response))
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	prompt := plugin.SuggestPrompt{
+		Prompt:     "errors per host",
+		Database:   "db",
+		Collection: "events",
+		Schema:     []plugin.SuggestField{{Name: "host", Types: []string{"string"}}},
+	}
+
+	It("Should send the prompt and schema, and accept a pipeline array", func() {
+		response = `{"pipeline": [{"$group": {"_id": "$host", "errors": {"$sum": 1}}}], "explanation": "Counts by host"}`
+		suggestion, err := plugin.RequestPipelineSuggestion(context.Background(), server.Client(), server.URL, "key", prompt)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authorization).To(Equal("Bearer key"))
+		Expect(received).To(Equal(prompt))
+		Expect(suggestion.Pipeline).To(MatchJSON(`[{"$group": {"_id": "$host", "errors": {"$sum": 1}}}]`))
+		Expect(suggestion.Explanation).To(Equal("Counts by host"))
+	})
+
+	It("Should accept a pipeline string, and not send an empty key", func() {
+		response = `{"pipeline": "[{\"$limit\": 1}]"}`
+		suggestion, err := plugin.RequestPipelineSuggestion(context.Background(), server.Client(), server.URL, "", prompt)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authorization).To(BeEmpty())
+		Expect(suggestion.Pipeline).To(Equal(`[{"$limit": 1}]`))
+	})
+
+	It("Should reject responses which are not pipelines", func() {
+		response = `{"pipeline": "I'm sorry, I can't do that"}`
+		_, err := plugin.RequestPipelineSuggestion(context.Background(), server.Client(), server.URL, "", prompt)
+		Expect(err).To(HaveOccurred())
+	})
+})
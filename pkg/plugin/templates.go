@@ -0,0 +1,285 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	templateParamField  = "field"
+	templateParamNumber = "number"
+	templateParamUnit   = "unit"
+)
+
+// dateTruncUnits are the units accepted by $dateTrunc
+var dateTruncUnits = []string{"year", "quarter", "month", "week", "day", "hour", "minute", "second", "millisecond"}
+
+type templateParameter struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Type is one of field, number, or unit
+	Type    string `json:"type"`
+	Default string `json:"default,omitempty"`
+}
+
+// pipelineTemplate is a parameterized query. Its pipeline and query model fields are text/templates, rendered with the
+// parameter values, which must produce JSON. Parameters are validated according to their type, and the field and json
+// template functions quote values, so parameters cannot inject pipeline stages.
+type pipelineTemplate struct {
+	ID               string              `json:"id"`
+	Name             string              `json:"name"`
+	Description      string              `json:"description"`
+	Parameters       []templateParameter `json:"parameters"`
+	MinServerVersion string              `json:"minServerVersion,omitempty"`
+
+	pipeline string
+	query    string
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		bytes, err := json.Marshal(value)
+		return string(bytes), err
+	},
+	"field": func(name string) (string, error) {
+		bytes, err := json.Marshal("$" + name)
+		return string(bytes), err
+	},
+}
+
+// pipelineTemplates are the templates served by the /templates route
+var pipelineTemplates = []pipelineTemplate{
+	{
+		ID:          "top-n-over-time",
+		Name:        "Top N over time",
+		Description: "Counts documents per time bucket for the N most frequent values of a field",
+		Parameters: []templateParameter{
+			{Name: "timeField", Description: "Field containing the document timestamp", Type: templateParamField, Default: "timestamp"},
+			{Name: "groupField", Description: "Field to group by", Type: templateParamField},
+			{Name: "n", Description: "Number of groups to keep", Type: templateParamNumber, Default: "10"},
+			{Name: "unit", Description: "Size of each time bucket", Type: templateParamUnit, Default: "hour"},
+		},
+		MinServerVersion: "5.0",
+		pipeline: `[
+	{"$group": {"_id": {"group": {{field .groupField}}, "time": {"$dateTrunc": {"date": {{field .timeField}}, "unit": {{json .unit}}}}}, "count": {"$sum": 1}}},
+	{"$group": {"_id": "$_id.group", "total": {"$sum": "$count"}, "points": {"$push": {"time": "$_id.time", "count": "$count"}}}},
+	{"$sort": {"total": -1}},
+	{"$limit": {{.n}}},
+	{"$unwind": "$points"},
+	{"$project": {"_id": 0, {{json .timeField}}: "$points.time", "group": {"$toString": "$_id"}, "count": {"$toDouble": "$points.count"}}}
+]`,
+		query: `{"queryType": "Timeseries", "timestampField": {{json .timeField}}, "labelFields": ["group"], "valueFields": ["count"], "valueFieldTypes": ["float64"],
+	"autoTimeBound": true, "autoTimeBoundAtStart": true, "autoTimeSort": true}`,
+	},
+	{
+		ID:          "error-rate",
+		Name:        "Error rate",
+		Description: "Fraction of documents per time bucket where a status field is at least a threshold",
+		Parameters: []templateParameter{
+			{Name: "timeField", Description: "Field containing the document timestamp", Type: templateParamField, Default: "timestamp"},
+			{Name: "statusField", Description: "Field containing the status code", Type: templateParamField, Default: "status"},
+			{Name: "threshold", Description: "Minimum status considered an error", Type: templateParamNumber, Default: "500"},
+			{Name: "unit", Description: "Size of each time bucket", Type: templateParamUnit, Default: "minute"},
+		},
+		MinServerVersion: "5.0",
+		pipeline: `[
+	{"$group": {
+		"_id": {"$dateTrunc": {"date": {{field .timeField}}, "unit": {{json .unit}}}},
+		"total": {"$sum": 1},
+		"errors": {"$sum": {"$cond": [{"$gte": [{{field .statusField}}, {{.threshold}}]}, 1, 0]}}
+	}},
+	{"$project": {"_id": 0, {{json .timeField}}: "$_id", "errorRate": {"$divide": ["$errors", "$total"]}}}
+]`,
+		query: `{"queryType": "Timeseries", "timestampField": {{json .timeField}}, "valueFields": ["errorRate"], "valueFieldTypes": ["float64"],
+	"autoTimeBound": true, "autoTimeBoundAtStart": true, "autoTimeSort": true}`,
+	},
+	{
+		ID:          "latency-percentile",
+		Name:        "Latency percentile",
+		Description: "Approximate percentile of a latency field per time bucket, using $percentile",
+		Parameters: []templateParameter{
+			{Name: "timeField", Description: "Field containing the document timestamp", Type: templateParamField, Default: "timestamp"},
+			{Name: "latencyField", Description: "Field containing the latency", Type: templateParamField, Default: "latency"},
+			{Name: "p", Description: "Percentile, between 0 and 1", Type: templateParamNumber, Default: "0.95"},
+			{Name: "unit", Description: "Size of each time bucket", Type: templateParamUnit, Default: "minute"},
+		},
+		MinServerVersion: "7.0",
+		pipeline: `[
+	{"$group": {
+		"_id": {"$dateTrunc": {"date": {{field .timeField}}, "unit": {{json .unit}}}},
+		"percentile": {"$percentile": {"input": {{field .latencyField}}, "p": [{{.p}}], "method": "approximate"}}
+	}},
+	{"$project": {"_id": 0, {{json .timeField}}: "$_id", "latency": {"$toDouble": {"$arrayElemAt": ["$percentile", 0]}}}}
+]`,
+		query: `{"queryType": "Timeseries", "timestampField": {{json .timeField}}, "valueFields": ["latency"], "valueFieldTypes": ["float64"],
+	"autoTimeBound": true, "autoTimeBoundAtStart": true, "autoTimeSort": true}`,
+	},
+	{
+		ID:          "retention-cohort",
+		Name:        "Retention cohort",
+		Description: "Number of users active in each period after the period they were first seen in",
+		Parameters: []templateParameter{
+			{Name: "timeField", Description: "Field containing the document timestamp", Type: templateParamField, Default: "timestamp"},
+			{Name: "userField", Description: "Field identifying the user", Type: templateParamField, Default: "userId"},
+			{Name: "unit", Description: "Size of each cohort", Type: templateParamUnit, Default: "week"},
+		},
+		MinServerVersion: "5.0",
+		pipeline: `[
+	{"$group": {"_id": {"user": {{field .userField}}, "period": {"$dateTrunc": {"date": {{field .timeField}}, "unit": {{json .unit}}}}}}},
+	{"$group": {"_id": "$_id.user", "periods": {"$addToSet": "$_id.period"}, "cohort": {"$min": "$_id.period"}}},
+	{"$unwind": "$periods"},
+	{"$group": {"_id": {"cohort": "$cohort", "period": "$periods"}, "users": {"$sum": 1}}},
+	{"$project": {
+		"_id": 0,
+		"cohort": "$_id.cohort",
+		"periodsSince": {"$dateDiff": {"startDate": "$_id.cohort", "endDate": "$_id.period", "unit": {{json .unit}}}},
+		"users": 1
+	}},
+	{"$sort": {"cohort": 1, "periodsSince": 1}}
+]`,
+		query: `{"queryType": "Table", "timestampField": {{json .timeField}}, "schemaInference": true, "schemaInferenceDepth": 20,
+	"autoTimeBound": true, "autoTimeBoundAtStart": true}`,
+	},
+}
+
+func findPipelineTemplate(id string) (*pipelineTemplate, bool) {
+	for ix := range pipelineTemplates {
+		if pipelineTemplates[ix].ID == id {
+			return &pipelineTemplates[ix], true
+		}
+	}
+	return nil, false
+}
+
+// validateTemplateParameter checks that a parameter value is valid for its type
+func validateTemplateParameter(param templateParameter, value string) error {
+	switch param.Type {
+	case templateParamField:
+		if value == "" || strings.HasPrefix(value, "$") {
+			return fmt.Errorf("Parameter %s must be a field name, without a leading $", param.Name)
+		}
+	case templateParamNumber:
+		_, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("Parameter %s must be a number", param.Name)
+		}
+	case templateParamUnit:
+		for _, unit := range dateTruncUnits {
+			if value == unit {
+				return nil
+			}
+		}
+		return fmt.Errorf("Parameter %s must be one of: %s", param.Name, strings.Join(dateTruncUnits, ", "))
+	}
+	return nil
+}
+
+func renderTemplateText(name, text string, params map[string]string) (string, error) {
+	tpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	err = tpl.Execute(&out, params)
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// RenderPipelineTemplate substitutes parameters into a template, producing the fields of a query model
+func RenderPipelineTemplate(id string, params map[string]string) (map[string]interface{}, error) {
+	tpl, ok := findPipelineTemplate(id)
+	if !ok {
+		return nil, fmt.Errorf("No such template %s", id)
+	}
+	values := make(map[string]string, len(tpl.Parameters))
+	for _, param := range tpl.Parameters {
+		value, ok := params[param.Name]
+		if !ok || value == "" {
+			value = param.Default
+		}
+		err := validateTemplateParameter(param, value)
+		if err != nil {
+			return nil, err
+		}
+		values[param.Name] = value
+	}
+	for name := range params {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("Unknown parameter %s for template %s", name, id)
+		}
+	}
+
+	pipeline, err := renderTemplateText(id+"/pipeline", tpl.pipeline, values)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to render pipeline")
+	}
+	// Make sure the template produced a valid pipeline, rather than leaving it to the query
+	var stages []interface{}
+	err = json.Unmarshal([]byte(pipeline), &stages)
+	if err != nil {
+		return nil, errors.Wrap(err, "Template produced an invalid pipeline")
+	}
+	query, err := renderTemplateText(id+"/query", tpl.query, values)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to render query")
+	}
+	model := map[string]interface{}{}
+	err = json.Unmarshal([]byte(query), &model)
+	if err != nil {
+		return nil, errors.Wrap(err, "Template produced an invalid query")
+	}
+	model["aggregation"] = pipeline
+	model["version"] = CurrentQueryModelVersion
+	return model, nil
+}
+
+// handleTemplates serves the pipeline template library:
+//
+//	GET /templates
+//	POST /templates/{id}/render with a JSON object of parameter values
+func (d *MongoDBDatasource) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	segments, err := resourcePathSegments(r, "/templates")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch {
+	case len(segments) == 0:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only GET is supported"))
+			return
+		}
+		writeJSON(w, http.StatusOK, pipelineTemplates)
+	case len(segments) == 2 && segments[1] == "render":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+			return
+		}
+		if _, ok := findPipelineTemplate(segments[0]); !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("No such template %s", segments[0]))
+			return
+		}
+		params := map[string]string{}
+		err = json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+			return
+		}
+		model, err := RenderPipelineTemplate(segments[0], params)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, model)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("Not found"))
+	}
+}
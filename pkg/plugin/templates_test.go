@@ -0,0 +1,41 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RenderPipelineTemplate", func() {
+	DescribeTable("Should render a valid pipeline with default parameters", func(id string, params map[string]string) {
+		model, err := plugin.RenderPipelineTemplate(id, params)
+		Expect(err).ToNot(HaveOccurred())
+		pipeline := mongo.Pipeline{}
+		Expect(bson.UnmarshalExtJSON([]byte(model["aggregation"].(string)), false, &pipeline)).To(Succeed())
+		Expect(pipeline).ToNot(BeEmpty())
+	},
+		Entry("top N", "top-n-over-time", map[string]string{"groupField": "host"}),
+		Entry("error rate", "error-rate", map[string]string{}),
+		Entry("latency percentile", "latency-percentile", map[string]string{"p": "0.99"}),
+		Entry("retention cohort", "retention-cohort", map[string]string{"unit": "month"}),
+	)
+
+	It("Should quote field names", func() {
+		model, err := plugin.RenderPipelineTemplate("top-n-over-time", map[string]string{"groupField": `a"}}, {"$out": "x`})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(model["aggregation"]).ToNot(ContainSubstring(`{"$out"`))
+	})
+
+	It("Should reject invalid parameters", func() {
+		_, err := plugin.RenderPipelineTemplate("error-rate", map[string]string{"threshold": "1}, {"})
+		Expect(err).To(HaveOccurred())
+		_, err = plugin.RenderPipelineTemplate("error-rate", map[string]string{"unit": "fortnight"})
+		Expect(err).To(HaveOccurred())
+		_, err = plugin.RenderPipelineTemplate("top-n-over-time", map[string]string{})
+		Expect(err).To(HaveOccurred())
+	})
+})
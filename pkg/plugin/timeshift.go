@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+// ShiftTimeRange applies the relative time and time shift of a query, both Grafana durations such as 1h or 7d, to the time range
+// of its panel, before macros are expanded. A relative time replaces the range with the one ending now, and a time shift moves
+// the range into the past. It returns the effective time range, and the shift, so that results may be moved back with ShiftTimeFields.
+func ShiftTimeRange(timeRange backend.TimeRange, relativeTime, timeShift string, now time.Time) (backend.TimeRange, time.Duration, error) {
+	if relativeTime != "" {
+		relative, err := gtime.ParseDuration(relativeTime)
+		if err != nil {
+			return backend.TimeRange{}, 0, errors.Wrap(err, "Invalid relative time")
+		}
+		if relative <= 0 {
+			return backend.TimeRange{}, 0, fmt.Errorf("Relative time must be positive")
+		}
+		timeRange = backend.TimeRange{From: now.Add(-relative), To: now}
+	}
+	var shift time.Duration
+	if timeShift != "" {
+		var err error
+		shift, err = gtime.ParseDuration(timeShift)
+		if err != nil {
+			return backend.TimeRange{}, 0, errors.Wrap(err, "Invalid time shift")
+		}
+		timeRange = backend.TimeRange{From: timeRange.From.Add(-shift), To: timeRange.To.Add(-shift)}
+	}
+	return timeRange, shift, nil
+}
+
+// ShiftTimeFields adds a time shift to every time in the frames, so that results from a shifted time range line up with the panel,
+// e.g. to compare this week with last week
+func ShiftTimeFields(frames []*data.Frame, shift time.Duration) {
+	if shift == 0 {
+		return
+	}
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if field.Type().NonNullableType() != data.FieldTypeTime {
+				continue
+			}
+			for row := 0; row < field.Len(); row++ {
+				value, ok := field.ConcreteAt(row)
+				if !ok {
+					continue
+				}
+				field.SetConcrete(row, value.(time.Time).Add(shift))
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ShiftTimeRange", func() {
+	now := time.Unix(30*86400, 0)
+	panel := backend.TimeRange{From: now.Add(-6 * time.Hour), To: now}
+
+	It("Should move the range into the past by the time shift", func() {
+		shifted, shift, err := plugin.ShiftTimeRange(panel, "", "1w", now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shift).To(Equal(7 * 24 * time.Hour))
+		Expect(shifted).To(Equal(backend.TimeRange{From: panel.From.Add(-shift), To: panel.To.Add(-shift)}))
+	})
+
+	It("Should replace the range with the relative time before shifting it", func() {
+		shifted, _, err := plugin.ShiftTimeRange(panel, "1h", "1d", now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shifted).To(Equal(backend.TimeRange{From: now.Add(-25 * time.Hour), To: now.Add(-24 * time.Hour)}))
+	})
+
+	It("Should reject invalid durations", func() {
+		_, _, err := plugin.ShiftTimeRange(panel, "", "soon", now)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ShiftTimeFields", func() {
+	It("Should move times forward by the shift", func() {
+		t := time.Unix(60, 0)
+		frame := data.NewFrame("", data.NewField("time", nil, []*time.Time{&t, nil}), data.NewField("value", nil, []int64{1, 2}))
+		plugin.ShiftTimeFields([]*data.Frame{frame}, time.Hour)
+		Expect(*frame.Fields[0].At(0).(*time.Time)).To(Equal(t.Add(time.Hour)))
+		Expect(frame.Fields[0].At(1)).To(BeNil())
+		Expect(frame.Fields[1].At(0)).To(Equal(int64(1)))
+	})
+})
@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+const (
+	topNBySum  = "sum"
+	topNByMax  = "max"
+	topNByAvg  = "avg"
+	topNByLast = "last"
+
+	// topNOther is the name, and the value of every label, of the series the remaining series are collapsed into
+	topNOther = "Other"
+)
+
+func (m *QueryModel) validateTopN() error {
+	if m.TopN == 0 {
+		return nil
+	}
+	if m.TopN < 0 {
+		return fmt.Errorf("Top N must be positive")
+	}
+	if m.QueryType != queryTypeTimeseries {
+		return fmt.Errorf("Top N is only supported for %s queries", queryTypeTimeseries)
+	}
+	switch m.TopNBy {
+	case "", topNBySum, topNByMax, topNByAvg, topNByLast:
+		return nil
+	default:
+		return fmt.Errorf("Top N must rank series by one of: %s, %s, %s, %s", topNBySum, topNByMax, topNByAvg, topNByLast)
+	}
+}
+
+// topNSeries is a frame and its rank
+type topNSeries struct {
+	frame *data.Frame
+	key   string
+	score float64
+}
+
+// seriesScore aggregates all numeric values of a frame into a single number to rank it by
+func seriesScore(frame *data.Frame, by string) (float64, error) {
+	var timeField *data.Field
+	for _, field := range frame.Fields {
+		if field.Type().Time() {
+			timeField = field
+			break
+		}
+	}
+	sum := 0.0
+	count := 0
+	max := math.Inf(-1)
+	last := math.Inf(-1)
+	var lastTime time.Time
+	for _, field := range frame.Fields {
+		if !field.Type().Numeric() {
+			continue
+		}
+		for row := 0; row < field.Len(); row++ {
+			value, err := field.NullableFloatAt(row)
+			if err != nil {
+				return 0, errors.Wrap(err, fmt.Sprintf("Failed to convert %s to a number", field.Name))
+			}
+			if value == nil || math.IsNaN(*value) {
+				continue
+			}
+			sum += *value
+			count++
+			if *value > max {
+				max = *value
+			}
+			at := time.Time{}
+			if timeField != nil {
+				if t, ok := timeField.ConcreteAt(row); ok {
+					at = t.(time.Time)
+				}
+			}
+			if !at.Before(lastTime) {
+				lastTime = at
+				last = *value
+			}
+		}
+	}
+	switch by {
+	case topNByMax:
+		return max, nil
+	case topNByAvg:
+		if count == 0 {
+			return math.Inf(-1), nil
+		}
+		return sum / float64(count), nil
+	case topNByLast:
+		return last, nil
+	default:
+		return sum, nil
+	}
+}
+
+// seriesKey identifies a frame by the labels of its fields, to break ties between series with the same score
+func seriesKey(frame *data.Frame) string {
+	for _, field := range frame.Fields {
+		if len(field.Labels) != 0 {
+			return field.Labels.String()
+		}
+	}
+	return frame.Name
+}
+
+// CollapseTopN keeps the n series with the greatest score, in descending order, and sums the remaining series into a single
+// series named Other, which has a value for each time at which any of the remaining series has a value.
+// Series are ranked by the sum, maximum, average, or last value of all of their numeric fields.
+// The number of collapsed series is returned, which is zero if there were no more than n series.
+func CollapseTopN(frames []*data.Frame, n int, by string) ([]*data.Frame, int, error) {
+	if len(frames) <= n {
+		return frames, 0, nil
+	}
+	series := make([]topNSeries, len(frames))
+	for ix, frame := range frames {
+		score, err := seriesScore(frame, by)
+		if err != nil {
+			return nil, 0, err
+		}
+		series[ix] = topNSeries{frame: frame, key: seriesKey(frame), score: score}
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		if series[i].score != series[j].score {
+			return series[i].score > series[j].score
+		}
+		return series[i].key < series[j].key
+	})
+
+	kept := make([]*data.Frame, 0, n+1)
+	for _, s := range series[:n] {
+		kept = append(kept, s.frame)
+	}
+	other, err := sumSeries(series[n:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(kept, other), len(series) - n, nil
+}
+
+// sumSeries sums the numeric fields of the same name of several series at each time
+func sumSeries(series []topNSeries) (*data.Frame, error) {
+	timeName := ""
+	valueNames := []string{}
+	labels := data.Labels{}
+	sums := map[string]map[time.Time]float64{}
+	times := map[time.Time]struct{}{}
+	for _, s := range series {
+		var timeField *data.Field
+		for _, field := range s.frame.Fields {
+			if field.Type().Time() {
+				timeField = field
+				break
+			}
+		}
+		if timeField == nil {
+			return nil, fmt.Errorf("Series %s has no time field", s.key)
+		}
+		timeName = timeField.Name
+		for _, field := range s.frame.Fields {
+			if !field.Type().Numeric() {
+				continue
+			}
+			for key := range field.Labels {
+				labels[key] = topNOther
+			}
+			fieldSums, ok := sums[field.Name]
+			if !ok {
+				fieldSums = map[time.Time]float64{}
+				sums[field.Name] = fieldSums
+				valueNames = append(valueNames, field.Name)
+			}
+			for row := 0; row < field.Len(); row++ {
+				t, ok := timeField.ConcreteAt(row)
+				if !ok {
+					continue
+				}
+				value, err := field.NullableFloatAt(row)
+				if err != nil {
+					return nil, errors.Wrap(err, fmt.Sprintf("Failed to convert %s to a number", field.Name))
+				}
+				if value == nil {
+					continue
+				}
+				at := t.(time.Time)
+				fieldSums[at] += *value
+				times[at] = struct{}{}
+			}
+		}
+	}
+
+	sortedTimes := make([]time.Time, 0, len(times))
+	for t := range times {
+		sortedTimes = append(sortedTimes, t)
+	}
+	sort.Slice(sortedTimes, func(i, j int) bool { return sortedTimes[i].Before(sortedTimes[j]) })
+
+	frame := data.NewFrame(topNOther, data.NewField(timeName, nil, sortedTimes))
+	for _, name := range valueNames {
+		values := make([]*float64, len(sortedTimes))
+		for ix, t := range sortedTimes {
+			if sum, ok := sums[name][t]; ok {
+				sum := sum
+				values[ix] = &sum
+			}
+		}
+		field := data.NewField(name, labels.Copy(), values)
+		displayName := topNOther
+		if len(valueNames) > 1 {
+			displayName = fmt.Sprintf("%s %s", topNOther, name)
+		}
+		field.Config = &data.FieldConfig{DisplayNameFromDS: displayName}
+		frame.Fields = append(frame.Fields, field)
+	}
+	return frame, nil
+}
+
+// CapSeries keeps at most max series, choosing the first by their labels so that the same series are kept on every refresh.
+// The number of dropped series is returned.
+func CapSeries(frames []*data.Frame, max int) ([]*data.Frame, int) {
+	if len(frames) <= max {
+		return frames, 0
+	}
+	sorted := make([]*data.Frame, len(frames))
+	copy(sorted, frames)
+	sort.SliceStable(sorted, func(i, j int) bool { return seriesKey(sorted[i]) < seriesKey(sorted[j]) })
+	return sorted[:max], len(frames) - max
+}
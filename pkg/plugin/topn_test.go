@@ -0,0 +1,73 @@
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CollapseTopN", func() {
+	t0, t1 := time.Unix(0, 0), time.Unix(60, 0)
+	series := func(host string, times []time.Time, values []float64) *data.Frame {
+		labels := data.Labels{"host": host}
+		return data.NewFrame("", data.NewField("ts", labels, times), data.NewField("value", labels, values))
+	}
+	frames := func() []*data.Frame {
+		return []*data.Frame{
+			series("a", []time.Time{t0, t1}, []float64{1, 1}),
+			series("b", []time.Time{t0, t1}, []float64{10, 0}),
+			series("c", []time.Time{t0, t1}, []float64{3, 4}),
+			series("d", []time.Time{t1}, []float64{2}),
+		}
+	}
+
+	It("Should keep the top series by sum and collapse the rest into Other", func() {
+		result, collapsed, err := plugin.CollapseTopN(frames(), 2, "sum")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(collapsed).To(Equal(2))
+		Expect(result).To(HaveLen(3))
+		Expect(result[0].Fields[1].Labels).To(Equal(data.Labels{"host": "b"}))
+		Expect(result[1].Fields[1].Labels).To(Equal(data.Labels{"host": "c"}))
+
+		other := result[2]
+		Expect(other.Name).To(Equal("Other"))
+		Expect(other.Fields[1].Labels).To(Equal(data.Labels{"host": "Other"}))
+		Expect(other.Rows()).To(Equal(2))
+		Expect(other.Fields[0].At(0)).To(Equal(t0))
+		Expect(*(other.Fields[1].At(0).(*float64))).To(Equal(1.0))
+		Expect(*(other.Fields[1].At(1).(*float64))).To(Equal(3.0))
+	})
+
+	It("Should rank by the last value", func() {
+		result, _, err := plugin.CollapseTopN(frames(), 1, "last")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result[0].Fields[1].Labels).To(Equal(data.Labels{"host": "c"}))
+	})
+
+	It("Should leave few enough series unchanged", func() {
+		result, collapsed, err := plugin.CollapseTopN(frames(), 4, "sum")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(collapsed).To(Equal(0))
+		Expect(result).To(HaveLen(4))
+	})
+})
+
+var _ = Describe("CapSeries", func() {
+	It("Should keep the first series by labels", func() {
+		frames := []*data.Frame{}
+		for _, host := range []string{"c", "a", "d", "b"} {
+			labels := data.Labels{"host": host}
+			frames = append(frames, data.NewFrame("", data.NewField("ts", labels, []time.Time{}), data.NewField("value", labels, []float64{})))
+		}
+		capped, dropped := plugin.CapSeries(frames, 2)
+		Expect(dropped).To(Equal(2))
+		Expect(capped).To(HaveLen(2))
+		Expect(capped[0].Fields[1].Labels).To(Equal(data.Labels{"host": "a"}))
+		Expect(capped[1].Fields[1].Labels).To(Equal(data.Labels{"host": "b"}))
+	})
+})
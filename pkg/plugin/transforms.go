@@ -0,0 +1,558 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/pkg/errors"
+)
+
+// Transforms reshape the frames of a query after they are built, so that pipelines only need to retrieve data.
+// They are applied in order, to every frame.
+
+const (
+	transformFlatten = "flatten"
+	transformPivot   = "pivot"
+	transformSort    = "sort"
+	transformLimit   = "limit"
+	transformRename  = "rename"
+	transformCoerce  = "coerce"
+)
+
+// coerceTypes are the types which fields may be coerced to
+var coerceTypes = map[string]data.FieldType{
+	"string":  data.FieldTypeNullableString,
+	"float64": data.FieldTypeNullableFloat64,
+	"int64":   data.FieldTypeNullableInt64,
+	"bool":    data.FieldTypeNullableBool,
+	"time":    data.FieldTypeNullableTime,
+}
+
+// frameTransform is a single transform. Only the fields relevant to its type may be set.
+type frameTransform struct {
+	Type string `json:"type"`
+	// Fields of flatten transforms are the JSON fields to flatten, or all of them if empty
+	Fields []string `json:"fields,omitempty"`
+	// Row, Column, and Value of pivot transforms produce a frame with a row for each distinct Row,
+	// and a field for each distinct Column, containing the Value for that row and column
+	Row    string `json:"row,omitempty"`
+	Column string `json:"column,omitempty"`
+	Value  string `json:"value,omitempty"`
+	// Sort of sort transforms, which is stable
+	Sort  []builderSortField `json:"sort,omitempty"`
+	Limit int                `json:"limit,omitempty"`
+	// Rename of rename transforms maps old field names to new ones
+	Rename map[string]string `json:"rename,omitempty"`
+	// Field and To of coerce transforms convert a field to a type. Values which cannot be converted become null.
+	Field string `json:"field,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// ApplyTransforms applies transforms, in order, to every frame. Notices are returned for any values which were lost.
+func ApplyTransforms(frames []*data.Frame, transforms []frameTransform) ([]*data.Frame, []data.Notice, error) {
+	notices := []data.Notice{}
+	for ix, transform := range transforms {
+		for frameIx, frame := range frames {
+			transformed, notice, err := transform.apply(frame)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, fmt.Sprintf("Transform %d (%s)", ix+1, transform.Type))
+			}
+			if notice != "" {
+				notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: fmt.Sprintf("Transform %d (%s): %s", ix+1, transform.Type, notice)})
+			}
+			frames[frameIx] = transformed
+		}
+	}
+	return frames, notices, nil
+}
+
+func (t *frameTransform) apply(frame *data.Frame) (*data.Frame, string, error) {
+	switch t.Type {
+	case transformFlatten:
+		return flattenFrame(frame, t.Fields)
+	case transformPivot:
+		frame, err := pivotFrame(frame, t.Row, t.Column, t.Value)
+		return frame, "", err
+	case transformSort:
+		frame, err := sortFrame(frame, t.Sort)
+		return frame, "", err
+	case transformLimit:
+		if t.Limit <= 0 {
+			return nil, "", fmt.Errorf("Limit transforms must have a positive limit")
+		}
+		if frame.Rows() <= t.Limit {
+			return frame, "", nil
+		}
+		rows := make([]int, t.Limit)
+		for ix := range rows {
+			rows[ix] = ix
+		}
+		return selectRows(frame, rows), "", nil
+	case transformRename:
+		for _, field := range frame.Fields {
+			if to, ok := t.Rename[field.Name]; ok {
+				field.Name = to
+			}
+		}
+		return frame, "", nil
+	case transformCoerce:
+		return coerceFrameField(frame, t.Field, t.To)
+	default:
+		return nil, "", fmt.Errorf(
+			"Transform type must be one of: %s, %s, %s, %s, %s, %s",
+			transformFlatten, transformPivot, transformSort, transformLimit, transformRename, transformCoerce,
+		)
+	}
+}
+
+// frameField returns the field of a frame with a name, or nil if there is none
+func frameField(frame *data.Frame, name string) *data.Field {
+	for _, field := range frame.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// copyField returns an empty field with the same name, type, labels, and config as another
+func copyField(field *data.Field, length int) *data.Field {
+	copied := data.NewFieldFromFieldType(field.Type(), length)
+	copied.Name = field.Name
+	copied.Labels = field.Labels
+	copied.Config = field.Config
+	return copied
+}
+
+// selectRows returns a frame with only the given rows of another, in the given order
+func selectRows(frame *data.Frame, rows []int) *data.Frame {
+	fields := make([]*data.Field, len(frame.Fields))
+	for ix, field := range frame.Fields {
+		fields[ix] = copyField(field, len(rows))
+		for to, from := range rows {
+			fields[ix].Set(to, field.CopyAt(from))
+		}
+	}
+	selected := data.NewFrame(frame.Name, fields...)
+	selected.RefID = frame.RefID
+	selected.Meta = frame.Meta
+	return selected
+}
+
+// compareValues orders the concrete values of a field, with nulls first
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case !a:
+			return -1
+		default:
+			return 1
+		}
+	case time.Time:
+		switch {
+		case a.Before(b.(time.Time)):
+			return -1
+		case a.After(b.(time.Time)):
+			return 1
+		default:
+			return 0
+		}
+	case json.RawMessage:
+		return bytes.Compare(a, b.(json.RawMessage))
+	}
+	af, aok := transformFloat(a)
+	bf, bok := transformFloat(b)
+	if !aok || !bok {
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+	switch {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// transformFloat converts a concrete numeric value to a float64
+func transformFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func sortFrame(frame *data.Frame, fields []builderSortField) (*data.Frame, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Sort transforms must have at least one field")
+	}
+	keys := make([]*data.Field, len(fields))
+	for ix, field := range fields {
+		keys[ix] = frameField(frame, field.Field)
+		if keys[ix] == nil {
+			return nil, fmt.Errorf("No such field %s", field.Field)
+		}
+	}
+	rows := make([]int, frame.Rows())
+	for ix := range rows {
+		rows[ix] = ix
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for ix, key := range keys {
+			a, _ := key.ConcreteAt(rows[i])
+			b, _ := key.ConcreteAt(rows[j])
+			cmp := compareValues(a, b)
+			if fields[ix].Descending {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	return selectRows(frame, rows), nil
+}
+
+// transformKey formats a concrete value to identify the rows and columns of a pivot
+func transformKey(value interface{}, ok bool) string {
+	if !ok {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case json.RawMessage:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func pivotFrame(frame *data.Frame, rowName, columnName, valueName string) (*data.Frame, error) {
+	if rowName == "" || columnName == "" || valueName == "" {
+		return nil, fmt.Errorf("Pivot transforms require a row, column, and value field")
+	}
+	row := frameField(frame, rowName)
+	column := frameField(frame, columnName)
+	value := frameField(frame, valueName)
+	for ix, field := range []*data.Field{row, column, value} {
+		if field == nil {
+			return nil, fmt.Errorf("No such field %s", []string{rowName, columnName, valueName}[ix])
+		}
+	}
+
+	rowIndexes := map[string]int{}
+	rowFirst := []int{}
+	columnIndexes := map[string]int{}
+	columnNames := []string{}
+	type cell struct{ row, column, from int }
+	cells := make([]cell, 0, frame.Rows())
+	for ix := 0; ix < frame.Rows(); ix++ {
+		rowKey := transformKey(row.ConcreteAt(ix))
+		r, ok := rowIndexes[rowKey]
+		if !ok {
+			r = len(rowFirst)
+			rowIndexes[rowKey] = r
+			rowFirst = append(rowFirst, ix)
+		}
+		columnKey := transformKey(column.ConcreteAt(ix))
+		c, ok := columnIndexes[columnKey]
+		if !ok {
+			c = len(columnNames)
+			columnIndexes[columnKey] = c
+			columnNames = append(columnNames, columnKey)
+		}
+		cells = append(cells, cell{row: r, column: c, from: ix})
+	}
+
+	fields := make([]*data.Field, 1+len(columnNames))
+	fields[0] = copyField(row, len(rowFirst))
+	for to, from := range rowFirst {
+		fields[0].Set(to, row.CopyAt(from))
+	}
+	for ix, name := range columnNames {
+		fields[1+ix] = data.NewFieldFromFieldType(value.Type().NullableType(), len(rowFirst))
+		fields[1+ix].Name = name
+		fields[1+ix].Config = value.Config
+	}
+	// Later rows with the same row and column replace earlier ones
+	for _, cell := range cells {
+		v, ok := value.ConcreteAt(cell.from)
+		if ok {
+			fields[1+cell.column].SetConcrete(cell.row, v)
+		}
+	}
+	pivoted := data.NewFrame(frame.Name, fields...)
+	pivoted.RefID = frame.RefID
+	pivoted.Meta = frame.Meta
+	return pivoted, nil
+}
+
+// flattenValue converts a value decoded from a JSON object to a frame value, and returns the type it needs
+func flattenValue(value interface{}) (interface{}, data.FieldType) {
+	switch v := value.(type) {
+	case string:
+		return v, data.FieldTypeNullableString
+	case bool:
+		return v, data.FieldTypeNullableBool
+	case json.Number:
+		f, err := v.Float64()
+		if err == nil {
+			return f, data.FieldTypeNullableFloat64
+		}
+	}
+	raw, _ := json.Marshal(value)
+	return json.RawMessage(raw), data.FieldTypeNullableJSON
+}
+
+// flattenField splits a JSON field whose values are all objects into a field per key, named field.key,
+// in the order the keys were first seen. Keys whose values have more than one type are kept as JSON.
+// It returns nil if the field contains anything other than objects.
+func flattenField(field *data.Field) []*data.Field {
+	keys := []string{}
+	types := map[string]data.FieldType{}
+	rows := make([]map[string]interface{}, field.Len())
+	for ix := range rows {
+		value, ok := field.ConcreteAt(ix)
+		if !ok {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(value.(json.RawMessage)))
+		decoder.UseNumber()
+		var object map[string]interface{}
+		if decoder.Decode(&object) != nil || object == nil {
+			return nil
+		}
+		rows[ix] = object
+		for key, value := range object {
+			if value == nil {
+				continue
+			}
+			_, type_ := flattenValue(value)
+			seen, ok := types[key]
+			if !ok {
+				keys = append(keys, key)
+				types[key] = type_
+			} else if seen != type_ {
+				types[key] = data.FieldTypeNullableJSON
+			}
+		}
+	}
+	// Map iteration order is random, so sort keys first seen in the same row
+	sort.SliceStable(keys, func(i, j int) bool {
+		return firstRow(rows, keys[i]) < firstRow(rows, keys[j]) ||
+			(firstRow(rows, keys[i]) == firstRow(rows, keys[j]) && keys[i] < keys[j])
+	})
+
+	fields := make([]*data.Field, len(keys))
+	for ix, key := range keys {
+		fields[ix] = data.NewFieldFromFieldType(types[key], len(rows))
+		fields[ix].Name = field.Name + "." + key
+		fields[ix].Labels = field.Labels
+		for row, object := range rows {
+			value, ok := object[key]
+			if !ok || value == nil {
+				continue
+			}
+			converted, type_ := flattenValue(value)
+			if type_ != types[key] {
+				raw, _ := json.Marshal(value)
+				converted = json.RawMessage(raw)
+			}
+			fields[ix].SetConcrete(row, converted)
+		}
+	}
+	return fields
+}
+
+// firstRow returns the first row of a flattened field where a key is present
+func firstRow(rows []map[string]interface{}, key string) int {
+	for ix, object := range rows {
+		if _, ok := object[key]; ok {
+			return ix
+		}
+	}
+	return len(rows)
+}
+
+func flattenFrame(frame *data.Frame, names []string) (*data.Frame, string, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		field := frameField(frame, name)
+		if field == nil {
+			return nil, "", fmt.Errorf("No such field %s", name)
+		}
+		if field.Type().NonNullableType() != data.FieldTypeJSON {
+			return nil, "", fmt.Errorf("Field %s is not JSON, so cannot be flattened", name)
+		}
+		selected[name] = true
+	}
+	skipped := []string{}
+	fields := make([]*data.Field, 0, len(frame.Fields))
+	for _, field := range frame.Fields {
+		if field.Type().NonNullableType() != data.FieldTypeJSON || (len(names) != 0 && !selected[field.Name]) {
+			fields = append(fields, field)
+			continue
+		}
+		flattened := flattenField(field)
+		if flattened == nil {
+			skipped = append(skipped, field.Name)
+			fields = append(fields, field)
+			continue
+		}
+		fields = append(fields, flattened...)
+	}
+	frame.Fields = fields
+	if len(skipped) != 0 && len(names) != 0 {
+		return frame, fmt.Sprintf("The field(s) %s contain values which are not objects, so were not flattened", strings.Join(skipped, ", ")), nil
+	}
+	return frame, "", nil
+}
+
+// coerceValue converts a concrete value to a coerce type, returning false if it cannot be converted
+func coerceValue(value interface{}, to data.FieldType) (interface{}, bool) {
+	switch to {
+	case data.FieldTypeNullableString:
+		return transformKey(value, true), true
+	case data.FieldTypeNullableFloat64:
+		if f, ok := transformFloat(value); ok {
+			return f, true
+		}
+		switch v := value.(type) {
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return f, err == nil
+		case bool:
+			if v {
+				return float64(1), true
+			}
+			return float64(0), true
+		}
+	case data.FieldTypeNullableInt64:
+		if f, ok := transformFloat(value); ok {
+			if f != math.Trunc(f) || f > math.MaxInt64 || f < math.MinInt64 {
+				return nil, false
+			}
+			return int64(f), true
+		}
+		switch v := value.(type) {
+		case string:
+			i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			return i, err == nil
+		case bool:
+			if v {
+				return int64(1), true
+			}
+			return int64(0), true
+		}
+	case data.FieldTypeNullableBool:
+		if f, ok := transformFloat(value); ok {
+			return f != 0, true
+		}
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			return b, err == nil
+		}
+	case data.FieldTypeNullableTime:
+		// Numbers are milliseconds since the epoch, as in Grafana
+		if f, ok := transformFloat(value); ok {
+			return time.Unix(0, int64(f*float64(time.Millisecond))).UTC(), true
+		}
+		switch v := value.(type) {
+		case time.Time:
+			return v, true
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(v))
+			return t, err == nil
+		}
+	}
+	return nil, false
+}
+
+func coerceFrameField(frame *data.Frame, name, to string) (*data.Frame, string, error) {
+	type_, ok := coerceTypes[to]
+	if !ok {
+		names := make([]string, 0, len(coerceTypes))
+		for name := range coerceTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, "", fmt.Errorf("Coerce transforms must convert to one of: %s", strings.Join(names, ", "))
+	}
+	for ix, field := range frame.Fields {
+		if field.Name != name {
+			continue
+		}
+		coerced := data.NewFieldFromFieldType(type_, field.Len())
+		coerced.Name = field.Name
+		coerced.Labels = field.Labels
+		coerced.Config = field.Config
+		failed := 0
+		for row := 0; row < field.Len(); row++ {
+			value, ok := field.ConcreteAt(row)
+			if !ok {
+				continue
+			}
+			converted, ok := coerceValue(value, type_)
+			if !ok {
+				failed++
+				continue
+			}
+			coerced.SetConcrete(row, converted)
+		}
+		frame.Fields[ix] = coerced
+		if failed != 0 {
+			return frame, fmt.Sprintf("%d value(s) of %s could not be converted to %s, and were replaced with null", failed, name, to), nil
+		}
+		return frame, "", nil
+	}
+	return nil, "", fmt.Errorf("No such field %s", name)
+}
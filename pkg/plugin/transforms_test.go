@@ -0,0 +1,108 @@
+package plugin_test
+
+import (
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ApplyTransforms", func() {
+	apply := func(text string, frame *data.Frame) (*data.Frame, []data.Notice, error) {
+		qm := plugin.QueryModel{}
+		Expect(json.Unmarshal([]byte(text), &qm)).To(Succeed())
+		frames, notices, err := plugin.ApplyTransforms([]*data.Frame{frame}, qm.Transforms)
+		if err != nil {
+			return nil, nil, err
+		}
+		Expect(frames).To(HaveLen(1))
+		return frames[0], notices, nil
+	}
+	str := func(s string) *string { return &s }
+	num := func(f float64) *float64 { return &f }
+
+	It("Should sort, limit, and rename in order", func() {
+		frame := data.NewFrame("",
+			data.NewField("host", nil, []string{"b", "a", "c", "a"}),
+			data.NewField("value", nil, []float64{1, 2, 3, 4}),
+		)
+		frame, notices, err := apply(`{"transforms": [
+			{"type": "sort", "sort": [{"field": "host"}, {"field": "value", "descending": true}]},
+			{"type": "limit", "limit": 3},
+			{"type": "rename", "rename": {"host": "instance"}}
+		]}`, frame)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notices).To(BeEmpty())
+		Expect(frame.Fields[0].Name).To(Equal("instance"))
+		Expect(frame.Rows()).To(Equal(3))
+		Expect([]interface{}{frame.Fields[0].At(0), frame.Fields[0].At(1), frame.Fields[0].At(2)}).To(Equal([]interface{}{"a", "a", "b"}))
+		Expect([]interface{}{frame.Fields[1].At(0), frame.Fields[1].At(1), frame.Fields[1].At(2)}).To(Equal([]interface{}{4.0, 2.0, 1.0}))
+	})
+
+	It("Should pivot long frames to wide frames", func() {
+		frame := data.NewFrame("",
+			data.NewField("host", nil, []string{"a", "a", "b"}),
+			data.NewField("metric", nil, []string{"cpu", "mem", "cpu"}),
+			data.NewField("value", nil, []float64{1, 2, 3}),
+		)
+		frame, _, err := apply(`{"transforms": [{"type": "pivot", "row": "host", "column": "metric", "value": "value"}]}`, frame)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame.Fields).To(HaveLen(3))
+		Expect(frame.Fields[1].Name).To(Equal("cpu"))
+		Expect(frame.Fields[2].Name).To(Equal("mem"))
+		Expect(frame.Rows()).To(Equal(2))
+		Expect(frame.Fields[2].At(0)).To(Equal(num(2)))
+		Expect(frame.Fields[2].At(1)).To(BeNil())
+	})
+
+	It("Should flatten JSON objects into a field per key", func() {
+		frame := data.NewFrame("",
+			data.NewField("tags", nil, []*json.RawMessage{
+				rawJSON(`{"env": "prod", "cpu": 2}`),
+				nil,
+				rawJSON(`{"env": "dev", "extra": true}`),
+			}),
+		)
+		frame, _, err := apply(`{"transforms": [{"type": "flatten"}]}`, frame)
+		Expect(err).ToNot(HaveOccurred())
+		names := []string{}
+		for _, field := range frame.Fields {
+			names = append(names, field.Name)
+		}
+		Expect(names).To(Equal([]string{"tags.cpu", "tags.env", "tags.extra"}))
+		Expect(frame.Fields[0].At(0)).To(Equal(num(2)))
+		Expect(frame.Fields[1].At(2)).To(Equal(str("dev")))
+		Expect(frame.Fields[2].At(0)).To(BeNil())
+	})
+
+	It("Should coerce values, replacing those which cannot be converted with null", func() {
+		frame := data.NewFrame("", data.NewField("value", nil, []*string{str("1.5"), str("n/a"), nil}))
+		frame, notices, err := apply(`{"transforms": [{"type": "coerce", "field": "value", "to": "float64"}]}`, frame)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame.Fields[0].Type()).To(Equal(data.FieldTypeNullableFloat64))
+		Expect(frame.Fields[0].At(0)).To(Equal(num(1.5)))
+		Expect(frame.Fields[0].At(1)).To(BeNil())
+		Expect(notices).To(HaveLen(1))
+	})
+
+	DescribeTable("Should reject", func(text string) {
+		frame := data.NewFrame("", data.NewField("value", nil, []float64{1}))
+		_, _, err := apply(text, frame)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("unknown transforms", `{"transforms": [{"type": "explode"}]}`),
+		Entry("missing fields", `{"transforms": [{"type": "sort", "sort": [{"field": "missing"}]}]}`),
+		Entry("non-positive limits", `{"transforms": [{"type": "limit"}]}`),
+		Entry("unknown types", `{"transforms": [{"type": "coerce", "field": "value", "to": "decimal"}]}`),
+		Entry("flattening fields which are not JSON", `{"transforms": [{"type": "flatten", "fields": ["value"]}]}`),
+	)
+})
+
+func rawJSON(text string) *json.RawMessage {
+	raw := json.RawMessage(text)
+	return &raw
+}
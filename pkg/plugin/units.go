@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+// unit is a unit numeric fields can be converted from or to
+type unit struct {
+	// dimension is what the unit measures. Units can only be converted to others of the same dimension.
+	dimension string
+	// factor is the size of the unit in the base unit of its dimension
+	factor float64
+	// grafanaUnit is the Grafana unit ID of the unit
+	grafanaUnit string
+}
+
+// units are the units supported by unit fields, both binary (KiB) and decimal (KB) byte units, and percentages,
+// either as ratios (0 to 1), or percents (0 to 100)
+var units = map[string]unit{
+	"B":       {dimension: "bytes", factor: 1, grafanaUnit: "bytes"},
+	"KiB":     {dimension: "bytes", factor: 1 << 10, grafanaUnit: "kbytes"},
+	"MiB":     {dimension: "bytes", factor: 1 << 20, grafanaUnit: "mbytes"},
+	"GiB":     {dimension: "bytes", factor: 1 << 30, grafanaUnit: "gbytes"},
+	"TiB":     {dimension: "bytes", factor: 1 << 40, grafanaUnit: "tbytes"},
+	"KB":      {dimension: "bytes", factor: 1e3, grafanaUnit: "deckbytes"},
+	"MB":      {dimension: "bytes", factor: 1e6, grafanaUnit: "decmbytes"},
+	"GB":      {dimension: "bytes", factor: 1e9, grafanaUnit: "decgbytes"},
+	"TB":      {dimension: "bytes", factor: 1e12, grafanaUnit: "dectbytes"},
+	"ratio":   {dimension: "percentage", factor: 1, grafanaUnit: "percentunit"},
+	"percent": {dimension: "percentage", factor: 0.01, grafanaUnit: "percent"},
+}
+
+// unitField converts a numeric field from the unit it is stored in to another, and sets the unit Grafana displays it in
+type unitField struct {
+	Field string `json:"field"`
+	// From is the unit the field is stored in
+	From string `json:"from"`
+	// To is the unit the field is converted to. If omitted, the field is not converted, and only its unit is set.
+	To string `json:"to,omitempty"`
+}
+
+func unitNames() string {
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// UnitConversion returns the factor which converts values from one unit to another, and the Grafana unit ID of the result.
+// If to is empty, values are not converted.
+func UnitConversion(from, to string) (scale float64, grafanaUnit string, err error) {
+	fromUnit, ok := units[from]
+	if !ok {
+		return 0, "", fmt.Errorf("Unknown unit %q, must be one of: %s", from, unitNames())
+	}
+	if to == "" {
+		return 1, fromUnit.grafanaUnit, nil
+	}
+	toUnit, ok := units[to]
+	if !ok {
+		return 0, "", fmt.Errorf("Unknown unit %q, must be one of: %s", to, unitNames())
+	}
+	if fromUnit.dimension != toUnit.dimension {
+		return 0, "", fmt.Errorf("Cannot convert %s to %s", from, to)
+	}
+	return fromUnit.factor / toUnit.factor, toUnit.grafanaUnit, nil
+}
+
+// applyUnitFields validates the unit fields of a query, and makes any converted fields into nullable numbers which are scaled as they are converted
+func (m *QueryModel) applyUnitFields(fields []bsonframe.Column) ([]bsonframe.Column, error) {
+	if len(m.UnitFields) == 0 {
+		return fields, nil
+	}
+	err := m.requireCurrentModel("Unit fields")
+	if err != nil {
+		return nil, err
+	}
+	durations := make(map[string]struct{}, len(m.DurationFields))
+	for _, duration := range m.DurationFields {
+		durations[duration.Field] = struct{}{}
+	}
+	scales := make(map[string]float64, len(m.UnitFields))
+	for _, unitField := range m.UnitFields {
+		if _, ok := durations[unitField.Field]; ok {
+			return nil, fmt.Errorf("Field %s cannot be both a duration field and a unit field", unitField.Field)
+		}
+		if _, ok := scales[unitField.Field]; ok {
+			return nil, fmt.Errorf("Field %s has more than one unit", unitField.Field)
+		}
+		scale, _, err := UnitConversion(unitField.From, unitField.To)
+		if err != nil {
+			return nil, fmt.Errorf("Unit field %s: %s", unitField.Field, err)
+		}
+		scales[unitField.Field] = scale
+	}
+	converted := make([]bsonframe.Column, len(fields))
+	for ix, f := range fields {
+		if scale, ok := scales[f.Name]; ok && scale != 1 {
+			f = bsonframe.NewColumn(f.Name, data.FieldTypeNullableFloat64)
+			f.Coerce = true
+			f.Scale = scale
+		}
+		converted[ix] = f
+	}
+	return converted, nil
+}
+
+// setUnits sets the unit of each unit field in a set of frames
+func (m *QueryModel) setUnits(frames []*data.Frame) {
+	if len(m.UnitFields) == 0 {
+		return
+	}
+	grafanaUnits := make(map[string]string, len(m.UnitFields))
+	for _, unitField := range m.UnitFields {
+		// Already validated by applyUnitFields
+		_, grafanaUnits[unitField.Field], _ = UnitConversion(unitField.From, unitField.To)
+	}
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			grafanaUnit, ok := grafanaUnits[field.Name]
+			if !ok {
+				continue
+			}
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.Unit = grafanaUnit
+		}
+	}
+}
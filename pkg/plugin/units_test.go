@@ -0,0 +1,31 @@
+package plugin_test
+
+import (
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UnitConversion", func() {
+	DescribeTable("Should convert", func(from, to string, expectedScale float64, expectedUnit string) {
+		scale, unit, err := plugin.UnitConversion(from, to)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scale).To(Equal(expectedScale))
+		Expect(unit).To(Equal(expectedUnit))
+	},
+		Entry("bytes to MiB", "B", "MiB", 1.0/(1<<20), "mbytes"),
+		Entry("GiB to bytes", "GiB", "B", float64(1<<30), "bytes"),
+		Entry("MB to KB", "MB", "KB", 1000.0, "deckbytes"),
+		Entry("ratios to percents", "ratio", "percent", 100.0, "percent"),
+		Entry("only the display unit", "KiB", "", 1.0, "kbytes"),
+	)
+
+	DescribeTable("Should reject", func(from, to string) {
+		_, _, err := plugin.UnitConversion(from, to)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("unknown units", "furlongs", "B"),
+		Entry("units of different kinds", "ratio", "MiB"),
+	)
+})
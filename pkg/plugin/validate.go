@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	bsonPrim "go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// defaultValidationTimeRange is the time range macros are expanded with when validating a query, if none is given
+const defaultValidationTimeRange = time.Hour
+
+// readStages are the aggregation stages which only read data. Anything else, such as $out and $merge, or a misspelled stage,
+// is reported as a problem by query validation.
+var readStages = map[string]struct{}{
+	"$addFields": {}, "$bucket": {}, "$bucketAuto": {}, "$collStats": {}, "$count": {}, "$densify": {}, "$documents": {},
+	"$facet": {}, "$fill": {}, "$geoNear": {}, "$graphLookup": {}, "$group": {}, "$indexStats": {}, "$limit": {},
+	"$lookup": {}, "$match": {}, "$project": {}, "$redact": {}, "$replaceRoot": {}, "$replaceWith": {}, "$sample": {},
+	"$search": {}, "$searchMeta": {}, "$set": {}, "$setWindowFields": {}, "$skip": {}, "$sort": {}, "$sortByCount": {},
+	"$unionWith": {}, "$unset": {}, "$unwind": {},
+}
+
+// ValidatePipelineStages checks that every stage of a pipeline, including those of the sub-pipelines of $facet, $lookup, and $unionWith,
+// is a single known stage which only reads data, and returns a description of each which is not
+func ValidatePipelineStages(pipeline mongo.Pipeline) []string {
+	return checkStages(pipeline, func(name string) string {
+		if _, ok := readStages[name]; !ok {
+			return "is not an allowed stage"
+		}
+		return ""
+	})
+}
+
+// checkStages checks every stage of a pipeline and its sub-pipelines, and returns a description of each for which
+// problem returns anything other than an empty string
+func checkStages(pipeline mongo.Pipeline, problem func(name string) string) []string {
+	problems := []string{}
+	var check func(path string, stages []bson.D)
+	check = func(path string, stages []bson.D) {
+		for ix, stage := range stages {
+			location := fmt.Sprintf("%sstage %d", path, ix+1)
+			if len(stage) != 1 {
+				problems = append(problems, fmt.Sprintf("%s must have exactly one key, but has %d", location, len(stage)))
+				continue
+			}
+			name := stage[0].Key
+			if reason := problem(name); reason != "" {
+				problems = append(problems, fmt.Sprintf("%s (%s) %s", location, name, reason))
+				continue
+			}
+			for _, sub := range subPipelines(name, stage[0].Value) {
+				check(fmt.Sprintf("%s%s %s, ", path, name, sub.name), sub.stages)
+			}
+		}
+	}
+	check("", pipeline)
+	return problems
+}
+
+type namedPipeline struct {
+	name   string
+	stages []bson.D
+}
+
+// subPipelines returns the pipelines nested in a stage
+func subPipelines(stage string, spec interface{}) []namedPipeline {
+	doc, ok := spec.(bson.D)
+	if !ok {
+		return nil
+	}
+	pipelines := []namedPipeline{}
+	for _, elem := range doc {
+		var name string
+		switch {
+		case stage == "$facet":
+			name = elem.Key
+		case (stage == "$lookup" || stage == "$unionWith") && elem.Key == "pipeline":
+			name = "pipeline"
+		default:
+			continue
+		}
+		stages, ok := asPipeline(elem.Value)
+		if ok {
+			pipelines = append(pipelines, namedPipeline{name: name, stages: stages})
+		}
+	}
+	sort.SliceStable(pipelines, func(i, j int) bool { return pipelines[i].name < pipelines[j].name })
+	return pipelines
+}
+
+// asPipeline converts an array of documents into a pipeline
+func asPipeline(value interface{}) ([]bson.D, bool) {
+	array, ok := value.(bsonPrim.A)
+	if !ok {
+		return nil, false
+	}
+	stages := make([]bson.D, len(array))
+	for ix, elem := range array {
+		stages[ix], ok = elem.(bson.D)
+		if !ok {
+			return nil, false
+		}
+	}
+	return stages, true
+}
+
+// getValidationPipeline produces the pipeline which would be executed for a query. Commands which do not use a pipeline
+// are validated with a pipeline matching their filter.
+func (m *QueryModel) getValidationPipeline(mctx MacroContext) (mongo.Pipeline, error) {
+	switch m.Command {
+	case commandDistinct, commandCount, commandEstimatedCount:
+		filter, err := m.getCommandFilter(mctx)
+		if err != nil {
+			return nil, err
+		}
+		return mongo.Pipeline{bson.D{bson.E{Key: "$match", Value: filter}}}, nil
+	default:
+		return m.getPipeline(mctx)
+	}
+}
+
+// validateRequest is the body of a request to /validate
+type validateRequest struct {
+	// Query is the query JSON, as sent by the editor
+	Query json.RawMessage `json:"query"`
+	// TimeRange is how far back from the current time macros are expanded with, e.g. "1h"
+	TimeRange string `json:"timeRange,omitempty"`
+	// Server, if true, also has MongoDB plan the pipeline
+	Server bool `json:"server,omitempty"`
+}
+
+// ValidationResult reports the problems with a query. Pipeline is the pipeline which would be executed, as relaxed extended JSON.
+type ValidationResult struct {
+	Valid    bool            `json:"valid"`
+	Problems []string        `json:"problems"`
+	Pipeline json.RawMessage `json:"pipeline,omitempty"`
+}
+
+// handleValidate parses a query, expands its macros, and checks its stages against an allowlist of read-only stages,
+// so that the editor can show problems before the query is executed:
+//
+//	POST /validate {"query": {...}, "timeRange": "1h", "server": true}
+//
+// If server is true, the pipeline is also explained by MongoDB, which checks it without executing it. Appending a $limit of 0
+// would be the obvious way to do this, but MongoDB rejects such stages.
+func (d *MongoDBDatasource) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Only POST is supported"))
+		return
+	}
+	var req validateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid request body"))
+		return
+	}
+	if len(req.Query) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("A query is required"))
+		return
+	}
+	timeRange := defaultValidationTimeRange
+	if req.TimeRange != "" {
+		timeRange, err = time.ParseDuration(req.TimeRange)
+		if err != nil || timeRange <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Time range must be a positive duration"))
+			return
+		}
+	}
+	var header struct {
+		IntervalMS    int64 `json:"intervalMs"`
+		MaxDataPoints int64 `json:"maxDataPoints"`
+	}
+	err = json.Unmarshal(req.Query, &header)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "Invalid query"))
+		return
+	}
+	settings, err := loadDatasource(httpadapter.PluginConfigFromContext(r.Context()))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	invalid := func(problem string) {
+		writeJSON(w, http.StatusOK, ValidationResult{Problems: []string{problem}})
+	}
+	qm, err := parseQueryModel(req.Query)
+	if err != nil {
+		invalid(err.Error())
+		return
+	}
+	now := time.Now()
+	pipeline, err := qm.getValidationPipeline(MacroContext{
+		From:          now.Add(-timeRange),
+		To:            now,
+		Interval:      time.Duration(header.IntervalMS) * time.Millisecond,
+		MaxDataPoints: header.MaxDataPoints,
+		Snippets:      settings.Snippets,
+	})
+	if err != nil {
+		invalid(err.Error())
+		return
+	}
+	result := ValidationResult{Problems: ValidatePipelineStages(pipeline)}
+	if settings.DataFederation && len(result.Problems) == 0 {
+		result.Problems = ValidateFederatedPipelineStages(pipeline)
+	}
+	result.Pipeline, err = bson.MarshalExtJSON(bson.D{bson.E{Key: "pipeline", Value: pipeline}}, false, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if req.Server && len(result.Problems) == 0 {
+		ctx, client, _, done, ok := resourceClient(w, r)
+		if !ok {
+			return
+		}
+		defer done()
+		hint, err := qm.getHint()
+		if err == nil {
+			_, err = explainAggregate(ctx, client.Database(qm.Database).Collection(qm.Collection), pipeline, hint, explainQueryPlanner)
+		}
+		if err != nil {
+			result.Problems = append(result.Problems, err.Error())
+		}
+	}
+	result.Valid = len(result.Problems) == 0
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,26 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidatePipelineStages", func() {
+	DescribeTable("Should report", func(stages string, count int) {
+		query, err := json.Marshal(map[string]interface{}{"aggregation": stages})
+		Expect(err).ToNot(HaveOccurred())
+		pipeline, err := buildPipeline(query, MacroContext{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ValidatePipelineStages(pipeline)).To(HaveLen(count))
+	},
+		Entry("nothing for read-only stages", `[{"$match": {"a": 1}}, {"$group": {"_id": "$b"}}, {"$limit": 5}]`, 0),
+		Entry("writing stages", `[{"$match": {}}, {"$out": "copy"}, {"$merge": {"into": "copy"}}]`, 2),
+		Entry("misspelled stages", `[{"$macth": {"a": 1}}]`, 1),
+		Entry("stages with more than one key", `[{"$match": {}, "$limit": 1}]`, 1),
+		Entry("stages nested in facets", `[{"$facet": {"a": [{"$count": "n"}], "b": [{"$out": "copy"}]}}]`, 1),
+		Entry("stages nested in lookups", `[{"$lookup": {"from": "b", "as": "b", "pipeline": [{"$merge": {"into": "c"}}]}}]`, 1),
+		Entry("stages nested in unions", `[{"$unionWith": {"coll": "b", "pipeline": [{"$nope": {}}]}}]`, 1),
+	)
+})
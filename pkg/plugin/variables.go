@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+const (
+	// queryTypeVariable produces the __text and __value fields which Grafana uses for the options of template variables,
+	// so that variables can display one field, such as a name, while interpolating another, such as an ObjectID
+	queryTypeVariable = "Variable"
+
+	variableTextField  = "__text"
+	variableValueField = "__value"
+)
+
+// FormatVariableValue formats a BSON value as the text of a template variable option, or nil if it is null or absent.
+// Values which are not strings are formatted as they would be displayed in a table, so ObjectIDs are their hex strings.
+func FormatVariableValue(value interface{}) (*string, error) {
+	converted, _, err := ToGrafanaValue(value)
+	if err != nil {
+		return nil, err
+	}
+	var str string
+	switch v := converted.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		str = v
+	case *string:
+		if v == nil {
+			return nil, nil
+		}
+		str = *v
+	default:
+		str = fmt.Sprintf("%v", v)
+	}
+	return &str, nil
+}
+
+type variableQueryModel struct {
+	textField  string
+	valueField string
+}
+
+var _ = resolvedQueryModel(&variableQueryModel{})
+
+func (m *variableQueryModel) makeFrame(id string, labels data.Labels) (*data.Frame, error) {
+	return data.NewFrame(id,
+		data.NewField(variableTextField, nil, []*string{}),
+		data.NewField(variableValueField, nil, []*string{}),
+	), nil
+}
+
+func (m *variableQueryModel) getLabels(doc timestepDocument) (data.Labels, string) {
+	return make(data.Labels), ""
+}
+
+func (m *variableQueryModel) getValues(doc timestepDocument, opts *bsonframe.ConversionOptions, stats *bsonframe.Stats) ([]interface{}, error) {
+	value, err := FormatVariableValue(doc[m.valueField])
+	if err != nil {
+		return nil, err
+	}
+	text := value
+	if m.textField != "" {
+		text, err = FormatVariableValue(doc[m.textField])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []interface{}{text, value}, nil
+}
+
+// resolveVariable validates a variable query. The text field is optional, and defaults to the value.
+func (m *QueryModel) resolveVariable() (resolvedQueryModel, error) {
+	err := m.requireCurrentModel("Variable queries")
+	if err != nil {
+		return nil, err
+	}
+	if m.ValueField == "" {
+		return nil, fmt.Errorf("Variable queries require a value field")
+	}
+	return &variableQueryModel{textField: m.TextField, valueField: m.ValueField}, nil
+}
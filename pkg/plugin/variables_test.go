@@ -0,0 +1,34 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FormatVariableValue", func() {
+	id, _ := primitive.ObjectIDFromHex("5f1d7b2e9c3a4b0012345678")
+
+	DescribeTable("Should format", func(value interface{}, expected string) {
+		formatted, err := plugin.FormatVariableValue(value)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(formatted).ToNot(BeNil())
+		Expect(*formatted).To(Equal(expected))
+	},
+		Entry("strings", "web-1", "web-1"),
+		Entry("ObjectIDs as hex", id, "5f1d7b2e9c3a4b0012345678"),
+		Entry("integers", int32(42), "42"),
+		Entry("booleans", true, "true"),
+	)
+
+	It("Should return nil for nulls and missing values", func() {
+		for _, value := range []interface{}{nil, primitive.Null{}} {
+			formatted, err := plugin.FormatVariableValue(value)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(formatted).To(BeNil())
+		}
+	})
+})
@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// serverVersion is a MongoDB server version, as reported by buildInfo
+type serverVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v serverVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// atLeast returns true if this version is the same as or newer than major.minor
+func (v serverVersion) atLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+func getServerVersion(ctx context.Context, client *mongo.Client) (serverVersion, error) {
+	var buildInfo struct {
+		VersionArray []int `bson:"versionArray"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{bson.E{Key: "buildInfo", Value: 1}}).Decode(&buildInfo)
+	if err != nil {
+		return serverVersion{}, errors.Wrap(err, "Failed to get server version")
+	}
+	version := serverVersion{}
+	parts := []*int{&version.Major, &version.Minor, &version.Patch}
+	for ix := 0; ix < len(parts) && ix < len(buildInfo.VersionArray); ix++ {
+		*parts[ix] = buildInfo.VersionArray[ix]
+	}
+	return version, nil
+}
@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pkg/errors"
+)
+
+// warmupQuery is a query which is periodically executed in the background to keep its result in the cache
+type warmupQuery struct {
+	// Query is the query JSON, as it appears in the panel's query inspector
+	Query json.RawMessage `json:"query"`
+	// TimeRange is how far back from the current time the query should cover, e.g. "24h"
+	TimeRange string `json:"timeRange"`
+	// Interval is how often the query should be executed. Defaults to the cache time-to-live.
+	Interval string `json:"interval"`
+}
+
+// warmupJob is a warmupQuery with its durations parsed
+type warmupJob struct {
+	query     backend.DataQuery
+	timeRange time.Duration
+	interval  time.Duration
+}
+
+func (q *warmupQuery) toJob(index int, cacheTTL time.Duration) (warmupJob, error) {
+	job := warmupJob{interval: cacheTTL}
+	var err error
+	if q.TimeRange == "" {
+		return warmupJob{}, fmt.Errorf("Warm-up query %d has no time range", index)
+	}
+	job.timeRange, err = time.ParseDuration(q.TimeRange)
+	if err != nil {
+		return warmupJob{}, errors.Wrap(err, fmt.Sprintf("Invalid time range for warm-up query %d", index))
+	}
+	if q.Interval != "" {
+		job.interval, err = time.ParseDuration(q.Interval)
+		if err != nil {
+			return warmupJob{}, errors.Wrap(err, fmt.Sprintf("Invalid interval for warm-up query %d", index))
+		}
+	}
+	if job.interval <= 0 {
+		return warmupJob{}, fmt.Errorf("Warm-up query %d must have a positive interval", index)
+	}
+	// Dashboards send these alongside the query model, and they are part of the cache key
+	var header struct {
+		RefID         string `json:"refId"`
+		IntervalMS    int64  `json:"intervalMs"`
+		MaxDataPoints int64  `json:"maxDataPoints"`
+	}
+	err = json.Unmarshal(q.Query, &header)
+	if err != nil {
+		return warmupJob{}, errors.Wrap(err, fmt.Sprintf("Invalid warm-up query %d", index))
+	}
+	if header.RefID == "" {
+		header.RefID = fmt.Sprintf("warmup-%d", index)
+	}
+	job.query = backend.DataQuery{
+		RefID:         header.RefID,
+		JSON:          q.Query,
+		Interval:      time.Duration(header.IntervalMS) * time.Millisecond,
+		MaxDataPoints: header.MaxDataPoints,
+	}
+	return job, nil
+}
+
+// warmupScheduledJob refreshes the cached result of a warm-up query
+func (d *MongoDBDatasource) warmupScheduledJob(pCtx backend.PluginContext, job warmupJob) scheduledJob {
+	return scheduledJob{
+		name:     "warm-up query " + job.query.RefID,
+		interval: job.interval,
+		run: func(ctx context.Context) error {
+			now := time.Now()
+			query := job.query
+			query.TimeRange = backend.TimeRange{From: now.Add(-job.timeRange), To: now}
+			return d.cachedQuery(ctx, pCtx, requestOrigin{app: requestAppPlugin, orgID: pCtx.OrgID}, query, cacheModeRefresh).Error
+		},
+	}
+}
+
+// getCacheTTL returns the configured time-to-live of cached query results, or zero if caching is disabled
+func (d *datasource) getCacheTTL() (time.Duration, error) {
+	if d.CacheTTL == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(d.CacheTTL)
+	if err != nil {
+		return 0, errors.Wrap(err, "Invalid cache TTL in datasource settings")
+	}
+	return ttl, nil
+}
+
+// getWarmupJobs parses the configured warm-up queries
+func (d *datasource) getWarmupJobs(cacheTTL time.Duration) ([]warmupJob, error) {
+	if len(d.WarmupQueries) != 0 && cacheTTL <= 0 {
+		return nil, fmt.Errorf("Warm-up queries require a cache TTL to be configured")
+	}
+	jobs := make([]warmupJob, 0, len(d.WarmupQueries))
+	for ix := range d.WarmupQueries {
+		job, err := d.WarmupQueries[ix].toJob(ix, cacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+)
+
+// defaultOverflowColumn is the name of the column holding the fields which are not materialized as columns of their own
+const defaultOverflowColumn = "_overflow"
+
+// TopFields returns the k fields present in the most documents, excluding ignored fields, and fields not in allowed, if it is not nil.
+// Ties are broken by name, so that the same documents always produce the same fields.
+func TopFields(docs []bsonframe.Document, k int, ignored, allowed map[string]struct{}) map[string]struct{} {
+	counts := map[string]int{}
+	for _, doc := range docs {
+		for name, value := range doc {
+			if value == nil {
+				continue
+			}
+			if _, ok := ignored[name]; ok {
+				continue
+			}
+			if _, ok := allowed[name]; allowed != nil && !ok {
+				continue
+			}
+			counts[name]++
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > k {
+		names = names[:k]
+	}
+	top := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		top[name] = struct{}{}
+	}
+	return top
+}
+
+// overflowColumn collects the fields of each document which are not materialized as columns into a single JSON column
+type overflowColumn struct {
+	column bsonframe.Column
+	// excluded are the fields which are not included, either because they are columns of their own, or are ignored
+	excluded map[string]struct{}
+	// allowed, if not nil, are the only fields which are included
+	allowed map[string]struct{}
+}
+
+// OverflowDocument returns the fields of a document which are not excluded, and are allowed, if allowed is not nil,
+// ordered by name, or nil if there are none
+func OverflowDocument(doc bsonframe.Document, excluded, allowed map[string]struct{}) bson.D {
+	var overflow bson.D
+	for name, value := range doc {
+		if _, ok := excluded[name]; ok {
+			continue
+		}
+		if _, ok := allowed[name]; allowed != nil && !ok {
+			continue
+		}
+		overflow = append(overflow, bson.E{Key: name, Value: value})
+	}
+	sort.Slice(overflow, func(i, j int) bool { return overflow[i].Key < overflow[j].Key })
+	return overflow
+}
+
+func (c *overflowColumn) convert(doc bsonframe.Document, opts *bsonframe.ConversionOptions, stats *bsonframe.Stats) (interface{}, error) {
+	overflow := OverflowDocument(doc, c.excluded, c.allowed)
+	if overflow == nil {
+		return c.column.Convert(nil, opts, stats)
+	}
+	return c.column.Convert(overflow, opts, stats)
+}
+
+// validateMaxColumns checks that the query can limit the columns it infers
+func (m *QueryModel) validateMaxColumns() error {
+	if m.MaxColumns == 0 {
+		return nil
+	}
+	err := m.requireCurrentModel("Max columns")
+	if err != nil {
+		return err
+	}
+	if m.MaxColumns < 0 {
+		return fmt.Errorf("Max columns must not be negative")
+	}
+	if !m.SchemaInference {
+		return fmt.Errorf("Max columns requires schema inference")
+	}
+	if m.QueryType != queryTypeTable && m.QueryType != "" {
+		return fmt.Errorf("Max columns is only supported for %s queries", queryTypeTable)
+	}
+	return nil
+}
+
+// getOverflowColumn returns the overflow column for a query which limits its columns, or nil if it does not
+func (m *QueryModel) getOverflowColumn(fields []bsonframe.Column) (*overflowColumn, error) {
+	if m.MaxColumns == 0 {
+		return nil, nil
+	}
+	name := m.OverflowColumn
+	if name == "" {
+		name = defaultOverflowColumn
+	}
+	excluded := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		if field.Name == name {
+			return nil, fmt.Errorf("The overflow column %s conflicts with a field of the same name, please choose another name", name)
+		}
+		excluded[field.Name] = struct{}{}
+	}
+	return &overflowColumn{
+		column:   bsonframe.NewColumn(name, data.FieldTypeNullableJSON),
+		excluded: excluded,
+		allowed:  m.columnSet(),
+	}, nil
+}
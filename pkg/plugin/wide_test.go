@@ -0,0 +1,33 @@
+package plugin_test
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/bsonframe"
+	"github.com/meln5674/grafana-mongodb-community-plugin/pkg/plugin"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Wide events", func() {
+	docs := []bsonframe.Document{
+		{"ts": 1, "host": "a", "user": "x", "rare": true},
+		{"ts": 2, "host": "b", "trace": "t1"},
+		{"ts": 3, "host": nil, "user": "y", "trace": "t2"},
+	}
+
+	It("Should pick the most common fields, breaking ties by name", func() {
+		Expect(plugin.TopFields(docs, 2, map[string]struct{}{"ts": {}}, nil)).To(Equal(map[string]struct{}{"host": {}, "trace": {}}))
+	})
+
+	It("Should only pick allowed fields", func() {
+		Expect(plugin.TopFields(docs, 2, nil, map[string]struct{}{"rare": {}})).To(Equal(map[string]struct{}{"rare": {}}))
+	})
+
+	It("Should collect the remaining fields in order", func() {
+		excluded := map[string]struct{}{"ts": {}, "host": {}}
+		Expect(plugin.OverflowDocument(docs[0], excluded, nil)).To(Equal(bson.D{{Key: "rare", Value: true}, {Key: "user", Value: "x"}}))
+		Expect(plugin.OverflowDocument(bsonframe.Document{"ts": 4}, excluded, nil)).To(BeNil())
+	})
+})
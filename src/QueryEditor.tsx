@@ -18,10 +18,38 @@ import { defaultQuery, MongoDBDataSourceOptions, MongoDBQuery, MongoDBQueryType
 
 type Props = QueryEditorProps<DataSource, MongoDBQuery, MongoDBDataSourceOptions>;
 
-export class QueryEditor extends PureComponent<Props> {
+interface State {
+  /** Suggestions for the database and collection, which are empty if they could not be listed */
+  databases: string[];
+  collections: string[];
+}
+
+export class QueryEditor extends PureComponent<Props, State> {
   readonly labelWidth = 25;
   readonly longWidth = 50;
 
+  state: State = { databases: [], collections: [] };
+
+  componentDidMount() {
+    this.props.datasource
+      .getDatabases()
+      .then((databases) => this.setState({ databases }))
+      .catch(() => this.setState({ databases: [] }));
+    this.loadCollections();
+  }
+
+  loadCollections = () => {
+    const { database } = this.props.query;
+    if (!database || database.includes('$')) {
+      this.setState({ collections: [] });
+      return;
+    }
+    this.props.datasource
+      .getCollections(database)
+      .then((collections) => this.setState({ collections }))
+      .catch(() => this.setState({ collections: [] }));
+  };
+
   readonly queryTypeOptions = [
     {
         label: "Timeseries",
@@ -206,6 +234,8 @@ export class QueryEditor extends PureComponent<Props> {
                 placeholder="my_database"
                 value={query.database || ''}
                 onChange={this.onDatabaseChange}
+                onBlur={this.loadCollections}
+                list={`mongodb-databases-${query.refId}`}
               ></Input>
             </InlineField>
             <InlineField label=".">
@@ -216,8 +246,19 @@ export class QueryEditor extends PureComponent<Props> {
                 placeholder="my_collection"
                 value={query.collection || ''}
                 onChange={this.onCollectionChange}
+                list={`mongodb-collections-${query.refId}`}
               ></Input>
             </InlineField>
+            <datalist id={`mongodb-databases-${query.refId}`}>
+              {this.state.databases.map((database) => (
+                <option key={database} value={database} />
+              ))}
+            </datalist>
+            <datalist id={`mongodb-collections-${query.refId}`}>
+              {this.state.collections.map((collection) => (
+                <option key={collection} value={collection} />
+              ))}
+            </datalist>
           </InlineFieldRow>
           <InlineField
               labelWidth={this.labelWidth}
@@ -39,6 +39,11 @@ export class VariableQueryEditor extends PureComponent<VariableQueryProps> {
     onChange({ ...query, fieldType: event.target.value });
   };
 
+  onTextFieldNameChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, textFieldName: event.target.value });
+  };
+
   onAggregationChange = (newAggregation: string) => {
     const { onChange, query } = this.props;
     onChange({ ...query, aggregation: newAggregation });
@@ -100,6 +105,21 @@ export class VariableQueryEditor extends PureComponent<VariableQueryProps> {
             ></Input>
           </InlineField>
         </InlineFieldRow>
+
+        <InlineFieldRow>
+          <InlineField
+              label="Display Field"
+              labelWidth={this.labelWidth}
+              tooltip="Optional. If set, each option displays this field, while the value of Field above is interpolated, e.g. a name for an ObjectID"
+              >
+            <Input
+              width={this.longWidth}
+              placeholder="name"
+              onChange={this.onTextFieldNameChange}
+              value={query.textFieldName ?? ''}
+            ></Input>
+          </InlineField>
+        </InlineFieldRow>
   
         <InlineFormLabel
           width={this.labelWidth}
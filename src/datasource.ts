@@ -8,24 +8,142 @@ import {
 } from '@grafana/data';
 import {
     DataSourceWithBackend, 
+    getBackendSrv,
     getTemplateSrv,
     frameToMetricFindValue
 } from '@grafana/runtime';
-import { MongoDBDataSourceOptions, MongoDBQuery, MongoDBQueryType, MongoDBVariableQuery } from './types';
+import {
+  MongoDBAnnotation,
+  MongoDBDataSourceOptions,
+  MongoDBIndex,
+  MongoDBInferredField,
+  MongoDBQuery,
+  MongoDBQueryType,
+  MongoDBValidationResult,
+  MongoDBVariableQuery,
+} from './types';
+
+// Queries saved before this version of the query model keep the plain json format, as typed literals would change
+// what their filters match
+const typedVariablesQueryVersion = 2;
+
+const objectIdPattern = /^[0-9a-fA-F]{24}$/;
+// Numbers with leading zeros, such as zip codes, are left as strings
+const numberPattern = /^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$/;
+
+/**
+ * Formats a single value of a multi-value variable as an extended JSON literal, so numbers stay numbers,
+ * and ObjectIDs become {"$oid": ...}. Anything else is a string.
+ */
+function typedLiteral(value: string): string {
+  if (objectIdPattern.test(value)) {
+    return JSON.stringify({ $oid: value });
+  }
+  if (numberPattern.test(value) && isFinite(Number(value))) {
+    return value;
+  }
+  return JSON.stringify(value);
+}
+
+/**
+ * Interpolates variables into extended JSON. Multi-value variables become arrays of typed literals, suitable for $in,
+ * while single values are quoted, as with the json format. Use ${var:json} to keep every value of a variable a string.
+ * Only used for queries at typedVariablesQueryVersion or later.
+ */
+export function formatVariableJSON(value: string | string[]): string {
+  if (Array.isArray(value)) {
+    return `[${value.map(typedLiteral).join(',')}]`;
+  }
+  return JSON.stringify(value);
+}
 
 export class DataSource extends DataSourceWithBackend<MongoDBQuery, MongoDBDataSourceOptions> {
-  constructor(instanceSettings: DataSourceInstanceSettings<MongoDBDataSourceOptions>) {
+  constructor(private instanceSettings: DataSourceInstanceSettings<MongoDBDataSourceOptions>) {
     super(instanceSettings);
   }
 
  applyTemplateVariables(query: MongoDBQuery, scopedVars: ScopedVars): Record<string, any> {
     const templateSrv = getTemplateSrv();
+    // $__interval and $__interval_ms are macros expanded by the backend, in both a string and a numeric form
+    const { __interval, __interval_ms, ...pipelineVars } = scopedVars;
+    const format = (query.version ?? 0) >= typedVariablesQueryVersion ? formatVariableJSON : 'json';
     return {
       ...query,
-      aggregation: query.aggregation ? templateSrv.replace(query.aggregation, scopedVars, 'json') : ''
+      aggregation: query.aggregation ? templateSrv.replace(query.aggregation, pipelineVars, format) : '',
+      filter: query.filter ? templateSrv.replace(query.filter, pipelineVars, format) : undefined,
+      afterClusterTime: query.afterClusterTime ? templateSrv.replace(query.afterClusterTime, scopedVars) : undefined,
+      snapshot: query.snapshot ? templateSrv.replace(query.snapshot, scopedVars) : undefined,
+      caseInsensitiveFilters: query.caseInsensitiveFilters?.map((filter) => ({
+        field: filter.field,
+        value: templateSrv.replace(filter.value, scopedVars),
+      })),
+      adhocFilters: (templateSrv as any).getAdhocFilters?.(this.name),
     };
   }
 
+  /**
+   * Lists the databases the datasource user may query, for the query editor
+   */
+  async getDatabases(): Promise<string[]> {
+    const rsp = await this.getResource('databases');
+    return rsp.databases;
+  }
+
+  /**
+   * Lists the collections of a database the datasource user may query, for the query editor
+   */
+  async getCollections(database: string): Promise<string[]> {
+    const rsp = await this.getResource(`databases/${encodeURIComponent(database)}/collections`);
+    return rsp.collections;
+  }
+
+  /**
+   * Lists the indexes of a collection, which may be used as index hints
+   */
+  async getIndexes(database: string, collection: string): Promise<MongoDBIndex[]> {
+    const rsp = await this.getResource(`collections/${encodeURIComponent(collection)}/indexes`, { database });
+    return rsp.indexes;
+  }
+
+  /**
+   * Infers the fields of a collection from a sample of its documents, for autocompletion of field paths
+   */
+  async getFields(database: string, collection: string): Promise<MongoDBInferredField[]> {
+    const rsp = await this.getResource('fields', { database, collection });
+    return rsp.fields;
+  }
+
+  /**
+   * Checks a query for problems without executing it. If server is set, MongoDB also plans the query.
+   */
+  async validateQuery(query: MongoDBQuery, server = false): Promise<MongoDBValidationResult> {
+    return this.postResource('validate', { query, server });
+  }
+
+  /**
+   * Offers the fields of a sample of the configured ad-hoc collection as ad-hoc filter keys
+   */
+  async getTagKeys(): Promise<MetricFindValue[]> {
+    const { adhocDatabase, adhocCollection } = this.instanceSettings.jsonData;
+    if (!adhocDatabase || !adhocCollection) {
+      return [];
+    }
+    const rsp = await this.getResource(`collections/${encodeURIComponent(adhocCollection)}/keys`, { database: adhocDatabase });
+    return rsp.keys.map((key: string) => ({ text: key }));
+  }
+
+  async getTagValues(options: { key: string }): Promise<MetricFindValue[]> {
+    const { adhocDatabase, adhocCollection } = this.instanceSettings.jsonData;
+    if (!adhocDatabase || !adhocCollection) {
+      return [];
+    }
+    const rsp = await this.getResource(
+      `collections/${encodeURIComponent(adhocCollection)}/fields/${encodeURIComponent(options.key)}/values`,
+      { database: adhocDatabase }
+    );
+    return rsp.values.map((value: any) => ({ text: String(value) }));
+  }
+
 
   query(request: DataQueryRequest<MongoDBQuery>): Observable<DataQueryResponse> {
       const templateSrv = getTemplateSrv();
@@ -34,6 +152,9 @@ export class DataSource extends DataSourceWithBackend<MongoDBQuery, MongoDBDataS
   }
 
   async metricFindQuery(query: MongoDBVariableQuery, options?: any): Promise<MetricFindValue[]> {
+    if (query.textFieldName) {
+      return this.findVariableOptions(query, options);
+    }
     const target: Partial<MongoDBQuery> = {
         refId: 'metricFindQuery',
         database: query.database,
@@ -69,4 +190,49 @@ export class DataSource extends DataSourceWithBackend<MongoDBQuery, MongoDBDataS
         return [];
     });
   }
+
+  /**
+   * Runs a Variable query, which returns __text and __value fields, so options can display a different field than they interpolate
+   */
+  findVariableOptions(query: MongoDBVariableQuery, options?: any): Promise<MetricFindValue[]> {
+    const target: Partial<MongoDBQuery> = {
+        refId: 'metricFindQuery',
+        version: 2,
+        database: query.database,
+        collection: query.collection,
+        queryType: MongoDBQueryType.Variable,
+        aggregation: query.aggregation,
+        textField: query.textFieldName,
+        valueField: query.fieldName,
+        valueFields: [],
+    }
+    const dataQueryRequest = { ...options, targets: [target] } as DataQueryRequest<MongoDBQuery>;
+    return lastValueFrom(this.query(dataQueryRequest)).then((rsp) => {
+        if (rsp.error) {
+            throw new Error(rsp.error.message);
+        }
+        return rsp.data?.length ? frameToMetricFindValue(rsp.data[0]) : [];
+    });
+  }
+
+  /**
+   * Creates an annotation, or updates it if an id is given, returning its id. Requires annotations to be enabled for the datasource.
+   */
+  async saveAnnotation(annotation: MongoDBAnnotation, id?: string): Promise<string> {
+    const rsp = await lastValueFrom(getBackendSrv().fetch<{ id: string }>({
+      url: this.annotationsURL(id),
+      method: id ? 'PUT' : 'POST',
+      data: annotation,
+    }));
+    return rsp.data.id;
+  }
+
+  async deleteAnnotation(id: string): Promise<void> {
+    await lastValueFrom(getBackendSrv().fetch({ url: this.annotationsURL(id), method: 'DELETE' }));
+  }
+
+  private annotationsURL(id?: string): string {
+    const base = `/api/datasources/uid/${this.uid}/resources/annotations`;
+    return id ? `${base}/${encodeURIComponent(id)}` : base;
+  }
 }
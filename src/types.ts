@@ -1,6 +1,7 @@
 import { DataQuery, DataSourceJsonData } from '@grafana/data';
 
 export interface MongoDBQuery extends DataQuery {
+  version?: number;
   database: string;
   collection: string;
   timestampField: string;
@@ -15,14 +16,249 @@ export interface MongoDBQuery extends DataQuery {
   autoTimeSort: boolean;
   schemaInference: boolean;
   schemaInferenceDepth: number;
+  /**
+   * Only the most common inferred fields become columns, the rest are collected into a JSON overflow column
+   */
+  maxColumns?: number;
+  /** Defaults to _overflow */
+  overflowColumn?: string;
+  /**
+   * Columns listed here come first, in this order, regardless of the order of the fields of the documents
+   */
+  columnOrder?: string[];
+  columns?: string[];
+  /**
+   * Decodes documents with this many workers, at most the number of CPUs available to the plugin
+   */
+  decodeParallelism?: number;
+  decimalReducer?: string;
+  dbRefFormat?: string;
+  timeout?: string;
+  maxRows?: number;
+  maxBytes?: number;
+  caseInsensitiveFilters?: MongoDBCaseInsensitiveFilter[];
+  nullRepresentation?: 'null' | 'empty' | 'text' | 'custom';
+  nullPlaceholder?: string;
+  sseCompatible?: boolean;
+  labelsFrom?: string[];
+  noDataMode?: 'empty' | 'zero' | 'lastValue';
+  afterClusterTime?: string;
+  snapshot?: string;
+  topN?: number;
+  topNBy?: 'sum' | 'max' | 'avg' | 'last';
+  maxSeries?: number;
+  decimalSeparator?: '.' | ',';
+  dateFormat?: string;
+  durationFields?: MongoDBDurationField[];
+  unitFields?: MongoDBUnitField[];
+  boolFields?: string[];
+  ipFields?: string[];
+  macFields?: string[];
+  joinKey?: MongoDBJoinKey;
+  indexAdvice?: boolean;
+  /**
+   * Explains the query, and shows the winning plans, stages, and indexes used in the query inspector
+   */
+  explain?: boolean;
+  /**
+   * Explains the query again with execution statistics, and shows the documents and keys examined in the query inspector.
+   * This executes the query twice.
+   */
+  executionStats?: boolean;
+  timezone?: string;
+  decimalMode?: 'float' | 'string';
+  binaryMode?: 'hex' | 'base64' | 'uuid';
+  extJSONFlavor?: 'relaxed' | 'canonical';
+  /**
+   * How the pipeline and find documents are parsed. Canonical extended JSON is parsed strictly, and never as mongosh syntax.
+   */
+  extJSONInputFlavor?: 'relaxed' | 'canonical';
+  maxCellDepth?: number;
+  command?: 'aggregate' | 'find' | 'distinct' | 'count' | 'estimatedDocumentCount';
+  filter?: string;
+  projection?: string;
+  sort?: string;
+  skip?: number;
+  limit?: number;
+  /**
+   * Forces an index, either by name, or by key pattern, e.g. {"ts": 1}
+   */
+  hint?: string;
+  /**
+   * Used instead of the aggregation if not empty
+   */
+  stages?: MongoDBBuilderStage[];
+  builder?: MongoDBBuilderQuery;
+  distinctField?: string;
+  /**
+   * Streams documents inserted after the query runs, and matching the filter, into the panel over Grafana Live.
+   * changeStream requires a replica set, while tailable requires a capped collection.
+   * Only queries using the find command can be streamed, and their filter cannot use time macros.
+   */
+  stream?: 'changeStream' | 'tailable';
+  /**
+   * For Variable queries, the field displayed for each option. Defaults to the value field.
+   */
+  textField?: string;
+  /**
+   * For Variable queries, the field interpolated when an option is selected
+   */
+  valueField?: string;
+  /**
+   * Applied in order to every frame after it is built
+   */
+  transforms?: MongoDBTransform[];
+  /**
+   * Moves JSON cells larger than minBytes (default 64KiB) into the frame metadata, compressed,
+   * and replaces them with links to their documents
+   */
+  compressCells?: {
+    minBytes?: number;
+    encoding?: 'zstd' | 'gzip';
+  };
+  /**
+   * Adds a data link to the _id field which opens the full document
+   */
+  documentLinks?: boolean;
+  /**
+   * Omits the diagnostic metadata otherwise attached to every frame, which is only shown in the query inspector
+   */
+  hideFromInspector?: boolean;
+  /**
+   * Set from the ad-hoc filters variables of the dashboard, and prepended to the pipeline as a $match stage
+   */
+  adhocFilters?: MongoDBAdhocFilter[];
+  /**
+   * Overrides the panel time range with the last relativeTime (e.g. 1h), and moves it into the past by timeShift (e.g. 7d)
+   * before macros are expanded. Results are moved forward by timeShift, to compare with other queries of the panel.
+   */
+  relativeTime?: string;
+  timeShift?: string;
+  /**
+   * Whether to use a cached response (the default), replace it with a fresh one, or bypass the cache entirely
+   */
+  cache?: 'use' | 'refresh' | 'bypass';
+}
+
+export interface MongoDBAdhocFilter {
+  key: string;
+  operator: '=' | '!=' | '<' | '>' | '=~' | '!~';
+  value: string;
+}
+
+/**
+ * A transform applied to the frames of a query. Only the fields relevant to its type may be set.
+ */
+export interface MongoDBTransform {
+  type: 'flatten' | 'pivot' | 'sort' | 'limit' | 'rename' | 'coerce';
+  /** flatten: JSON fields to flatten, or all of them if empty */
+  fields?: string[];
+  /** pivot */
+  row?: string;
+  column?: string;
+  value?: string;
+  sort?: MongoDBBuilderSortField[];
+  limit?: number;
+  /** rename: old names to new names */
+  rename?: Record<string, string>;
+  /** coerce */
+  field?: string;
+  to?: 'string' | 'float64' | 'int64' | 'bool' | 'time';
+}
+
+/**
+ * A typed pipeline stage, compiled by the backend. Only the fields relevant to the type are used
+ */
+export interface MongoDBBuilderStage {
+  type: 'match' | 'group' | 'sort' | 'project' | 'limit';
+  conditions?: MongoDBBuilderCondition[];
+  groupBy?: string[];
+  accumulators?: MongoDBBuilderAccumulator[];
+  sort?: MongoDBBuilderSortField[];
+  fields?: string[];
+  limit?: number;
+}
+
+/**
+ * A query built by the visual query builder, compiled by the backend in the order match, group, sort, limit
+ */
+export interface MongoDBBuilderQuery {
+  match?: MongoDBBuilderCondition[];
+  groupBy?: string[];
+  accumulators?: MongoDBBuilderAccumulator[];
+  sort?: MongoDBBuilderSortField[];
+  limit?: number;
+}
+
+export interface MongoDBBuilderCondition {
+  field: string;
+  operator: 'eq' | 'ne' | 'gt' | 'gte' | 'lt' | 'lte' | 'in' | 'nin' | 'exists' | 'regex';
+  /**
+   * Extended JSON, e.g. {"$date": "2022-01-01T00:00:00Z"}
+   */
+  value?: any;
+}
+
+export interface MongoDBBuilderAccumulator {
+  name: string;
+  operator: 'count' | 'sum' | 'avg' | 'min' | 'max' | 'first' | 'last';
+  field?: string;
+}
+
+export interface MongoDBBuilderSortField {
+  field: string;
+  descending?: boolean;
+}
+
+/**
+ * Restricts a query to documents where a field equals a value, ignoring case
+ */
+export interface MongoDBCaseInsensitiveFilter {
+  field: string;
+  value: string;
+}
+
+/**
+ * Marks a numeric field as a duration, optionally converting ISO-8601 duration strings to seconds
+ */
+export interface MongoDBDurationField {
+  field: string;
+  unit?: 'ns' | 'us' | 'ms' | 's' | 'm' | 'h' | 'd';
+  iso8601?: boolean;
+}
+
+export type MongoDBUnit = 'B' | 'KiB' | 'MiB' | 'GiB' | 'TiB' | 'KB' | 'MB' | 'GB' | 'TB' | 'ratio' | 'percent';
+
+/**
+ * Converts a numeric column from the unit it is stored in to another of the same kind, and sets its display unit
+ */
+export interface MongoDBUnitField {
+  field: string;
+  from: MongoDBUnit;
+  /** If omitted, the values are not converted, and only the display unit is set */
+  to?: MongoDBUnit;
+}
+
+/**
+ * Prepares a column for the "join by field" transformation, to match the key column of frames from other datasources
+ */
+export interface MongoDBJoinKey {
+  field: string;
+  as?: string;
+  trim?: boolean;
+  case?: 'lower' | 'upper';
 }
 
 export enum MongoDBQueryType {
     Timeseries = "Timeseries",
     Table = "Table",
+    HealthEvents = "HealthEvents",
+    Variable = "Variable",
+    Annotations = "Annotations",
 };
 
 export const defaultQuery: Partial<MongoDBQuery> = {
+    version: 2,
     database: "my_db",
     collection: "my_collection",
     queryType: MongoDBQueryType.Timeseries,
@@ -54,6 +290,10 @@ export interface MongoDBVariableQuery {
     aggregation: string;
     fieldName: string;
     fieldType: string;
+    /**
+     * If set, the field displayed for each option, while fieldName is the value which is interpolated
+     */
+    textFieldName?: string;
 };
 
 export const defaultVariableQuery: Partial<MongoDBVariableQuery> = {
@@ -66,6 +306,140 @@ export const defaultVariableQuery: Partial<MongoDBVariableQuery> = {
     fieldType: "string"
 };
 
+/**
+ * Overrides for queries issued interactively (dashboard) or by the alerting engine (alert)
+ */
+export interface MongoDBReadIntentSettings {
+  readPreference?: string;
+  maxQueryTimeout?: string;
+  maxQueryRows?: number;
+  maxQueryBytes?: number;
+  hardQueryTimeout?: string;
+  maxStalenessSeconds?: number;
+}
+
+/**
+ * A query which is periodically executed in the background to keep its result cached
+ */
+export interface MongoDBWarmupQuery {
+  query: MongoDBQuery;
+  timeRange: string;
+  interval?: string;
+}
+
+/**
+ * A request to materialize the results of a query as a view, or by merging them into a collection
+ */
+export interface MongoDBMaterializeRequest {
+  query: MongoDBQuery;
+  mode: 'view' | 'merge';
+  database?: string;
+  target: string;
+  timeRange?: string;
+}
+
+/**
+ * A merge materialization which is run periodically in the background
+ */
+export interface MongoDBScheduledMerge extends MongoDBMaterializeRequest {
+  interval: string;
+}
+
+/**
+ * The body of a request to /export, which streams the documents returned by a query as CSV or newline-delimited JSON
+ */
+export interface MongoDBExportRequest {
+  query: MongoDBQuery;
+  format: 'csv' | 'ndjson';
+  timeRange?: string;
+  from?: string;
+  to?: string;
+  columns?: string[];
+}
+
+/**
+ * An export which is run periodically in the background, writing a new file to the export directory each time
+ */
+export interface MongoDBScheduledExport extends MongoDBExportRequest {
+  name: string;
+  interval: string;
+  /**
+   * The number of most recent files of this export kept in the export directory, 30 if unset. Older files are deleted.
+   */
+  maxFiles?: number;
+}
+
+/**
+ * The body of a request to /test-alert-query, which runs a query as the alert scheduler would
+ */
+export interface MongoDBAlertTestRequest {
+  query: MongoDBQuery;
+  timeRange?: string;
+}
+
+/**
+ * Whether the result of a query can be evaluated by an alert rule, and the last value of each series
+ */
+export interface MongoDBAlertTestResult {
+  alertable: boolean;
+  noData?: boolean;
+  error?: string;
+  problems: string[];
+  notices: string[];
+  series: Array<{ name: string; labels?: Record<string, string>; value: number | null }>;
+}
+
+/**
+ * A parameter of a pipeline template served by the /templates resource route
+ */
+export interface MongoDBTemplateParameter {
+  name: string;
+  description: string;
+  type: 'field' | 'number' | 'unit';
+  default?: string;
+}
+
+/**
+ * A parameterized pipeline template. POST a map of parameter values to /templates/{id}/render
+ * to get the fields of a query to insert into the editor.
+ */
+export interface MongoDBPipelineTemplate {
+  id: string;
+  name: string;
+  description: string;
+  parameters: MongoDBTemplateParameter[];
+  minServerVersion?: string;
+}
+
+/**
+ * The body of a request to /demo-data, which seeds the demo database used by the sample dashboard.
+ * Only available to admins, and only if demo data is enabled for the datasource.
+ */
+export interface MongoDBDemoDataRequest {
+  days?: number;
+  interval?: string;
+  reset?: boolean;
+}
+
+/**
+ * The body of a request to /suggest, which asks the configured suggestion endpoint for a pipeline answering the prompt.
+ * Only the names and types of fields in a sample of the collection are sent, never their values.
+ */
+export interface MongoDBSuggestRequest {
+  database: string;
+  collection: string;
+  prompt: string;
+  sample?: number;
+}
+
+/**
+ * A candidate pipeline from /suggest. It is checked to parse, but has not been run.
+ */
+export interface MongoDBPipelineSuggestion {
+  pipeline: string;
+  explanation?: string;
+}
+
 /**
  * These are options configured for each DataSource instance.
  */
@@ -76,6 +450,131 @@ export interface MongoDBDataSourceOptions extends DataSourceJsonData {
   tlsCertificate?: string;
   tlsCa?: string;
   tlsServerName?: string;
+  /**
+   * The URL is an Atlas Data Federation endpoint, which has no indexes, and whose collections are read from its storage configuration
+   */
+  dataFederation?: boolean;
+  maxQueryTimeout?: string;
+  maxQueryRows?: number;
+  maxQueryBytes?: number;
+  hardQueryTimeout?: string;
+  /**
+   * The time allowed for all of the queries of a request, such as those of a dashboard, which is shared between them
+   */
+  requestTimeBudget?: string;
+  /**
+   * Limits on the nesting depth and size in bytes of document and array cells, which are replaced by a truncation marker
+   * when exceeded. Unset means no limit.
+   */
+  maxCellDepth?: number;
+  maxCellBytes?: number;
+  readPreference?: string;
+  /**
+   * Excludes secondaries lagging the primary by more than this many seconds, at least 90. Requires a non-primary read preference.
+   */
+  maxStalenessSeconds?: number;
+  dashboardReadIntent?: MongoDBReadIntentSettings;
+  alertReadIntent?: MongoDBReadIntentSettings;
+  alertSinkEnabled?: boolean;
+  alertSinkDatabase?: string;
+  alertSinkCollection?: string;
+  cacheTTL?: string;
+  warmupQueries?: MongoDBWarmupQuery[];
+  materializeEnabled?: boolean;
+  scheduledMerges?: MongoDBScheduledMerge[];
+  exportDirectory?: string;
+  scheduledExports?: MongoDBScheduledExport[];
+  staticCollections?: Record<string, string[]>;
+  healthEventsEnabled?: boolean;
+  demoDataEnabled?: boolean;
+  demoDataDatabase?: string;
+  suggestEndpoint?: string;
+  suggestModel?: string;
+  /**
+   * Collections, as database.collection or just collection, which queries must filter by time before any other stage
+   */
+  largeCollections?: string[];
+  /**
+   * Overrides the severity of lint rules by their id
+   */
+  lintRules?: Partial<Record<MongoDBLintRule, 'off' | 'info' | 'warn' | 'block'>>;
+  /**
+   * Saved arrays of stages, as JSON text, by name, which pipelines may include with $__include(name)
+   */
+  snippets?: Record<string, string>;
+  /**
+   * Fields removed from documents served for drill-downs, by database.collection, collection, or * for every collection.
+   * This only applies to drill-downs. Panel queries and exports still return these fields, so it does not restrict access to them.
+   */
+  drillDownRedactedFields?: Record<string, string[]>;
+  /**
+   * Allows editors to write annotations to annotationsDatabase.annotationsCollection, using the separate
+   * annotationsUsername and annotationsPassword credentials
+   */
+  annotationsEnabled?: boolean;
+  annotationsDatabase?: string;
+  annotationsCollection?: string;
+  /**
+   * The collection whose fields and values are offered by ad-hoc filters
+   */
+  adhocDatabase?: string;
+  adhocCollection?: string;
+}
+
+/**
+ * An annotation written to the annotations collection. time and timeEnd are milliseconds since the epoch.
+ */
+/**
+ * The result of checking a query without executing it
+ */
+export interface MongoDBValidationResult {
+  valid: boolean;
+  problems: string[];
+  pipeline?: { pipeline: any[] };
+}
+
+/**
+ * An index of a collection
+ */
+export interface MongoDBIndex {
+  name: string;
+  key: Record<string, any>;
+  unique?: boolean;
+  sparse?: boolean;
+  expireAfterSeconds?: number;
+  partialFilterExpression?: Record<string, any>;
+}
+
+/**
+ * A field seen in a sample of the documents of a collection
+ */
+export interface MongoDBInferredField {
+  /** Dotted path of the field */
+  path: string;
+  /** BSON types the field was seen with, by their $type alias */
+  types: string[];
+  /** Number of sampled documents with the field */
+  count: number;
+}
+
+export interface MongoDBAnnotation {
+  time: number;
+  timeEnd?: number;
+  text: string;
+  tags?: string[];
+  dashboardUID?: string;
+  panelId?: number;
+}
+
+export type MongoDBLintRule = 'leading-project' | 'unanchored-regex' | 'unbounded-lookup' | 'missing-time-filter';
+
+/**
+ * The body of non-2xx responses from resource routes. A reason of "permission" indicates the datasource user
+ * lacks the privileges for the request, and the editor should fall back to free-text input.
+ */
+export interface MongoDBResourceError {
+  error: string;
+  reason?: 'permission';
 }
 
 /**
@@ -85,4 +584,7 @@ export interface MongoDBSecureJsonData {
     username?: string;
     password?: string;
     tlsCertificateKey?: string;
+    suggestApiKey?: string;
+    annotationsUsername?: string;
+    annotationsPassword?: string;
 }